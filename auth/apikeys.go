@@ -0,0 +1,274 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"trading-bot-web/config"
+)
+
+// Области действия API ключей
+const (
+	// ScopeRead - только чтение: счета, заявки, боты, инструменты и маркетдата
+	ScopeRead    = "read"
+	ScopeTrading = "trading"
+	ScopeBots    = "bots"
+	ScopeAdmin   = "admin"
+)
+
+// scopeRoles - роль, которую дает область ключа
+var scopeRoles = map[string]string{
+	ScopeRead:    RoleViewer,
+	ScopeTrading: RoleTrader,
+	ScopeBots:    RoleBotOperator,
+	ScopeAdmin:   RoleAdmin,
+}
+
+const (
+	// apiKeyPrefix - префикс выдаваемых ключей, чтобы их было легко найти в утечках
+	apiKeyPrefix = "tbk_"
+	// staticKeyPrefix - префикс ID ключей из конфигурации
+	staticKeyPrefix = "config:"
+	// touchInterval - как часто сохранять время последнего использования ключа
+	touchInterval = time.Minute
+)
+
+var (
+	ErrInvalidAPIKey  = errors.New("invalid api key")
+	ErrAPIKeyNotFound = errors.New("api key not found")
+)
+
+// APIKey - выданный API ключ; хранится только хеш секрета
+type APIKey struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Prefix - начало ключа для опознания в списке
+	Prefix  string   `json:"prefix"`
+	KeyHash string   `json:"-"`
+	Scopes  []string `json:"scopes"`
+	// Accounts - доступные ключу счета, пусто - все счета
+	Accounts []string `json:"accounts"`
+	// RateLimit - запросов в минуту, 0 - только общий лимит
+	RateLimit  int        `json:"rate_limit"`
+	CreatedBy  string     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Active - действует ли ключ в момент now
+func (k *APIKey) Active(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+// APIKeyStore - хранилище API ключей
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, key APIKey) error
+	// APIKeyByHash - ключ по хешу секрета, nil если его нет
+	APIKeyByHash(ctx context.Context, hash string) (*APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	// RevokeAPIKey - отзыв ключа, ErrAPIKeyNotFound если его нет
+	RevokeAPIKey(ctx context.Context, id string, at time.Time) error
+	// TouchAPIKey - отметка о последнем использовании
+	TouchAPIKey(ctx context.Context, id string, at time.Time) error
+}
+
+// NewAPIKey - параметры выдачи ключа
+type NewAPIKey struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1"`
+	Accounts  []string   `json:"accounts"`
+	RateLimit int        `json:"rate_limit"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// apiKeys - проверка API ключей из хранилища и конфигурации
+type apiKeys struct {
+	store APIKeyStore
+	// static - ключи из конфигурации по хешу, пусто при api_keys.enabled: false
+	static map[string]*APIKey
+
+	mu sync.Mutex
+	// touched - когда последний раз сохранялось использование ключа; записи
+	// старше touchInterval удаляются, в карте только ключи последней минуты
+	touched map[string]time.Time
+}
+
+// newAPIKeys - ключи из настроек и хранилища. Флаг enabled относится только
+// к ключам из конфигурации: выданные через /admin/api-keys действуют всегда.
+func newAPIKeys(cfg config.APIKeysConfig, store APIKeyStore) (*apiKeys, error) {
+	keys := &apiKeys{
+		store:   store,
+		static:  make(map[string]*APIKey),
+		touched: make(map[string]time.Time),
+	}
+	if !cfg.Enabled {
+		return keys, nil
+	}
+	for _, static := range cfg.Keys {
+		if static.Name == "" || static.Key == "" {
+			return nil, errors.New("api key name and key are required")
+		}
+		if err := validateScopes(static.Scopes); err != nil {
+			return nil, fmt.Errorf("api key %s: %w", static.Name, err)
+		}
+		keys.static[hashAPIKey(static.Key)] = &APIKey{
+			ID:        staticKeyPrefix + static.Name,
+			Name:      static.Name,
+			Scopes:    static.Scopes,
+			Accounts:  static.Accounts,
+			RateLimit: static.RateLimit,
+		}
+	}
+	return keys, nil
+}
+
+// hashAPIKey - хеш секрета ключа. Секрет случайный и длинный,
+// поэтому достаточно SHA-256 без соли, и ключ ищется по хешу.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// validateScopes - проверка списка областей
+func validateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if _, known := scopeRoles[scope]; !known {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// ScopeRoles - роли, которые дают области ключа
+func ScopeRoles(scopes []string) []string {
+	roles := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if role, known := scopeRoles[scope]; known {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// CreateAPIKey - выдача ключа. Секрет возвращается только здесь.
+func (s *Service) CreateAPIKey(ctx context.Context, req NewAPIKey, createdBy string) (string, *APIKey, error) {
+	if req.Name == "" {
+		return "", nil, errors.New("api key name is required")
+	}
+	if err := validateScopes(req.Scopes); err != nil {
+		return "", nil, err
+	}
+	if req.RateLimit < 0 {
+		return "", nil, errors.New("rate_limit must not be negative")
+	}
+	now := time.Now()
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		return "", nil, errors.New("expires_at must be in the future")
+	}
+
+	secret := apiKeyPrefix + randomID() + randomID()
+	accounts := req.Accounts
+	if accounts == nil {
+		accounts = []string{}
+	}
+	key := APIKey{
+		ID:        "key_" + randomID(),
+		Name:      req.Name,
+		Prefix:    secret[:len(apiKeyPrefix)+8],
+		KeyHash:   hashAPIKey(secret),
+		Scopes:    req.Scopes,
+		Accounts:  accounts,
+		RateLimit: req.RateLimit,
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := s.apiKeys.store.CreateAPIKey(ctx, key); err != nil {
+		return "", nil, err
+	}
+	return secret, &key, nil
+}
+
+// ListAPIKeys - выданные ключи без секретов
+func (s *Service) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	return s.apiKeys.store.ListAPIKeys(ctx)
+}
+
+// RevokeAPIKey - отзыв ключа, действует со следующего запроса
+func (s *Service) RevokeAPIKey(ctx context.Context, id string) error {
+	return s.apiKeys.store.RevokeAPIKey(ctx, id, time.Now())
+}
+
+// AuthenticateAPIKey - субъект запроса по API ключу
+func (s *Service) AuthenticateAPIKey(ctx context.Context, secret string) (*Principal, error) {
+	keys := s.apiKeys
+	hash := hashAPIKey(secret)
+	key, err := keys.lookup(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if key == nil || !key.Active(now) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if keys.shouldTouch(key.ID, now) {
+		if err := keys.store.TouchAPIKey(ctx, key.ID, now); err != nil {
+			s.logger.Warnf("Failed to update api key %s usage: %v", key.ID, err)
+		}
+	}
+
+	return &Principal{
+		Username:  key.Name,
+		Roles:     ScopeRoles(key.Scopes),
+		APIKey:    true,
+		APIKeyID:  key.ID,
+		Accounts:  key.Accounts,
+		RateLimit: key.RateLimit,
+	}, nil
+}
+
+// lookup - ключ из конфигурации или хранилища по хешу
+func (k *apiKeys) lookup(ctx context.Context, hash string) (*APIKey, error) {
+	if key, exists := k.static[hash]; exists {
+		return key, nil
+	}
+	return k.store.APIKeyByHash(ctx, hash)
+}
+
+// shouldTouch - пора ли сохранить использование ключа.
+// Ключи из конфигурации не сохраняются.
+func (k *apiKeys) shouldTouch(id string, now time.Time) bool {
+	if strings.HasPrefix(id, staticKeyPrefix) {
+		return false
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if last, exists := k.touched[id]; exists && now.Sub(last) < touchInterval {
+		return false
+	}
+	// Использование уже сохранено в базе, старые отметки больше не нужны
+	for touchedID, last := range k.touched {
+		if now.Sub(last) >= touchInterval {
+			delete(k.touched, touchedID)
+		}
+	}
+	k.touched[id] = now
+	return true
+}
@@ -0,0 +1,75 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"trading-bot-web/auth"
+	"trading-bot-web/config"
+	"trading-bot-web/storage"
+)
+
+// newTestService - сервис с настройками из config.yaml и пустой базой SQLite
+func newTestService(t *testing.T) (*auth.Service, *storage.SQLStore) {
+	t.Helper()
+	ctx := context.Background()
+
+	cfg, err := config.Load("../config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.OpenSQLite(filepath.Join(t.TempDir(), "auth.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	if _, err := store.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	service, err := auth.NewService(ctx, cfg.Security, store, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service, store
+}
+
+func TestCreatedAPIKeyAuthenticates(t *testing.T) {
+	ctx := context.Background()
+	service, _ := newTestService(t)
+
+	secret, key, err := service.CreateAPIKey(ctx, auth.NewAPIKey{
+		Name:     "monitoring",
+		Scopes:   []string{auth.ScopeRead},
+		Accounts: []string{"acc"},
+	}, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	principal, err := service.AuthenticateAPIKey(ctx, secret)
+	if err != nil {
+		t.Fatalf("AuthenticateAPIKey() error = %v", err)
+	}
+	if !principal.APIKey || principal.APIKeyID != key.ID {
+		t.Errorf("principal = %+v, want api key %s", principal, key.ID)
+	}
+	if len(principal.Roles) != 1 || principal.Roles[0] != auth.RoleViewer {
+		t.Errorf("roles = %v, want [%s]", principal.Roles, auth.RoleViewer)
+	}
+
+	if _, err := service.AuthenticateAPIKey(ctx, secret+"x"); !errors.Is(err, auth.ErrInvalidAPIKey) {
+		t.Errorf("wrong secret: error = %v, want %v", err, auth.ErrInvalidAPIKey)
+	}
+
+	if err := service.RevokeAPIKey(ctx, key.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.AuthenticateAPIKey(ctx, secret); !errors.Is(err, auth.ErrInvalidAPIKey) {
+		t.Errorf("revoked key: error = %v, want %v", err, auth.ErrInvalidAPIKey)
+	}
+}
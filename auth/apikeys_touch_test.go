@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"trading-bot-web/config"
+)
+
+func TestShouldTouchPrunesSavedKeys(t *testing.T) {
+	keys, err := newAPIKeys(config.APIKeysConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+
+	tests := []struct {
+		id   string
+		at   time.Duration
+		want bool
+		// wantTracked - ключи в карте после вызова
+		wantTracked []string
+	}{
+		{id: "key_a", at: 0, want: true, wantTracked: []string{"key_a"}},
+		{id: "key_a", at: 30 * time.Second, want: false, wantTracked: []string{"key_a"}},
+		{id: "key_b", at: 40 * time.Second, want: true, wantTracked: []string{"key_a", "key_b"}},
+		{id: "key_c", at: 70 * time.Second, want: true, wantTracked: []string{"key_b", "key_c"}},
+		{id: "key_a", at: 75 * time.Second, want: true, wantTracked: []string{"key_a", "key_b", "key_c"}},
+		{id: "config:static", at: 80 * time.Second, want: false, wantTracked: []string{"key_a", "key_b", "key_c"}},
+		{id: "key_d", at: 200 * time.Second, want: true, wantTracked: []string{"key_d"}},
+	}
+
+	for _, tt := range tests {
+		if got := keys.shouldTouch(tt.id, start.Add(tt.at)); got != tt.want {
+			t.Errorf("shouldTouch(%s, +%s) = %v, want %v", tt.id, tt.at, got, tt.want)
+		}
+		if len(keys.touched) != len(tt.wantTracked) {
+			t.Errorf("after %s at +%s tracked %v, want %v", tt.id, tt.at, keys.touched, tt.wantTracked)
+			continue
+		}
+		for _, id := range tt.wantTracked {
+			if _, exists := keys.touched[id]; !exists {
+				t.Errorf("after %s at +%s %s is not tracked", tt.id, tt.at, id)
+			}
+		}
+	}
+}
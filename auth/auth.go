@@ -0,0 +1,417 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+
+	"trading-bot-web/config"
+)
+
+// Типы токенов в claim typ
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrInvalidToken       = errors.New("invalid token")
+	ErrTokenRevoked       = errors.New("token revoked")
+	ErrUserNotFound       = errors.New("user not found")
+)
+
+// User - пользователь сервера
+type User struct {
+	ID           string    `json:"id"`
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"-"`
+	Roles        []string  `json:"roles"`
+	Disabled     bool      `json:"disabled"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// UserStore - хранилище пользователей
+type UserStore interface {
+	CreateUser(ctx context.Context, user User) error
+	// UserByUsername и UserByID возвращают nil, если пользователя нет
+	UserByUsername(ctx context.Context, username string) (*User, error)
+	UserByID(ctx context.Context, id string) (*User, error)
+	CountUsers(ctx context.Context) (int, error)
+	ListUsers(ctx context.Context) ([]User, error)
+	// UpdateUser - сохранение ролей и блокировки пользователя
+	UpdateUser(ctx context.Context, user User) error
+
+	// UserAccounts - брокерские счета, выданные пользователю
+	UserAccounts(ctx context.Context, userID string) ([]string, error)
+	GrantAccount(ctx context.Context, userID, accountID string) error
+	RevokeAccount(ctx context.Context, userID, accountID string) error
+}
+
+// TokenStore - хранилище отозванных токенов
+type TokenStore interface {
+	// RevokeToken - отзыв токена, false - токен уже был отозван. Проверка
+	// и отзыв атомарны, из параллельных вызовов true получает только один.
+	RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) (bool, error)
+	// RevokedTokens - отозванные токены, срок которых еще не истек
+	RevokedTokens(ctx context.Context) (map[string]time.Time, error)
+}
+
+// Claims - данные токена: ID пользователя в sub, ID токена в jti
+type Claims struct {
+	Username string   `json:"username"`
+	Roles    []string `json:"roles"`
+	Type     string   `json:"typ"`
+	jwt.RegisteredClaims
+}
+
+// UserID - пользователь, которому выдан токен
+func (c *Claims) UserID() string {
+	return c.Subject
+}
+
+// HasRole - есть ли у пользователя роль
+func (c *Claims) HasRole(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// TokenPair - выданные при входе или обновлении токены
+type TokenPair struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	RefreshExpiresIn int64  `json:"refresh_expires_in"`
+}
+
+// Store - хранилище пользователей, токенов и API ключей
+type Store interface {
+	UserStore
+	TokenStore
+	APIKeyStore
+}
+
+// Service - вход пользователей, выдача, проверка и отзыв токенов и API ключей
+type Service struct {
+	cfg     config.JWTConfig
+	keys    *keySet
+	apiKeys *apiKeys
+	users   UserStore
+	tokens  TokenStore
+	logger  *zap.SugaredLogger
+
+	mu      sync.RWMutex
+	revoked map[string]time.Time
+	// disabled - заблокированные пользователи, их токены отклоняются до истечения
+	disabled map[string]bool
+}
+
+// NewService - создание сервиса с загрузкой отозванных токенов
+func NewService(ctx context.Context, cfg config.SecurityConfig, store Store, logger *zap.SugaredLogger) (*Service, error) {
+	keys, err := newKeySet(cfg.JWT)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.JWT.Expiry <= 0 || cfg.JWT.RefreshExpiry <= 0 {
+		return nil, errors.New("jwt expiry and refresh_expiry must be positive")
+	}
+	apiKeys, err := newAPIKeys(cfg.APIKeys, store)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, err := store.RevokedTokens(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load revoked tokens: %w", err)
+	}
+	users, err := store.ListUsers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load users: %w", err)
+	}
+	disabled := make(map[string]bool)
+	for _, user := range users {
+		if user.Disabled {
+			disabled[user.ID] = true
+		}
+	}
+
+	return &Service{
+		cfg:      cfg.JWT,
+		keys:     keys,
+		apiKeys:  apiKeys,
+		users:    store,
+		tokens:   store,
+		logger:   logger,
+		revoked:  revoked,
+		disabled: disabled,
+	}, nil
+}
+
+// EnsureAdmin - создание администратора, если пользователей еще нет
+func (s *Service) EnsureAdmin(ctx context.Context, admin config.BootstrapAdminConfig) error {
+	count, err := s.users.CountUsers(ctx)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	if admin.Username == "" || admin.Password == "" {
+		s.logger.Warn("No users exist, set security.bootstrap_admin to create an administrator")
+		return nil
+	}
+
+	if _, err := s.CreateUser(ctx, admin.Username, admin.Password, []string{RoleAdmin}); err != nil {
+		return err
+	}
+	s.logger.Infof("Administrator %s created", admin.Username)
+	return nil
+}
+
+// CreateUser - создание пользователя с хешированием пароля
+func (s *Service) CreateUser(ctx context.Context, username, password string, roles []string) (*User, error) {
+	if username == "" {
+		return nil, errors.New("username is required")
+	}
+	if err := validateRoles(roles); err != nil {
+		return nil, err
+	}
+	existing, err := s.users.UserByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("user %s already exists", username)
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	user := User{
+		ID:           "usr_" + randomID(),
+		Username:     username,
+		PasswordHash: hash,
+		Roles:        roles,
+		CreatedAt:    time.Now(),
+	}
+	if err := s.users.CreateUser(ctx, user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Login - проверка пароля и выдача пары токенов
+func (s *Service) Login(ctx context.Context, username, password string) (*TokenPair, error) {
+	user, err := s.users.UserByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	// Хеш сравнивается и для несуществующего пользователя, чтобы время ответа не выдавало логины
+	if user == nil {
+		CheckPassword(dummyHash, password)
+		return nil, ErrInvalidCredentials
+	}
+	if !CheckPassword(user.PasswordHash, password) || user.Disabled {
+		return nil, ErrInvalidCredentials
+	}
+	return s.issue(user)
+}
+
+// Refresh - выдача новой пары токенов по refresh токену.
+// Использованный refresh токен отзывается; из параллельных запросов с одним
+// токеном новую пару получает только первый, остальные - ErrTokenRevoked.
+func (s *Service) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	claims, err := s.parse(refreshToken, TokenTypeRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.users.UserByID(ctx, claims.UserID())
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.Disabled {
+		return nil, ErrInvalidToken
+	}
+
+	first, err := s.revokeOnce(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+	if !first {
+		return nil, ErrTokenRevoked
+	}
+	return s.issue(user)
+}
+
+// Logout - отзыв access токена и, если передан, refresh токена
+func (s *Service) Logout(ctx context.Context, access *Claims, refreshToken string) error {
+	if access != nil {
+		if err := s.revoke(ctx, access); err != nil {
+			return err
+		}
+	}
+	if refreshToken == "" {
+		return nil
+	}
+
+	claims, err := s.parse(refreshToken, TokenTypeRefresh)
+	if errors.Is(err, ErrTokenRevoked) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if access != nil && claims.UserID() != access.UserID() {
+		return ErrInvalidToken
+	}
+	return s.revoke(ctx, claims)
+}
+
+// ValidateAccessToken - проверка подписи, срока и отзыва access токена
+// и блокировки его пользователя
+func (s *Service) ValidateAccessToken(token string) (*Claims, error) {
+	return s.parse(token, TokenTypeAccess)
+}
+
+// issue - подпись пары токенов для пользователя
+func (s *Service) issue(user *User) (*TokenPair, error) {
+	access, err := s.sign(user, TokenTypeAccess, s.cfg.Expiry)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := s.sign(user, TokenTypeRefresh, s.cfg.RefreshExpiry)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:      access,
+		RefreshToken:     refresh,
+		TokenType:        "Bearer",
+		ExpiresIn:        int64(s.cfg.Expiry.Seconds()),
+		RefreshExpiresIn: int64(s.cfg.RefreshExpiry.Seconds()),
+	}, nil
+}
+
+// sign - подпись токена активным ключом
+func (s *Service) sign(user *User, tokenType string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := Claims{
+		Username: user.Username,
+		Roles:    user.Roles,
+		Type:     tokenType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        randomID(),
+			Subject:   user.ID,
+			Issuer:    s.cfg.Issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+
+	kid, secret := s.keys.signingKey()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(secret)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	return signed, nil
+}
+
+// parse - проверка токена нужного типа
+func (s *Service) parse(token, tokenType string) (*Claims, error) {
+	claims := &Claims{}
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+	}
+	if s.cfg.Issuer != "" {
+		options = append(options, jwt.WithIssuer(s.cfg.Issuer))
+	}
+
+	_, err := jwt.ParseWithClaims(token, claims, s.keys.verificationKey, options...)
+	if err != nil || claims.Type != tokenType || claims.ID == "" {
+		return nil, ErrInvalidToken
+	}
+	if s.isRevoked(claims.ID) || s.isDisabled(claims.UserID()) {
+		return nil, ErrTokenRevoked
+	}
+	return claims, nil
+}
+
+// revoke - отзыв токена до истечения его срока
+func (s *Service) revoke(ctx context.Context, claims *Claims) error {
+	_, err := s.revokeOnce(ctx, claims)
+	return err
+}
+
+// revokeOnce - отзыв токена, false - его уже отозвал другой запрос
+func (s *Service) revokeOnce(ctx context.Context, claims *Claims) (bool, error) {
+	expiresAt := claims.ExpiresAt.Time
+	first, err := s.tokens.RevokeToken(ctx, claims.ID, expiresAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke token: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, exp := range s.revoked {
+		if exp.Before(now) {
+			delete(s.revoked, id)
+		}
+	}
+	s.revoked[claims.ID] = expiresAt
+	return first, nil
+}
+
+// isRevoked - отозван ли токен
+func (s *Service) isRevoked(tokenID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, revoked := s.revoked[tokenID]
+	return revoked
+}
+
+// isDisabled - заблокирован ли пользователь
+func (s *Service) isDisabled(userID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.disabled[userID]
+}
+
+// setDisabled - учет блокировки пользователя для проверки токенов
+func (s *Service) setDisabled(userID string, disabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if disabled {
+		s.disabled[userID] = true
+	} else {
+		delete(s.disabled, userID)
+	}
+}
+
+// randomID - случайный идентификатор в hex
+func randomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,70 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"trading-bot-web/auth"
+	"trading-bot-web/config"
+)
+
+func TestRefreshTokenIsUsedOnce(t *testing.T) {
+	ctx := context.Background()
+	service, store := newTestService(t)
+	if _, err := service.CreateUser(ctx, "trader", "correct horse battery", []string{auth.RoleTrader}); err != nil {
+		t.Fatal(err)
+	}
+	pair, err := service.Login(ctx, "trader", "correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const requests = 8
+	var wg sync.WaitGroup
+	errs := make([]error, requests)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = service.Refresh(ctx, pair.RefreshToken)
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, auth.ErrTokenRevoked):
+			t.Errorf("Refresh() error = %v, want %v", err, auth.ErrTokenRevoked)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d of %d concurrent refreshes succeeded, want 1", succeeded, requests)
+	}
+
+	// Другая реплика не знает об отзыве в памяти, но база не даст использовать токен снова
+	cfg, err := config.Load("../config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replica, err := auth.NewService(ctx, cfg.Security, store, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err = service.Login(ctx, "trader", "correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Refresh(ctx, pair.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := replica.Refresh(ctx, pair.RefreshToken); !errors.Is(err, auth.ErrTokenRevoked) {
+		t.Errorf("refresh on replica: error = %v, want %v", err, auth.ErrTokenRevoked)
+	}
+}
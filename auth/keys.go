@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"trading-bot-web/config"
+)
+
+// defaultKeyID - идентификатор ключа из security.jwt.secret
+const defaultKeyID = "default"
+
+// keySet - ключи подписи по kid: подпись активным, проверка любым
+type keySet struct {
+	keys   map[string][]byte
+	active string
+}
+
+// newKeySet - ключи из настроек
+func newKeySet(cfg config.JWTConfig) (*keySet, error) {
+	ks := &keySet{keys: make(map[string][]byte)}
+	if cfg.Secret != "" {
+		ks.keys[defaultKeyID] = []byte(cfg.Secret)
+	}
+	for _, key := range cfg.Keys {
+		if key.ID == "" || key.Secret == "" {
+			return nil, errors.New("jwt key id and secret are required")
+		}
+		if _, exists := ks.keys[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate jwt key %q", key.ID)
+		}
+		ks.keys[key.ID] = []byte(key.Secret)
+	}
+	if len(ks.keys) == 0 {
+		return nil, errors.New("jwt secret is not configured")
+	}
+
+	ks.active = cfg.ActiveKey
+	if ks.active == "" {
+		ks.active = defaultKeyID
+	}
+	if _, exists := ks.keys[ks.active]; !exists {
+		return nil, fmt.Errorf("active jwt key %q is not configured", ks.active)
+	}
+	return ks, nil
+}
+
+// signingKey - активный ключ подписи
+func (ks *keySet) signingKey() (string, []byte) {
+	return ks.active, ks.keys[ks.active]
+}
+
+// verificationKey - ключ проверки по kid из заголовка токена
+func (ks *keySet) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = defaultKeyID
+	}
+	key, exists := ks.keys[kid]
+	if !exists {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
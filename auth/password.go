@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// minPasswordLength - минимальная длина пароля
+const minPasswordLength = 8
+
+// dummyHash - хеш для сравнения, когда пользователь не найден
+var dummyHash, _ = HashPassword("dummy-password")
+
+// HashPassword - bcrypt-хеш пароля
+func HashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrPasswordTooLong) {
+			return "", errors.New("password is too long")
+		}
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// CheckPassword - совпадает ли пароль с хешем
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
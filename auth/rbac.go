@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+)
+
+// Роли пользователей. Администратору доступно все.
+const (
+	// RoleViewer - просмотр счетов, заявок, ботов и маркетдаты
+	RoleViewer = "viewer"
+	// RoleTrader - выставление и отмена заявок
+	RoleTrader = "trader"
+	// RoleBotOperator - создание и управление ботами
+	RoleBotOperator = "bot-operator"
+	RoleAdmin       = "admin"
+)
+
+// ValidRole - известна ли роль
+func ValidRole(role string) bool {
+	switch role {
+	case RoleViewer, RoleTrader, RoleBotOperator, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+// validateRoles - проверка списка ролей
+func validateRoles(roles []string) error {
+	for _, role := range roles {
+		if !ValidRole(role) {
+			return fmt.Errorf("unknown role %q", role)
+		}
+	}
+	return nil
+}
+
+// HasRole - есть ли среди ролей одна из требуемых; администратору доступно все
+func HasRole(roles []string, required ...string) bool {
+	for _, role := range roles {
+		if role == RoleAdmin {
+			return true
+		}
+		for _, r := range required {
+			if role == r {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Principal - субъект запроса: пользователь с JWT токеном или API ключ
+type Principal struct {
+	UserID   string   `json:"user_id,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Roles    []string `json:"roles"`
+	// APIKey - доступ по API ключу, без пользователя
+	APIKey   bool   `json:"api_key,omitempty"`
+	APIKeyID string `json:"api_key_id,omitempty"`
+	// Accounts - счета, доступные API ключу; пусто - все счета
+	Accounts []string `json:"accounts,omitempty"`
+	// RateLimit - собственный лимит запросов в минуту API ключа, 0 - только общий
+	RateLimit int `json:"rate_limit,omitempty"`
+}
+
+// PrincipalFromClaims - субъект запроса по access токену
+func PrincipalFromClaims(claims *Claims) *Principal {
+	return &Principal{
+		UserID:   claims.UserID(),
+		Username: claims.Username,
+		Roles:    claims.Roles,
+	}
+}
+
+// HasRole - есть ли у субъекта одна из ролей
+func (p *Principal) HasRole(required ...string) bool {
+	return p != nil && HasRole(p.Roles, required...)
+}
+
+// Name - имя субъекта для журналов
+func (p *Principal) Name() string {
+	switch {
+	case p == nil:
+		return "anonymous"
+	case p.APIKey:
+		return "api-key:" + p.Username
+	default:
+		return p.Username
+	}
+}
+
+// Subject - постоянный идентификатор субъекта: "key:<id>" или "user:<id>",
+// пустой для анонимного запроса
+func (p *Principal) Subject() string {
+	switch {
+	case p == nil:
+		return ""
+	case p.APIKeyID != "":
+		return "key:" + p.APIKeyID
+	case p.UserID != "":
+		return "user:" + p.UserID
+	default:
+		return ""
+	}
+}
+
+// CanAccessAccount - доступ субъекта к брокерскому счету.
+// Администратору доступны все счета, пользователю - выданные ему,
+// API ключу - перечисленные в нем или все, если список пуст.
+func (s *Service) CanAccessAccount(ctx context.Context, p *Principal, accountID string) (bool, error) {
+	if p == nil {
+		return false, nil
+	}
+	if p.HasRole(RoleAdmin) {
+		return true, nil
+	}
+
+	accounts := p.Accounts
+	if p.APIKey {
+		if len(accounts) == 0 {
+			return true, nil
+		}
+	} else {
+		var err error
+		accounts, err = s.users.UserAccounts(ctx, p.UserID)
+		if err != nil {
+			return false, err
+		}
+	}
+	for _, id := range accounts {
+		if id == accountID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// FilterAccounts - счета из списка, доступные субъекту
+func (s *Service) FilterAccounts(ctx context.Context, p *Principal, accountIDs []string) ([]string, error) {
+	allowed := make([]string, 0, len(accountIDs))
+	for _, id := range accountIDs {
+		ok, err := s.CanAccessAccount(ctx, p, id)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			allowed = append(allowed, id)
+		}
+	}
+	return allowed, nil
+}
+
+// UserWithAccounts - пользователь вместе с выданными счетами
+type UserWithAccounts struct {
+	User
+	Accounts []string `json:"accounts"`
+}
+
+// ListUsers - все пользователи с выданными счетами
+func (s *Service) ListUsers(ctx context.Context) ([]UserWithAccounts, error) {
+	users, err := s.users.ListUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]UserWithAccounts, 0, len(users))
+	for _, user := range users {
+		accounts, err := s.users.UserAccounts(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, UserWithAccounts{User: user, Accounts: accounts})
+	}
+	return result, nil
+}
+
+// UpdateUser - смена ролей и блокировка пользователя.
+// Выданные токены сохраняют прежние роли до обновления, блокировка действует сразу.
+func (s *Service) UpdateUser(ctx context.Context, userID string, roles []string, disabled bool) (*User, error) {
+	if err := validateRoles(roles); err != nil {
+		return nil, err
+	}
+	user, err := s.requireUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Roles = roles
+	user.Disabled = disabled
+	if err := s.users.UpdateUser(ctx, *user); err != nil {
+		return nil, err
+	}
+	s.setDisabled(user.ID, disabled)
+	return user, nil
+}
+
+// GrantAccount - выдача пользователю доступа к счету
+func (s *Service) GrantAccount(ctx context.Context, userID, accountID string) error {
+	if _, err := s.requireUser(ctx, userID); err != nil {
+		return err
+	}
+	return s.users.GrantAccount(ctx, userID, accountID)
+}
+
+// RevokeAccount - отзыв доступа пользователя к счету
+func (s *Service) RevokeAccount(ctx context.Context, userID, accountID string) error {
+	if _, err := s.requireUser(ctx, userID); err != nil {
+		return err
+	}
+	return s.users.RevokeAccount(ctx, userID, accountID)
+}
+
+// requireUser - пользователь по ID или ErrUserNotFound
+func (s *Service) requireUser(ctx context.Context, userID string) (*User, error) {
+	user, err := s.users.UserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
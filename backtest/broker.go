@@ -0,0 +1,197 @@
+package backtest
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+
+	"trading-bot-web/bots"
+	"trading-bot-web/money"
+)
+
+// simBroker - симуляция исполнения заявок по ценам исторических свечей.
+// Рыночные заявки исполняются по цене закрытия текущей свечи с проскальзыванием,
+// лимитные - когда цена последующих свечей достигает лимита.
+type simBroker struct {
+	cash          decimal.Decimal
+	commissionPct decimal.Decimal
+	slippagePct   decimal.Decimal
+	lotSize       int64
+
+	now       time.Time
+	prices    map[string]decimal.Decimal
+	positions map[string]int64
+	orders    map[string]*simOrder
+	fills     []bots.Fill
+	seq       int
+}
+
+// simOrder - активная лимитная заявка
+type simOrder struct {
+	id  string
+	req bots.OrderRequest
+}
+
+// newSimBroker - создание симулятора со стартовым капиталом
+func newSimBroker(initialCapital decimal.Decimal, commissionPct, slippagePct float64, lotSize int64) *simBroker {
+	return &simBroker{
+		cash:          initialCapital,
+		commissionPct: decimal.NewFromFloat(commissionPct),
+		slippagePct:   decimal.NewFromFloat(slippagePct),
+		lotSize:       lotSize,
+		prices:        make(map[string]decimal.Decimal),
+		positions:     make(map[string]int64),
+		orders:        make(map[string]*simOrder),
+	}
+}
+
+// PlaceOrder - исполнение или постановка заявки
+func (b *simBroker) PlaceOrder(ctx context.Context, req bots.OrderRequest) (*bots.OrderResult, error) {
+	price, exists := b.prices[req.InstrumentID]
+	if !exists {
+		return nil, fmt.Errorf("no price for %s yet", req.InstrumentID)
+	}
+	if req.Lots < 1 {
+		return nil, fmt.Errorf("lots must be positive")
+	}
+
+	b.seq++
+	orderID := fmt.Sprintf("bt_%d", b.seq)
+
+	if req.Price == nil {
+		slippage := price.Mul(b.slippagePct).Div(decimal.NewFromInt(100))
+		if req.Direction == pb.OrderDirection_ORDER_DIRECTION_BUY {
+			price = price.Add(slippage)
+		} else {
+			price = price.Sub(slippage)
+		}
+		return b.execute(orderID, req, price), nil
+	}
+
+	// Лимитная заявка, которая уже пересекает рынок, исполняется сразу
+	if (req.Direction == pb.OrderDirection_ORDER_DIRECTION_BUY && req.Price.GreaterThanOrEqual(price)) ||
+		(req.Direction == pb.OrderDirection_ORDER_DIRECTION_SELL && req.Price.LessThanOrEqual(price)) {
+		return b.execute(orderID, req, price), nil
+	}
+
+	b.orders[orderID] = &simOrder{id: orderID, req: req}
+	return &bots.OrderResult{
+		OrderID: orderID,
+		Status:  pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_NEW,
+	}, nil
+}
+
+// CancelOrder - снятие активной лимитной заявки
+func (b *simBroker) CancelOrder(ctx context.Context, orderID string) error {
+	if _, exists := b.orders[orderID]; !exists {
+		return fmt.Errorf("order %s not found", orderID)
+	}
+	delete(b.orders, orderID)
+	return nil
+}
+
+// AvailableMoney - свободные денежные средства симуляции
+func (b *simBroker) AvailableMoney(ctx context.Context, currency string) (decimal.Decimal, error) {
+	return b.cash, nil
+}
+
+// LotSize - размер лота из параметров бэктеста
+func (b *simBroker) LotSize(instrumentID string) int64 {
+	return b.lotSize
+}
+
+// onCandle - обновление цены и исполнение лимитных заявок по диапазону свечи
+func (b *simBroker) onCandle(instrumentID string, candle *pb.HistoricCandle) {
+	b.now = candle.GetTime().AsTime()
+	b.prices[instrumentID] = money.FromQuotation(candle.GetClose())
+
+	low, high := money.FromQuotation(candle.GetLow()), money.FromQuotation(candle.GetHigh())
+	for id, order := range b.orders {
+		if order.req.InstrumentID != instrumentID {
+			continue
+		}
+		limit := *order.req.Price
+		buyHit := order.req.Direction == pb.OrderDirection_ORDER_DIRECTION_BUY && low.LessThanOrEqual(limit)
+		sellHit := order.req.Direction == pb.OrderDirection_ORDER_DIRECTION_SELL && high.GreaterThanOrEqual(limit)
+		if !buyHit && !sellHit {
+			continue
+		}
+
+		result := b.execute(id, order.req, limit)
+		if result.Status != pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_NEW {
+			delete(b.orders, id)
+		}
+	}
+}
+
+// execute - исполнение заявки целиком по цене с учетом комиссии.
+// Симуляция моделирует счет без плеча: покупка ограничена деньгами,
+// продажа - имеющейся позицией.
+func (b *simBroker) execute(orderID string, req bots.OrderRequest, price decimal.Decimal) *bots.OrderResult {
+	quantity := req.Lots * b.lotSize
+	amount := price.Mul(decimal.NewFromInt(quantity))
+	commission := amount.Mul(b.commissionPct).Div(decimal.NewFromInt(100))
+
+	switch req.Direction {
+	case pb.OrderDirection_ORDER_DIRECTION_BUY:
+		if b.cash.LessThan(amount.Add(commission)) {
+			return rejected(orderID)
+		}
+		b.cash = b.cash.Sub(amount.Add(commission))
+		b.positions[req.InstrumentID] += quantity
+	case pb.OrderDirection_ORDER_DIRECTION_SELL:
+		if b.positions[req.InstrumentID] < quantity {
+			return rejected(orderID)
+		}
+		b.cash = b.cash.Add(amount.Sub(commission))
+		b.positions[req.InstrumentID] -= quantity
+	default:
+		return rejected(orderID)
+	}
+
+	b.fills = append(b.fills, bots.Fill{
+		OrderID:      orderID,
+		InstrumentID: req.InstrumentID,
+		Direction:    req.Direction,
+		Lots:         req.Lots,
+		Quantity:     quantity,
+		Price:        price,
+		Commission:   commission,
+		Time:         b.now,
+	})
+
+	return &bots.OrderResult{
+		OrderID:       orderID,
+		Status:        pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_FILL,
+		ExecutedLots:  req.Lots,
+		ExecutedPrice: price,
+		Commission:    commission,
+	}
+}
+
+// drainFills - исполнения, накопленные с прошлого вызова
+func (b *simBroker) drainFills() []bots.Fill {
+	fills := b.fills
+	b.fills = nil
+	return fills
+}
+
+// equity - оценка счета по последним ценам
+func (b *simBroker) equity() decimal.Decimal {
+	total := b.cash
+	for instrumentID, quantity := range b.positions {
+		total = total.Add(b.prices[instrumentID].Mul(decimal.NewFromInt(quantity)))
+	}
+	return total
+}
+
+// rejected - ответ на отклоненную заявку
+func rejected(orderID string) *bots.OrderResult {
+	return &bots.OrderResult{
+		OrderID: orderID,
+		Status:  pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_REJECTED,
+	}
+}
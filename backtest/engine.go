@@ -0,0 +1,390 @@
+package backtest
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+	"go.uber.org/zap"
+
+	"trading-bot-web/bots"
+)
+
+// Источники исторических данных
+const (
+	SourceAPI  = "api"
+	SourceFile = "file"
+)
+
+// tradingDay - примерная длительность основной торговой сессии для расчета Sharpe
+const tradingDay = 9 * time.Hour
+
+// Request - параметры бэктеста
+type Request struct {
+	Bot      bots.BotConfig `json:"bot" binding:"-"`
+	From     time.Time      `json:"from" binding:"required"`
+	To       time.Time      `json:"to" binding:"required"`
+	Interval string         `json:"interval"`
+
+	InitialCapital decimal.Decimal `json:"initial_capital"`
+	// CommissionPct - комиссия в процентах, по умолчанию trading.fees из config.yaml
+	CommissionPct *float64 `json:"commission_pct"`
+	SlippagePct   float64  `json:"slippage_pct"`
+	LotSize       int64    `json:"lot_size"`
+
+	// Source - api (по умолчанию) или file
+	Source      string `json:"source"`
+	CandlesFile string `json:"candles_file"`
+}
+
+// Result - результат бэктеста
+type Result struct {
+	InitialCapital decimal.Decimal `json:"initial_capital"`
+	FinalEquity    decimal.Decimal `json:"final_equity"`
+	TotalReturnPct float64         `json:"total_return_pct"`
+	MaxDrawdownPct float64         `json:"max_drawdown_pct"`
+	SharpeRatio    float64         `json:"sharpe_ratio"`
+	WinRate        float64         `json:"win_rate"`
+	Candles        int             `json:"candles"`
+	Trades         []Trade         `json:"trades"`
+	EquityCurve    []EquityPoint   `json:"equity_curve"`
+	Stats          bots.BotStats   `json:"stats"`
+	Warnings       []string        `json:"warnings,omitempty"`
+}
+
+// Trade - исполнение в бэктесте с реализованной прибылью
+type Trade struct {
+	bots.Fill
+	RealizedProfit decimal.Decimal `json:"realized_profit"`
+}
+
+// EquityPoint - точка кривой капитала
+type EquityPoint struct {
+	Time   time.Time       `json:"time"`
+	Equity decimal.Decimal `json:"equity"`
+}
+
+// Engine - движок бэктестов
+type Engine struct {
+	api                  HistorySource
+	historyDir           string
+	defaultCommissionPct float64
+	logger               *zap.SugaredLogger
+}
+
+// NewEngine - создание движка бэктестов
+func NewEngine(api HistorySource, historyDir string, defaultCommissionPct float64, logger *zap.SugaredLogger) *Engine {
+	return &Engine{
+		api:                  api,
+		historyDir:           historyDir,
+		defaultCommissionPct: defaultCommissionPct,
+		logger:               logger,
+	}
+}
+
+// Prepare - проверка запроса и заполнение значений по умолчанию
+func (e *Engine) Prepare(req *Request) error {
+	if len(req.Bot.Instruments) == 0 {
+		return fmt.Errorf("bot.instruments is required")
+	}
+	if _, err := bots.ParseStrategyConfig(req.Bot); err != nil {
+		return err
+	}
+	if !req.From.Before(req.To) {
+		return fmt.Errorf("from must be before to")
+	}
+	if _, _, err := ParseCandleInterval(req.Interval); err != nil {
+		return err
+	}
+
+	if req.InitialCapital.IsZero() {
+		req.InitialCapital = decimal.NewFromInt(100000)
+	}
+	if req.InitialCapital.IsNegative() {
+		return fmt.Errorf("initial_capital must be positive")
+	}
+	if req.CommissionPct == nil {
+		commission := e.defaultCommissionPct
+		req.CommissionPct = &commission
+	}
+	if *req.CommissionPct < 0 || req.SlippagePct < 0 {
+		return fmt.Errorf("commission_pct and slippage_pct must not be negative")
+	}
+	if req.LotSize == 0 {
+		req.LotSize = 1
+	}
+	if req.LotSize < 0 {
+		return fmt.Errorf("lot_size must be positive")
+	}
+
+	switch req.Source {
+	case "":
+		req.Source = SourceAPI
+	case SourceAPI, SourceFile:
+	default:
+		return fmt.Errorf("unknown source %q", req.Source)
+	}
+	return nil
+}
+
+// event - свеча одного инструмента в общей хронологии
+type event struct {
+	instrumentID string
+	candle       *pb.HistoricCandle
+}
+
+// Run - прогон стратегии по истории. Запрос должен быть подготовлен через Prepare.
+func (e *Engine) Run(ctx context.Context, req Request) (*Result, error) {
+	interval, intervalDuration, err := ParseCandleInterval(req.Interval)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := e.loadEvents(ctx, req, interval)
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, fmt.Errorf("no candles for the requested period")
+	}
+
+	strategy, err := bots.NewStrategy(req.Bot)
+	if err != nil {
+		return nil, err
+	}
+
+	broker := newSimBroker(req.InitialCapital, *req.CommissionPct, req.SlippagePct, req.LotSize)
+	logger := e.logger.With("backtest_bot", req.Bot.Name)
+	env := &bots.Env{
+		Bot:      req.Bot,
+		Executor: broker,
+		Logger:   logger,
+	}
+	if err := strategy.Init(ctx, env); err != nil {
+		return nil, fmt.Errorf("strategy init error: %w", err)
+	}
+
+	needs := strategy.Requirements()
+	run := &runState{
+		ledger:   bots.NewLedger(),
+		strategy: strategy,
+		broker:   broker,
+		logger:   logger,
+	}
+	result := &Result{
+		InitialCapital: req.InitialCapital,
+		Candles:        len(events),
+	}
+	if needs.OrderBook {
+		result.Warnings = append(result.Warnings, "order books are approximated from candle ranges")
+	}
+	if needs.Trades {
+		result.Warnings = append(result.Warnings, "anonymous trades are not available in candle history")
+	}
+
+	for _, ev := range events {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		broker.onCandle(ev.instrumentID, ev.candle)
+		run.deliverFills(ctx, true)
+
+		if needs.Candles {
+			run.check("OnCandle", strategy.OnCandle(ctx, ToStreamCandle(ev.instrumentID, ev.candle)))
+			run.deliverFills(ctx, true)
+		}
+		if needs.OrderBook {
+			run.check("OnOrderBook", strategy.OnOrderBook(ctx, SyntheticOrderBook(ev.instrumentID, ev.candle, needs.OrderBookDepth)))
+			run.deliverFills(ctx, true)
+		}
+
+		result.addEquityPoint(ev.candle.GetTime().AsTime(), broker.equity())
+	}
+
+	run.check("Shutdown", strategy.Shutdown(ctx))
+	run.deliverFills(ctx, false)
+	if len(result.EquityCurve) > 0 {
+		result.EquityCurve[len(result.EquityCurve)-1].Equity = broker.equity()
+	}
+
+	result.Trades = run.trades
+	result.Stats = run.ledger.Stats()
+	result.finalize(intervalDuration)
+	return result, nil
+}
+
+// loadEvents - загрузка свечей всех инструментов и сортировка по времени
+func (e *Engine) loadEvents(ctx context.Context, req Request, interval pb.CandleInterval) ([]event, error) {
+	source := e.api
+	if req.Source == SourceFile {
+		source = NewFileHistory(e.historyDir, req.CandlesFile)
+	}
+	if source == nil {
+		return nil, fmt.Errorf("history source %s is not available", req.Source)
+	}
+
+	var events []event
+	for _, instrumentID := range req.Bot.Instruments {
+		candles, err := source.Candles(ctx, instrumentID, interval, req.From, req.To)
+		if err != nil {
+			return nil, err
+		}
+		for _, candle := range candles {
+			if !candle.GetIsComplete() {
+				continue
+			}
+			events = append(events, event{instrumentID: instrumentID, candle: candle})
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].candle.GetTime().AsTime().Before(events[j].candle.GetTime().AsTime())
+	})
+	return events, nil
+}
+
+// runState - состояние прогона: учет сделок и доставка исполнений стратегии
+type runState struct {
+	ledger   *bots.Ledger
+	strategy bots.Strategy
+	broker   *simBroker
+	logger   *zap.SugaredLogger
+	trades   []Trade
+}
+
+// deliverFills - учет исполнений и, при notify, вызов OnOrderFill.
+// Как и живой бот, стратегия получает исполнения после возврата из обработчика.
+func (r *runState) deliverFills(ctx context.Context, notify bool) {
+	for {
+		fills := r.broker.drainFills()
+		if len(fills) == 0 {
+			return
+		}
+		for _, fill := range fills {
+			realized := r.ledger.Apply(fill)
+			r.trades = append(r.trades, Trade{Fill: fill, RealizedProfit: realized})
+			if notify {
+				r.check("OnOrderFill", r.strategy.OnOrderFill(ctx, fill))
+			}
+		}
+	}
+}
+
+// check - логирование ошибок стратегии, прогон при этом продолжается
+func (r *runState) check(hook string, err error) {
+	if err != nil {
+		r.logger.Warnf("Strategy %s error: %v", hook, err)
+	}
+}
+
+// addEquityPoint - добавление точки кривой капитала, одна точка на момент времени
+func (r *Result) addEquityPoint(t time.Time, equity decimal.Decimal) {
+	if n := len(r.EquityCurve); n > 0 && r.EquityCurve[n-1].Time.Equal(t) {
+		r.EquityCurve[n-1].Equity = equity
+		return
+	}
+	r.EquityCurve = append(r.EquityCurve, EquityPoint{Time: t, Equity: equity})
+}
+
+// finalize - расчет итоговых метрик по кривой капитала и сделкам.
+// Капитал считается точно, статистические метрики - в float64.
+func (r *Result) finalize(interval time.Duration) {
+	r.FinalEquity = r.InitialCapital
+	if n := len(r.EquityCurve); n > 0 {
+		r.FinalEquity = r.EquityCurve[n-1].Equity
+	}
+	initial := r.InitialCapital.InexactFloat64()
+	r.TotalReturnPct = (r.FinalEquity.InexactFloat64()/initial - 1) * 100
+
+	if r.Stats.TotalTrades > 0 {
+		r.WinRate = float64(r.Stats.WinningTrades) / float64(r.Stats.TotalTrades) * 100
+	}
+
+	peak := initial
+	returns := make([]float64, 0, len(r.EquityCurve))
+	prev := initial
+	for _, point := range r.EquityCurve {
+		equity := point.Equity.InexactFloat64()
+		if equity > peak {
+			peak = equity
+		}
+		if drawdown := (peak - equity) / peak * 100; drawdown > r.MaxDrawdownPct {
+			r.MaxDrawdownPct = drawdown
+		}
+		if prev != 0 {
+			returns = append(returns, equity/prev-1)
+		}
+		prev = equity
+	}
+
+	r.SharpeRatio = sharpeRatio(returns, periodsPerYear(interval))
+}
+
+// periodsPerYear - число интервалов в торговом году
+func periodsPerYear(interval time.Duration) float64 {
+	if interval >= 24*time.Hour {
+		return 252
+	}
+	return 252 * float64(tradingDay) / float64(interval)
+}
+
+// sharpeRatio - годовой коэффициент Шарпа без безрисковой ставки
+func sharpeRatio(returns []float64, periods float64) float64 {
+	if len(returns) < 2 {
+		return 0
+	}
+
+	mean := 0.0
+	for _, r := range returns {
+		mean += r
+	}
+	mean /= float64(len(returns))
+
+	variance := 0.0
+	for _, r := range returns {
+		variance += (r - mean) * (r - mean)
+	}
+	std := math.Sqrt(variance / float64(len(returns)-1))
+	if std == 0 {
+		return 0
+	}
+	return mean / std * math.Sqrt(periods)
+}
+
+// ToStreamCandle - историческая свеча в формате стрима, который получает живой бот
+func ToStreamCandle(instrumentID string, candle *pb.HistoricCandle) *pb.Candle {
+	return &pb.Candle{
+		Figi:   instrumentID,
+		Open:   candle.GetOpen(),
+		High:   candle.GetHigh(),
+		Low:    candle.GetLow(),
+		Close:  candle.GetClose(),
+		Volume: candle.GetVolume(),
+		Time:   candle.GetTime(),
+	}
+}
+
+// SyntheticOrderBook - приближение стакана по свече: объем делится между bid и ask
+// пропорционально положению цены закрытия в диапазоне свечи
+func SyntheticOrderBook(instrumentID string, candle *pb.HistoricCandle, depth int32) *pb.OrderBook {
+	volume := candle.GetVolume()
+	low, high, closePrice := candle.GetLow().ToFloat(), candle.GetHigh().ToFloat(), candle.GetClose().ToFloat()
+
+	bidVolume := volume / 2
+	if high > low {
+		bidVolume = int64(float64(volume) * (closePrice - low) / (high - low))
+	}
+
+	return &pb.OrderBook{
+		Figi:  instrumentID,
+		Depth: depth,
+		Bids:  []*pb.Order{{Price: candle.GetClose(), Quantity: bidVolume}},
+		Asks:  []*pb.Order{{Price: candle.GetClose(), Quantity: volume - bidVolume}},
+		Time:  candle.GetTime(),
+	}
+}
@@ -0,0 +1,148 @@
+package backtest
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/shopspring/decimal"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"trading-bot-web/broker"
+	"trading-bot-web/money"
+)
+
+// HistorySource - источник исторических свечей
+type HistorySource interface {
+	Candles(ctx context.Context, instrumentID string, interval pb.CandleInterval, from, to time.Time) ([]*pb.HistoricCandle, error)
+}
+
+// APIHistory - загрузка свечей через MarketDataProvider брокера
+type APIHistory struct {
+	marketData broker.MarketDataProvider
+}
+
+// NewAPIHistory - создание источника свечей из API брокера
+func NewAPIHistory(marketData broker.MarketDataProvider) *APIHistory {
+	return &APIHistory{marketData: marketData}
+}
+
+// Candles - загрузка свечей за период
+func (h *APIHistory) Candles(ctx context.Context, instrumentID string, interval pb.CandleInterval, from, to time.Time) ([]*pb.HistoricCandle, error) {
+	candles, err := h.marketData.GetCandles(ctx, instrumentID, interval, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load candles for %s: %w", instrumentID, err)
+	}
+	return candles, nil
+}
+
+// FileHistory - загрузка свечей из CSV-файлов.
+// Формат строки: time (RFC3339),open,high,low,close,volume; первая строка - заголовок.
+// Файл ищется как <dir>/<instrument>.csv, если в запросе не указан конкретный файл.
+type FileHistory struct {
+	dir  string
+	file string
+}
+
+// NewFileHistory - создание источника свечей из каталога с CSV-файлами
+func NewFileHistory(dir, file string) *FileHistory {
+	return &FileHistory{dir: dir, file: file}
+}
+
+// Candles - чтение свечей из файла с фильтрацией по периоду
+func (h *FileHistory) Candles(ctx context.Context, instrumentID string, interval pb.CandleInterval, from, to time.Time) ([]*pb.HistoricCandle, error) {
+	name := h.file
+	if name == "" {
+		name = instrumentID + ".csv"
+	}
+	// Не даем выйти за пределы каталога с историей
+	path := filepath.Join(h.dir, filepath.Clean("/"+name))
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open candles file: %w", err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = 6
+
+	var candles []*pb.HistoricCandle
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("candles file line %d: %w", line, err)
+		}
+		if line == 1 {
+			continue
+		}
+
+		candle, err := parseCandleRecord(record)
+		if err != nil {
+			return nil, fmt.Errorf("candles file line %d: %w", line, err)
+		}
+		t := candle.GetTime().AsTime()
+		if t.Before(from) || !t.Before(to) {
+			continue
+		}
+		candles = append(candles, candle)
+	}
+	return candles, nil
+}
+
+// parseCandleRecord - разбор строки CSV в свечу
+func parseCandleRecord(record []string) (*pb.HistoricCandle, error) {
+	t, err := time.Parse(time.RFC3339, record[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid time: %w", err)
+	}
+
+	prices := make([]*pb.Quotation, 4)
+	for i := range prices {
+		price, err := decimal.NewFromString(record[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid price: %w", err)
+		}
+		prices[i] = money.ToQuotation(price)
+	}
+	volume, err := strconv.ParseInt(record[5], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid volume: %w", err)
+	}
+
+	return &pb.HistoricCandle{
+		Open:       prices[0],
+		High:       prices[1],
+		Low:        prices[2],
+		Close:      prices[3],
+		Volume:     volume,
+		Time:       timestamppb.New(t),
+		IsComplete: true,
+	}, nil
+}
+
+// ParseCandleInterval - разбор интервала свечей и его длительности
+func ParseCandleInterval(interval string) (pb.CandleInterval, time.Duration, error) {
+	switch interval {
+	case "1min":
+		return pb.CandleInterval_CANDLE_INTERVAL_1_MIN, time.Minute, nil
+	case "5min":
+		return pb.CandleInterval_CANDLE_INTERVAL_5_MIN, 5 * time.Minute, nil
+	case "15min":
+		return pb.CandleInterval_CANDLE_INTERVAL_15_MIN, 15 * time.Minute, nil
+	case "hour":
+		return pb.CandleInterval_CANDLE_INTERVAL_HOUR, time.Hour, nil
+	case "day", "":
+		return pb.CandleInterval_CANDLE_INTERVAL_DAY, 24 * time.Hour, nil
+	}
+	return pb.CandleInterval_CANDLE_INTERVAL_UNSPECIFIED, 0, fmt.Errorf("unknown interval %q", interval)
+}
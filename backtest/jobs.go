@@ -0,0 +1,166 @@
+package backtest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// JobStatus - состояние задачи бэктеста
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobCompleted JobStatus = "completed"
+	JobFailed    JobStatus = "failed"
+)
+
+// Job - асинхронная задача бэктеста
+type Job struct {
+	ID         string     `json:"id"`
+	Status     JobStatus  `json:"status"`
+	Request    Request    `json:"request"`
+	Result     *Result    `json:"result,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// JobManager - очередь задач бэктеста с ограничением параллельности
+type JobManager struct {
+	engine *Engine
+	logger *zap.SugaredLogger
+	ctx    context.Context
+	cancel context.CancelFunc
+	slots  chan struct{}
+	wg     sync.WaitGroup
+
+	mu   sync.RWMutex
+	jobs map[string]*Job
+}
+
+// NewJobManager - создание менеджера задач
+func NewJobManager(engine *Engine, maxConcurrent int, logger *zap.SugaredLogger) *JobManager {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &JobManager{
+		engine: engine,
+		logger: logger,
+		ctx:    ctx,
+		cancel: cancel,
+		slots:  make(chan struct{}, maxConcurrent),
+		jobs:   make(map[string]*Job),
+	}
+}
+
+// Submit - проверка запроса и постановка задачи в очередь
+func (m *JobManager) Submit(req Request) (Job, error) {
+	if err := m.engine.Prepare(&req); err != nil {
+		return Job{}, err
+	}
+
+	job := &Job{
+		ID:        generateJobID(),
+		Status:    JobQueued,
+		Request:   req,
+		CreatedAt: time.Now(),
+	}
+
+	m.mu.Lock()
+	m.jobs[job.ID] = job
+	m.mu.Unlock()
+
+	m.wg.Add(1)
+	go m.run(job)
+
+	m.logger.Infof("Backtest %s queued for bot type %s", job.ID, req.Bot.Type)
+	return *job, nil
+}
+
+// Get - состояние задачи
+func (m *JobManager) Get(jobID string) (Job, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	job, exists := m.jobs[jobID]
+	if !exists {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// List - все задачи без результатов, от новых к старым
+func (m *JobManager) List() []Job {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	jobs := make([]Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		summary := *job
+		summary.Result = nil
+		jobs = append(jobs, summary)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.After(jobs[j].CreatedAt) })
+	return jobs
+}
+
+// Shutdown - отмена выполняющихся задач и ожидание их завершения
+func (m *JobManager) Shutdown() {
+	m.cancel()
+	m.wg.Wait()
+}
+
+// run - выполнение задачи при наличии свободного слота
+func (m *JobManager) run(job *Job) {
+	defer m.wg.Done()
+
+	select {
+	case m.slots <- struct{}{}:
+		defer func() { <-m.slots }()
+	case <-m.ctx.Done():
+		m.finish(job, nil, m.ctx.Err())
+		return
+	}
+
+	started := time.Now()
+	m.mu.Lock()
+	job.Status = JobRunning
+	job.StartedAt = &started
+	m.mu.Unlock()
+
+	result, err := m.engine.Run(m.ctx, job.Request)
+	m.finish(job, result, err)
+}
+
+// finish - сохранение результата задачи
+func (m *JobManager) finish(job *Job, result *Result, err error) {
+	finished := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job.FinishedAt = &finished
+	if err != nil {
+		job.Status = JobFailed
+		job.Error = err.Error()
+		m.logger.Warnf("Backtest %s failed: %v", job.ID, err)
+		return
+	}
+	job.Status = JobCompleted
+	job.Result = result
+	m.logger.Infof("Backtest %s completed: %d trades, return %.2f%%", job.ID, len(result.Trades), result.TotalReturnPct)
+}
+
+// generateJobID - генерация ID задачи
+func generateJobID() string {
+	return fmt.Sprintf("bt_%d", time.Now().UnixNano())
+}
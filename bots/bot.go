@@ -0,0 +1,580 @@
+package bots
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+	"go.uber.org/zap"
+)
+
+// BotState - состояние жизненного цикла бота
+type BotState string
+
+const (
+	BotStateStopped BotState = "stopped"
+	BotStateRunning BotState = "running"
+	BotStatePaused  BotState = "paused"
+)
+
+// Режимы исполнения заявок
+const (
+	ExecutionModeLive  = "live"
+	ExecutionModePaper = "paper"
+)
+
+// BotConfig - конфигурация торгового бота.
+// Параметры стратегии передаются в разделе "<type>_config", например
+// "orderbook_config" для бота с типом "orderbook".
+type BotConfig struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name" binding:"required"`
+	Type        string   `json:"type" binding:"required"`
+	AccountID   string   `json:"account_id" binding:"required"`
+	Instruments []string `json:"instruments" binding:"required,min=1"`
+	Currency    string   `json:"currency"`
+	// ExecutionMode - live (по умолчанию) или paper
+	ExecutionMode string `json:"execution_mode,omitempty"`
+	// OwnerID - пользователь, создавший бота; пусто для ботов, созданных по API ключу
+	OwnerID   string    `json:"owner_id,omitempty"`
+	IsActive  bool      `json:"is_active"`
+	State     BotState  `json:"state,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// StrategyParams - сырой JSON раздела "<type>_config"
+	StrategyParams json.RawMessage `json:"-"`
+}
+
+// UnmarshalJSON - разбор конфигурации с разделом параметров стратегии
+func (c *BotConfig) UnmarshalJSON(data []byte) error {
+	type plainConfig BotConfig
+	var config plainConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return err
+	}
+
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(data, &sections); err != nil {
+		return err
+	}
+	if config.Type != "" {
+		config.StrategyParams = sections[strategyConfigKey(config.Type)]
+	}
+
+	*c = BotConfig(config)
+	return nil
+}
+
+// MarshalJSON - сериализация конфигурации вместе с разделом параметров стратегии
+func (c BotConfig) MarshalJSON() ([]byte, error) {
+	type plainConfig BotConfig
+	data, err := json.Marshal(plainConfig(c))
+	if err != nil || c.Type == "" || len(c.StrategyParams) == 0 {
+		return data, err
+	}
+
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(data, &sections); err != nil {
+		return nil, err
+	}
+	sections[strategyConfigKey(c.Type)] = c.StrategyParams
+	return json.Marshal(sections)
+}
+
+// Bot - запущенный экземпляр торгового бота
+type Bot struct {
+	id       string
+	config   BotConfig
+	executor Executor
+	feed     MarketFeed
+	store    Store
+	logger   *zap.SugaredLogger
+
+	mu          sync.RWMutex
+	state       BotState
+	strategy    Strategy
+	filling     *fillingExecutor
+	ledger      *Ledger
+	orders      *openOrders
+	startedAt   time.Time
+	runningTime time.Duration
+
+	// strategyState - сохраненное состояние стратегии, передается ей при запуске
+	strategyState json.RawMessage
+	// missedFills - исполнения, найденные при сверке с брокером, доставляются после запуска
+	missedFills []Fill
+
+	fills  chan Fill
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newBot - создание бота в остановленном состоянии
+func newBot(config BotConfig, executor Executor, feed MarketFeed, store Store, logger *zap.SugaredLogger) *Bot {
+	return &Bot{
+		id:       config.ID,
+		config:   config,
+		executor: executor,
+		feed:     feed,
+		store:    store,
+		logger:   logger.With("bot_id", config.ID),
+		state:    BotStateStopped,
+		ledger:   NewLedger(),
+		orders:   newOpenOrders(nil),
+	}
+}
+
+// ID - идентификатор бота
+func (b *Bot) ID() string {
+	return b.id
+}
+
+// Config - текущая конфигурация бота с актуальным состоянием
+func (b *Bot) Config() BotConfig {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	config := b.config
+	config.State = b.state
+	config.IsActive = b.state != BotStateStopped
+	return config
+}
+
+// State - текущее состояние бота
+func (b *Bot) State() BotState {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.state
+}
+
+// Pause - приостановка обработки рыночных данных.
+// Исполнения уже выставленных заявок продолжают учитываться.
+func (b *Bot) Pause() error {
+	b.mu.Lock()
+	if b.state != BotStateRunning {
+		b.mu.Unlock()
+		return fmt.Errorf("bot %s is not running", b.id)
+	}
+	b.state = BotStatePaused
+	b.mu.Unlock()
+
+	b.persist()
+	b.logger.Info("Bot paused")
+	return nil
+}
+
+// Resume - возобновление работы после паузы
+func (b *Bot) Resume() error {
+	b.mu.Lock()
+	if b.state != BotStatePaused {
+		b.mu.Unlock()
+		return fmt.Errorf("bot %s is not paused", b.id)
+	}
+	b.state = BotStateRunning
+	b.mu.Unlock()
+
+	b.persist()
+	b.logger.Info("Bot resumed")
+	return nil
+}
+
+// Stats - статистика бота
+func (b *Bot) Stats() BotStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	stats := b.ledger.Stats()
+	stats.BotID = b.id
+	stats.RunningTime = b.runningTime
+	if b.state != BotStateStopped {
+		stats.RunningTime += time.Since(b.startedAt)
+	}
+	return stats
+}
+
+// Positions - открытые позиции бота по инструментам
+func (b *Bot) Positions() map[string]Position {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.ledger.Snapshot().Positions
+}
+
+// OpenOrders - заявки бота, ожидающие исполнения
+func (b *Bot) OpenOrders() []OpenOrder {
+	return b.orders.list()
+}
+
+// start - запуск бота с сохранением состояния
+func (b *Bot) start() error {
+	if err := b.launch(); err != nil {
+		return err
+	}
+	b.persist()
+	return nil
+}
+
+// launch - запуск стратегии на потоке рыночных данных
+func (b *Bot) launch() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != BotStateStopped {
+		return fmt.Errorf("bot %s is already started", b.id)
+	}
+
+	strategy, err := NewStrategy(b.config)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	fills := make(chan Fill, 64)
+	filling := &fillingExecutor{Executor: b.executor, orders: b.orders}
+	env := &Env{
+		Bot:      b.config,
+		Executor: filling,
+		Logger:   b.logger,
+	}
+	if err := strategy.Init(ctx, env); err != nil {
+		cancel()
+		return fmt.Errorf("strategy init error: %w", err)
+	}
+	if stateful, ok := strategy.(StatefulStrategy); ok && len(b.strategyState) > 0 {
+		if err := stateful.RestoreState(b.strategyState); err != nil {
+			cancel()
+			return fmt.Errorf("strategy state restore error: %w", err)
+		}
+	}
+	b.strategyState = nil
+
+	feed, err := b.feed.Subscribe(ctx, b.config.Instruments, strategy.Requirements())
+	if err != nil {
+		cancel()
+		return fmt.Errorf("market data subscription error: %w", err)
+	}
+
+	// Исполнения отложенных заявок приходят от исполнителя асинхронно
+	if notifier, ok := b.executor.(FillNotifier); ok {
+		notifier.SetFillHandler(func(fill Fill) {
+			select {
+			case fills <- fill:
+			case <-ctx.Done():
+			}
+		})
+	}
+
+	b.strategy = strategy
+	b.filling = filling
+	b.fills = fills
+	b.cancel = cancel
+	b.done = make(chan struct{})
+	b.state = BotStateRunning
+	b.startedAt = time.Now()
+
+	go b.run(ctx, feed)
+
+	b.logger.Infof("Bot started with strategy %s", b.config.Type)
+	return nil
+}
+
+// stop - остановка бота с сохранением состояния
+func (b *Bot) stop() error {
+	if err := b.halt(); err != nil {
+		return err
+	}
+	b.persist()
+	return nil
+}
+
+// halt - остановка стратегии без сохранения состояния бота.
+// Используется при завершении сервера, чтобы в хранилище осталось
+// состояние на момент остановки.
+func (b *Bot) halt() error {
+	b.mu.Lock()
+	if b.state == BotStateStopped {
+		b.mu.Unlock()
+		return fmt.Errorf("bot %s is not running", b.id)
+	}
+	cancel, done := b.cancel, b.done
+	b.mu.Unlock()
+
+	cancel()
+	<-done
+
+	b.mu.Lock()
+	b.runningTime += time.Since(b.startedAt)
+	b.state = BotStateStopped
+	b.strategy = nil
+	b.filling = nil
+	b.mu.Unlock()
+
+	b.persistStats()
+
+	b.logger.Info("Bot stopped")
+	return nil
+}
+
+// run - основной цикл бота: доставка событий стратегии
+func (b *Bot) run(ctx context.Context, f *Feed) {
+	defer close(b.done)
+	defer f.Close()
+
+	candles, orderBooks, trades := f.Candles, f.OrderBooks, f.Trades
+
+	// Исполнения, пропущенные за время остановки сервера
+	b.mu.Lock()
+	missed := b.missedFills
+	b.missedFills = nil
+	b.mu.Unlock()
+	for _, fill := range missed {
+		b.handleFill(ctx, fill)
+	}
+	b.deliverQueued(ctx)
+
+	ticker := time.NewTicker(snapshotInterval)
+	defer ticker.Stop()
+
+	// Исполнитель без уведомлений об исполнении опрашивается по открытым заявкам
+	var poll <-chan time.Time
+	states, polled := b.executor.(orderStateSource)
+	if polled {
+		pollTicker := time.NewTicker(orderPollInterval)
+		defer pollTicker.Stop()
+		poll = pollTicker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			b.shutdownStrategy()
+			return
+
+		case <-ticker.C:
+			b.persistSnapshot()
+
+		case <-poll:
+			b.pollOrders(ctx, states)
+
+		case fill := <-b.fills:
+			b.handleFill(ctx, fill)
+
+		case candle, ok := <-candles:
+			if !ok {
+				candles = nil
+				continue
+			}
+			if b.State() == BotStateRunning {
+				b.dispatch("OnCandle", b.strategy.OnCandle(ctx, candle))
+			}
+			b.deliverQueued(ctx)
+
+		case orderBook, ok := <-orderBooks:
+			if !ok {
+				orderBooks = nil
+				continue
+			}
+			if b.State() == BotStateRunning {
+				b.dispatch("OnOrderBook", b.strategy.OnOrderBook(ctx, orderBook))
+			}
+			b.deliverQueued(ctx)
+
+		case trade, ok := <-trades:
+			if !ok {
+				trades = nil
+				continue
+			}
+			if b.State() == BotStateRunning {
+				b.dispatch("OnTrade", b.strategy.OnTrade(ctx, trade))
+			}
+			b.deliverQueued(ctx)
+		}
+	}
+}
+
+// shutdownStrategy - вызов Shutdown и учет исполнений, пришедших во время остановки
+func (b *Bot) shutdownStrategy() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	b.dispatch("Shutdown", b.strategy.Shutdown(ctx))
+
+	// Стратегия уже остановлена, поэтому исполнения только учитываются
+	for _, fill := range b.filling.drain() {
+		b.applyFill(fill)
+	}
+	for {
+		select {
+		case fill := <-b.fills:
+			b.applyFill(fill)
+		default:
+			b.persistSnapshot()
+			return
+		}
+	}
+}
+
+// handleFill - учет исполнения и уведомление стратегии
+func (b *Bot) handleFill(ctx context.Context, fill Fill) {
+	b.applyFill(fill)
+	b.dispatch("OnOrderFill", b.strategy.OnOrderFill(ctx, fill))
+	b.persistSnapshot()
+	b.deliverQueued(ctx)
+}
+
+// deliverQueued - доставка исполнений, поставленных в очередь обработчиками
+// стратегии, в том числе вызванными из OnOrderFill
+func (b *Bot) deliverQueued(ctx context.Context) {
+	for {
+		fills := b.filling.drain()
+		if len(fills) == 0 {
+			return
+		}
+		for _, fill := range fills {
+			b.applyFill(fill)
+			b.dispatch("OnOrderFill", b.strategy.OnOrderFill(ctx, fill))
+		}
+		b.persistSnapshot()
+	}
+}
+
+// pollOrders - исполнения открытых заявок по их состоянию у брокера: лимитная
+// заявка может исполниться после возврата из PlaceOrder. Завершенные заявки
+// (исполненные, снятые, отклоненные) убираются из учета.
+func (b *Bot) pollOrders(ctx context.Context, states orderStateSource) {
+	for _, order := range b.orders.list() {
+		state, err := states.OrderState(ctx, order.OrderID)
+		if err != nil {
+			b.logger.Warnf("Failed to get state of order %s: %v", order.OrderID, err)
+			continue
+		}
+		if delta := state.GetLotsExecuted() - order.LotsExecuted; delta > 0 {
+			b.handleFill(ctx, executionFill(b, order, state, delta))
+		}
+		if !orderActive(state.GetExecutionReportStatus()) {
+			b.orders.remove(order.OrderID)
+		}
+	}
+}
+
+// applyFill - учет исполнения в позициях и открытых заявках с сохранением
+func (b *Bot) applyFill(fill Fill) {
+	b.mu.Lock()
+	b.ledger.Apply(fill)
+	b.mu.Unlock()
+	b.orders.filled(fill)
+	b.persistFill(fill)
+}
+
+// dispatch - логирование ошибок обработчиков стратегии
+func (b *Bot) dispatch(hook string, err error) {
+	if err != nil {
+		b.logger.Errorf("Strategy %s error: %v", hook, err)
+	}
+}
+
+// fillingExecutor - обертка исполнителя, ставящая исполнения в очередь бота.
+// Стратегия получает OnOrderFill после возврата из текущего обработчика.
+// Очередь не ограничена: PlaceOrder вызывается из горутины бота, которая
+// ее и разбирает, поэтому блокироваться на ней нельзя.
+type fillingExecutor struct {
+	Executor
+	orders *openOrders
+
+	mu     sync.Mutex
+	queued []Fill
+}
+
+// PlaceOrder - выставление заявки с постановкой исполнения в очередь
+func (e *fillingExecutor) PlaceOrder(ctx context.Context, req OrderRequest) (*OrderResult, error) {
+	result, err := e.Executor.PlaceOrder(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Заявка остается открытой до полного исполнения, в том числе
+	// исполнением, которое ставится в очередь ниже
+	if orderActive(result.Status) {
+		e.orders.add(OpenOrder{
+			OrderID:      result.OrderID,
+			InstrumentID: req.InstrumentID,
+			Direction:    req.Direction,
+			Lots:         req.Lots,
+			Price:        req.Price,
+		})
+	}
+
+	if result.ExecutedLots > 0 {
+		e.mu.Lock()
+		e.queued = append(e.queued, Fill{
+			OrderID:      result.OrderID,
+			InstrumentID: req.InstrumentID,
+			Direction:    req.Direction,
+			Lots:         result.ExecutedLots,
+			Quantity:     result.ExecutedLots * lotSizeOf(e.Executor, req.InstrumentID),
+			Price:        result.ExecutedPrice,
+			Commission:   result.Commission,
+			Time:         time.Now(),
+		})
+		e.mu.Unlock()
+	}
+	return result, nil
+}
+
+// drain - исполнения, поставленные в очередь с прошлого вызова
+func (e *fillingExecutor) drain() []Fill {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	fills := e.queued
+	e.queued = nil
+	return fills
+}
+
+// CancelOrder - отмена заявки со снятием ее с учета открытых
+func (e *fillingExecutor) CancelOrder(ctx context.Context, orderID string) error {
+	if err := e.Executor.CancelOrder(ctx, orderID); err != nil {
+		return err
+	}
+	e.orders.remove(orderID)
+	return nil
+}
+
+// FillNotifier - исполнитель, сообщающий об исполнении ранее выставленных заявок,
+// например лимитных, исполненных после возврата из PlaceOrder
+type FillNotifier interface {
+	SetFillHandler(handler func(Fill))
+}
+
+// orderStateSource - исполнитель, у которого можно запросить состояние заявки.
+// Открытые заявки такого исполнителя опрашиваются раз в orderPollInterval.
+type orderStateSource interface {
+	OrderState(ctx context.Context, orderID string) (*pb.OrderState, error)
+}
+
+// orderActive - ожидает ли заявка в этом статусе дальнейшего исполнения
+func orderActive(status pb.OrderExecutionReportStatus) bool {
+	switch status {
+	case pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_NEW,
+		pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_PARTIALLYFILL:
+		return true
+	}
+	return false
+}
+
+// lotSizer - исполнитель, знающий размер лота инструмента
+type lotSizer interface {
+	LotSize(instrumentID string) int64
+}
+
+// lotSizeOf - размер лота инструмента или 1, если исполнитель его не знает
+func lotSizeOf(executor Executor, instrumentID string) int64 {
+	if sizer, ok := executor.(lotSizer); ok {
+		if lot := sizer.LotSize(instrumentID); lot > 0 {
+			return lot
+		}
+	}
+	return 1
+}
@@ -0,0 +1,113 @@
+package bots
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/shopspring/decimal"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+
+	"trading-bot-web/broker"
+	"trading-bot-web/money"
+)
+
+// liveExecutor - исполнение заявок бота через API брокера
+type liveExecutor struct {
+	botID     string
+	accountID string
+	broker    broker.Broker
+
+	mu   sync.Mutex
+	lots map[string]int64
+}
+
+// newLiveExecutor - создание исполнителя бота для аккаунта
+func newLiveExecutor(brk broker.Broker, botID, accountID string) *liveExecutor {
+	return &liveExecutor{
+		botID:     botID,
+		accountID: accountID,
+		broker:    brk,
+		lots:      make(map[string]int64),
+	}
+}
+
+// PlaceOrder - выставление заявки через OrderGateway
+func (e *liveExecutor) PlaceOrder(ctx context.Context, req OrderRequest) (*OrderResult, error) {
+	orderType := pb.OrderType_ORDER_TYPE_MARKET
+	if req.Price != nil {
+		orderType = pb.OrderType_ORDER_TYPE_LIMIT
+	}
+
+	resp, err := e.broker.PostOrder(ctx, broker.OrderRequest{
+		AccountID:    e.accountID,
+		InstrumentID: req.InstrumentID,
+		Direction:    req.Direction,
+		OrderType:    orderType,
+		Lots:         req.Lots,
+		Price:        req.Price,
+		BotID:        e.botID,
+		Origin:       broker.OriginBot,
+		OriginID:     e.botID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &OrderResult{
+		OrderID:       resp.GetOrderId(),
+		Status:        resp.GetExecutionReportStatus(),
+		ExecutedLots:  resp.GetLotsExecuted(),
+		ExecutedPrice: money.FromMoneyValue(resp.GetExecutedOrderPrice()),
+		Commission:    money.FromMoneyValue(resp.GetExecutedCommission()),
+	}, nil
+}
+
+// CancelOrder - отмена заявки
+func (e *liveExecutor) CancelOrder(ctx context.Context, orderID string) error {
+	_, err := e.broker.CancelOrder(ctx, e.accountID, orderID)
+	return err
+}
+
+// OrderState - состояние заявки у брокера, по нему бот узнает об исполнении
+// лимитных заявок после возврата из PlaceOrder
+func (e *liveExecutor) OrderState(ctx context.Context, orderID string) (*pb.OrderState, error) {
+	return e.broker.GetOrderState(ctx, e.accountID, orderID)
+}
+
+// AvailableMoney - доступные денежные средства в валюте
+func (e *liveExecutor) AvailableMoney(ctx context.Context, currency string) (decimal.Decimal, error) {
+	positions, err := e.broker.GetPositions(ctx, e.accountID)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	total := decimal.Zero
+	for _, balance := range positions.GetMoney() {
+		if strings.EqualFold(balance.GetCurrency(), currency) {
+			total = total.Add(money.FromMoneyValue(balance))
+		}
+	}
+	return total, nil
+}
+
+// LotSize - размер лота инструмента, запрашивается один раз; запрос к брокеру
+// идет без блокировки
+func (e *liveExecutor) LotSize(instrumentID string) int64 {
+	e.mu.Lock()
+	lot, exists := e.lots[instrumentID]
+	e.mu.Unlock()
+	if exists {
+		return lot
+	}
+
+	instrument, err := e.broker.InstrumentByFigi(context.Background(), instrumentID)
+	if err != nil {
+		return 1
+	}
+	lot = int64(instrument.GetLot())
+	e.mu.Lock()
+	e.lots[instrumentID] = lot
+	e.mu.Unlock()
+	return lot
+}
@@ -0,0 +1,88 @@
+package bots
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/tinkoff/invest-api-go-sdk/investgo"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+	"go.uber.org/zap"
+
+	"trading-bot-web/broker"
+	"trading-bot-web/streams"
+)
+
+// MarketFeed - источник рыночных данных для ботов
+type MarketFeed interface {
+	Subscribe(ctx context.Context, instruments []string, req DataRequest) (*Feed, error)
+}
+
+// Feed - подписка бота на рыночные данные.
+// Каналы, на которые стратегия не подписывалась, равны nil.
+type Feed struct {
+	Candles    <-chan *pb.Candle
+	OrderBooks <-chan *pb.OrderBook
+	Trades     <-chan *pb.Trade
+	// Close - отписка и освобождение ресурсов
+	Close func()
+}
+
+// feedBufferSize - буфер каналов данных бота
+const feedBufferSize = 256
+
+// liveFeed - данные из MarketDataStream брокера, отдельный стрим на каждого бота.
+// Стрим переподключается после разрыва и догружает пропущенные свечи.
+type liveFeed struct {
+	client  *investgo.Client
+	history broker.MarketDataProvider
+	monitor *streams.Monitor
+	logger  *zap.SugaredLogger
+	seq     atomic.Int64
+}
+
+// NewLiveFeed - источник данных из стрима маркетдаты
+func NewLiveFeed(client *investgo.Client, history broker.MarketDataProvider, monitor *streams.Monitor, logger *zap.SugaredLogger) MarketFeed {
+	return &liveFeed{
+		client:  client,
+		history: history,
+		monitor: monitor,
+		logger:  logger,
+	}
+}
+
+// Subscribe - открытие стрима и подписка на запрошенные данные
+func (f *liveFeed) Subscribe(ctx context.Context, instruments []string, req DataRequest) (*Feed, error) {
+	name := fmt.Sprintf("bot_feed_%d", f.seq.Add(1))
+	stream := streams.NewMarketData(name, f.client, f.history, f.monitor, feedBufferSize, f.logger)
+
+	// Подписки до запуска только запоминаются и применяются при подключении
+	feed := &Feed{}
+	if req.Candles {
+		interval := req.CandleInterval
+		if interval == pb.SubscriptionInterval_SUBSCRIPTION_INTERVAL_UNSPECIFIED {
+			interval = pb.SubscriptionInterval_SUBSCRIPTION_INTERVAL_ONE_MINUTE
+		}
+		if err := stream.SubscribeCandle(instruments, interval); err != nil {
+			return nil, err
+		}
+		feed.Candles = stream.Candles()
+	}
+	if req.OrderBook {
+		if err := stream.SubscribeOrderBook(instruments, req.OrderBookDepth); err != nil {
+			return nil, err
+		}
+		feed.OrderBooks = stream.OrderBooks()
+	}
+	if req.Trades {
+		if err := stream.SubscribeTrade(instruments); err != nil {
+			return nil, err
+		}
+		feed.Trades = stream.Trades()
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	feed.Close = cancel
+	go stream.Run(streamCtx)
+	return feed, nil
+}
@@ -0,0 +1,294 @@
+package bots
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tinkoff/invest-api-go-sdk/investgo"
+	"go.uber.org/zap"
+
+	"trading-bot-web/broker"
+	"trading-bot-web/streams"
+)
+
+// ExecutionBackend - исполнитель заявок и источник данных для режима исполнения
+type ExecutionBackend struct {
+	NewExecutor func(config BotConfig) (Executor, error)
+	Feed        MarketFeed
+}
+
+// BotManager - менеджер торговых ботов
+type BotManager struct {
+	client *investgo.Client
+	broker broker.Broker
+	store  Store
+	logger *zap.SugaredLogger
+
+	mu       sync.RWMutex
+	bots     map[string]*Bot
+	backends map[string]ExecutionBackend
+}
+
+// NewBotManager - создание менеджера ботов с режимом исполнения live:
+// заявки идут через brk, рыночные данные - из стрима клиента
+func NewBotManager(client *investgo.Client, brk broker.Broker, monitor *streams.Monitor, logger *zap.SugaredLogger) *BotManager {
+	bm := &BotManager{
+		client:   client,
+		broker:   brk,
+		store:    nopStore{},
+		logger:   logger,
+		bots:     make(map[string]*Bot),
+		backends: make(map[string]ExecutionBackend),
+	}
+
+	bm.RegisterExecutionMode(ExecutionModeLive, ExecutionBackend{
+		NewExecutor: func(config BotConfig) (Executor, error) {
+			return newLiveExecutor(brk, config.ID, config.AccountID), nil
+		},
+		Feed: NewLiveFeed(client, brk, monitor, logger),
+	})
+	return bm
+}
+
+// SetStore - хранилище ботов; задается до создания ботов
+func (bm *BotManager) SetStore(store Store) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	bm.store = store
+}
+
+// RegisterExecutionMode - регистрация режима исполнения, выбираемого через BotConfig.ExecutionMode
+func (bm *BotManager) RegisterExecutionMode(mode string, backend ExecutionBackend) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	bm.backends[mode] = backend
+}
+
+// newBotWithBackend - создание бота с исполнителем выбранного режима
+func (bm *BotManager) newBotWithBackend(config BotConfig) (*Bot, error) {
+	backend, exists := bm.backends[config.ExecutionMode]
+	if !exists {
+		return nil, invalidConfig("unknown execution mode %q", config.ExecutionMode)
+	}
+
+	executor, err := backend.NewExecutor(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s executor: %w", config.ExecutionMode, err)
+	}
+	return newBot(config, executor, backend.Feed, bm.store, bm.logger), nil
+}
+
+// CreateBot - создание бота, тип стратегии выбирается по config.Type
+func (bm *BotManager) CreateBot(config BotConfig) (string, error) {
+	if _, err := ParseStrategyConfig(config); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	config.ID = generateBotID()
+	config.IsActive = false
+	config.State = BotStateStopped
+	config.CreatedAt = now
+	config.UpdatedAt = now
+	if config.ExecutionMode == "" {
+		config.ExecutionMode = ExecutionModeLive
+	}
+
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	bot, err := bm.newBotWithBackend(config)
+	if err != nil {
+		return "", err
+	}
+	if err := bm.saveBot(bot); err != nil {
+		return "", err
+	}
+	bm.bots[config.ID] = bot
+
+	bm.logger.Infof("Bot %s (%s) created", config.ID, config.Type)
+	return config.ID, nil
+}
+
+// GetBots - конфигурации всех ботов
+func (bm *BotManager) GetBots() map[string]BotConfig {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+
+	configs := make(map[string]BotConfig, len(bm.bots))
+	for id, bot := range bm.bots {
+		configs[id] = bot.Config()
+	}
+	return configs
+}
+
+// GetBot - поиск бота по ID
+func (bm *BotManager) GetBot(botID string) (*Bot, bool) {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+
+	bot, exists := bm.bots[botID]
+	return bot, exists
+}
+
+// UpdateBotConfig - обновление конфигурации остановленного бота
+func (bm *BotManager) UpdateBotConfig(botID string, config BotConfig) error {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	bot, exists := bm.bots[botID]
+	if !exists {
+		return fmt.Errorf("bot %s not found", botID)
+	}
+	if bot.State() != BotStateStopped {
+		return fmt.Errorf("bot %s must be stopped before update", botID)
+	}
+	if _, err := ParseStrategyConfig(config); err != nil {
+		return err
+	}
+
+	current := bot.Config()
+	config.ID = botID
+	config.CreatedAt = current.CreatedAt
+	config.OwnerID = current.OwnerID
+	config.UpdatedAt = time.Now()
+	if config.ExecutionMode == "" {
+		config.ExecutionMode = current.ExecutionMode
+	}
+
+	updated, err := bm.newBotWithBackend(config)
+	if err != nil {
+		return err
+	}
+	updated.ledger = bot.ledger
+	updated.runningTime = bot.runningTime
+	updated.orders = bot.orders
+	if err := bm.saveBot(updated); err != nil {
+		return err
+	}
+	bm.bots[botID] = updated
+
+	bm.logger.Infof("Bot %s updated", botID)
+	return nil
+}
+
+// DeleteBot - остановка и удаление бота
+func (bm *BotManager) DeleteBot(botID string) error {
+	bm.mu.Lock()
+	bot, exists := bm.bots[botID]
+	if !exists {
+		bm.mu.Unlock()
+		return fmt.Errorf("bot %s not found", botID)
+	}
+	delete(bm.bots, botID)
+	bm.mu.Unlock()
+
+	if bot.State() != BotStateStopped {
+		if err := bot.halt(); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := bm.store.DeleteBot(ctx, botID); err != nil {
+		return fmt.Errorf("failed to delete bot %s from store: %w", botID, err)
+	}
+
+	bm.logger.Infof("Bot %s deleted", botID)
+	return nil
+}
+
+// StartBot - запуск бота
+func (bm *BotManager) StartBot(botID string) error {
+	bot, exists := bm.GetBot(botID)
+	if !exists {
+		return fmt.Errorf("bot %s not found", botID)
+	}
+	return bot.start()
+}
+
+// StopBot - остановка бота
+func (bm *BotManager) StopBot(botID string) error {
+	bot, exists := bm.GetBot(botID)
+	if !exists {
+		return fmt.Errorf("bot %s not found", botID)
+	}
+	return bot.stop()
+}
+
+// StopBots - остановка запущенных ботов счета, пустой accountID - всех ботов.
+// Возвращает ID остановленных ботов.
+func (bm *BotManager) StopBots(accountID string) []string {
+	bm.mu.RLock()
+	running := make([]*Bot, 0, len(bm.bots))
+	for _, bot := range bm.bots {
+		if bot.State() == BotStateStopped {
+			continue
+		}
+		if accountID == "" || bot.Config().AccountID == accountID {
+			running = append(running, bot)
+		}
+	}
+	bm.mu.RUnlock()
+
+	stopped := make([]string, 0, len(running))
+	for _, bot := range running {
+		if err := bot.stop(); err != nil {
+			bm.logger.Errorf("Failed to stop bot %s: %v", bot.ID(), err)
+			continue
+		}
+		stopped = append(stopped, bot.ID())
+	}
+	return stopped
+}
+
+// GetBotStats - статистика бота
+func (bm *BotManager) GetBotStats(botID string) (BotStats, error) {
+	bot, exists := bm.GetBot(botID)
+	if !exists {
+		return BotStats{}, fmt.Errorf("bot %s not found", botID)
+	}
+	return bot.Stats(), nil
+}
+
+// Shutdown - остановка всех запущенных ботов.
+// Состояние ботов в хранилище не меняется, чтобы после перезапуска
+// было видно, какие боты работали.
+func (bm *BotManager) Shutdown() error {
+	bm.mu.RLock()
+	running := make([]*Bot, 0, len(bm.bots))
+	for _, bot := range bm.bots {
+		if bot.State() != BotStateStopped {
+			running = append(running, bot)
+		}
+	}
+	bm.mu.RUnlock()
+
+	var lastErr error
+	for _, bot := range running {
+		if err := bot.halt(); err != nil {
+			bm.logger.Errorf("Failed to stop bot %s: %v", bot.ID(), err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// saveBot - сохранение конфигурации бота в хранилище
+func (bm *BotManager) saveBot(bot *Bot) error {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	if err := bm.store.SaveBot(ctx, bot.Config()); err != nil {
+		return fmt.Errorf("failed to save bot %s: %w", bot.ID(), err)
+	}
+	return nil
+}
+
+// generateBotID - генерация ID бота
+func generateBotID() string {
+	return fmt.Sprintf("bot_%d", time.Now().UnixNano())
+}
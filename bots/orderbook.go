@@ -0,0 +1,255 @@
+package bots
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+
+	"trading-bot-web/money"
+)
+
+// OrderbookStrategyName - тип бота для стратегии на стакане заявок
+const OrderbookStrategyName = "orderbook"
+
+func init() {
+	MustRegisterStrategy(StrategyDefinition{
+		Name:        OrderbookStrategyName,
+		Description: "Покупка и продажа по соотношению объемов bid/ask в стакане",
+		NewConfig: func() StrategyConfig {
+			return &OrderbookConfig{
+				Depth:        20,
+				BuyRatio:     decimal.NewFromInt(2),
+				SellRatio:    decimal.NewFromInt(2),
+				MaxPositions: 1,
+				Lots:         1,
+			}
+		},
+		NewStrategy: func(config StrategyConfig) (Strategy, error) {
+			obConfig, ok := config.(*OrderbookConfig)
+			if !ok {
+				return nil, fmt.Errorf("unexpected config type %T", config)
+			}
+			return &orderbookStrategy{config: *obConfig}, nil
+		},
+	})
+}
+
+// OrderbookConfig - параметры стратегии на стакане заявок. Дробные
+// параметры - decimal: в JSON пишутся строками, читаются из строк и чисел.
+type OrderbookConfig struct {
+	// RequiredMoneyBalance - минимальный остаток средств для покупки
+	RequiredMoneyBalance decimal.Decimal `json:"required_money_balance"`
+	// Depth - глубина стакана для расчета объемов
+	Depth int32 `json:"depth"`
+	// BuyRatio - во сколько раз bid должен превышать ask для покупки
+	BuyRatio decimal.Decimal `json:"buy_ratio"`
+	// SellRatio - во сколько раз ask должен превышать bid для продажи
+	SellRatio decimal.Decimal `json:"sell_ratio"`
+	// MinProfit - минимальная прибыль для продажи, в процентах
+	MinProfit decimal.Decimal `json:"min_profit"`
+	// SellOut - закрыть позиции при остановке бота
+	SellOut bool `json:"sell_out"`
+	// MaxPositions - максимальное число одновременно открытых позиций
+	MaxPositions int `json:"max_positions"`
+	// Lots - размер заявки в лотах
+	Lots int64 `json:"lots"`
+}
+
+// Validate - проверка параметров стратегии
+func (c *OrderbookConfig) Validate() error {
+	if c.Depth < 1 || c.Depth > 50 {
+		return fmt.Errorf("depth must be between 1 and 50")
+	}
+	if !c.BuyRatio.IsPositive() {
+		return fmt.Errorf("buy_ratio must be positive")
+	}
+	if !c.SellRatio.IsPositive() {
+		return fmt.Errorf("sell_ratio must be positive")
+	}
+	if c.MinProfit.IsNegative() {
+		return fmt.Errorf("min_profit must not be negative")
+	}
+	if c.RequiredMoneyBalance.IsNegative() {
+		return fmt.Errorf("required_money_balance must not be negative")
+	}
+	if c.MaxPositions < 1 {
+		return fmt.Errorf("max_positions must be at least 1")
+	}
+	if c.Lots < 1 {
+		return fmt.Errorf("lots must be at least 1")
+	}
+	return nil
+}
+
+// orderbookStrategy - стратегия на соотношении объемов стакана:
+// ratio = sum(bid) / sum(ask), покупка при ratio > buy_ratio,
+// продажа при 1/ratio > sell_ratio и достижении минимальной прибыли.
+type orderbookStrategy struct {
+	config OrderbookConfig
+	env    *Env
+
+	// positions - цена входа по инструментам с открытой позицией
+	positions map[string]decimal.Decimal
+	// pending - инструменты с заявкой, ожидающей исполнения
+	pending map[string]bool
+}
+
+// Init - подготовка стратегии
+func (s *orderbookStrategy) Init(ctx context.Context, env *Env) error {
+	s.env = env
+	s.positions = make(map[string]decimal.Decimal)
+	s.pending = make(map[string]bool)
+	return nil
+}
+
+// Requirements - стратегии нужен только стакан
+func (s *orderbookStrategy) Requirements() DataRequest {
+	return DataRequest{
+		OrderBook:      true,
+		OrderBookDepth: s.config.Depth,
+	}
+}
+
+// OnCandle - свечи стратегией не используются
+func (s *orderbookStrategy) OnCandle(ctx context.Context, candle *pb.Candle) error {
+	return nil
+}
+
+// OnTrade - обезличенные сделки стратегией не используются
+func (s *orderbookStrategy) OnTrade(ctx context.Context, trade *pb.Trade) error {
+	return nil
+}
+
+// OnOrderBook - анализ стакана и принятие торгового решения
+func (s *orderbookStrategy) OnOrderBook(ctx context.Context, orderBook *pb.OrderBook) error {
+	instrumentID := orderBook.GetFigi()
+	if s.pending[instrumentID] {
+		return nil
+	}
+
+	bids := sumQuantity(orderBook.GetBids(), s.config.Depth)
+	asks := sumQuantity(orderBook.GetAsks(), s.config.Depth)
+	if bids == 0 || asks == 0 {
+		return nil
+	}
+	bidVolume, askVolume := decimal.NewFromInt(bids), decimal.NewFromInt(asks)
+
+	entryPrice, hasPosition := s.positions[instrumentID]
+	if !hasPosition {
+		if bidVolume.Div(askVolume).LessThanOrEqual(s.config.BuyRatio) || len(s.positions) >= s.config.MaxPositions {
+			return nil
+		}
+		if s.config.RequiredMoneyBalance.IsPositive() {
+			available, err := s.env.Executor.AvailableMoney(ctx, s.env.Bot.Currency)
+			if err != nil {
+				return fmt.Errorf("failed to get available money: %w", err)
+			}
+			if available.LessThan(s.config.RequiredMoneyBalance) {
+				return nil
+			}
+		}
+		return s.placeOrder(ctx, instrumentID, pb.OrderDirection_ORDER_DIRECTION_BUY)
+	}
+
+	if askVolume.Div(bidVolume).LessThanOrEqual(s.config.SellRatio) {
+		return nil
+	}
+	bestBid := money.FromQuotation(orderBook.GetBids()[0].GetPrice())
+	minPrice := entryPrice.Add(entryPrice.Mul(s.config.MinProfit).Div(decimal.NewFromInt(100)))
+	if bestBid.LessThan(minPrice) {
+		return nil
+	}
+	return s.placeOrder(ctx, instrumentID, pb.OrderDirection_ORDER_DIRECTION_SELL)
+}
+
+// OnOrderFill - обновление открытых позиций
+func (s *orderbookStrategy) OnOrderFill(ctx context.Context, fill Fill) error {
+	delete(s.pending, fill.InstrumentID)
+
+	if fill.Direction == pb.OrderDirection_ORDER_DIRECTION_BUY {
+		s.positions[fill.InstrumentID] = fill.Price
+	} else {
+		delete(s.positions, fill.InstrumentID)
+	}
+	return nil
+}
+
+// Shutdown - закрытие позиций, если включен sell_out
+func (s *orderbookStrategy) Shutdown(ctx context.Context) error {
+	if !s.config.SellOut {
+		return nil
+	}
+
+	var lastErr error
+	for instrumentID := range s.positions {
+		if err := s.placeOrder(ctx, instrumentID, pb.OrderDirection_ORDER_DIRECTION_SELL); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// orderbookState - сохраняемое состояние стратегии
+type orderbookState struct {
+	Positions map[string]decimal.Decimal `json:"positions"`
+}
+
+// SnapshotState - цены входа открытых позиций. Ожидающие исполнения заявки
+// не сохраняются: бот сверяет их с брокером при восстановлении.
+func (s *orderbookStrategy) SnapshotState() (json.RawMessage, error) {
+	return json.Marshal(orderbookState{Positions: s.positions})
+}
+
+// RestoreState - восстановление цен входа открытых позиций
+func (s *orderbookStrategy) RestoreState(state json.RawMessage) error {
+	var restored orderbookState
+	if err := json.Unmarshal(state, &restored); err != nil {
+		return err
+	}
+	for instrumentID, price := range restored.Positions {
+		s.positions[instrumentID] = price
+	}
+	return nil
+}
+
+// placeOrder - выставление рыночной заявки на размер из конфигурации
+func (s *orderbookStrategy) placeOrder(ctx context.Context, instrumentID string, direction pb.OrderDirection) error {
+	s.pending[instrumentID] = true
+
+	result, err := s.env.Executor.PlaceOrder(ctx, OrderRequest{
+		InstrumentID: instrumentID,
+		Direction:    direction,
+		Lots:         s.config.Lots,
+	})
+	if err != nil {
+		delete(s.pending, instrumentID)
+		return fmt.Errorf("failed to place order: %w", err)
+	}
+	if result.Status == pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_REJECTED ||
+		result.Status == pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_CANCELLED {
+		delete(s.pending, instrumentID)
+	}
+
+	s.env.Logger.Infow("Order placed",
+		"instrument", instrumentID,
+		"direction", direction.String(),
+		"lots", s.config.Lots,
+		"order_id", result.OrderID,
+	)
+	return nil
+}
+
+// sumQuantity - суммарный объем заявок на заданной глубине
+func sumQuantity(orders []*pb.Order, depth int32) int64 {
+	total := int64(0)
+	for i, order := range orders {
+		if int32(i) >= depth {
+			break
+		}
+		total += order.GetQuantity()
+	}
+	return total
+}
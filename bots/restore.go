@@ -0,0 +1,270 @@
+package bots
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+
+	"trading-bot-web/broker"
+	"trading-bot-web/money"
+)
+
+// RestorePolicy - что делать с ботами, работавшими до перезапуска сервера
+type RestorePolicy string
+
+const (
+	// RestoreResume - запустить в прежнем состоянии: работающие и на паузе
+	RestoreResume RestorePolicy = "resume"
+	// RestoreResumePaused - запустить на паузе до ручного возобновления
+	RestoreResumePaused RestorePolicy = "resume-paused"
+	// RestoreStopped - оставить остановленными
+	RestoreStopped RestorePolicy = "stopped"
+)
+
+// ParseRestorePolicy - проверка политики восстановления, пустая означает resume
+func ParseRestorePolicy(value string) (RestorePolicy, error) {
+	switch policy := RestorePolicy(value); policy {
+	case "":
+		return RestoreResume, nil
+	case RestoreResume, RestoreResumePaused, RestoreStopped:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown restore policy %q", value)
+	}
+}
+
+// RestoredBot - результат восстановления бота
+type RestoredBot struct {
+	BotID string `json:"bot_id"`
+	// PreviousState - состояние на момент остановки сервера
+	PreviousState BotState `json:"previous_state"`
+	State         BotState `json:"state"`
+	// MissedFills - исполнения заявок, найденные при сверке с брокером
+	MissedFills int `json:"missed_fills"`
+	// Issues - расхождения с брокером, из-за которых бот оставлен остановленным
+	Issues []string `json:"issues,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// Restore - восстановление ботов из хранилища после перезапуска сервера.
+// Открытые заявки и позиции live-ботов сверяются с брокером, пропущенные
+// исполнения учитываются, затем боты запускаются согласно policy.
+// Бот с расхождениями остается остановленным.
+func (bm *BotManager) Restore(ctx context.Context, policy RestorePolicy) ([]RestoredBot, error) {
+	configs, err := bm.store.ListBots(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load bots: %w", err)
+	}
+
+	accounts := newAccountCache(bm.broker)
+	results := make([]RestoredBot, 0, len(configs))
+	for _, config := range configs {
+		results = append(results, bm.restoreBot(ctx, config, policy, accounts))
+	}
+	return results, nil
+}
+
+// restoreBot - восстановление одного бота
+func (bm *BotManager) restoreBot(ctx context.Context, config BotConfig, policy RestorePolicy, accounts *accountCache) RestoredBot {
+	result := RestoredBot{BotID: config.ID, PreviousState: config.State, State: BotStateStopped}
+
+	bm.mu.Lock()
+	bot, err := bm.newBotWithBackend(config)
+	if err == nil {
+		bm.bots[config.ID] = bot
+	}
+	bm.mu.Unlock()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	snapshot, err := bm.store.LoadSnapshot(ctx, config.ID)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if snapshot != nil {
+		bot.restore(*snapshot)
+	}
+
+	var missed []Fill
+	if config.ExecutionMode == ExecutionModeLive {
+		missed, result.Issues = bm.reconcile(ctx, bot, accounts)
+	} else if orders := bot.orders.list(); len(orders) > 0 {
+		// Заявки других режимов не переживают перезапуск исполнителя
+		bot.logger.Warnf("Dropping %d open %s orders", len(orders), config.ExecutionMode)
+		bot.orders = newOpenOrders(nil)
+	}
+	result.MissedFills = len(missed)
+
+	target := restoredState(config.State, policy)
+	if len(result.Issues) > 0 {
+		target = BotStateStopped
+	}
+
+	if target == BotStateStopped {
+		for _, fill := range missed {
+			bot.applyFill(fill)
+		}
+		bot.persistSnapshot()
+		bot.persist()
+		return result
+	}
+
+	bot.missedFills = missed
+	if err := bot.start(); err != nil {
+		result.Error = err.Error()
+		bot.persist()
+		return result
+	}
+	if target == BotStatePaused {
+		if err := bot.Pause(); err != nil {
+			result.Error = err.Error()
+		}
+	}
+	result.State = bot.State()
+	return result
+}
+
+// restoredState - состояние бота после перезапуска по политике
+func restoredState(previous BotState, policy RestorePolicy) BotState {
+	if previous == BotStateStopped || policy == RestoreStopped {
+		return BotStateStopped
+	}
+	if policy == RestoreResumePaused {
+		return BotStatePaused
+	}
+	return previous
+}
+
+// reconcile - сверка открытых заявок и позиций бота с брокером.
+// Возвращает исполнения, пропущенные за время остановки, и расхождения.
+func (bm *BotManager) reconcile(ctx context.Context, bot *Bot, accounts *accountCache) ([]Fill, []string) {
+	accountID := bot.config.AccountID
+	active, err := accounts.orders(ctx, accountID)
+	if err != nil {
+		return nil, []string{fmt.Sprintf("failed to get orders: %v", err)}
+	}
+
+	var (
+		missed []Fill
+		issues []string
+	)
+	for _, order := range bot.orders.list() {
+		state, isActive := active[order.OrderID]
+		if !isActive {
+			state, err = bm.broker.GetOrderState(ctx, accountID, order.OrderID)
+			if err != nil {
+				issues = append(issues, fmt.Sprintf("order %s: %v", order.OrderID, err))
+				continue
+			}
+		}
+
+		if delta := state.GetLotsExecuted() - order.LotsExecuted; delta > 0 {
+			missed = append(missed, executionFill(bot, order, state, delta))
+		}
+		if !isActive {
+			// Исполнение учитывается по пропущенным исполнениям выше
+			bot.orders.remove(order.OrderID)
+		}
+	}
+
+	positions, err := accounts.positions(ctx, accountID)
+	if err != nil {
+		return missed, append(issues, fmt.Sprintf("failed to get positions: %v", err))
+	}
+
+	// Позиции бота с учетом пропущенных исполнений не должны превышать позиции счета
+	ledger := RestoreLedger(bot.ledger.Snapshot())
+	for _, fill := range missed {
+		ledger.Apply(fill)
+	}
+	for instrumentID, pos := range ledger.Snapshot().Positions {
+		if pos.Quantity <= 0 {
+			continue
+		}
+		if held := positions[instrumentID]; held < pos.Quantity {
+			issues = append(issues, fmt.Sprintf("position %s: bot holds %d, account holds %d", instrumentID, pos.Quantity, held))
+		}
+	}
+	return missed, issues
+}
+
+// executionFill - исполнение lots лотов заявки по ее состоянию у брокера:
+// пропущенное за время остановки или найденное опросом открытых заявок
+func executionFill(bot *Bot, order OpenOrder, state *pb.OrderState, lots int64) Fill {
+	commission := money.FromMoneyValue(state.GetExecutedCommission())
+	if executed := state.GetLotsExecuted(); executed > 0 {
+		commission = commission.Mul(decimal.NewFromInt(lots)).Div(decimal.NewFromInt(executed))
+	}
+	return Fill{
+		OrderID:      order.OrderID,
+		InstrumentID: order.InstrumentID,
+		Direction:    order.Direction,
+		Lots:         lots,
+		Quantity:     lots * lotSizeOf(bot.executor, order.InstrumentID),
+		Price:        money.FromMoneyValue(state.GetAveragePositionPrice()),
+		Commission:   commission,
+		Time:         time.Now(),
+	}
+}
+
+// accountCache - заявки и позиции счетов, запрошенные один раз на восстановление
+type accountCache struct {
+	broker       broker.Broker
+	activeOrders map[string]map[string]*pb.OrderState
+	balances     map[string]map[string]int64
+}
+
+// newAccountCache - пустой кэш счетов
+func newAccountCache(brk broker.Broker) *accountCache {
+	return &accountCache{
+		broker:       brk,
+		activeOrders: make(map[string]map[string]*pb.OrderState),
+		balances:     make(map[string]map[string]int64),
+	}
+}
+
+// orders - активные заявки счета по ID
+func (c *accountCache) orders(ctx context.Context, accountID string) (map[string]*pb.OrderState, error) {
+	if orders, exists := c.activeOrders[accountID]; exists {
+		return orders, nil
+	}
+
+	states, err := c.broker.GetOrders(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	orders := make(map[string]*pb.OrderState, len(states))
+	for _, state := range states {
+		orders[state.GetOrderId()] = state
+	}
+	c.activeOrders[accountID] = orders
+	return orders, nil
+}
+
+// positions - количество бумаг на счете в штуках, по FIGI и UID инструмента
+func (c *accountCache) positions(ctx context.Context, accountID string) (map[string]int64, error) {
+	if balances, exists := c.balances[accountID]; exists {
+		return balances, nil
+	}
+
+	resp, err := c.broker.GetPositions(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	balances := make(map[string]int64, len(resp.GetSecurities()))
+	for _, security := range resp.GetSecurities() {
+		held := security.GetBalance() + security.GetBlocked()
+		balances[security.GetFigi()] = held
+		if uid := security.GetInstrumentUid(); uid != "" {
+			balances[uid] = held
+		}
+	}
+	c.balances[accountID] = balances
+	return balances, nil
+}
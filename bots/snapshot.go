@@ -0,0 +1,155 @@
+package bots
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+)
+
+// snapshotInterval - период сохранения состояния работающего бота
+const snapshotInterval = 30 * time.Second
+
+// orderPollInterval - период опроса состояния открытых заявок live-бота
+const orderPollInterval = 5 * time.Second
+
+// StatefulStrategy - стратегия, внутреннее состояние которой переживает
+// перезапуск сервера. Методы вызываются из горутины бота.
+type StatefulStrategy interface {
+	Strategy
+	// SnapshotState - состояние стратегии для сохранения
+	SnapshotState() (json.RawMessage, error)
+	// RestoreState - восстановление состояния после Init и до первых событий
+	RestoreState(state json.RawMessage) error
+}
+
+// BotSnapshot - состояние бота для восстановления после перезапуска сервера
+type BotSnapshot struct {
+	BotID         string          `json:"bot_id"`
+	Ledger        LedgerSnapshot  `json:"ledger"`
+	RunningTime   time.Duration   `json:"running_time"`
+	OpenOrders    []OpenOrder     `json:"open_orders"`
+	StrategyState json.RawMessage `json:"strategy_state,omitempty"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+}
+
+// OpenOrder - заявка бота, ожидающая исполнения
+type OpenOrder struct {
+	OrderID      string            `json:"order_id"`
+	InstrumentID string            `json:"instrument_id"`
+	Direction    pb.OrderDirection `json:"direction"`
+	Lots         int64             `json:"lots"`
+	LotsExecuted int64             `json:"lots_executed"`
+	Price        *decimal.Decimal  `json:"price,omitempty"`
+}
+
+// openOrders - учет неисполненных заявок бота
+type openOrders struct {
+	mu     sync.Mutex
+	orders map[string]*OpenOrder
+}
+
+// newOpenOrders - учет, заполненный сохраненными заявками
+func newOpenOrders(orders []OpenOrder) *openOrders {
+	o := &openOrders{orders: make(map[string]*OpenOrder, len(orders))}
+	for _, order := range orders {
+		order := order
+		o.orders[order.OrderID] = &order
+	}
+	return o
+}
+
+// add - новая заявка, ожидающая исполнения
+func (o *openOrders) add(order OpenOrder) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.orders[order.OrderID] = &order
+}
+
+// remove - заявка больше не активна
+func (o *openOrders) remove(orderID string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.orders, orderID)
+}
+
+// filled - учет исполнения, полностью исполненная заявка удаляется
+func (o *openOrders) filled(fill Fill) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	order, exists := o.orders[fill.OrderID]
+	if !exists {
+		return
+	}
+	order.LotsExecuted += fill.Lots
+	if order.LotsExecuted >= order.Lots {
+		delete(o.orders, fill.OrderID)
+	}
+}
+
+// list - копия открытых заявок
+func (o *openOrders) list() []OpenOrder {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	orders := make([]OpenOrder, 0, len(o.orders))
+	for _, order := range o.orders {
+		orders = append(orders, *order)
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].OrderID < orders[j].OrderID })
+	return orders
+}
+
+// snapshot - состояние бота. Вызывается из горутины бота
+// или для остановленного бота, когда стратегии нет.
+func (b *Bot) snapshot() BotSnapshot {
+	b.mu.RLock()
+	snapshot := BotSnapshot{
+		BotID:       b.id,
+		Ledger:      b.ledger.Snapshot(),
+		RunningTime: b.runningTime,
+		OpenOrders:  b.orders.list(),
+		UpdatedAt:   time.Now(),
+	}
+	if b.state != BotStateStopped {
+		snapshot.RunningTime += time.Since(b.startedAt)
+	}
+	strategy := b.strategy
+	b.mu.RUnlock()
+
+	if stateful, ok := strategy.(StatefulStrategy); ok {
+		state, err := stateful.SnapshotState()
+		if err != nil {
+			b.logger.Errorf("Failed to snapshot strategy state: %v", err)
+		} else {
+			snapshot.StrategyState = state
+		}
+	}
+	return snapshot
+}
+
+// persistSnapshot - сохранение состояния бота
+func (b *Bot) persistSnapshot() {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	if err := b.store.SaveSnapshot(ctx, b.snapshot()); err != nil {
+		b.logger.Errorf("Failed to save bot snapshot: %v", err)
+	}
+}
+
+// restore - перенос сохраненного состояния в остановленного бота
+func (b *Bot) restore(snapshot BotSnapshot) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.ledger = RestoreLedger(snapshot.Ledger)
+	b.runningTime = snapshot.RunningTime
+	b.orders = newOpenOrders(snapshot.OpenOrders)
+	b.strategyState = snapshot.StrategyState
+}
@@ -0,0 +1,231 @@
+package bots
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+)
+
+// BotStats - статистика работы бота
+type BotStats struct {
+	BotID            string              `json:"bot_id"`
+	TotalTrades      int                 `json:"total_trades"`
+	WinningTrades    int                 `json:"winning_trades"`
+	LosingTrades     int                 `json:"losing_trades"`
+	TotalProfit      decimal.Decimal     `json:"total_profit"`
+	TotalProfitPct   float64             `json:"total_profit_pct"`
+	TotalCommission  decimal.Decimal     `json:"total_commission"`
+	RunningTime      time.Duration       `json:"running_time"`
+	CurrentPositions map[string]Position `json:"current_positions"`
+}
+
+// Position - открытая позиция по инструменту
+type Position struct {
+	InstrumentID string `json:"instrument_id"`
+	// Quantity - количество в штуках, отрицательное для короткой позиции
+	Quantity     int64           `json:"quantity"`
+	AveragePrice decimal.Decimal `json:"average_price"`
+}
+
+// Ledger - учет позиций и реализованной прибыли по исполнениям.
+// Сделкой считается каждое закрытие (полное или частичное) позиции.
+type Ledger struct {
+	positions     map[string]*Position
+	trades        int
+	winningTrades int
+	losingTrades  int
+	profit        decimal.Decimal
+	closedCost    decimal.Decimal
+	commission    decimal.Decimal
+
+	// openCost - стоимость открытой позиции по ценам входа. Прибыль считается
+	// от нее, а не от средней цены, чтобы полное закрытие позиции, набранной
+	// по разным ценам, не оставляло остатка от деления.
+	openCost map[string]decimal.Decimal
+	// openCommission - комиссия открытия, еще не отнесенная на сделку
+	openCommission map[string]decimal.Decimal
+}
+
+// NewLedger - создание пустого учета
+func NewLedger() *Ledger {
+	return &Ledger{
+		positions:      make(map[string]*Position),
+		openCost:       make(map[string]decimal.Decimal),
+		openCommission: make(map[string]decimal.Decimal),
+	}
+}
+
+// Apply - учет исполнения, возвращает реализованную прибыль за вычетом комиссий
+func (l *Ledger) Apply(fill Fill) decimal.Decimal {
+	quantity := fill.Quantity
+	if quantity == 0 {
+		quantity = fill.Lots
+	}
+	if fill.Direction == pb.OrderDirection_ORDER_DIRECTION_SELL {
+		quantity = -quantity
+	}
+	l.commission = l.commission.Add(fill.Commission)
+
+	id := fill.InstrumentID
+	pos, exists := l.positions[id]
+	if !exists {
+		pos = &Position{InstrumentID: id}
+		l.positions[id] = pos
+	}
+
+	// Увеличение позиции или открытие новой
+	if pos.Quantity == 0 || sign(pos.Quantity) == sign(quantity) {
+		pos.Quantity += quantity
+		l.openCost[id] = l.openCost[id].Add(fill.Price.Mul(decimal.NewFromInt(abs(quantity))))
+		pos.AveragePrice = l.openCost[id].Div(decimal.NewFromInt(abs(pos.Quantity)))
+		l.openCommission[id] = l.openCommission[id].Add(fill.Commission)
+		return decimal.Zero
+	}
+
+	// Закрытие позиции (возможно, с разворотом): стоимость входа и комиссия
+	// открытия относятся на сделку пропорционально закрытому количеству
+	held := decimal.NewFromInt(abs(pos.Quantity))
+	closed := decimal.NewFromInt(min(abs(pos.Quantity), abs(quantity)))
+	closedCost := l.openCost[id].Mul(closed).Div(held)
+	openCommission := l.openCommission[id].Mul(closed).Div(held)
+	l.openCost[id] = l.openCost[id].Sub(closedCost)
+	l.openCommission[id] = l.openCommission[id].Sub(openCommission)
+
+	gross := fill.Price.Mul(closed).Sub(closedCost)
+	if pos.Quantity < 0 {
+		gross = gross.Neg()
+	}
+	realized := gross.Sub(openCommission).Sub(fill.Commission)
+
+	l.trades++
+	if realized.IsPositive() {
+		l.winningTrades++
+	} else {
+		l.losingTrades++
+	}
+	l.profit = l.profit.Add(realized)
+	l.closedCost = l.closedCost.Add(closedCost)
+
+	pos.Quantity += quantity
+	switch {
+	case pos.Quantity == 0:
+		delete(l.positions, id)
+		delete(l.openCost, id)
+		delete(l.openCommission, id)
+	case sign(pos.Quantity) == sign(quantity):
+		// Разворот: остаток открывает позицию по цене исполнения
+		pos.AveragePrice = fill.Price
+		l.openCost[id] = fill.Price.Mul(decimal.NewFromInt(abs(pos.Quantity)))
+		l.openCommission[id] = decimal.Zero
+	}
+	return realized
+}
+
+// Position - текущая позиция по инструменту
+func (l *Ledger) Position(instrumentID string) (Position, bool) {
+	pos, exists := l.positions[instrumentID]
+	if !exists {
+		return Position{}, false
+	}
+	return *pos, true
+}
+
+// Stats - статистика по учтенным исполнениям
+func (l *Ledger) Stats() BotStats {
+	stats := BotStats{
+		TotalTrades:      l.trades,
+		WinningTrades:    l.winningTrades,
+		LosingTrades:     l.losingTrades,
+		TotalProfit:      l.profit,
+		TotalCommission:  l.commission,
+		CurrentPositions: make(map[string]Position, len(l.positions)),
+	}
+	if !l.closedCost.IsZero() {
+		stats.TotalProfitPct = l.profit.Div(l.closedCost.Abs()).Mul(decimal.NewFromInt(100)).InexactFloat64()
+	}
+	for id, pos := range l.positions {
+		stats.CurrentPositions[id] = *pos
+	}
+	return stats
+}
+
+// LedgerSnapshot - состояние учета для восстановления после перезапуска
+type LedgerSnapshot struct {
+	Positions      map[string]Position        `json:"positions"`
+	Trades         int                        `json:"trades"`
+	WinningTrades  int                        `json:"winning_trades"`
+	LosingTrades   int                        `json:"losing_trades"`
+	Profit         decimal.Decimal            `json:"profit"`
+	ClosedCost     decimal.Decimal            `json:"closed_cost"`
+	Commission     decimal.Decimal            `json:"commission"`
+	OpenCost       map[string]decimal.Decimal `json:"open_cost"`
+	OpenCommission map[string]decimal.Decimal `json:"open_commission"`
+}
+
+// Snapshot - копия состояния учета
+func (l *Ledger) Snapshot() LedgerSnapshot {
+	snapshot := LedgerSnapshot{
+		Positions:      make(map[string]Position, len(l.positions)),
+		Trades:         l.trades,
+		WinningTrades:  l.winningTrades,
+		LosingTrades:   l.losingTrades,
+		Profit:         l.profit,
+		ClosedCost:     l.closedCost,
+		Commission:     l.commission,
+		OpenCost:       make(map[string]decimal.Decimal, len(l.openCost)),
+		OpenCommission: make(map[string]decimal.Decimal, len(l.openCommission)),
+	}
+	for id, pos := range l.positions {
+		snapshot.Positions[id] = *pos
+	}
+	for id, cost := range l.openCost {
+		snapshot.OpenCost[id] = cost
+	}
+	for id, commission := range l.openCommission {
+		snapshot.OpenCommission[id] = commission
+	}
+	return snapshot
+}
+
+// RestoreLedger - учет из сохраненного состояния. В состояниях, сохраненных
+// до учета стоимости входа, она восстанавливается по средней цене.
+func RestoreLedger(snapshot LedgerSnapshot) *Ledger {
+	l := NewLedger()
+	l.trades = snapshot.Trades
+	l.winningTrades = snapshot.WinningTrades
+	l.losingTrades = snapshot.LosingTrades
+	l.profit = snapshot.Profit
+	l.closedCost = snapshot.ClosedCost
+	l.commission = snapshot.Commission
+	for id, pos := range snapshot.Positions {
+		pos := pos
+		l.positions[id] = &pos
+		cost, exists := snapshot.OpenCost[id]
+		if !exists {
+			cost = pos.AveragePrice.Mul(decimal.NewFromInt(abs(pos.Quantity)))
+		}
+		l.openCost[id] = cost
+	}
+	for id, commission := range snapshot.OpenCommission {
+		l.openCommission[id] = commission
+	}
+	return l
+}
+
+func sign(v int64) int64 {
+	switch {
+	case v > 0:
+		return 1
+	case v < 0:
+		return -1
+	}
+	return 0
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,64 @@
+package bots
+
+import (
+	"context"
+	"time"
+)
+
+// storeTimeout - ограничение времени записи в хранилище
+const storeTimeout = 5 * time.Second
+
+// Store - хранилище конфигураций ботов, их состояния, исполнений и статистики
+type Store interface {
+	SaveBot(ctx context.Context, config BotConfig) error
+	DeleteBot(ctx context.Context, botID string) error
+	// ListBots - сохраненные боты с состоянием на момент последнего сохранения
+	ListBots(ctx context.Context) ([]BotConfig, error)
+	SaveFill(ctx context.Context, botID, accountID string, fill Fill) error
+	SaveBotStats(ctx context.Context, stats BotStats) error
+	SaveSnapshot(ctx context.Context, snapshot BotSnapshot) error
+	// LoadSnapshot - последнее состояние бота, nil если его нет
+	LoadSnapshot(ctx context.Context, botID string) (*BotSnapshot, error)
+}
+
+// nopStore - хранилище по умолчанию, ничего не сохраняет
+type nopStore struct{}
+
+func (nopStore) SaveBot(context.Context, BotConfig) error                   { return nil }
+func (nopStore) DeleteBot(context.Context, string) error                    { return nil }
+func (nopStore) SaveFill(context.Context, string, string, Fill) error       { return nil }
+func (nopStore) SaveBotStats(context.Context, BotStats) error               { return nil }
+func (nopStore) ListBots(context.Context) ([]BotConfig, error)              { return nil, nil }
+func (nopStore) SaveSnapshot(context.Context, BotSnapshot) error            { return nil }
+func (nopStore) LoadSnapshot(context.Context, string) (*BotSnapshot, error) { return nil, nil }
+
+// persist - сохранение конфигурации и состояния бота
+func (b *Bot) persist() {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	if err := b.store.SaveBot(ctx, b.Config()); err != nil {
+		b.logger.Errorf("Failed to save bot state: %v", err)
+	}
+}
+
+// persistStats - сохранение статистики бота
+func (b *Bot) persistStats() {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	if err := b.store.SaveBotStats(ctx, b.Stats()); err != nil {
+		b.logger.Errorf("Failed to save bot stats: %v", err)
+	}
+}
+
+// persistFill - сохранение исполнения и обновленной статистики
+func (b *Bot) persistFill(fill Fill) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	if err := b.store.SaveFill(ctx, b.id, b.config.AccountID, fill); err != nil {
+		b.logger.Errorf("Failed to save fill of order %s: %v", fill.OrderID, err)
+	}
+	b.persistStats()
+}
@@ -0,0 +1,217 @@
+package bots
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+	"go.uber.org/zap"
+)
+
+// Strategy - торговая стратегия, которую исполняет бот.
+// Все методы вызываются из одной горутины бота, поэтому стратегии
+// не нужно защищать свое состояние мьютексами.
+type Strategy interface {
+	// Init - подготовка стратегии перед запуском бота
+	Init(ctx context.Context, env *Env) error
+	// Requirements - рыночные данные, на которые нужно подписать бота
+	Requirements() DataRequest
+	// OnCandle - новая свеча по инструменту
+	OnCandle(ctx context.Context, candle *pb.Candle) error
+	// OnOrderBook - обновление стакана заявок
+	OnOrderBook(ctx context.Context, orderBook *pb.OrderBook) error
+	// OnTrade - обезличенная сделка по инструменту
+	OnTrade(ctx context.Context, trade *pb.Trade) error
+	// OnOrderFill - исполнение заявки, выставленной стратегией
+	OnOrderFill(ctx context.Context, fill Fill) error
+	// Shutdown - завершение работы стратегии при остановке бота
+	Shutdown(ctx context.Context) error
+}
+
+// StrategyConfig - типизированный раздел конфигурации стратегии
+type StrategyConfig interface {
+	Validate() error
+}
+
+// StrategyDefinition - описание стратегии в реестре
+type StrategyDefinition struct {
+	// Name - значение BotConfig.Type, по которому выбирается стратегия
+	Name        string
+	Description string
+	// NewConfig - пустой конфиг со значениями по умолчанию
+	NewConfig func() StrategyConfig
+	// NewStrategy - создание экземпляра стратегии из проверенного конфига
+	NewStrategy func(config StrategyConfig) (Strategy, error)
+}
+
+// DataRequest - рыночные данные, необходимые стратегии
+type DataRequest struct {
+	Candles        bool
+	CandleInterval pb.SubscriptionInterval
+	OrderBook      bool
+	OrderBookDepth int32
+	Trades         bool
+}
+
+// Env - окружение, в котором работает стратегия
+type Env struct {
+	Bot      BotConfig
+	Executor Executor
+	Logger   *zap.SugaredLogger
+}
+
+// Executor - исполнитель заявок стратегии
+type Executor interface {
+	PlaceOrder(ctx context.Context, req OrderRequest) (*OrderResult, error)
+	CancelOrder(ctx context.Context, orderID string) error
+	AvailableMoney(ctx context.Context, currency string) (decimal.Decimal, error)
+}
+
+// OrderRequest - заявка стратегии
+type OrderRequest struct {
+	InstrumentID string
+	Direction    pb.OrderDirection
+	Lots         int64
+	// Price - цена лимитной заявки, nil для рыночной
+	Price *decimal.Decimal
+}
+
+// OrderResult - ответ исполнителя на заявку
+type OrderResult struct {
+	OrderID       string
+	Status        pb.OrderExecutionReportStatus
+	ExecutedLots  int64
+	ExecutedPrice decimal.Decimal
+	Commission    decimal.Decimal
+}
+
+// Fill - исполнение заявки
+type Fill struct {
+	OrderID      string            `json:"order_id"`
+	InstrumentID string            `json:"instrument_id"`
+	Direction    pb.OrderDirection `json:"direction"`
+	Lots         int64             `json:"lots"`
+	// Quantity - количество в штуках инструмента
+	Quantity int64 `json:"quantity"`
+	// Price - цена одного инструмента
+	Price      decimal.Decimal `json:"price"`
+	Commission decimal.Decimal `json:"commission"`
+	Time       time.Time       `json:"time"`
+}
+
+// strategyRegistry - реестр стратегий по имени типа бота
+type strategyRegistry struct {
+	mu          sync.RWMutex
+	definitions map[string]StrategyDefinition
+}
+
+var registry = &strategyRegistry{
+	definitions: make(map[string]StrategyDefinition),
+}
+
+// RegisterStrategy - регистрация новой стратегии
+func RegisterStrategy(def StrategyDefinition) error {
+	if def.Name == "" {
+		return fmt.Errorf("strategy name is required")
+	}
+	if def.NewConfig == nil || def.NewStrategy == nil {
+		return fmt.Errorf("strategy %s: NewConfig and NewStrategy are required", def.Name)
+	}
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if _, exists := registry.definitions[def.Name]; exists {
+		return fmt.Errorf("strategy %s already registered", def.Name)
+	}
+	registry.definitions[def.Name] = def
+	return nil
+}
+
+// MustRegisterStrategy - регистрация стратегии с паникой при ошибке, для init()
+func MustRegisterStrategy(def StrategyDefinition) {
+	if err := RegisterStrategy(def); err != nil {
+		panic(err)
+	}
+}
+
+// LookupStrategy - поиск стратегии по имени
+func LookupStrategy(name string) (StrategyDefinition, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	def, exists := registry.definitions[name]
+	return def, exists
+}
+
+// RegisteredStrategies - список зарегистрированных стратегий, отсортированный по имени
+func RegisteredStrategies() []StrategyDefinition {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	defs := make([]StrategyDefinition, 0, len(registry.definitions))
+	for _, def := range registry.definitions {
+		defs = append(defs, def)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Name < defs[j].Name })
+	return defs
+}
+
+// ConfigError - недопустимая конфигурация бота
+type ConfigError struct {
+	message string
+}
+
+func (e *ConfigError) Error() string {
+	return e.message
+}
+
+// invalidConfig - создание ошибки конфигурации
+func invalidConfig(format string, args ...any) error {
+	return &ConfigError{message: fmt.Sprintf(format, args...)}
+}
+
+// ParseStrategyConfig - разбор и проверка раздела "<type>_config" конфигурации бота.
+// Ошибки конфигурации возвращаются как *ConfigError.
+func ParseStrategyConfig(config BotConfig) (StrategyConfig, error) {
+	def, exists := LookupStrategy(config.Type)
+	if !exists {
+		return nil, invalidConfig("unknown bot type %q", config.Type)
+	}
+
+	strategyConfig := def.NewConfig()
+	if len(config.StrategyParams) > 0 {
+		decoder := json.NewDecoder(strings.NewReader(string(config.StrategyParams)))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(strategyConfig); err != nil {
+			return nil, invalidConfig("invalid %s: %v", strategyConfigKey(config.Type), err)
+		}
+	}
+
+	if err := strategyConfig.Validate(); err != nil {
+		return nil, invalidConfig("invalid %s: %v", strategyConfigKey(config.Type), err)
+	}
+	return strategyConfig, nil
+}
+
+// NewStrategy - создание стратегии по конфигурации бота
+func NewStrategy(config BotConfig) (Strategy, error) {
+	strategyConfig, err := ParseStrategyConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	def, _ := LookupStrategy(config.Type)
+	return def.NewStrategy(strategyConfig)
+}
+
+// strategyConfigKey - имя JSON-раздела с параметрами стратегии
+func strategyConfigKey(botType string) string {
+	return botType + "_config"
+}
@@ -0,0 +1,220 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+)
+
+// OrderGateway - выставление, отмена и состояние заявок
+type OrderGateway interface {
+	PostOrder(ctx context.Context, req OrderRequest) (*pb.PostOrderResponse, error)
+	// ReplaceOrder - изменение количества и цены активной заявки, у заявки появляется новый ID
+	ReplaceOrder(ctx context.Context, req ReplaceRequest) (*pb.PostOrderResponse, error)
+	CancelOrder(ctx context.Context, accountID, orderID string) (*pb.CancelOrderResponse, error)
+	GetOrderState(ctx context.Context, accountID, orderID string) (*pb.OrderState, error)
+	GetOrders(ctx context.Context, accountID string) ([]*pb.OrderState, error)
+}
+
+// StopOrderGateway - стоп-заявки: исполняются брокером при достижении стоп-цены
+type StopOrderGateway interface {
+	PostStopOrder(ctx context.Context, req StopOrderRequest) (*pb.PostStopOrderResponse, error)
+	GetStopOrders(ctx context.Context, accountID string) ([]*pb.StopOrder, error)
+	CancelStopOrder(ctx context.Context, accountID, stopOrderID string) (*pb.CancelStopOrderResponse, error)
+}
+
+// MarketDataProvider - рыночные данные по запросу
+type MarketDataProvider interface {
+	GetCandles(ctx context.Context, instrumentID string, interval pb.CandleInterval, from, to time.Time) ([]*pb.HistoricCandle, error)
+	GetOrderBook(ctx context.Context, instrumentID string, depth int32) (*pb.GetOrderBookResponse, error)
+	GetLastPrices(ctx context.Context, instrumentIDs []string) ([]*pb.LastPrice, error)
+	GetTradingStatus(ctx context.Context, instrumentID string) (*pb.GetTradingStatusResponse, error)
+}
+
+// PortfolioProvider - счета, портфель, позиции и операции
+type PortfolioProvider interface {
+	GetAccounts(ctx context.Context) ([]*pb.Account, error)
+	GetPortfolio(ctx context.Context, accountID string) (*pb.PortfolioResponse, error)
+	GetPositions(ctx context.Context, accountID string) (*pb.PositionsResponse, error)
+	GetOperations(ctx context.Context, accountID string, from, to time.Time) ([]*pb.Operation, error)
+}
+
+// InstrumentCatalog - справочник инструментов
+type InstrumentCatalog interface {
+	FindInstrument(ctx context.Context, query string) ([]*pb.InstrumentShort, error)
+	InstrumentByFigi(ctx context.Context, figi string) (*pb.Instrument, error)
+	// PointValue - стоимость пункта цены инструмента в валюте расчетов
+	PointValue(ctx context.Context, instrumentID string) (decimal.Decimal, error)
+	Shares(ctx context.Context) ([]*pb.Share, error)
+	Bonds(ctx context.Context) ([]*pb.Bond, error)
+	Etfs(ctx context.Context) ([]*pb.Etf, error)
+	Futures(ctx context.Context) ([]*pb.Future, error)
+	Currencies(ctx context.Context) ([]*pb.Currency, error)
+	Options(ctx context.Context) ([]*pb.Option, error)
+}
+
+// Broker - полный набор сервисов брокера, который использует сервер
+type Broker interface {
+	OrderGateway
+	StopOrderGateway
+	MarketDataProvider
+	PortfolioProvider
+	InstrumentCatalog
+}
+
+// OrderRequest - заявка в терминах сервера
+type OrderRequest struct {
+	AccountID    string
+	InstrumentID string
+	Direction    pb.OrderDirection
+	OrderType    pb.OrderType
+	// Lots - количество в лотах
+	Lots int64
+	// Price - цена за штуку в пунктах котировки, обязательна для лимитной заявки.
+	// Для акций пункт равен единице валюты, см. PointValue.
+	Price *decimal.Decimal
+	// TimeInForce - срок действия, по умолчанию до конца дня
+	TimeInForce TimeInForce
+	// OrderID - клиентский ключ идемпотентности, генерируется, если пуст
+	OrderID string
+	// BotID - бот, выставивший заявку; пуст для заявок через API
+	BotID string
+	// Origin и OriginID - источник заявки для журнала заявок, см. OriginAPI
+	Origin   string
+	OriginID string
+}
+
+// Источники заявок
+const (
+	// OriginAPI - заявка через REST API, OriginID - субъект запроса
+	OriginAPI = "api"
+	// OriginBot - заявка бота, OriginID - ID бота
+	OriginBot = "bot"
+	// OriginSynthetic - нога синтетической заявки, OriginID - ее ID
+	OriginSynthetic = "synthetic"
+	// OriginRisk - закрытие позиции риск-движком, OriginID - stop_loss, take_profit или liquidation
+	OriginRisk = "risk"
+)
+
+// ReplaceRequest - изменение активной заявки
+type ReplaceRequest struct {
+	AccountID string
+	OrderID   string
+	// NewOrderID - ключ идемпотентности новой заявки, генерируется, если пуст
+	NewOrderID string
+	Lots       int64
+	Price      *decimal.Decimal
+	// PriceType - единицы Price: пункты или валюта расчетов
+	PriceType pb.PriceType
+}
+
+// StopOrderRequest - стоп-заявка в терминах сервера
+type StopOrderRequest struct {
+	AccountID    string
+	InstrumentID string
+	Direction    pb.StopOrderDirection
+	Type         pb.StopOrderType
+	// Lots - количество в лотах
+	Lots int64
+	// StopPrice - цена активации в пунктах котировки
+	StopPrice decimal.Decimal
+	// Price - цена выставляемой лимитной заявки, обязательна для stop-limit
+	Price *decimal.Decimal
+	// ExpirationType - до отмены или до ExpireDate
+	ExpirationType pb.StopOrderExpirationType
+	ExpireDate     time.Time
+}
+
+// TimeInForce - срок действия заявки
+type TimeInForce string
+
+const (
+	// TimeInForceDay - заявка действует до конца торгового дня
+	TimeInForceDay TimeInForce = "day"
+	// TimeInForceFillAndKill - неисполненный сразу остаток снимается
+	TimeInForceFillAndKill TimeInForce = "fill_and_kill"
+)
+
+// ParseDirection - направление заявки: buy или sell
+func ParseDirection(value string) (pb.OrderDirection, error) {
+	switch value {
+	case "buy":
+		return pb.OrderDirection_ORDER_DIRECTION_BUY, nil
+	case "sell":
+		return pb.OrderDirection_ORDER_DIRECTION_SELL, nil
+	}
+	return pb.OrderDirection_ORDER_DIRECTION_UNSPECIFIED, fmt.Errorf("unknown direction %q, expected buy or sell", value)
+}
+
+// ParseOrderType - тип заявки: market, limit или bestprice
+func ParseOrderType(value string) (pb.OrderType, error) {
+	switch value {
+	case "market":
+		return pb.OrderType_ORDER_TYPE_MARKET, nil
+	case "limit":
+		return pb.OrderType_ORDER_TYPE_LIMIT, nil
+	case "bestprice":
+		return pb.OrderType_ORDER_TYPE_BESTPRICE, nil
+	}
+	return pb.OrderType_ORDER_TYPE_UNSPECIFIED, fmt.Errorf("unknown order type %q, expected market, limit or bestprice", value)
+}
+
+// ParsePriceType - единицы цены: currency (по умолчанию) или points
+func ParsePriceType(value string) (pb.PriceType, error) {
+	switch value {
+	case "", "currency":
+		return pb.PriceType_PRICE_TYPE_CURRENCY, nil
+	case "points":
+		return pb.PriceType_PRICE_TYPE_POINT, nil
+	}
+	return pb.PriceType_PRICE_TYPE_UNSPECIFIED, fmt.Errorf("unknown price type %q, expected currency or points", value)
+}
+
+// ParseTimeInForce - срок действия: day (по умолчанию) или fill_and_kill.
+// Fill-or-kill investAPI не поддерживает.
+func ParseTimeInForce(value string) (TimeInForce, error) {
+	switch TimeInForce(value) {
+	case "", TimeInForceDay:
+		return TimeInForceDay, nil
+	case TimeInForceFillAndKill:
+		return TimeInForceFillAndKill, nil
+	case "fill_or_kill":
+		return "", fmt.Errorf("time in force fill_or_kill is not supported by the broker")
+	}
+	return "", fmt.Errorf("unknown time in force %q, expected day or fill_and_kill", value)
+}
+
+// ParseStopOrderType - тип стоп-заявки: stop_loss, take_profit или stop_limit
+func ParseStopOrderType(value string) (pb.StopOrderType, error) {
+	switch value {
+	case "stop_loss":
+		return pb.StopOrderType_STOP_ORDER_TYPE_STOP_LOSS, nil
+	case "take_profit":
+		return pb.StopOrderType_STOP_ORDER_TYPE_TAKE_PROFIT, nil
+	case "stop_limit":
+		return pb.StopOrderType_STOP_ORDER_TYPE_STOP_LIMIT, nil
+	}
+	return pb.StopOrderType_STOP_ORDER_TYPE_UNSPECIFIED, fmt.Errorf("unknown stop order type %q, expected stop_loss, take_profit or stop_limit", value)
+}
+
+// ParseStopExpiration - срок действия стоп-заявки: gtc (по умолчанию) или gtd
+func ParseStopExpiration(value string) (pb.StopOrderExpirationType, error) {
+	switch value {
+	case "", "gtc":
+		return pb.StopOrderExpirationType_STOP_ORDER_EXPIRATION_TYPE_GOOD_TILL_CANCEL, nil
+	case "gtd":
+		return pb.StopOrderExpirationType_STOP_ORDER_EXPIRATION_TYPE_GOOD_TILL_DATE, nil
+	}
+	return pb.StopOrderExpirationType_STOP_ORDER_EXPIRATION_TYPE_UNSPECIFIED, fmt.Errorf("unknown expiration %q, expected gtc or gtd", value)
+}
+
+// StopDirection - направление стоп-заявки по направлению заявки
+func StopDirection(direction pb.OrderDirection) pb.StopOrderDirection {
+	if direction == pb.OrderDirection_ORDER_DIRECTION_SELL {
+		return pb.StopOrderDirection_STOP_ORDER_DIRECTION_SELL
+	}
+	return pb.StopOrderDirection_STOP_ORDER_DIRECTION_BUY
+}
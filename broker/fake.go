@@ -0,0 +1,714 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"trading-bot-web/money"
+)
+
+// Fake - брокер в памяти для тестов обработчиков и локального запуска.
+// Данные задаются методами Set*/Add*, выставленные заявки запоминаются.
+// Рыночная заявка исполняется сразу по последней цене, лимитная остается активной.
+type Fake struct {
+	mu sync.Mutex
+
+	accounts    []*pb.Account
+	portfolios  map[string]*pb.PortfolioResponse
+	positions   map[string]*pb.PositionsResponse
+	operations  map[string][]*pb.Operation
+	candles     map[string][]*pb.HistoricCandle
+	orderBooks  map[string]*pb.GetOrderBookResponse
+	lastPrices  map[string]*pb.LastPrice
+	statuses    map[string]*pb.GetTradingStatusResponse
+	instruments map[string]*pb.Instrument
+	shares      []*pb.Share
+	bonds       []*pb.Bond
+	etfs        []*pb.Etf
+	futures     []*pb.Future
+	currencies  []*pb.Currency
+	options     []*pb.Option
+
+	orders        map[string]*pb.OrderState
+	orderAccounts map[string]string
+	posted        []OrderRequest
+	stopOrders    map[string]*pb.StopOrder
+	stopAccounts  map[string]string
+	seq           int
+	err           error
+	// requests - выставленные заявки по ключу идемпотентности
+	requests map[string]string
+	postErr  error
+}
+
+// NewFake - создание пустого брокера в памяти
+func NewFake() *Fake {
+	return &Fake{
+		portfolios:    make(map[string]*pb.PortfolioResponse),
+		positions:     make(map[string]*pb.PositionsResponse),
+		operations:    make(map[string][]*pb.Operation),
+		candles:       make(map[string][]*pb.HistoricCandle),
+		orderBooks:    make(map[string]*pb.GetOrderBookResponse),
+		lastPrices:    make(map[string]*pb.LastPrice),
+		statuses:      make(map[string]*pb.GetTradingStatusResponse),
+		instruments:   make(map[string]*pb.Instrument),
+		orders:        make(map[string]*pb.OrderState),
+		orderAccounts: make(map[string]string),
+		stopOrders:    make(map[string]*pb.StopOrder),
+		stopAccounts:  make(map[string]string),
+		requests:      make(map[string]string),
+	}
+}
+
+// FailWith - все последующие вызовы возвращают err, nil снимает ошибку
+func (f *Fake) FailWith(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+// FailAfterPost - последующие PostOrder выставляют заявку, но возвращают err,
+// как при потерянном ответе брокера; nil снимает ошибку
+func (f *Fake) FailAfterPost(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.postErr = err
+}
+
+// AddAccount - добавление счета
+func (f *Fake) AddAccount(account *pb.Account) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.accounts = append(f.accounts, account)
+}
+
+// SetPortfolio - портфель счета
+func (f *Fake) SetPortfolio(accountID string, portfolio *pb.PortfolioResponse) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.portfolios[accountID] = portfolio
+}
+
+// SetPositions - позиции счета
+func (f *Fake) SetPositions(accountID string, positions *pb.PositionsResponse) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.positions[accountID] = positions
+}
+
+// AddOperations - операции счета
+func (f *Fake) AddOperations(accountID string, operations ...*pb.Operation) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.operations[accountID] = append(f.operations[accountID], operations...)
+}
+
+// AddCandles - свечи инструмента
+func (f *Fake) AddCandles(instrumentID string, candles ...*pb.HistoricCandle) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.candles[instrumentID] = append(f.candles[instrumentID], candles...)
+}
+
+// SetOrderBook - стакан инструмента
+func (f *Fake) SetOrderBook(instrumentID string, orderBook *pb.GetOrderBookResponse) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.orderBooks[instrumentID] = orderBook
+}
+
+// SetLastPrice - последняя цена инструмента
+func (f *Fake) SetLastPrice(instrumentID string, price float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lastPrices[instrumentID] = &pb.LastPrice{
+		Figi:  instrumentID,
+		Price: money.ToQuotation(decimal.NewFromFloat(price)),
+		Time:  timestamppb.Now(),
+	}
+}
+
+// SetTradingStatus - торговый статус инструмента
+func (f *Fake) SetTradingStatus(instrumentID string, status *pb.GetTradingStatusResponse) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.statuses[instrumentID] = status
+}
+
+// AddInstrument - инструмент справочника
+func (f *Fake) AddInstrument(instrument *pb.Instrument) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.instruments[instrument.GetFigi()] = instrument
+}
+
+// AddShares - акции справочника
+func (f *Fake) AddShares(shares ...*pb.Share) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.shares = append(f.shares, shares...)
+}
+
+// AddBonds - облигации справочника
+func (f *Fake) AddBonds(bonds ...*pb.Bond) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.bonds = append(f.bonds, bonds...)
+}
+
+// AddEtfs - фонды справочника
+func (f *Fake) AddEtfs(etfs ...*pb.Etf) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.etfs = append(f.etfs, etfs...)
+}
+
+// AddFutures - фьючерсы справочника
+func (f *Fake) AddFutures(futures ...*pb.Future) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.futures = append(f.futures, futures...)
+}
+
+// AddCurrencies - валюты справочника
+func (f *Fake) AddCurrencies(currencies ...*pb.Currency) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.currencies = append(f.currencies, currencies...)
+}
+
+// AddOptions - опционы справочника
+func (f *Fake) AddOptions(options ...*pb.Option) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.options = append(f.options, options...)
+}
+
+// Posted - все выставленные заявки в порядке поступления
+func (f *Fake) Posted() []OrderRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]OrderRequest(nil), f.posted...)
+}
+
+// PostOrder - выставление заявки
+func (f *Fake) PostOrder(ctx context.Context, req OrderRequest) (*pb.PostOrderResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	resp, err := f.postOrder(req)
+	if err == nil && f.postErr != nil {
+		return nil, f.postErr
+	}
+	return resp, err
+}
+
+// ReplaceOrder - отмена активной заявки и выставление новой с тем же инструментом и направлением
+func (f *Fake) ReplaceOrder(ctx context.Context, req ReplaceRequest) (*pb.PostOrderResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	state, exists := f.orders[req.OrderID]
+	if !exists || f.orderAccounts[req.OrderID] != req.AccountID {
+		return nil, fmt.Errorf("order %s not found", req.OrderID)
+	}
+	if state.ExecutionReportStatus != pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_NEW {
+		return nil, fmt.Errorf("order %s is not active", req.OrderID)
+	}
+	state.ExecutionReportStatus = pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_CANCELLED
+
+	return f.postOrder(OrderRequest{
+		AccountID:    req.AccountID,
+		InstrumentID: state.Figi,
+		Direction:    state.Direction,
+		OrderType:    state.OrderType,
+		Lots:         req.Lots,
+		Price:        req.Price,
+		OrderID:      req.NewOrderID,
+	})
+}
+
+// postOrder - выставление заявки под блокировкой
+func (f *Fake) postOrder(req OrderRequest) (*pb.PostOrderResponse, error) {
+	if req.Lots < 1 {
+		return nil, fmt.Errorf("quantity must be positive")
+	}
+	// Повтор с тем же ключом идемпотентности возвращает уже выставленную заявку
+	if orderID, exists := f.requests[req.OrderID]; exists && req.OrderID != "" {
+		return orderResponse(f.orders[orderID]), nil
+	}
+
+	f.seq++
+	f.posted = append(f.posted, req)
+	state := &pb.OrderState{
+		OrderId:               fmt.Sprintf("fake_%d", f.seq),
+		OrderRequestId:        req.OrderID,
+		ExecutionReportStatus: pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_NEW,
+		LotsRequested:         req.Lots,
+		Figi:                  req.InstrumentID,
+		InstrumentUid:         req.InstrumentID,
+		Direction:             req.Direction,
+		OrderType:             req.OrderType,
+		OrderDate:             timestamppb.Now(),
+	}
+	if req.Price != nil {
+		state.InitialSecurityPrice = money.ToMoneyValue(*req.Price, "")
+	}
+
+	if last, exists := f.lastPrices[req.InstrumentID]; exists && req.OrderType != pb.OrderType_ORDER_TYPE_LIMIT {
+		state.ExecutionReportStatus = pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_FILL
+		state.LotsExecuted = req.Lots
+		state.ExecutedOrderPrice = quotationToMoney(last.GetPrice())
+	}
+	if req.TimeInForce == TimeInForceFillAndKill && state.ExecutionReportStatus == pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_NEW {
+		state.ExecutionReportStatus = pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_CANCELLED
+	}
+	f.orders[state.OrderId] = state
+	f.orderAccounts[state.OrderId] = req.AccountID
+	if req.OrderID != "" {
+		f.requests[req.OrderID] = state.OrderId
+	}
+	return orderResponse(state), nil
+}
+
+// orderResponse - ответ на выставление по состоянию заявки
+func orderResponse(state *pb.OrderState) *pb.PostOrderResponse {
+	return &pb.PostOrderResponse{
+		OrderId:               state.OrderId,
+		ExecutionReportStatus: state.ExecutionReportStatus,
+		LotsRequested:         state.LotsRequested,
+		LotsExecuted:          state.LotsExecuted,
+		ExecutedOrderPrice:    state.ExecutedOrderPrice,
+		InitialSecurityPrice:  state.InitialSecurityPrice,
+		Figi:                  state.Figi,
+		InstrumentUid:         state.InstrumentUid,
+		Direction:             state.Direction,
+		OrderType:             state.OrderType,
+	}
+}
+
+// FillOrder - исполнение lots лотов активной заявки по цене за штуку, как если
+// бы биржа свела ее после выставления
+func (f *Fake) FillOrder(orderID string, lots int64, price float64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	state, exists := f.orders[orderID]
+	if !exists {
+		return fmt.Errorf("order %s not found", orderID)
+	}
+	if !fakeOrderActive(state) {
+		return fmt.Errorf("order %s is not active", orderID)
+	}
+	if lots < 1 || state.LotsExecuted+lots > state.LotsRequested {
+		return fmt.Errorf("cannot fill %d lots of order %s", lots, orderID)
+	}
+
+	state.LotsExecuted += lots
+	state.AveragePositionPrice = money.ToMoneyValue(decimal.NewFromFloat(price), "")
+	state.ExecutionReportStatus = pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_PARTIALLYFILL
+	if state.LotsExecuted == state.LotsRequested {
+		state.ExecutionReportStatus = pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_FILL
+	}
+	return nil
+}
+
+// CancelOrder - отмена активной заявки
+func (f *Fake) CancelOrder(ctx context.Context, accountID, orderID string) (*pb.CancelOrderResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	state, exists := f.orders[orderID]
+	if !exists || f.orderAccounts[orderID] != accountID {
+		return nil, fmt.Errorf("order %s not found", orderID)
+	}
+	if !fakeOrderActive(state) {
+		return nil, fmt.Errorf("order %s is not active", orderID)
+	}
+	state.ExecutionReportStatus = pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_CANCELLED
+	return &pb.CancelOrderResponse{Time: timestamppb.Now()}, nil
+}
+
+// GetOrderState - состояние заявки
+func (f *Fake) GetOrderState(ctx context.Context, accountID, orderID string) (*pb.OrderState, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	state, exists := f.orders[orderID]
+	if !exists || f.orderAccounts[orderID] != accountID {
+		return nil, fmt.Errorf("order %s not found", orderID)
+	}
+	// Копия: состояние в памяти меняется при исполнении и отмене
+	return proto.Clone(state).(*pb.OrderState), nil
+}
+
+// GetOrders - активные заявки
+func (f *Fake) GetOrders(ctx context.Context, accountID string) ([]*pb.OrderState, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	orders := make([]*pb.OrderState, 0)
+	for _, state := range f.orders {
+		if f.orderAccounts[state.OrderId] == accountID && fakeOrderActive(state) {
+			orders = append(orders, proto.Clone(state).(*pb.OrderState))
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].OrderDate.AsTime().Before(orders[j].OrderDate.AsTime()) })
+	return orders, nil
+}
+
+// PostStopOrder - выставление стоп-заявки; стоп-заявки не срабатывают
+func (f *Fake) PostStopOrder(ctx context.Context, req StopOrderRequest) (*pb.PostStopOrderResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	if req.Lots < 1 {
+		return nil, fmt.Errorf("quantity must be positive")
+	}
+
+	f.seq++
+	stopOrder := &pb.StopOrder{
+		StopOrderId:   fmt.Sprintf("fake_stop_%d", f.seq),
+		LotsRequested: req.Lots,
+		Figi:          req.InstrumentID,
+		InstrumentUid: req.InstrumentID,
+		Direction:     req.Direction,
+		OrderType:     req.Type,
+		CreateDate:    timestamppb.Now(),
+		StopPrice:     money.ToMoneyValue(req.StopPrice, ""),
+	}
+	if req.Price != nil {
+		stopOrder.Price = money.ToMoneyValue(*req.Price, "")
+	}
+	if req.ExpirationType == pb.StopOrderExpirationType_STOP_ORDER_EXPIRATION_TYPE_GOOD_TILL_DATE {
+		stopOrder.ExpirationTime = timestamppb.New(req.ExpireDate)
+	}
+	f.stopOrders[stopOrder.StopOrderId] = stopOrder
+	f.stopAccounts[stopOrder.StopOrderId] = req.AccountID
+
+	return &pb.PostStopOrderResponse{StopOrderId: stopOrder.StopOrderId}, nil
+}
+
+// GetStopOrders - активные стоп-заявки
+func (f *Fake) GetStopOrders(ctx context.Context, accountID string) ([]*pb.StopOrder, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	stopOrders := make([]*pb.StopOrder, 0)
+	for id, stopOrder := range f.stopOrders {
+		if f.stopAccounts[id] == accountID {
+			stopOrders = append(stopOrders, stopOrder)
+		}
+	}
+	sort.Slice(stopOrders, func(i, j int) bool {
+		return stopOrders[i].CreateDate.AsTime().Before(stopOrders[j].CreateDate.AsTime())
+	})
+	return stopOrders, nil
+}
+
+// CancelStopOrder - отмена стоп-заявки
+func (f *Fake) CancelStopOrder(ctx context.Context, accountID, stopOrderID string) (*pb.CancelStopOrderResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	if _, exists := f.stopOrders[stopOrderID]; !exists || f.stopAccounts[stopOrderID] != accountID {
+		return nil, fmt.Errorf("stop order %s not found", stopOrderID)
+	}
+	delete(f.stopOrders, stopOrderID)
+	delete(f.stopAccounts, stopOrderID)
+	return &pb.CancelStopOrderResponse{Time: timestamppb.Now()}, nil
+}
+
+// GetCandles - свечи инструмента за период
+func (f *Fake) GetCandles(ctx context.Context, instrumentID string, interval pb.CandleInterval, from, to time.Time) ([]*pb.HistoricCandle, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	candles := make([]*pb.HistoricCandle, 0)
+	for _, candle := range f.candles[instrumentID] {
+		t := candle.GetTime().AsTime()
+		if !t.Before(from) && t.Before(to) {
+			candles = append(candles, candle)
+		}
+	}
+	return candles, nil
+}
+
+// GetOrderBook - стакан инструмента
+func (f *Fake) GetOrderBook(ctx context.Context, instrumentID string, depth int32) (*pb.GetOrderBookResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	orderBook, exists := f.orderBooks[instrumentID]
+	if !exists {
+		return nil, fmt.Errorf("order book for %s not found", instrumentID)
+	}
+	return orderBook, nil
+}
+
+// GetLastPrices - последние цены известных инструментов
+func (f *Fake) GetLastPrices(ctx context.Context, instrumentIDs []string) ([]*pb.LastPrice, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	prices := make([]*pb.LastPrice, 0, len(instrumentIDs))
+	for _, instrumentID := range instrumentIDs {
+		if price, exists := f.lastPrices[instrumentID]; exists {
+			prices = append(prices, price)
+		}
+	}
+	return prices, nil
+}
+
+// GetTradingStatus - торговый статус, по умолчанию нормальная торговля
+func (f *Fake) GetTradingStatus(ctx context.Context, instrumentID string) (*pb.GetTradingStatusResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	if status, exists := f.statuses[instrumentID]; exists {
+		return status, nil
+	}
+	return &pb.GetTradingStatusResponse{
+		Figi:                     instrumentID,
+		TradingStatus:            pb.SecurityTradingStatus_SECURITY_TRADING_STATUS_NORMAL_TRADING,
+		LimitOrderAvailableFlag:  true,
+		MarketOrderAvailableFlag: true,
+		ApiTradeAvailableFlag:    true,
+	}, nil
+}
+
+// GetAccounts - счета
+func (f *Fake) GetAccounts(ctx context.Context) ([]*pb.Account, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	return append([]*pb.Account(nil), f.accounts...), nil
+}
+
+// GetPortfolio - портфель счета, пустой, если не задан
+func (f *Fake) GetPortfolio(ctx context.Context, accountID string) (*pb.PortfolioResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	if portfolio, exists := f.portfolios[accountID]; exists {
+		return portfolio, nil
+	}
+	return &pb.PortfolioResponse{AccountId: accountID}, nil
+}
+
+// GetPositions - позиции счета, пустые, если не заданы
+func (f *Fake) GetPositions(ctx context.Context, accountID string) (*pb.PositionsResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	if positions, exists := f.positions[accountID]; exists {
+		return positions, nil
+	}
+	return &pb.PositionsResponse{}, nil
+}
+
+// GetOperations - операции счета
+func (f *Fake) GetOperations(ctx context.Context, accountID string, from, to time.Time) ([]*pb.Operation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	return append([]*pb.Operation(nil), f.operations[accountID]...), nil
+}
+
+// FindInstrument - поиск по FIGI, тикеру или вхождению в название
+func (f *Fake) FindInstrument(ctx context.Context, query string) ([]*pb.InstrumentShort, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	query = strings.ToLower(query)
+	found := make([]*pb.InstrumentShort, 0)
+	for _, instrument := range f.instruments {
+		if strings.ToLower(instrument.GetFigi()) == query ||
+			strings.ToLower(instrument.GetTicker()) == query ||
+			strings.Contains(strings.ToLower(instrument.GetName()), query) {
+			found = append(found, &pb.InstrumentShort{
+				Figi:                  instrument.GetFigi(),
+				Ticker:                instrument.GetTicker(),
+				ClassCode:             instrument.GetClassCode(),
+				Isin:                  instrument.GetIsin(),
+				InstrumentType:        instrument.GetInstrumentType(),
+				Name:                  instrument.GetName(),
+				Uid:                   instrument.GetUid(),
+				ApiTradeAvailableFlag: instrument.GetApiTradeAvailableFlag(),
+			})
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Figi < found[j].Figi })
+	return found, nil
+}
+
+// InstrumentByFigi - инструмент по FIGI
+func (f *Fake) InstrumentByFigi(ctx context.Context, figi string) (*pb.Instrument, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	instrument, exists := f.instruments[figi]
+	if !exists {
+		// Как investAPI: отсутствие инструмента - код NotFound
+		return nil, status.Errorf(codes.NotFound, "instrument %s not found", figi)
+	}
+	return instrument, nil
+}
+
+// PointValue - стоимость пункта: процент номинала для облигаций справочника, иначе 1
+func (f *Fake) PointValue(ctx context.Context, instrumentID string) (decimal.Decimal, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return decimal.Zero, f.err
+	}
+	for _, bond := range f.bonds {
+		if bond.GetFigi() == instrumentID || bond.GetUid() == instrumentID {
+			return money.FromMoneyValue(bond.GetNominal()).Div(decimal.NewFromInt(100)), nil
+		}
+	}
+	return decimal.NewFromInt(1), nil
+}
+
+// Shares - акции
+func (f *Fake) Shares(ctx context.Context) ([]*pb.Share, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	return append([]*pb.Share(nil), f.shares...), nil
+}
+
+// Bonds - облигации
+func (f *Fake) Bonds(ctx context.Context) ([]*pb.Bond, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	return append([]*pb.Bond(nil), f.bonds...), nil
+}
+
+// Etfs - фонды
+func (f *Fake) Etfs(ctx context.Context) ([]*pb.Etf, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	return append([]*pb.Etf(nil), f.etfs...), nil
+}
+
+// Futures - фьючерсы
+func (f *Fake) Futures(ctx context.Context) ([]*pb.Future, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	return append([]*pb.Future(nil), f.futures...), nil
+}
+
+// Currencies - валюты
+func (f *Fake) Currencies(ctx context.Context) ([]*pb.Currency, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	return append([]*pb.Currency(nil), f.currencies...), nil
+}
+
+// Options - опционы
+func (f *Fake) Options(ctx context.Context) ([]*pb.Option, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	return append([]*pb.Option(nil), f.options...), nil
+}
+
+// fakeOrderActive - ожидает ли заявка исполнения
+func fakeOrderActive(state *pb.OrderState) bool {
+	return state.ExecutionReportStatus == pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_NEW ||
+		state.ExecutionReportStatus == pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_PARTIALLYFILL
+}
+
+// quotationToMoney - цена в MoneyValue без валюты
+func quotationToMoney(q *pb.Quotation) *pb.MoneyValue {
+	return &pb.MoneyValue{Units: q.GetUnits(), Nano: q.GetNano()}
+}
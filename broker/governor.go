@@ -0,0 +1,408 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"trading-bot-web/config"
+)
+
+// Сервисы investAPI с отдельными квотами запросов
+const (
+	ServiceUsers       = "users"
+	ServiceOrders      = "orders"
+	ServiceStopOrders  = "stoporders"
+	ServiceOperations  = "operations"
+	ServiceMarketData  = "marketdata"
+	ServiceInstruments = "instruments"
+)
+
+// DefaultQuotas - квоты investAPI в минуту для сервисов, не заданных в настройках
+var DefaultQuotas = map[string]config.RateLimitConfig{
+	ServiceUsers:       {RequestsPerMinute: 100, Burst: 10},
+	ServiceOrders:      {RequestsPerMinute: 100, Burst: 10},
+	ServiceStopOrders:  {RequestsPerMinute: 50, Burst: 5},
+	ServiceOperations:  {RequestsPerMinute: 200, Burst: 20},
+	ServiceMarketData:  {RequestsPerMinute: 600, Burst: 50},
+	ServiceInstruments: {RequestsPerMinute: 200, Burst: 20},
+}
+
+// Priority - очередность вызова при нехватке квоты
+type Priority int
+
+const (
+	// PriorityLow - поиск инструментов и чтение для дашборда
+	PriorityLow Priority = iota
+	// PriorityNormal - чтение состояния ботами и фоновыми задачами
+	PriorityNormal
+	// PriorityHigh - выставление и отмена заявок
+	PriorityHigh
+
+	priorityCount = 3
+)
+
+// String - название приоритета для метрик
+func (p Priority) String() string {
+	switch p {
+	case PriorityHigh:
+		return "high"
+	case PriorityNormal:
+		return "normal"
+	default:
+		return "low"
+	}
+}
+
+var ErrQueueFull = errors.New("broker api queue is full")
+
+type priorityKey struct{}
+
+// WithPriority - приоритет вызовов брокера, сделанных с этим контекстом
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// priorityFrom - приоритет из контекста или def, если он не задан
+func priorityFrom(ctx context.Context, def Priority) Priority {
+	if priority, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return priority
+	}
+	return def
+}
+
+// Governor - общий для ботов и обработчиков ограничитель исходящих запросов
+// к investAPI. Держит квоту каждого сервиса, а вызовы сверх квоты ждут
+// в очереди: сначала заявки, затем чтение ботами, затем справочники и дашборд.
+type Governor struct {
+	maxQueue int
+	cooldown time.Duration
+	logger   *zap.SugaredLogger
+	services map[string]*serviceQueue
+}
+
+// NewGovernor - ограничитель по квотам из настроек
+func NewGovernor(cfg config.BrokerAPIConfig, logger *zap.SugaredLogger) *Governor {
+	g := &Governor{
+		maxQueue: cfg.MaxQueue,
+		cooldown: cfg.Cooldown,
+		logger:   logger,
+		services: make(map[string]*serviceQueue),
+	}
+	for name, quota := range DefaultQuotas {
+		if custom, exists := cfg.Quotas[name]; exists && custom.RequestsPerMinute > 0 {
+			quota = custom
+		}
+		g.services[name] = newServiceQueue(quota)
+	}
+	return g
+}
+
+// Acquire - ожидание квоты сервиса. Возвращает ошибку, если очередь переполнена
+// или контекст отменен раньше, чем подошла очередь вызова.
+func (g *Governor) Acquire(ctx context.Context, service string, priority Priority) error {
+	q, exists := g.services[service]
+	if !exists {
+		return nil
+	}
+
+	q.mu.Lock()
+	now := time.Now()
+	q.refill(now)
+	if q.queuedFrom(priority) == 0 && q.available(now, priority) {
+		q.tokens--
+		q.calls++
+		q.mu.Unlock()
+		return nil
+	}
+	if g.maxQueue > 0 && q.depth() >= g.maxQueue {
+		q.rejected++
+		q.mu.Unlock()
+		return ErrQueueFull
+	}
+
+	w := &waiter{ready: make(chan struct{})}
+	q.queues[priority] = append(q.queues[priority], w)
+	q.throttled++
+	q.schedule(now)
+	q.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		q.observeWait(time.Since(now))
+		return nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if w.granted {
+			// Квота выдана одновременно с отменой - возвращаем ее следующему
+			q.tokens++
+			q.calls--
+			q.schedule(time.Now())
+		} else {
+			q.remove(priority, w)
+		}
+		q.canceled++
+		return ctx.Err()
+	}
+}
+
+// Observe - учет ответа брокера. На ResourceExhausted сервис приостанавливается
+// на время cooldown, чтобы не добивать исчерпанную квоту повторами.
+func (g *Governor) Observe(service string, err error) {
+	q, exists := g.services[service]
+	if !exists || !resourceExhausted(err) {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	q.exhausted++
+	q.tokens = 0
+	q.updated = now
+	q.pausedUntil = now.Add(g.cooldown)
+	q.schedule(now)
+	g.logger.Warnf("Broker API quota exhausted for %s service, pausing for %v", service, g.cooldown)
+}
+
+// ServiceStats - состояние квоты и очереди сервиса
+type ServiceStats struct {
+	RequestsPerMinute int `json:"requests_per_minute"`
+	Available         int `json:"available"`
+	// Queued - ожидающие вызовы по приоритетам
+	Queued     map[string]int `json:"queued"`
+	QueueDepth int            `json:"queue_depth"`
+	Calls      int64          `json:"calls"`
+	// Throttled - вызовы, ждавшие квоту в очереди
+	Throttled         int64      `json:"throttled"`
+	Rejected          int64      `json:"rejected"`
+	Canceled          int64      `json:"canceled"`
+	ResourceExhausted int64      `json:"resource_exhausted"`
+	AvgWaitMs         float64    `json:"avg_wait_ms"`
+	MaxWaitMs         float64    `json:"max_wait_ms"`
+	PausedUntil       *time.Time `json:"paused_until,omitempty"`
+}
+
+// Stats - состояние всех сервисов
+func (g *Governor) Stats() map[string]ServiceStats {
+	stats := make(map[string]ServiceStats, len(g.services))
+	for name, q := range g.services {
+		stats[name] = q.stats()
+	}
+	return stats
+}
+
+// QueueDepth - число вызовов, ожидающих квоту во всех сервисах
+func (g *Governor) QueueDepth() int {
+	depth := 0
+	for _, q := range g.services {
+		q.mu.Lock()
+		depth += q.depth()
+		q.mu.Unlock()
+	}
+	return depth
+}
+
+// resourceExhausted - исчерпана ли квота по ответу брокера
+func resourceExhausted(err error) bool {
+	var se interface{ GRPCStatus() *status.Status }
+	return errors.As(err, &se) && se.GRPCStatus().Code() == codes.ResourceExhausted
+}
+
+// waiter - вызов, ожидающий квоту
+type waiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// serviceQueue - корзина квоты сервиса и очереди ожидающих вызовов
+type serviceQueue struct {
+	quota    config.RateLimitConfig
+	rate     float64
+	capacity float64
+
+	mu          sync.Mutex
+	tokens      float64
+	updated     time.Time
+	pausedUntil time.Time
+	queues      [priorityCount][]*waiter
+	timer       *time.Timer
+
+	calls, throttled, rejected, canceled, exhausted int64
+	waitTotal, waitMax                              time.Duration
+	waited                                          int64
+}
+
+// newServiceQueue - полная корзина с пополнением quota в минуту
+func newServiceQueue(quota config.RateLimitConfig) *serviceQueue {
+	capacity := float64(quota.Burst)
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &serviceQueue{
+		quota:    quota,
+		rate:     float64(quota.RequestsPerMinute) / 60,
+		capacity: capacity,
+		tokens:   capacity,
+		updated:  time.Now(),
+	}
+}
+
+// refill - пополнение корзины на момент now; во время паузы квота не копится
+func (q *serviceQueue) refill(now time.Time) {
+	from := q.updated
+	if from.Before(q.pausedUntil) {
+		from = q.pausedUntil
+	}
+	if elapsed := now.Sub(from).Seconds(); elapsed > 0 {
+		q.tokens += elapsed * q.rate
+		if q.tokens > q.capacity {
+			q.tokens = q.capacity
+		}
+	}
+	q.updated = now
+}
+
+// reserve - сколько токенов вызов с приоритетом оставляет более важным.
+// Чтение для дашборда не забирает последние токены у заявок того же сервиса.
+func (q *serviceQueue) reserve(priority Priority) float64 {
+	if priority == PriorityLow {
+		return float64(int(q.capacity / 5))
+	}
+	return 0
+}
+
+// available - можно ли сейчас выполнить вызов с приоритетом
+func (q *serviceQueue) available(now time.Time, priority Priority) bool {
+	return !now.Before(q.pausedUntil) && q.tokens >= 1+q.reserve(priority)
+}
+
+// queuedFrom - ожидающие вызовы с приоритетом не ниже заданного
+func (q *serviceQueue) queuedFrom(priority Priority) int {
+	n := 0
+	for p := priority; p < priorityCount; p++ {
+		n += len(q.queues[p])
+	}
+	return n
+}
+
+// depth - все ожидающие вызовы
+func (q *serviceQueue) depth() int {
+	return q.queuedFrom(PriorityLow)
+}
+
+// top - наивысший приоритет среди ожидающих вызовов
+func (q *serviceQueue) top() (Priority, bool) {
+	for p := Priority(priorityCount - 1); p >= PriorityLow; p-- {
+		if len(q.queues[p]) > 0 {
+			return p, true
+		}
+	}
+	return 0, false
+}
+
+// schedule - запуск раздачи квоты, когда ее хватит первому в очереди
+func (q *serviceQueue) schedule(now time.Time) {
+	priority, waiting := q.top()
+	if !waiting {
+		return
+	}
+
+	var delay time.Duration
+	if now.Before(q.pausedUntil) {
+		delay = q.pausedUntil.Sub(now)
+	} else if missing := 1 + q.reserve(priority) - q.tokens; missing > 0 {
+		delay = time.Duration(missing / q.rate * float64(time.Second))
+	}
+
+	if q.timer == nil {
+		q.timer = time.AfterFunc(delay, q.dispatch)
+	} else {
+		q.timer.Reset(delay)
+	}
+}
+
+// dispatch - выдача квоты ожидающим вызовам строго по приоритету
+func (q *serviceQueue) dispatch() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	q.refill(now)
+	for {
+		priority, waiting := q.top()
+		if !waiting || !q.available(now, priority) {
+			break
+		}
+		w := q.queues[priority][0]
+		q.queues[priority] = q.queues[priority][1:]
+		q.tokens--
+		q.calls++
+		w.granted = true
+		close(w.ready)
+	}
+	q.schedule(now)
+}
+
+// remove - удаление отмененного вызова из очереди
+func (q *serviceQueue) remove(priority Priority, w *waiter) {
+	queue := q.queues[priority]
+	for i, queued := range queue {
+		if queued == w {
+			q.queues[priority] = append(queue[:i], queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// observeWait - учет времени ожидания квоты
+func (q *serviceQueue) observeWait(wait time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.waited++
+	q.waitTotal += wait
+	if wait > q.waitMax {
+		q.waitMax = wait
+	}
+}
+
+// stats - снимок состояния сервиса
+func (q *serviceQueue) stats() ServiceStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	q.refill(now)
+
+	stats := ServiceStats{
+		RequestsPerMinute: q.quota.RequestsPerMinute,
+		Available:         int(q.tokens),
+		Queued:            make(map[string]int, priorityCount),
+		QueueDepth:        q.depth(),
+		Calls:             q.calls,
+		Throttled:         q.throttled,
+		Rejected:          q.rejected,
+		Canceled:          q.canceled,
+		ResourceExhausted: q.exhausted,
+		MaxWaitMs:         float64(q.waitMax) / float64(time.Millisecond),
+	}
+	for p := PriorityLow; p < priorityCount; p++ {
+		stats.Queued[p.String()] = len(q.queues[p])
+	}
+	if q.waited > 0 {
+		stats.AvgWaitMs = float64(q.waitTotal) / float64(q.waited) / float64(time.Millisecond)
+	}
+	if now.Before(q.pausedUntil) {
+		paused := q.pausedUntil
+		stats.PausedUntil = &paused
+	}
+	return stats
+}
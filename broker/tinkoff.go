@@ -0,0 +1,473 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/tinkoff/invest-api-go-sdk/investgo"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+
+	"trading-bot-web/money"
+)
+
+// Tinkoff - реализация Broker поверх сервисов investgo
+type Tinkoff struct {
+	users       *investgo.UsersServiceClient
+	orders      *investgo.OrdersServiceClient
+	stopOrders  *investgo.StopOrdersServiceClient
+	operations  *investgo.OperationsServiceClient
+	marketData  *investgo.MarketDataServiceClient
+	instruments *investgo.InstrumentsServiceClient
+	// governor - квоты запросов, общие для всех пользователей адаптера
+	governor *Governor
+}
+
+// NewTinkoff - создание адаптера для клиента investAPI.
+// Все вызовы проходят через governor; nil - без ограничений.
+func NewTinkoff(client *investgo.Client, governor *Governor) *Tinkoff {
+	return &Tinkoff{
+		users:       client.NewUsersServiceClient(),
+		orders:      client.NewOrdersServiceClient(),
+		stopOrders:  client.NewStopOrdersServiceClient(),
+		operations:  client.NewOperationsServiceClient(),
+		marketData:  client.NewMarketDataServiceClient(),
+		instruments: client.NewInstrumentsServiceClient(),
+		governor:    governor,
+	}
+}
+
+// call - вызов сервиса investAPI в пределах его квоты.
+// Приоритет из контекста (WithPriority) заменяет приоритет метода.
+func (t *Tinkoff) call(ctx context.Context, service string, priority Priority, fn func() error) error {
+	if t.governor == nil {
+		return fn()
+	}
+	if err := t.governor.Acquire(ctx, service, priorityFrom(ctx, priority)); err != nil {
+		return err
+	}
+	err := fn()
+	t.governor.Observe(service, err)
+	return err
+}
+
+// PostOrder - выставление заявки
+func (t *Tinkoff) PostOrder(ctx context.Context, req OrderRequest) (*pb.PostOrderResponse, error) {
+	price := money.OptionalQuotation(req.Price)
+	orderID := req.OrderID
+	if orderID == "" {
+		orderID = investgo.CreateUid()
+	}
+
+	var resp *investgo.PostOrderResponse
+	err := t.call(ctx, ServiceOrders, PriorityHigh, func() (err error) {
+		resp, err = t.orders.PostOrder(&investgo.PostOrderRequest{
+			InstrumentId: req.InstrumentID,
+			Quantity:     req.Lots,
+			Price:        price,
+			Direction:    req.Direction,
+			AccountId:    req.AccountID,
+			OrderType:    req.OrderType,
+			OrderId:      orderID,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if req.TimeInForce == TimeInForceFillAndKill {
+		return t.killRemainder(ctx, req.AccountID, resp.PostOrderResponse), nil
+	}
+	return resp.PostOrderResponse, nil
+}
+
+// killRemainder - снятие неисполненного остатка заявки fill-and-kill.
+// investAPI не принимает срок действия заявки, поэтому остаток снимается
+// отдельным вызовом. Если отмена не удалась, возвращается исходный статус,
+// и клиент видит, что заявка еще активна.
+func (t *Tinkoff) killRemainder(ctx context.Context, accountID string, resp *pb.PostOrderResponse) *pb.PostOrderResponse {
+	switch resp.GetExecutionReportStatus() {
+	case pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_NEW,
+		pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_PARTIALLYFILL:
+	default:
+		return resp
+	}
+	if _, err := t.CancelOrder(ctx, accountID, resp.GetOrderId()); err != nil {
+		return resp
+	}
+	resp.ExecutionReportStatus = pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_CANCELLED
+	return resp
+}
+
+// ReplaceOrder - изменение активной заявки
+func (t *Tinkoff) ReplaceOrder(ctx context.Context, req ReplaceRequest) (*pb.PostOrderResponse, error) {
+	price := money.OptionalQuotation(req.Price)
+	newOrderID := req.NewOrderID
+	if newOrderID == "" {
+		newOrderID = investgo.CreateUid()
+	}
+
+	var resp *investgo.PostOrderResponse
+	err := t.call(ctx, ServiceOrders, PriorityHigh, func() (err error) {
+		resp, err = t.orders.ReplaceOrder(&investgo.ReplaceOrderRequest{
+			AccountId:  req.AccountID,
+			OrderId:    req.OrderID,
+			NewOrderId: newOrderID,
+			Quantity:   req.Lots,
+			Price:      price,
+			PriceType:  req.PriceType,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.PostOrderResponse, nil
+}
+
+// CancelOrder - отмена заявки
+func (t *Tinkoff) CancelOrder(ctx context.Context, accountID, orderID string) (*pb.CancelOrderResponse, error) {
+	var resp *investgo.CancelOrderResponse
+	err := t.call(ctx, ServiceOrders, PriorityHigh, func() (err error) {
+		resp, err = t.orders.CancelOrder(accountID, orderID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.CancelOrderResponse, nil
+}
+
+// GetOrderState - состояние заявки
+func (t *Tinkoff) GetOrderState(ctx context.Context, accountID, orderID string) (*pb.OrderState, error) {
+	var resp *investgo.GetOrderStateResponse
+	err := t.call(ctx, ServiceOrders, PriorityNormal, func() (err error) {
+		resp, err = t.orders.GetOrderState(accountID, orderID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.OrderState, nil
+}
+
+// GetOrders - активные заявки счета
+func (t *Tinkoff) GetOrders(ctx context.Context, accountID string) ([]*pb.OrderState, error) {
+	var resp *investgo.GetOrdersResponse
+	err := t.call(ctx, ServiceOrders, PriorityNormal, func() (err error) {
+		resp, err = t.orders.GetOrders(accountID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetOrders(), nil
+}
+
+// PostStopOrder - выставление стоп-заявки
+func (t *Tinkoff) PostStopOrder(ctx context.Context, req StopOrderRequest) (*pb.PostStopOrderResponse, error) {
+	price := money.OptionalQuotation(req.Price)
+
+	var resp *investgo.PostStopOrderResponse
+	err := t.call(ctx, ServiceStopOrders, PriorityHigh, func() (err error) {
+		resp, err = t.stopOrders.PostStopOrder(&investgo.PostStopOrderRequest{
+			InstrumentId:   req.InstrumentID,
+			Quantity:       req.Lots,
+			Price:          price,
+			StopPrice:      money.ToQuotation(req.StopPrice),
+			Direction:      req.Direction,
+			AccountId:      req.AccountID,
+			ExpirationType: req.ExpirationType,
+			StopOrderType:  req.Type,
+			ExpireDate:     req.ExpireDate,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.PostStopOrderResponse, nil
+}
+
+// GetStopOrders - активные стоп-заявки счета
+func (t *Tinkoff) GetStopOrders(ctx context.Context, accountID string) ([]*pb.StopOrder, error) {
+	var resp *investgo.GetStopOrdersResponse
+	err := t.call(ctx, ServiceStopOrders, PriorityNormal, func() (err error) {
+		resp, err = t.stopOrders.GetStopOrders(accountID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetStopOrders(), nil
+}
+
+// CancelStopOrder - отмена стоп-заявки
+func (t *Tinkoff) CancelStopOrder(ctx context.Context, accountID, stopOrderID string) (*pb.CancelStopOrderResponse, error) {
+	var resp *investgo.CancelStopOrderResponse
+	err := t.call(ctx, ServiceStopOrders, PriorityHigh, func() (err error) {
+		resp, err = t.stopOrders.CancelStopOrder(accountID, stopOrderID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.CancelStopOrderResponse, nil
+}
+
+// GetCandles - свечи за период, SDK сам разбивает период на допустимые запросы
+func (t *Tinkoff) GetCandles(ctx context.Context, instrumentID string, interval pb.CandleInterval, from, to time.Time) ([]*pb.HistoricCandle, error) {
+	var candles []*pb.HistoricCandle
+	err := t.call(ctx, ServiceMarketData, PriorityNormal, func() (err error) {
+		candles, err = t.marketData.GetHistoricCandles(&investgo.GetHistoricCandlesRequest{
+			Instrument: instrumentID,
+			Interval:   interval,
+			From:       from,
+			To:         to,
+		})
+		return err
+	})
+	return candles, err
+}
+
+// GetOrderBook - стакан инструмента
+func (t *Tinkoff) GetOrderBook(ctx context.Context, instrumentID string, depth int32) (*pb.GetOrderBookResponse, error) {
+	var resp *investgo.GetOrderBookResponse
+	err := t.call(ctx, ServiceMarketData, PriorityNormal, func() (err error) {
+		resp, err = t.marketData.GetOrderBook(instrumentID, depth)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetOrderBookResponse, nil
+}
+
+// GetLastPrices - последние цены инструментов
+func (t *Tinkoff) GetLastPrices(ctx context.Context, instrumentIDs []string) ([]*pb.LastPrice, error) {
+	var resp *investgo.GetLastPricesResponse
+	err := t.call(ctx, ServiceMarketData, PriorityNormal, func() (err error) {
+		resp, err = t.marketData.GetLastPrices(instrumentIDs)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetLastPrices(), nil
+}
+
+// GetTradingStatus - торговый статус инструмента
+func (t *Tinkoff) GetTradingStatus(ctx context.Context, instrumentID string) (*pb.GetTradingStatusResponse, error) {
+	var resp *investgo.GetTradingStatusResponse
+	err := t.call(ctx, ServiceMarketData, PriorityNormal, func() (err error) {
+		resp, err = t.marketData.GetTradingStatus(instrumentID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetTradingStatusResponse, nil
+}
+
+// GetAccounts - счета пользователя
+func (t *Tinkoff) GetAccounts(ctx context.Context) ([]*pb.Account, error) {
+	var resp *investgo.GetAccountsResponse
+	err := t.call(ctx, ServiceUsers, PriorityNormal, func() (err error) {
+		resp, err = t.users.GetAccounts()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetAccounts(), nil
+}
+
+// GetPortfolio - портфель счета в рублях
+func (t *Tinkoff) GetPortfolio(ctx context.Context, accountID string) (*pb.PortfolioResponse, error) {
+	var resp *investgo.PortfolioResponse
+	err := t.call(ctx, ServiceOperations, PriorityNormal, func() (err error) {
+		resp, err = t.operations.GetPortfolio(accountID, pb.PortfolioRequest_RUB)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.PortfolioResponse, nil
+}
+
+// GetPositions - позиции счета
+func (t *Tinkoff) GetPositions(ctx context.Context, accountID string) (*pb.PositionsResponse, error) {
+	var resp *investgo.PositionsResponse
+	err := t.call(ctx, ServiceOperations, PriorityNormal, func() (err error) {
+		resp, err = t.operations.GetPositions(accountID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.PositionsResponse, nil
+}
+
+// GetOperations - операции счета за период
+func (t *Tinkoff) GetOperations(ctx context.Context, accountID string, from, to time.Time) ([]*pb.Operation, error) {
+	var resp *investgo.OperationsResponse
+	err := t.call(ctx, ServiceOperations, PriorityNormal, func() (err error) {
+		resp, err = t.operations.GetOperations(&investgo.GetOperationsRequest{
+			AccountId: accountID,
+			From:      from,
+			To:        to,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetOperations(), nil
+}
+
+// FindInstrument - поиск инструмента по тикеру, FIGI или названию
+func (t *Tinkoff) FindInstrument(ctx context.Context, query string) ([]*pb.InstrumentShort, error) {
+	var resp *investgo.FindInstrumentResponse
+	err := t.call(ctx, ServiceInstruments, PriorityLow, func() (err error) {
+		resp, err = t.instruments.FindInstrument(query)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetInstruments(), nil
+}
+
+// InstrumentByFigi - инструмент по FIGI
+func (t *Tinkoff) InstrumentByFigi(ctx context.Context, figi string) (*pb.Instrument, error) {
+	var resp *investgo.InstrumentResponse
+	err := t.call(ctx, ServiceInstruments, PriorityLow, func() (err error) {
+		resp, err = t.instruments.InstrumentByFigi(figi)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.GetInstrument() == nil {
+		return nil, fmt.Errorf("instrument %s not found", figi)
+	}
+	return resp.GetInstrument(), nil
+}
+
+// PointValue - стоимость пункта цены в валюте расчетов: для облигаций цена
+// задается в процентах номинала, для фьючерсов - в пунктах, для остальных
+// инструментов пункт равен единице валюты
+func (t *Tinkoff) PointValue(ctx context.Context, instrumentID string) (decimal.Decimal, error) {
+	instrument, err := t.InstrumentByFigi(ctx, instrumentID)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	switch instrument.GetInstrumentType() {
+	case "bond":
+		var resp *investgo.BondResponse
+		err := t.call(ctx, ServiceInstruments, PriorityNormal, func() (err error) {
+			resp, err = t.instruments.BondByFigi(instrument.GetFigi())
+			return err
+		})
+		if err != nil {
+			return decimal.Zero, err
+		}
+		return money.FromMoneyValue(resp.GetInstrument().GetNominal()).Div(decimal.NewFromInt(100)), nil
+	case "futures":
+		var resp *investgo.GetFuturesMarginResponse
+		err := t.call(ctx, ServiceInstruments, PriorityNormal, func() (err error) {
+			resp, err = t.instruments.GetFuturesMargin(instrument.GetFigi())
+			return err
+		})
+		if err != nil {
+			return decimal.Zero, err
+		}
+		step := money.FromQuotation(resp.GetMinPriceIncrement())
+		if step.IsZero() {
+			return decimal.Zero, fmt.Errorf("futures %s has no price increment", instrumentID)
+		}
+		return money.FromQuotation(resp.GetMinPriceIncrementAmount()).Div(step), nil
+	default:
+		return decimal.NewFromInt(1), nil
+	}
+}
+
+// Shares - акции, доступные для торговли через API
+func (t *Tinkoff) Shares(ctx context.Context) ([]*pb.Share, error) {
+	var resp *investgo.SharesResponse
+	err := t.call(ctx, ServiceInstruments, PriorityLow, func() (err error) {
+		resp, err = t.instruments.Shares(pb.InstrumentStatus_INSTRUMENT_STATUS_BASE)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetInstruments(), nil
+}
+
+// Bonds - облигации, доступные для торговли через API
+func (t *Tinkoff) Bonds(ctx context.Context) ([]*pb.Bond, error) {
+	var resp *investgo.BondsResponse
+	err := t.call(ctx, ServiceInstruments, PriorityLow, func() (err error) {
+		resp, err = t.instruments.Bonds(pb.InstrumentStatus_INSTRUMENT_STATUS_BASE)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetInstruments(), nil
+}
+
+// Etfs - фонды, доступные для торговли через API
+func (t *Tinkoff) Etfs(ctx context.Context) ([]*pb.Etf, error) {
+	var resp *investgo.EtfsResponse
+	err := t.call(ctx, ServiceInstruments, PriorityLow, func() (err error) {
+		resp, err = t.instruments.Etfs(pb.InstrumentStatus_INSTRUMENT_STATUS_BASE)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetInstruments(), nil
+}
+
+// Futures - фьючерсы, доступные для торговли через API
+func (t *Tinkoff) Futures(ctx context.Context) ([]*pb.Future, error) {
+	var resp *investgo.FuturesResponse
+	err := t.call(ctx, ServiceInstruments, PriorityLow, func() (err error) {
+		resp, err = t.instruments.Futures(pb.InstrumentStatus_INSTRUMENT_STATUS_BASE)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetInstruments(), nil
+}
+
+// Currencies - валюты, доступные для торговли через API
+func (t *Tinkoff) Currencies(ctx context.Context) ([]*pb.Currency, error) {
+	var resp *investgo.CurrenciesResponse
+	err := t.call(ctx, ServiceInstruments, PriorityLow, func() (err error) {
+		resp, err = t.instruments.Currencies(pb.InstrumentStatus_INSTRUMENT_STATUS_BASE)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetInstruments(), nil
+}
+
+// Options - опционы, доступные для торговли через API
+func (t *Tinkoff) Options(ctx context.Context) ([]*pb.Option, error) {
+	var resp *investgo.OptionsResponse
+	err := t.call(ctx, ServiceInstruments, PriorityLow, func() (err error) {
+		resp, err = t.instruments.Options(pb.InstrumentStatus_INSTRUMENT_STATUS_BASE)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetInstruments(), nil
+}
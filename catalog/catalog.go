@@ -0,0 +1,422 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"trading-bot-web/broker"
+	"trading-bot-web/config"
+)
+
+// refreshInterval - справочник загружается раз в сутки: состав инструментов
+// и их параметры меняются между торговыми днями
+const refreshInterval = 24 * time.Hour
+
+// retryInterval - повтор загрузки справочника после ошибки
+const retryInterval = time.Minute
+
+// searchLimit - максимум инструментов в ответе поиска по справочнику
+const searchLimit = 100
+
+// ErrNotFound - инструмента нет ни в справочнике, ни у брокера
+var ErrNotFound = errors.New("instrument not found")
+
+// snapshot - загруженный справочник и индексы по нему
+type snapshot struct {
+	loadedAt   time.Time
+	shares     []*pb.Share
+	bonds      []*pb.Bond
+	etfs       []*pb.Etf
+	futures    []*pb.Future
+	currencies []*pb.Currency
+	options    []*pb.Option
+
+	instruments []*Instrument
+	// byID - по FIGI и UID, byTicker - по тикеру и режиму торгов
+	byID     map[string]*Instrument
+	byTicker map[string]*Instrument
+	byISIN   map[string][]*Instrument
+}
+
+// cached - инструмент, запрошенный у брокера по одному
+type cached struct {
+	instrument *pb.Instrument
+	expires    time.Time
+}
+
+// Catalog - справочник инструментов в памяти поверх брокера. Акции, облигации,
+// фонды, фьючерсы, валюты и опционы загружаются раз в сутки и отдаются без
+// запросов к брокеру; InstrumentByFigi кэшируется по настройкам cache.instruments.
+// До первой загрузки запросы уходят брокеру.
+type Catalog struct {
+	broker.Broker
+	cfg    config.CacheEntryConfig
+	logger *zap.SugaredLogger
+
+	mu       sync.RWMutex
+	snapshot *snapshot
+	cache    map[string]cached
+}
+
+// New - справочник поверх брокера next
+func New(next broker.Broker, cfg config.CacheEntryConfig, logger *zap.SugaredLogger) *Catalog {
+	return &Catalog{
+		Broker: next,
+		cfg:    cfg,
+		logger: logger,
+		cache:  make(map[string]cached),
+	}
+}
+
+// Run - загрузка справочника и ежедневное обновление до отмены контекста
+func (c *Catalog) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		wait := refreshInterval
+		if err := c.Load(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.logger.Errorf("Instrument catalog: failed to load: %v", err)
+			wait = retryInterval
+		}
+		timer.Reset(wait)
+	}
+}
+
+// Load - загрузка справочника; при ошибке остается прежний
+func (c *Catalog) Load(ctx context.Context) error {
+	ctx = broker.WithPriority(ctx, broker.PriorityLow)
+	s := &snapshot{
+		byID:     make(map[string]*Instrument),
+		byTicker: make(map[string]*Instrument),
+		byISIN:   make(map[string][]*Instrument),
+	}
+
+	var err error
+	if s.shares, err = c.Broker.Shares(ctx); err != nil {
+		return fmt.Errorf("failed to load shares: %w", err)
+	}
+	if s.bonds, err = c.Broker.Bonds(ctx); err != nil {
+		return fmt.Errorf("failed to load bonds: %w", err)
+	}
+	if s.etfs, err = c.Broker.Etfs(ctx); err != nil {
+		return fmt.Errorf("failed to load etfs: %w", err)
+	}
+	if s.futures, err = c.Broker.Futures(ctx); err != nil {
+		return fmt.Errorf("failed to load futures: %w", err)
+	}
+	if s.currencies, err = c.Broker.Currencies(ctx); err != nil {
+		return fmt.Errorf("failed to load currencies: %w", err)
+	}
+	if s.options, err = c.Broker.Options(ctx); err != nil {
+		return fmt.Errorf("failed to load options: %w", err)
+	}
+
+	for _, share := range s.shares {
+		s.add(fromShare(share))
+	}
+	for _, etf := range s.etfs {
+		s.add(fromEtf(etf))
+	}
+	for _, bond := range s.bonds {
+		s.add(fromBond(bond))
+	}
+	for _, currency := range s.currencies {
+		s.add(fromCurrency(currency))
+	}
+	for _, future := range s.futures {
+		s.add(fromFuture(future))
+	}
+	for _, option := range s.options {
+		s.add(fromOption(option))
+	}
+	s.loadedAt = time.Now()
+
+	c.mu.Lock()
+	c.snapshot = s
+	c.mu.Unlock()
+	c.logger.Infof("Instrument catalog loaded: %d instruments", len(s.instruments))
+	return nil
+}
+
+// add - инструмент в справочник и индексы
+func (s *snapshot) add(instrument Instrument) {
+	i := &instrument
+	s.instruments = append(s.instruments, i)
+	if i.Figi != "" {
+		s.byID[i.Figi] = i
+	}
+	if i.UID != "" {
+		s.byID[i.UID] = i
+	}
+	if i.Ticker != "" {
+		s.byTicker[tickerKey(i.Ticker, i.ClassCode)] = i
+	}
+	if i.ISIN != "" {
+		s.byISIN[i.ISIN] = append(s.byISIN[i.ISIN], i)
+	}
+}
+
+// tickerKey - ключ индекса по тикеру, регистр не важен
+func tickerKey(ticker, classCode string) string {
+	return strings.ToUpper(ticker) + "@" + strings.ToUpper(classCode)
+}
+
+// current - загруженный справочник, nil до первой загрузки
+func (c *Catalog) current() *snapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.snapshot
+}
+
+// LoadedAt - время загрузки справочника, нулевое до первой загрузки
+func (c *Catalog) LoadedAt() time.Time {
+	if s := c.current(); s != nil {
+		return s.loadedAt
+	}
+	return time.Time{}
+}
+
+// Get - инструмент справочника по FIGI или UID
+func (c *Catalog) Get(id string) (Instrument, bool) {
+	s := c.current()
+	if s == nil {
+		return Instrument{}, false
+	}
+	if i, exists := s.byID[id]; exists {
+		return *i, true
+	}
+	return Instrument{}, false
+}
+
+// ByTicker - инструмент справочника по тикеру и режиму торгов (class_code)
+func (c *Catalog) ByTicker(ticker, classCode string) (Instrument, bool) {
+	s := c.current()
+	if s == nil {
+		return Instrument{}, false
+	}
+	if i, exists := s.byTicker[tickerKey(ticker, classCode)]; exists {
+		return *i, true
+	}
+	return Instrument{}, false
+}
+
+// ByISIN - инструменты справочника по ISIN: у одной бумаги бывает несколько режимов торгов
+func (c *Catalog) ByISIN(isin string) []Instrument {
+	s := c.current()
+	if s == nil {
+		return nil
+	}
+	found := make([]Instrument, 0, len(s.byISIN[isin]))
+	for _, i := range s.byISIN[isin] {
+		found = append(found, *i)
+	}
+	return found
+}
+
+// Lookup - инструмент по FIGI, UID или ISIN, с classCode - по тикеру.
+// Для ISIN предпочитается режим торгов, доступный через API.
+// Инструменты вне справочника запрашиваются у брокера по FIGI.
+func (c *Catalog) Lookup(ctx context.Context, id, classCode string) (Instrument, error) {
+	if classCode != "" {
+		if instrument, exists := c.ByTicker(id, classCode); exists {
+			return instrument, nil
+		}
+		return Instrument{}, fmt.Errorf("%w: %s@%s", ErrNotFound, id, classCode)
+	}
+	if instrument, exists := c.Get(id); exists {
+		return instrument, nil
+	}
+	if found := c.ByISIN(id); len(found) > 0 {
+		for _, instrument := range found {
+			if instrument.APITradeAvailable {
+				return instrument, nil
+			}
+		}
+		return found[0], nil
+	}
+
+	instrument, err := c.InstrumentByFigi(ctx, id)
+	if notFound(err) {
+		return Instrument{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return Instrument{}, err
+	}
+	return FromInstrument(instrument), nil
+}
+
+// notFound - брокер ответил, что инструмента нет
+func notFound(err error) bool {
+	var se interface{ GRPCStatus() *status.Status }
+	return errors.As(err, &se) && se.GRPCStatus().Code() == codes.NotFound
+}
+
+// InstrumentByFigi - инструмент по FIGI или UID из справочника. Полное описание
+// есть только у брокера, поэтому ответы брокера кэшируются на cache.instruments.ttl.
+func (c *Catalog) InstrumentByFigi(ctx context.Context, id string) (*pb.Instrument, error) {
+	now := time.Now()
+	c.mu.RLock()
+	entry, exists := c.cache[id]
+	c.mu.RUnlock()
+	if exists && now.Before(entry.expires) {
+		return entry.instrument, nil
+	}
+
+	// Брокер ищет по FIGI, UID справочника переводится в FIGI
+	figi := id
+	if instrument, exists := c.Get(id); exists && instrument.Figi != "" {
+		figi = instrument.Figi
+	}
+	instrument, err := c.Broker.InstrumentByFigi(ctx, figi)
+	if err != nil {
+		return nil, err
+	}
+	c.store(id, instrument, now)
+	return instrument, nil
+}
+
+// store - ответ брокера в кэш; при переполнении вытесняются устаревшие
+// и ближайшие к истечению записи
+func (c *Catalog) store(id string, instrument *pb.Instrument, now time.Time) {
+	if c.cfg.TTL <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cfg.MaxSize > 0 && len(c.cache) >= c.cfg.MaxSize {
+		for key, entry := range c.cache {
+			if !now.Before(entry.expires) {
+				delete(c.cache, key)
+			}
+		}
+		for len(c.cache) >= c.cfg.MaxSize {
+			oldest := ""
+			for key, entry := range c.cache {
+				if oldest == "" || entry.expires.Before(c.cache[oldest].expires) {
+					oldest = key
+				}
+			}
+			delete(c.cache, oldest)
+		}
+	}
+	c.cache[id] = cached{instrument: instrument, expires: now.Add(c.cfg.TTL)}
+}
+
+// PointValue - стоимость пункта цены: для облигаций из номинала справочника,
+// для акций, фондов и валют пункт равен единице валюты; фьючерсы и инструменты
+// вне справочника уточняются у брокера
+func (c *Catalog) PointValue(ctx context.Context, instrumentID string) (decimal.Decimal, error) {
+	instrument, exists := c.Get(instrumentID)
+	if !exists {
+		return c.Broker.PointValue(ctx, instrumentID)
+	}
+	switch instrument.Type {
+	case TypeShare, TypeEtf, TypeCurrency:
+		return decimal.NewFromInt(1), nil
+	case TypeBond:
+		if instrument.Nominal.IsPositive() {
+			return instrument.Nominal.Div(decimal.NewFromInt(100)), nil
+		}
+	}
+	if instrument.Figi != "" {
+		instrumentID = instrument.Figi
+	}
+	return c.Broker.PointValue(ctx, instrumentID)
+}
+
+// FindInstrument - поиск по тикеру, FIGI, UID, ISIN или части названия.
+// Сначала идут точные совпадения, результат ограничен searchLimit.
+func (c *Catalog) FindInstrument(ctx context.Context, query string) ([]*pb.InstrumentShort, error) {
+	s := c.current()
+	if s == nil {
+		return c.Broker.FindInstrument(ctx, query)
+	}
+
+	query = strings.ToLower(strings.TrimSpace(query))
+	exact := make([]*pb.InstrumentShort, 0)
+	partial := make([]*pb.InstrumentShort, 0)
+	for _, i := range s.instruments {
+		switch {
+		case strings.ToLower(i.Ticker) == query, strings.ToLower(i.Figi) == query,
+			strings.ToLower(i.UID) == query, strings.ToLower(i.ISIN) == query:
+			exact = append(exact, i.short())
+		case len(partial) < searchLimit && (strings.HasPrefix(strings.ToLower(i.Ticker), query) ||
+			strings.Contains(strings.ToLower(i.Name), query)):
+			partial = append(partial, i.short())
+		}
+	}
+	found := append(exact, partial...)
+	if len(found) > searchLimit {
+		found = found[:searchLimit]
+	}
+	return found, nil
+}
+
+// Shares - акции из справочника
+func (c *Catalog) Shares(ctx context.Context) ([]*pb.Share, error) {
+	if s := c.current(); s != nil {
+		return s.shares, nil
+	}
+	return c.Broker.Shares(ctx)
+}
+
+// Bonds - облигации из справочника
+func (c *Catalog) Bonds(ctx context.Context) ([]*pb.Bond, error) {
+	if s := c.current(); s != nil {
+		return s.bonds, nil
+	}
+	return c.Broker.Bonds(ctx)
+}
+
+// Etfs - фонды из справочника
+func (c *Catalog) Etfs(ctx context.Context) ([]*pb.Etf, error) {
+	if s := c.current(); s != nil {
+		return s.etfs, nil
+	}
+	return c.Broker.Etfs(ctx)
+}
+
+// Futures - фьючерсы из справочника
+func (c *Catalog) Futures(ctx context.Context) ([]*pb.Future, error) {
+	if s := c.current(); s != nil {
+		return s.futures, nil
+	}
+	return c.Broker.Futures(ctx)
+}
+
+// Currencies - валюты из справочника
+func (c *Catalog) Currencies(ctx context.Context) ([]*pb.Currency, error) {
+	if s := c.current(); s != nil {
+		return s.currencies, nil
+	}
+	return c.Broker.Currencies(ctx)
+}
+
+// Options - опционы из справочника
+func (c *Catalog) Options(ctx context.Context) ([]*pb.Option, error) {
+	if s := c.current(); s != nil {
+		return s.options, nil
+	}
+	return c.Broker.Options(ctx)
+}
@@ -0,0 +1,248 @@
+package catalog
+
+import (
+	"github.com/shopspring/decimal"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+
+	"trading-bot-web/money"
+)
+
+// Типы инструментов, как в InstrumentType инструментов investAPI
+const (
+	TypeShare    = "share"
+	TypeBond     = "bond"
+	TypeEtf      = "etf"
+	TypeFutures  = "futures"
+	TypeCurrency = "currency"
+	TypeOption   = "option"
+)
+
+// instrumentKinds - вид инструмента investAPI по типу
+var instrumentKinds = map[string]pb.InstrumentType{
+	TypeShare:    pb.InstrumentType_INSTRUMENT_TYPE_SHARE,
+	TypeBond:     pb.InstrumentType_INSTRUMENT_TYPE_BOND,
+	TypeEtf:      pb.InstrumentType_INSTRUMENT_TYPE_ETF,
+	TypeFutures:  pb.InstrumentType_INSTRUMENT_TYPE_FUTURES,
+	TypeCurrency: pb.InstrumentType_INSTRUMENT_TYPE_CURRENCY,
+	TypeOption:   pb.InstrumentType_INSTRUMENT_TYPE_OPTION,
+}
+
+// Instrument - параметры инструмента, нужные для проверки заявок
+type Instrument struct {
+	Figi        string `json:"figi,omitempty"`
+	UID         string `json:"uid"`
+	PositionUID string `json:"position_uid,omitempty"`
+	Ticker      string `json:"ticker"`
+	ClassCode   string `json:"class_code"`
+	ISIN        string `json:"isin,omitempty"`
+	Name        string `json:"name"`
+	// Type - share, bond, etf, futures, currency или option
+	Type     string `json:"instrument_type"`
+	Currency string `json:"currency"`
+	Exchange string `json:"exchange,omitempty"`
+	// Lot - количество штук в лоте
+	Lot int64 `json:"lot"`
+	// MinPriceIncrement - шаг цены в пунктах котировки
+	MinPriceIncrement decimal.Decimal `json:"min_price_increment"`
+	// Nominal - номинал облигации, цена облигации задается в процентах номинала
+	Nominal decimal.Decimal `json:"nominal"`
+	// TradingStatus - режим торгов на момент загрузки справочника
+	TradingStatus     string `json:"trading_status"`
+	APITradeAvailable bool   `json:"api_trade_available"`
+	BuyAvailable      bool   `json:"buy_available"`
+	SellAvailable     bool   `json:"sell_available"`
+	ShortEnabled      bool   `json:"short_enabled"`
+	ForQualInvestor   bool   `json:"for_qual_investor"`
+	WeekendTrading    bool   `json:"weekend_trading"`
+}
+
+// short - инструмент в формате ответа FindInstrument
+func (i *Instrument) short() *pb.InstrumentShort {
+	return &pb.InstrumentShort{
+		Isin:                  i.ISIN,
+		Figi:                  i.Figi,
+		Ticker:                i.Ticker,
+		ClassCode:             i.ClassCode,
+		InstrumentType:        i.Type,
+		Name:                  i.Name,
+		Uid:                   i.UID,
+		PositionUid:           i.PositionUID,
+		InstrumentKind:        instrumentKinds[i.Type],
+		ApiTradeAvailableFlag: i.APITradeAvailable,
+		ForQualInvestorFlag:   i.ForQualInvestor,
+		WeekendFlag:           i.WeekendTrading,
+	}
+}
+
+// FromInstrument - параметры инструмента из ответа GetInstrumentBy
+func FromInstrument(instrument *pb.Instrument) Instrument {
+	return Instrument{
+		Figi:              instrument.GetFigi(),
+		UID:               instrument.GetUid(),
+		PositionUID:       instrument.GetPositionUid(),
+		Ticker:            instrument.GetTicker(),
+		ClassCode:         instrument.GetClassCode(),
+		ISIN:              instrument.GetIsin(),
+		Name:              instrument.GetName(),
+		Type:              instrument.GetInstrumentType(),
+		Currency:          instrument.GetCurrency(),
+		Exchange:          instrument.GetExchange(),
+		Lot:               int64(instrument.GetLot()),
+		MinPriceIncrement: money.FromQuotation(instrument.GetMinPriceIncrement()),
+		TradingStatus:     instrument.GetTradingStatus().String(),
+		APITradeAvailable: instrument.GetApiTradeAvailableFlag(),
+		BuyAvailable:      instrument.GetBuyAvailableFlag(),
+		SellAvailable:     instrument.GetSellAvailableFlag(),
+		ShortEnabled:      instrument.GetShortEnabledFlag(),
+		ForQualInvestor:   instrument.GetForQualInvestorFlag(),
+		WeekendTrading:    instrument.GetWeekendFlag(),
+	}
+}
+
+// fromShare - параметры акции
+func fromShare(share *pb.Share) Instrument {
+	return Instrument{
+		Figi:              share.GetFigi(),
+		UID:               share.GetUid(),
+		PositionUID:       share.GetPositionUid(),
+		Ticker:            share.GetTicker(),
+		ClassCode:         share.GetClassCode(),
+		ISIN:              share.GetIsin(),
+		Name:              share.GetName(),
+		Type:              TypeShare,
+		Currency:          share.GetCurrency(),
+		Exchange:          share.GetExchange(),
+		Lot:               int64(share.GetLot()),
+		MinPriceIncrement: money.FromQuotation(share.GetMinPriceIncrement()),
+		TradingStatus:     share.GetTradingStatus().String(),
+		APITradeAvailable: share.GetApiTradeAvailableFlag(),
+		BuyAvailable:      share.GetBuyAvailableFlag(),
+		SellAvailable:     share.GetSellAvailableFlag(),
+		ShortEnabled:      share.GetShortEnabledFlag(),
+		ForQualInvestor:   share.GetForQualInvestorFlag(),
+		WeekendTrading:    share.GetWeekendFlag(),
+	}
+}
+
+// fromBond - параметры облигации
+func fromBond(bond *pb.Bond) Instrument {
+	return Instrument{
+		Figi:              bond.GetFigi(),
+		UID:               bond.GetUid(),
+		PositionUID:       bond.GetPositionUid(),
+		Ticker:            bond.GetTicker(),
+		ClassCode:         bond.GetClassCode(),
+		ISIN:              bond.GetIsin(),
+		Name:              bond.GetName(),
+		Type:              TypeBond,
+		Currency:          bond.GetCurrency(),
+		Exchange:          bond.GetExchange(),
+		Lot:               int64(bond.GetLot()),
+		MinPriceIncrement: money.FromQuotation(bond.GetMinPriceIncrement()),
+		Nominal:           money.FromMoneyValue(bond.GetNominal()),
+		TradingStatus:     bond.GetTradingStatus().String(),
+		APITradeAvailable: bond.GetApiTradeAvailableFlag(),
+		BuyAvailable:      bond.GetBuyAvailableFlag(),
+		SellAvailable:     bond.GetSellAvailableFlag(),
+		ShortEnabled:      bond.GetShortEnabledFlag(),
+		ForQualInvestor:   bond.GetForQualInvestorFlag(),
+		WeekendTrading:    bond.GetWeekendFlag(),
+	}
+}
+
+// fromEtf - параметры фонда
+func fromEtf(etf *pb.Etf) Instrument {
+	return Instrument{
+		Figi:              etf.GetFigi(),
+		UID:               etf.GetUid(),
+		PositionUID:       etf.GetPositionUid(),
+		Ticker:            etf.GetTicker(),
+		ClassCode:         etf.GetClassCode(),
+		ISIN:              etf.GetIsin(),
+		Name:              etf.GetName(),
+		Type:              TypeEtf,
+		Currency:          etf.GetCurrency(),
+		Exchange:          etf.GetExchange(),
+		Lot:               int64(etf.GetLot()),
+		MinPriceIncrement: money.FromQuotation(etf.GetMinPriceIncrement()),
+		TradingStatus:     etf.GetTradingStatus().String(),
+		APITradeAvailable: etf.GetApiTradeAvailableFlag(),
+		BuyAvailable:      etf.GetBuyAvailableFlag(),
+		SellAvailable:     etf.GetSellAvailableFlag(),
+		ShortEnabled:      etf.GetShortEnabledFlag(),
+		ForQualInvestor:   etf.GetForQualInvestorFlag(),
+		WeekendTrading:    etf.GetWeekendFlag(),
+	}
+}
+
+// fromFuture - параметры фьючерса
+func fromFuture(future *pb.Future) Instrument {
+	return Instrument{
+		Figi:              future.GetFigi(),
+		UID:               future.GetUid(),
+		PositionUID:       future.GetPositionUid(),
+		Ticker:            future.GetTicker(),
+		ClassCode:         future.GetClassCode(),
+		Name:              future.GetName(),
+		Type:              TypeFutures,
+		Currency:          future.GetCurrency(),
+		Exchange:          future.GetExchange(),
+		Lot:               int64(future.GetLot()),
+		MinPriceIncrement: money.FromQuotation(future.GetMinPriceIncrement()),
+		TradingStatus:     future.GetTradingStatus().String(),
+		APITradeAvailable: future.GetApiTradeAvailableFlag(),
+		BuyAvailable:      future.GetBuyAvailableFlag(),
+		SellAvailable:     future.GetSellAvailableFlag(),
+		ShortEnabled:      future.GetShortEnabledFlag(),
+		ForQualInvestor:   future.GetForQualInvestorFlag(),
+		WeekendTrading:    future.GetWeekendFlag(),
+	}
+}
+
+// fromCurrency - параметры валюты
+func fromCurrency(currency *pb.Currency) Instrument {
+	return Instrument{
+		Figi:              currency.GetFigi(),
+		UID:               currency.GetUid(),
+		PositionUID:       currency.GetPositionUid(),
+		Ticker:            currency.GetTicker(),
+		ClassCode:         currency.GetClassCode(),
+		ISIN:              currency.GetIsin(),
+		Name:              currency.GetName(),
+		Type:              TypeCurrency,
+		Currency:          currency.GetCurrency(),
+		Exchange:          currency.GetExchange(),
+		Lot:               int64(currency.GetLot()),
+		MinPriceIncrement: money.FromQuotation(currency.GetMinPriceIncrement()),
+		TradingStatus:     currency.GetTradingStatus().String(),
+		APITradeAvailable: currency.GetApiTradeAvailableFlag(),
+		BuyAvailable:      currency.GetBuyAvailableFlag(),
+		SellAvailable:     currency.GetSellAvailableFlag(),
+		ShortEnabled:      currency.GetShortEnabledFlag(),
+		ForQualInvestor:   currency.GetForQualInvestorFlag(),
+		WeekendTrading:    currency.GetWeekendFlag(),
+	}
+}
+
+// fromOption - у опционов нет FIGI, они ищутся по UID и тикеру
+func fromOption(option *pb.Option) Instrument {
+	return Instrument{
+		UID:               option.GetUid(),
+		PositionUID:       option.GetPositionUid(),
+		Ticker:            option.GetTicker(),
+		ClassCode:         option.GetClassCode(),
+		Name:              option.GetName(),
+		Type:              TypeOption,
+		Currency:          option.GetCurrency(),
+		Exchange:          option.GetExchange(),
+		Lot:               int64(option.GetLot()),
+		MinPriceIncrement: money.FromQuotation(option.GetMinPriceIncrement()),
+		TradingStatus:     option.GetTradingStatus().String(),
+		APITradeAvailable: option.GetApiTradeAvailableFlag(),
+		BuyAvailable:      option.GetBuyAvailableFlag(),
+		SellAvailable:     option.GetSellAvailableFlag(),
+		ShortEnabled:      option.GetShortEnabledFlag(),
+		ForQualInvestor:   option.GetForQualInvestorFlag(),
+		WeekendTrading:    option.GetWeekendFlag(),
+	}
+}
@@ -0,0 +1,314 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/shopspring/decimal"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+
+	"trading-bot-web/broker"
+	"trading-bot-web/config"
+)
+
+// Направления округления цены до шага
+const (
+	RoundNearest = "nearest"
+	RoundDown    = "down"
+	RoundUp      = "up"
+	// RoundReject - цена вне шага не округляется, заявка отклоняется
+	RoundReject = "reject"
+)
+
+// Коды ошибок полей заявки
+const (
+	CodeNotFound            = "not_found"
+	CodeAPITradeUnavailable = "api_trade_unavailable"
+	CodeSideUnavailable     = "side_unavailable"
+	CodeTradingClosed       = "trading_closed"
+	CodeLotMultiple         = "lot_multiple"
+	CodeInvalidPrice        = "invalid_price"
+	CodePriceStep           = "price_step"
+)
+
+// stepTolerance - допуск при сравнении цены с шагом, в долях шага: цена
+// в валюте, переведенная в пункты, может не делиться на стоимость пункта нацело
+var stepTolerance = decimal.New(1, -6)
+
+// FieldError - ошибка в поле заявки
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError - заявка не соответствует правилам инструмента
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = field.Message
+	}
+	return "invalid order: " + strings.Join(messages, "; ")
+}
+
+// Order - заявка до приведения к правилам инструмента
+type Order struct {
+	InstrumentID string
+	Direction    pb.OrderDirection
+	// OrderType - тип заявки для проверки режима торгов; UNSPECIFIED
+	// для стоп-заявок, которые принимаются и вне торговой сессии
+	OrderType pb.OrderType
+	Quantity  int64
+	// InShares - количество задано в штуках, а не в лотах
+	InShares bool
+	// Prices - цены по имени поля запроса, nil пропускается
+	Prices map[string]*decimal.Decimal
+	// PriceType - единицы Prices: валюта расчетов или пункты котировки
+	PriceType pb.PriceType
+}
+
+// Normalized - заявка, приведенная к правилам инструмента
+type Normalized struct {
+	Instrument Instrument
+	Lots       int64
+	// Prices - цены в пунктах котировки, округленные до шага цены, по имени поля запроса
+	Prices map[string]*decimal.Decimal
+}
+
+// Rules - проверка заявок по справочнику до отправки брокеру: инструмент
+// доступен для торговли через API и в текущем режиме торгов, количество
+// кратно лоту, цена кратна шагу цены или округляется до него
+type Rules struct {
+	catalog  *Catalog
+	market   broker.MarketDataProvider
+	rounding map[pb.OrderDirection]string
+}
+
+// NewRules - проверка заявок; market - источник режима торгов инструмента
+func NewRules(catalog *Catalog, market broker.MarketDataProvider, cfg config.PriceRoundingConfig) (*Rules, error) {
+	buy, err := ParseRounding(cfg.Buy)
+	if err != nil {
+		return nil, fmt.Errorf("price_rounding.buy: %w", err)
+	}
+	sell, err := ParseRounding(cfg.Sell)
+	if err != nil {
+		return nil, fmt.Errorf("price_rounding.sell: %w", err)
+	}
+	return &Rules{
+		catalog: catalog,
+		market:  market,
+		rounding: map[pb.OrderDirection]string{
+			pb.OrderDirection_ORDER_DIRECTION_BUY:  buy,
+			pb.OrderDirection_ORDER_DIRECTION_SELL: sell,
+		},
+	}, nil
+}
+
+// ParseRounding - направление округления: nearest (по умолчанию), down, up или reject
+func ParseRounding(value string) (string, error) {
+	switch value {
+	case "":
+		return RoundNearest, nil
+	case RoundNearest, RoundDown, RoundUp, RoundReject:
+		return value, nil
+	}
+	return "", fmt.Errorf("unknown price rounding %q, expected nearest, down, up or reject", value)
+}
+
+// Normalize - приведение заявки к правилам инструмента. Нарушения правил
+// возвращаются как *ValidationError со всеми ошибочными полями сразу.
+func (r *Rules) Normalize(ctx context.Context, order Order) (Normalized, error) {
+	instrument, err := r.catalog.Lookup(ctx, order.InstrumentID, "")
+	if errors.Is(err, ErrNotFound) {
+		return Normalized{}, &ValidationError{Fields: []FieldError{{
+			Field:   "instrument_id",
+			Code:    CodeNotFound,
+			Message: fmt.Sprintf("instrument %s not found", order.InstrumentID),
+		}}}
+	}
+	if err != nil {
+		return Normalized{}, err
+	}
+
+	var fields []FieldError
+	if !instrument.APITradeAvailable {
+		fields = append(fields, FieldError{
+			Field:   "instrument_id",
+			Code:    CodeAPITradeUnavailable,
+			Message: fmt.Sprintf("instrument %s is not available for trading via API", instrument.Ticker),
+		})
+	}
+	if field := sideAvailable(instrument, order.Direction); field != nil {
+		fields = append(fields, *field)
+	}
+
+	normalized := Normalized{Instrument: instrument, Lots: order.Quantity, Prices: make(map[string]*decimal.Decimal)}
+	if order.InShares {
+		lot := instrument.Lot
+		if lot <= 0 {
+			lot = 1
+		}
+		if order.Quantity%lot != 0 {
+			fields = append(fields, FieldError{
+				Field:   "quantity",
+				Code:    CodeLotMultiple,
+				Message: fmt.Sprintf("quantity %d is not a multiple of lot size %d", order.Quantity, lot),
+			})
+		}
+		normalized.Lots = order.Quantity / lot
+	}
+
+	names := make([]string, 0, len(order.Prices))
+	for name, price := range order.Prices {
+		if price != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	pointValue := decimal.NewFromInt(1)
+	if len(names) > 0 && order.PriceType != pb.PriceType_PRICE_TYPE_POINT {
+		// Шаг цены задан в пунктах, цена в валюте сначала переводится в пункты
+		if pointValue, err = r.catalog.PointValue(ctx, order.InstrumentID); err != nil {
+			return Normalized{}, err
+		}
+		if !pointValue.IsPositive() {
+			fields = append(fields, FieldError{
+				Field:   "price_type",
+				Code:    CodeInvalidPrice,
+				Message: fmt.Sprintf("instrument %s has no point value, use price_type points", instrument.Ticker),
+			})
+			names = nil
+		}
+	}
+	for _, name := range names {
+		rounded, field := r.roundPrice(instrument, name, order.Prices[name].Div(pointValue), order.Direction)
+		if field != nil {
+			fields = append(fields, *field)
+			continue
+		}
+		normalized.Prices[name] = &rounded
+	}
+
+	if len(fields) == 0 && order.OrderType != pb.OrderType_ORDER_TYPE_UNSPECIFIED {
+		field, err := r.checkSession(ctx, instrument, order.OrderType)
+		if err != nil {
+			return Normalized{}, err
+		}
+		if field != nil {
+			fields = append(fields, *field)
+		}
+	}
+	if len(fields) > 0 {
+		return Normalized{}, &ValidationError{Fields: fields}
+	}
+	return normalized, nil
+}
+
+// sideAvailable - доступна ли сторона заявки по флагам справочника
+func sideAvailable(instrument Instrument, direction pb.OrderDirection) *FieldError {
+	switch {
+	case direction == pb.OrderDirection_ORDER_DIRECTION_BUY && !instrument.BuyAvailable:
+		return &FieldError{
+			Field:   "direction",
+			Code:    CodeSideUnavailable,
+			Message: fmt.Sprintf("buying %s is not available", instrument.Ticker),
+		}
+	case direction == pb.OrderDirection_ORDER_DIRECTION_SELL && !instrument.SellAvailable:
+		return &FieldError{
+			Field:   "direction",
+			Code:    CodeSideUnavailable,
+			Message: fmt.Sprintf("selling %s is not available", instrument.Ticker),
+		}
+	}
+	return nil
+}
+
+// roundPrice - цена, кратная шагу цены инструмента, с округлением по стороне заявки
+func (r *Rules) roundPrice(instrument Instrument, field string, price decimal.Decimal, direction pb.OrderDirection) (decimal.Decimal, *FieldError) {
+	if !price.IsPositive() {
+		return decimal.Zero, &FieldError{Field: field, Code: CodeInvalidPrice, Message: fmt.Sprintf("%s must be positive", field)}
+	}
+	step := instrument.MinPriceIncrement
+	if !step.IsPositive() {
+		return price, nil
+	}
+
+	steps := price.Div(step)
+	nearest := steps.Round(0)
+	if steps.Sub(nearest).Abs().LessThan(stepTolerance) {
+		return nearest.Mul(step), nil
+	}
+
+	switch r.rounding[direction] {
+	case RoundDown:
+		steps = steps.Floor()
+	case RoundUp:
+		steps = steps.Ceil()
+	case RoundReject:
+		return decimal.Zero, &FieldError{
+			Field:   field,
+			Code:    CodePriceStep,
+			Message: fmt.Sprintf("%s %s is not a multiple of price step %s", field, price, step),
+		}
+	default:
+		steps = nearest
+	}
+	if steps.LessThan(decimal.NewFromInt(1)) {
+		return decimal.Zero, &FieldError{
+			Field:   field,
+			Code:    CodePriceStep,
+			Message: fmt.Sprintf("%s %s is below price step %s", field, price, step),
+		}
+	}
+	return steps.Mul(step), nil
+}
+
+// checkSession - принимает ли биржа заявки такого типа через API сейчас
+func (r *Rules) checkSession(ctx context.Context, instrument Instrument, orderType pb.OrderType) (*FieldError, error) {
+	id := instrument.Figi
+	if id == "" {
+		id = instrument.UID
+	}
+	status, err := r.market.GetTradingStatus(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trading status: %w", err)
+	}
+	if !status.GetApiTradeAvailableFlag() {
+		return &FieldError{
+			Field:   "instrument_id",
+			Code:    CodeAPITradeUnavailable,
+			Message: fmt.Sprintf("instrument %s is not available for trading via API now", instrument.Ticker),
+		}, nil
+	}
+
+	available := status.GetLimitOrderAvailableFlag()
+	if orderType == pb.OrderType_ORDER_TYPE_MARKET {
+		available = status.GetMarketOrderAvailableFlag()
+	}
+	if available {
+		return nil, nil
+	}
+	return &FieldError{
+		Field:   "order_type",
+		Code:    CodeTradingClosed,
+		Message: fmt.Sprintf("%s orders for %s are not accepted in trading status %s", orderTypeName(orderType), instrument.Ticker, status.GetTradingStatus()),
+	}, nil
+}
+
+// orderTypeName - тип заявки в терминах API сервера
+func orderTypeName(orderType pb.OrderType) string {
+	switch orderType {
+	case pb.OrderType_ORDER_TYPE_MARKET:
+		return "market"
+	case pb.OrderType_ORDER_TYPE_BESTPRICE:
+		return "bestprice"
+	}
+	return "limit"
+}
@@ -0,0 +1,374 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config - настройки приложения из config.yaml.
+// Раздел api читается отдельно через investgo.LoadConfig.
+type Config struct {
+	Trading      TradingConfig      `yaml:"trading"`
+	PaperTrading PaperTradingConfig `yaml:"paper_trading"`
+	Bots         BotsConfig         `yaml:"bots"`
+	Streams      StreamsConfig      `yaml:"streams"`
+	Database     DatabaseConfig     `yaml:"database"`
+	Security     SecurityConfig     `yaml:"security"`
+	Redis        RedisConfig        `yaml:"redis"`
+	BrokerAPI    BrokerAPIConfig    `yaml:"broker_api"`
+	// Reconciliation - периодическая сверка ботов и заявок сервера с брокером
+	Reconciliation ReconciliationConfig `yaml:"reconciliation"`
+	Cache          CacheConfig          `yaml:"cache"`
+}
+
+// TradingConfig - настройки торговли
+type TradingConfig struct {
+	Limits         LimitsConfig         `yaml:"limits"`
+	RiskManagement RiskManagementConfig `yaml:"risk_management"`
+	KillSwitch     KillSwitchConfig     `yaml:"kill_switch"`
+	Fees           FeesConfig           `yaml:"fees"`
+	Idempotency    IdempotencyConfig    `yaml:"idempotency"`
+	// SyntheticOrders - OCO, bracket и трейлинг-стопы, которые ведет сервер
+	SyntheticOrders SyntheticOrdersConfig `yaml:"synthetic_orders"`
+	// OrderJournal - история заявок, выставленных через сервер
+	OrderJournal OrderJournalConfig `yaml:"order_journal"`
+	// PriceRounding - округление цены заявки до шага цены инструмента
+	PriceRounding PriceRoundingConfig `yaml:"price_rounding"`
+}
+
+// PriceRoundingConfig - направление округления цены по стороне заявки:
+// nearest, down, up или reject - отклонять цену вне шага
+type PriceRoundingConfig struct {
+	Buy  string `yaml:"buy"`
+	Sell string `yaml:"sell"`
+}
+
+// LimitsConfig - ограничения на заявки, 0 - без ограничения
+type LimitsConfig struct {
+	MaxOrderAmount     float64 `yaml:"max_order_amount"`
+	MaxOrdersPerMinute int     `yaml:"max_orders_per_minute"`
+	MaxPositions       int     `yaml:"max_positions"`
+}
+
+// RiskManagementConfig - дневной лимит убытка и уровни выхода из позиций
+type RiskManagementConfig struct {
+	Enabled           bool    `yaml:"enabled"`
+	MaxLossPerDay     float64 `yaml:"max_loss_per_day"`
+	StopLossPercent   float64 `yaml:"stop_loss_percent"`
+	TakeProfitPercent float64 `yaml:"take_profit_percent"`
+	// AutoClose - закрытие сервером позиций, учтенных риск-движком, по уровням
+	// stop_loss_percent и take_profit_percent; по умолчанию уровни используются
+	// только для attach_stops
+	AutoClose bool `yaml:"auto_close"`
+}
+
+// KillSwitchConfig - аварийная блокировка торговли
+type KillSwitchConfig struct {
+	// StateFile - файл, в котором блокировка переживает перезапуск
+	StateFile string `yaml:"state_file"`
+}
+
+// FeesConfig - комиссии и сборы, в процентах от суммы сделки
+type FeesConfig struct {
+	BrokerCommission float64 `yaml:"broker_commission"`
+	ExchangeFee      float64 `yaml:"exchange_fee"`
+}
+
+// TotalPercent - суммарная комиссия в процентах
+func (f FeesConfig) TotalPercent() float64 {
+	return f.BrokerCommission + f.ExchangeFee
+}
+
+// IdempotencyConfig - повтор заявок с ключом Idempotency-Key
+type IdempotencyConfig struct {
+	// Retention - сколько хранится ответ на запрос с ключом
+	Retention time.Duration `yaml:"retention"`
+}
+
+// SyntheticOrdersConfig - синтетические заявки
+type SyntheticOrdersConfig struct {
+	// PollInterval - как часто проверяется исполнение выставленных заявок ног
+	PollInterval time.Duration `yaml:"poll_interval"`
+}
+
+// OrderJournalConfig - журнал заявок
+type OrderJournalConfig struct {
+	// PollInterval - как часто опрашиваются незавершенные заявки, кроме обновлений из стрима сделок
+	PollInterval time.Duration `yaml:"poll_interval"`
+}
+
+// PaperTradingConfig - настройки бумажной торговли (execution_mode: paper)
+type PaperTradingConfig struct {
+	InitialBalance float64 `yaml:"initial_balance"`
+	Currency       string  `yaml:"currency"`
+	// Feed - источник цен: live (стрим брокера) или replay (CSV из ReplayDir)
+	Feed           string        `yaml:"feed"`
+	ReplayDir      string        `yaml:"replay_dir"`
+	ReplayInterval time.Duration `yaml:"replay_interval"`
+}
+
+// BotsConfig - настройки ботов
+type BotsConfig struct {
+	// RestorePolicy - запуск ботов после перезапуска сервера: resume, resume-paused или stopped
+	RestorePolicy string `yaml:"restore_policy"`
+}
+
+// ReconciliationConfig - сверка позиций ботов и заявок сервера со счетами брокера
+type ReconciliationConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"`
+	// PauseBots - ставить на паузу ботов с расхождениями, пока оператор их не возобновит
+	PauseBots bool `yaml:"pause_bots"`
+}
+
+// StreamsConfig - стримы данных брокера, транслируемые в WebSocket
+type StreamsConfig struct {
+	MarketData MarketDataStreamConfig `yaml:"market_data"`
+	Operations OperationsStreamConfig `yaml:"operations"`
+}
+
+// MarketDataStreamConfig - стрим маркетдаты
+type MarketDataStreamConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// BufferSize - буфер каналов данных на время переподключения
+	BufferSize int `yaml:"buffer_size"`
+	// Instruments и Subscriptions - подписки, которые держатся независимо от клиентов
+	Instruments    []string `yaml:"instruments"`
+	Subscriptions  []string `yaml:"subscriptions"`
+	OrderBookDepth int32    `yaml:"orderbook_depth"`
+}
+
+// OperationsStreamConfig - стримы портфеля, позиций и сделок по заявкам
+type OperationsStreamConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Accounts - транслируемые счета, пустой список - все счета токена
+	Accounts      []string `yaml:"accounts"`
+	Subscriptions []string `yaml:"subscriptions"`
+}
+
+// BrokerAPIConfig - квоты исходящих запросов к investAPI, общие для ботов и обработчиков
+type BrokerAPIConfig struct {
+	// Quotas - запросов в минуту и емкость корзины по сервисам:
+	// users, orders, stoporders, operations, marketdata, instruments
+	Quotas map[string]RateLimitConfig `yaml:"quotas"`
+	// MaxQueue - предел ожидающих квоту вызовов на сервис, 0 - без предела
+	MaxQueue int `yaml:"max_queue"`
+	// Cooldown - пауза сервиса после ResourceExhausted от брокера
+	Cooldown time.Duration `yaml:"cooldown"`
+}
+
+// DatabaseConfig - хранилище ботов, заявок и исполнений
+type DatabaseConfig struct {
+	// Driver - postgres или sqlite
+	Driver string `yaml:"driver"`
+	// AutoMigrate - применение миграций при запуске сервера
+	AutoMigrate bool           `yaml:"auto_migrate"`
+	Postgres    PostgresConfig `yaml:"postgres"`
+	SQLite      SQLiteConfig   `yaml:"sqlite"`
+}
+
+// PostgresConfig - подключение к PostgreSQL
+type PostgresConfig struct {
+	Host                  string        `yaml:"host"`
+	Port                  int           `yaml:"port"`
+	Database              string        `yaml:"database"`
+	Username              string        `yaml:"username"`
+	Password              string        `yaml:"password"`
+	SSLMode               string        `yaml:"ssl_mode"`
+	MaxConnections        int           `yaml:"max_connections"`
+	MaxIdleConnections    int           `yaml:"max_idle_connections"`
+	ConnectionMaxLifetime time.Duration `yaml:"connection_max_lifetime"`
+}
+
+// DSN - строка подключения к PostgreSQL
+func (c PostgresConfig) DSN() string {
+	dsn := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(c.Username, c.Password),
+		Host:   net.JoinHostPort(c.Host, strconv.Itoa(c.Port)),
+		Path:   "/" + c.Database,
+	}
+	if c.SSLMode != "" {
+		dsn.RawQuery = url.Values{"sslmode": {c.SSLMode}}.Encode()
+	}
+	return dsn.String()
+}
+
+// SQLiteConfig - встроенная база SQLite
+type SQLiteConfig struct {
+	Path string `yaml:"path"`
+}
+
+// RedisConfig - подключение к Redis
+type RedisConfig struct {
+	Host         string        `yaml:"host"`
+	Port         int           `yaml:"port"`
+	Password     string        `yaml:"password"`
+	Database     int           `yaml:"database"`
+	PoolSize     int           `yaml:"pool_size"`
+	MinIdleConns int           `yaml:"min_idle_conns"`
+	DialTimeout  time.Duration `yaml:"dial_timeout"`
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	PoolTimeout  time.Duration `yaml:"pool_timeout"`
+}
+
+// Addr - адрес Redis в виде host:port
+func (c RedisConfig) Addr() string {
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+}
+
+// SecurityConfig - аутентификация пользователей
+type SecurityConfig struct {
+	JWT JWTConfig `yaml:"jwt"`
+	// BootstrapAdmin - администратор, создаваемый при пустой таблице пользователей
+	BootstrapAdmin BootstrapAdminConfig `yaml:"bootstrap_admin"`
+	APIKeys        APIKeysConfig        `yaml:"api_keys"`
+	RateLimiting   RateLimitingConfig   `yaml:"rate_limiting"`
+}
+
+// RateLimitingConfig - ограничение частоты входящих запросов (token bucket).
+// Лимиты считаются по API ключу или пользователю, без аутентификации - по IP.
+type RateLimitingConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// RequestsPerMinute и Burst - пополнение и емкость корзины для обычных запросов
+	RequestsPerMinute int `yaml:"requests_per_minute"`
+	Burst             int `yaml:"burst"`
+	// Orders - отдельная корзина для выставления и отмены заявок
+	Orders RateLimitConfig `yaml:"orders"`
+	// IdleTimeout - через сколько удаляются корзины неактивных клиентов
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+	// Backend - memory или redis (общие лимиты для нескольких реплик)
+	Backend string `yaml:"backend"`
+}
+
+// RateLimitConfig - параметры одной корзины
+type RateLimitConfig struct {
+	RequestsPerMinute int `yaml:"requests_per_minute"`
+	Burst             int `yaml:"burst"`
+}
+
+// JWTConfig - подпись и срок жизни токенов
+type JWTConfig struct {
+	// Secret - ключ подписи с идентификатором "default"
+	Secret string `yaml:"secret"`
+	// Expiry - срок жизни access токена
+	Expiry time.Duration `yaml:"expiry"`
+	// RefreshExpiry - срок жизни refresh токена
+	RefreshExpiry time.Duration `yaml:"refresh_expiry"`
+	Issuer        string        `yaml:"issuer"`
+	// Keys - дополнительные ключи для ротации: токены проверяются всеми ключами,
+	// а подписываются ключом ActiveKey
+	Keys      []JWTKeyConfig `yaml:"keys"`
+	ActiveKey string         `yaml:"active_key"`
+}
+
+// JWTKeyConfig - ключ подписи токенов
+type JWTKeyConfig struct {
+	ID     string `yaml:"id"`
+	Secret string `yaml:"secret"`
+}
+
+// APIKeysConfig - доступ по API ключам
+type APIKeysConfig struct {
+	// Enabled - принимать ли ключи из Keys; ключи, выданные через
+	// /admin/api-keys, действуют независимо от флага
+	Enabled bool `yaml:"enabled"`
+	// Keys - ключи из конфигурации, в дополнение к созданным через /admin/api-keys
+	Keys []StaticAPIKeyConfig `yaml:"keys"`
+}
+
+// StaticAPIKeyConfig - API ключ, заданный в конфигурации
+type StaticAPIKeyConfig struct {
+	Name   string   `yaml:"name"`
+	Key    string   `yaml:"key"`
+	Scopes []string `yaml:"scopes"`
+	// Accounts - доступные ключу счета, пусто - все счета
+	Accounts []string `yaml:"accounts"`
+	// RateLimit - запросов в минуту, 0 - без отдельного лимита
+	RateLimit int `yaml:"rate_limit"`
+}
+
+// BootstrapAdminConfig - учетная запись первого администратора
+type BootstrapAdminConfig struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// CacheConfig - кэши данных брокера
+type CacheConfig struct {
+	// Instruments - инструменты, запрошенные по одному сверх ежедневного справочника
+	Instruments CacheEntryConfig `yaml:"instruments"`
+}
+
+// CacheEntryConfig - время жизни и размер кэша
+type CacheEntryConfig struct {
+	TTL     time.Duration `yaml:"ttl"`
+	MaxSize int           `yaml:"max_size"`
+}
+
+// Load - загрузка настроек из YAML-файла
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	cfg := Config{
+		Trading: TradingConfig{
+			KillSwitch:      KillSwitchConfig{StateFile: "./data/kill_switch.json"},
+			Idempotency:     IdempotencyConfig{Retention: 24 * time.Hour},
+			SyntheticOrders: SyntheticOrdersConfig{PollInterval: 5 * time.Second},
+			OrderJournal:    OrderJournalConfig{PollInterval: 30 * time.Second},
+			PriceRounding:   PriceRoundingConfig{Buy: "down", Sell: "up"},
+		},
+		PaperTrading: PaperTradingConfig{
+			InitialBalance: 1000000,
+			Currency:       "rub",
+			Feed:           "live",
+			ReplayDir:      "./data/history",
+			ReplayInterval: time.Second,
+		},
+		Bots: BotsConfig{RestorePolicy: "resume"},
+		Security: SecurityConfig{
+			JWT: JWTConfig{
+				Expiry:        15 * time.Minute,
+				RefreshExpiry: 30 * 24 * time.Hour,
+				Issuer:        "trading-server",
+			},
+			BootstrapAdmin: BootstrapAdminConfig{Username: "admin"},
+			RateLimiting: RateLimitingConfig{
+				RequestsPerMinute: 100,
+				Burst:             50,
+				Orders:            RateLimitConfig{RequestsPerMinute: 30, Burst: 10},
+				IdleTimeout:       10 * time.Minute,
+				Backend:           "memory",
+			},
+		},
+		Redis:          RedisConfig{Host: "localhost", Port: 6379},
+		BrokerAPI:      BrokerAPIConfig{MaxQueue: 200, Cooldown: 10 * time.Second},
+		Reconciliation: ReconciliationConfig{Enabled: true, Interval: 5 * time.Minute},
+		Cache: CacheConfig{
+			Instruments: CacheEntryConfig{TTL: time.Hour, MaxSize: 10000},
+		},
+		Streams: StreamsConfig{
+			MarketData: MarketDataStreamConfig{BufferSize: 1000, OrderBookDepth: 10},
+		},
+		Database: DatabaseConfig{
+			Driver:      "sqlite",
+			AutoMigrate: true,
+			Postgres:    PostgresConfig{Host: "localhost", Port: 5432, SSLMode: "disable"},
+			SQLite:      SQLiteConfig{Path: "./data/trading.db"},
+		},
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,195 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// MaxKeyLength - предел длины ключа идемпотентности
+	MaxKeyLength = 255
+	// staleAfter - через сколько незавершенный запрос считается прерванным,
+	// и ключ можно занять повторно
+	staleAfter = time.Minute
+	// purgeInterval - как часто удалять истекшие ключи
+	purgeInterval = time.Hour
+)
+
+var (
+	// ErrPayloadMismatch - ключ уже использован с другим телом запроса
+	ErrPayloadMismatch = errors.New("idempotency key was already used with a different request")
+	// ErrInProgress - запрос с этим ключом еще выполняется
+	ErrInProgress = errors.New("request with this idempotency key is still in progress")
+	ErrInvalidKey = fmt.Errorf("idempotency key must be 1 to %d characters", MaxKeyLength)
+)
+
+// Record - запрос с ключом идемпотентности и сохраненный ответ на него
+type Record struct {
+	// Owner - субъект запроса: ключи разных пользователей не пересекаются
+	Owner       string
+	Key         string
+	RequestHash string
+	// StatusCode и Response - ответ, 0 пока запрос выполняется
+	StatusCode int
+	Response   json.RawMessage
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+}
+
+// Completed - есть ли сохраненный ответ
+func (r *Record) Completed() bool {
+	return r.StatusCode != 0
+}
+
+// Store - хранилище ключей идемпотентности
+type Store interface {
+	// CreateIdempotencyRecord - занятие ключа, false если он уже занят
+	CreateIdempotencyRecord(ctx context.Context, record Record) (bool, error)
+	// IdempotencyRecord - запись по ключу, nil если ее нет
+	IdempotencyRecord(ctx context.Context, owner, key string) (*Record, error)
+	// CompleteIdempotencyRecord - сохранение ответа на запрос
+	CompleteIdempotencyRecord(ctx context.Context, owner, key string, statusCode int, response json.RawMessage) error
+	DeleteIdempotencyRecord(ctx context.Context, owner, key string) error
+	// DeleteExpiredIdempotencyRecords - удаление ключей, истекших до before
+	DeleteExpiredIdempotencyRecords(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Service - повтор ответа на запрос, уже выполненный с тем же ключом
+type Service struct {
+	store     Store
+	retention time.Duration
+	logger    *zap.SugaredLogger
+
+	mu        sync.Mutex
+	lastPurge time.Time
+}
+
+// NewService - ключи хранятся retention после первого запроса
+func NewService(store Store, retention time.Duration, logger *zap.SugaredLogger) *Service {
+	return &Service{
+		store:     store,
+		retention: retention,
+		logger:    logger,
+	}
+}
+
+// Begin - занятие ключа перед выполнением запроса с телом payload.
+// Если запрос с этим ключом уже выполнен, возвращается его запись с ответом;
+// если ключ использован с другим телом - ErrPayloadMismatch.
+func (s *Service) Begin(ctx context.Context, owner, key string, payload interface{}) (*Record, error) {
+	if key == "" || len(key) > MaxKeyLength {
+		return nil, ErrInvalidKey
+	}
+	hash, err := hashPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	s.purge(ctx, now)
+
+	record := Record{
+		Owner:       owner,
+		Key:         key,
+		RequestHash: hash,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(s.retention),
+	}
+	created, err := s.store.CreateIdempotencyRecord(ctx, record)
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		return nil, nil
+	}
+
+	existing, err := s.store.IdempotencyRecord(ctx, owner, key)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil || now.After(existing.ExpiresAt) || (!existing.Completed() && now.Sub(existing.CreatedAt) > staleAfter) {
+		// Истекший или брошенный ключ занимается заново. Повтор прерванного
+		// запроса безопасен: брокер не выставит заявку с тем же OrderID дважды.
+		if err := s.store.DeleteIdempotencyRecord(ctx, owner, key); err != nil {
+			return nil, err
+		}
+		if created, err = s.store.CreateIdempotencyRecord(ctx, record); err != nil {
+			return nil, err
+		}
+		if created {
+			return nil, nil
+		}
+		return nil, ErrInProgress
+	}
+
+	if existing.RequestHash != hash {
+		return nil, ErrPayloadMismatch
+	}
+	if !existing.Completed() {
+		return nil, ErrInProgress
+	}
+	return existing, nil
+}
+
+// Complete - сохранение ответа для повторов запроса
+func (s *Service) Complete(ctx context.Context, owner, key string, statusCode int, response interface{}) error {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return fmt.Errorf("failed to encode idempotent response: %w", err)
+	}
+	return s.store.CompleteIdempotencyRecord(ctx, owner, key, statusCode, data)
+}
+
+// Release - освобождение ключа, если запрос не выполнен и его можно повторить
+func (s *Service) Release(ctx context.Context, owner, key string) error {
+	return s.store.DeleteIdempotencyRecord(ctx, owner, key)
+}
+
+// OrderID - идентификатор заявки у брокера по ключу. Одинаков для повторов,
+// поэтому брокер не выставит заявку дважды, даже если ответ не был сохранен.
+func OrderID(owner, key string) string {
+	sum := sha256.Sum256([]byte(owner + "\x00" + key))
+	b := sum[:16]
+	b[6] = b[6]&0x0f | 0x50
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
+
+// hashPayload - хеш тела запроса для сравнения повторов
+func hashPayload(payload interface{}) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode idempotent request: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// purge - удаление истекших ключей, не чаще раза в час
+func (s *Service) purge(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.lastPurge) < purgeInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastPurge = now
+	s.mu.Unlock()
+
+	removed, err := s.store.DeleteExpiredIdempotencyRecords(ctx, now)
+	if err != nil {
+		s.logger.Warnf("Failed to purge expired idempotency keys: %v", err)
+		return
+	}
+	if removed > 0 {
+		s.logger.Debugf("Purged %d expired idempotency keys", removed)
+	}
+}
@@ -0,0 +1,193 @@
+package journal
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tinkoff/invest-api-go-sdk/investgo"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+	"go.uber.org/zap"
+
+	"trading-bot-web/bots"
+	"trading-bot-web/broker"
+	"trading-bot-web/storage"
+	"trading-bot-web/streams"
+)
+
+// openWindow - сколько отслеживается незавершенная заявка: заявки брокера
+// дневные и не живут дольше суток
+const openWindow = 24 * time.Hour
+
+// Entry - заявка журнала с событиями жизненного цикла
+type Entry struct {
+	storage.OrderRecord
+	Events []storage.OrderEvent `json:"events"`
+}
+
+// Tracker - обновление журнала заявок после выставления. Сделки по заявкам
+// приходят из стрима сделок, статус и комиссия уточняются через GetOrderState;
+// незавершенные заявки дополнительно опрашиваются на случай разрыва стрима.
+type Tracker struct {
+	orders       broker.OrderGateway
+	repo         storage.Repository
+	stream       *investgo.OrdersStreamClient
+	monitor      *streams.Monitor
+	pollInterval time.Duration
+	logger       *zap.SugaredLogger
+
+	// traded - заявки, по которым пришли сделки
+	traded chan string
+	// fillObservers - получатели исполнения, появившегося после выставления заявки
+	fillObservers []func(accountID string, fill bots.Fill)
+}
+
+// NewTracker - журнал заявок, состояние заявок запрашивается через orders
+func NewTracker(orders broker.OrderGateway, repo storage.Repository, client *investgo.Client, monitor *streams.Monitor, pollInterval time.Duration, logger *zap.SugaredLogger) *Tracker {
+	return &Tracker{
+		orders:       orders,
+		repo:         repo,
+		stream:       client.NewOrdersStreamClient(),
+		monitor:      monitor,
+		pollInterval: pollInterval,
+		logger:       logger,
+		traded:       make(chan string, 256),
+	}
+}
+
+// OnFill - подписка на исполнение заявок после выставления: исполнение из ответа
+// на заявку уже учтено выставившим ее. Quantity не заполняется, лотность журналу
+// неизвестна. Задается до Run.
+func (t *Tracker) OnFill(observer func(accountID string, fill bots.Fill)) {
+	t.fillObservers = append(t.fillObservers, observer)
+}
+
+// Run - отслеживание заявок счетов accounts до отмены контекста
+func (t *Tracker) Run(ctx context.Context, accounts []string) {
+	if len(accounts) > 0 {
+		go t.monitor.Run(ctx, "order_journal", func(context.Context, bool) (streams.Session, error) {
+			stream, err := t.stream.TradesStream(accounts)
+			if err != nil {
+				return streams.Session{}, fmt.Errorf("trades stream error: %w", err)
+			}
+			go t.listen(ctx, stream.Trades())
+			return streams.Session{Listen: stream.Listen, Stop: stream.Stop}, nil
+		})
+	}
+
+	// Журнал не должен занимать квоту заявок, нужную ботам и API
+	ctx = broker.WithPriority(ctx, broker.PriorityLow)
+	ticker := time.NewTicker(t.pollInterval)
+	defer ticker.Stop()
+
+	t.poll(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case orderID := <-t.traded:
+			record, err := t.repo.GetOrder(ctx, orderID)
+			if err != nil {
+				t.logger.Errorf("Order journal: failed to load order %s: %v", orderID, err)
+				continue
+			}
+			// Заявки, выставленные не через сервер, в журнал не попадают
+			if record != nil {
+				t.refresh(ctx, *record)
+			}
+		case <-ticker.C:
+			t.poll(ctx)
+		}
+	}
+}
+
+// listen - передача сделок текущего стрима в цикл Run; при переполнении
+// сделка пропускается, заявку обновит опрос
+func (t *Tracker) listen(ctx context.Context, trades <-chan *pb.OrderTrades) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case orderTrades, ok := <-trades:
+			if !ok {
+				return
+			}
+			select {
+			case t.traded <- orderTrades.GetOrderId():
+			default:
+			}
+		}
+	}
+}
+
+// poll - обновление незавершенных заявок за последние сутки
+func (t *Tracker) poll(ctx context.Context) {
+	open, err := t.repo.ListOrders(ctx, storage.OrderFilter{
+		Statuses: storage.OpenOrderStatuses,
+		From:     time.Now().Add(-openWindow),
+	})
+	if err != nil {
+		t.logger.Errorf("Order journal: failed to list open orders: %v", err)
+		return
+	}
+	for _, record := range open {
+		if ctx.Err() != nil {
+			return
+		}
+		t.refresh(ctx, record)
+	}
+}
+
+// refresh - сохранение текущего состояния заявки у брокера
+func (t *Tracker) refresh(ctx context.Context, record storage.OrderRecord) {
+	state, err := t.orders.GetOrderState(ctx, record.AccountID, record.OrderID)
+	if err != nil {
+		t.logger.Warnf("Order journal: failed to get state of order %s: %v", record.OrderID, err)
+		return
+	}
+
+	updated := storage.ApplyOrderState(record, state)
+	changed, err := storage.SaveOrderUpdate(ctx, t.repo, updated, &record, "")
+	if err != nil {
+		t.logger.Errorf("Order journal: failed to save order %s: %v", record.OrderID, err)
+		return
+	}
+	if changed {
+		t.logger.Infof("Order %s: %s, executed %d of %d lots", record.OrderID, updated.Status, updated.LotsExecuted, updated.Lots)
+	}
+	if updated.LotsExecuted > record.LotsExecuted {
+		fill := storage.FillBetween(record, updated)
+		for _, observer := range t.fillObservers {
+			observer(updated.AccountID, fill)
+		}
+	}
+}
+
+// History - заявки журнала по фильтру с событиями, новые первыми
+func (t *Tracker) History(ctx context.Context, filter storage.OrderFilter) ([]Entry, error) {
+	records, err := t.repo.ListOrders(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(records))
+	for i, record := range records {
+		ids[i] = record.OrderID
+	}
+	events, err := t.repo.ListOrderEvents(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	byOrder := make(map[string][]storage.OrderEvent, len(records))
+	for _, event := range events {
+		byOrder[event.OrderID] = append(byOrder[event.OrderID], event)
+	}
+	entries := make([]Entry, len(records))
+	for i, record := range records {
+		entries[i] = Entry{OrderRecord: record, Events: byOrder[record.OrderID]}
+		if entries[i].Events == nil {
+			entries[i].Events = make([]storage.OrderEvent, 0)
+		}
+	}
+	return entries, nil
+}
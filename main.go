@@ -2,42 +2,61 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
 	"github.com/tinkoff/invest-api-go-sdk/investgo"
 	pb "github.com/tinkoff/invest-api-go-sdk/proto"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	
 	// Локальные пакеты
-	"./middleware"
-	"./websocket"
-	"./bots"
+	"trading-bot-web/auth"
+	"trading-bot-web/backtest"
+	"trading-bot-web/bots"
+	"trading-bot-web/broker"
+	"trading-bot-web/catalog"
+	"trading-bot-web/config"
+	"trading-bot-web/idempotency"
+	"trading-bot-web/journal"
+	"trading-bot-web/middleware"
+	"trading-bot-web/paper"
+	"trading-bot-web/ratelimit"
+	"trading-bot-web/reconcile"
+	"trading-bot-web/risk"
+	"trading-bot-web/storage"
+	"trading-bot-web/streams"
+	"trading-bot-web/synthetic"
+	"trading-bot-web/websocket"
 )
 
 // TradingServer - основная структура сервера
 type TradingServer struct {
 	client                *investgo.Client
 	config                investgo.Config
+	appConfig             *config.Config
 	logger                *zap.SugaredLogger
 	ctx                   context.Context
 	cancel                context.CancelFunc
 	
-	// Сервисы API
-	usersService          *investgo.UsersServiceClient
-	ordersService         *investgo.OrdersServiceClient
-	instrumentsService    *investgo.InstrumentsServiceClient
-	marketDataService     *investgo.MarketDataServiceClient
-	sandboxService        *investgo.SandboxServiceClient
-	operationsService     *investgo.OperationsServiceClient
+	// Сервисы брокера
+	orderGateway          broker.OrderGateway
+	stopOrders            broker.StopOrderGateway
+	marketData            broker.MarketDataProvider
+	portfolioProvider     broker.PortfolioProvider
+	instruments           broker.InstrumentCatalog
 	
 	// Стримы
 	marketDataStream      *investgo.MarketDataStreamClient
@@ -55,9 +74,51 @@ type TradingServer struct {
 	wsHub             *websocket.Hub
 	streamManager     *websocket.StreamManager
 	
+	// Переподключение стримов брокера
+	streamMonitor     *streams.Monitor
+	
 	// Менеджер ботов
 	botManager        *bots.BotManager
 	
+	// Очередь бэктестов
+	backtests         *backtest.JobManager
+	
+	// Симулятор бумажной торговли
+	paperBroker       *paper.Broker
+	
+	// Риск-движок, через который проходят все заявки
+	riskGateway       *risk.Gateway
+	
+	// Синтетические заявки: OCO, bracket, трейлинг-стопы
+	syntheticOrders   *synthetic.Manager
+	
+	// Журнал заявок, выставленных через сервер
+	orderJournal      *journal.Tracker
+	
+	// Сверка состояния сервера с брокером
+	reconciler        *reconcile.Reconciler
+	
+	// Хранилище ботов, заявок и исполнений
+	store             storage.Repository
+	
+	// Пользователи и JWT токены
+	auth              *auth.Service
+	
+	// Лимит входящих запросов, nil если отключен
+	rateLimiter       *ratelimit.Limiter
+	
+	// Квоты исходящих запросов к investAPI
+	brokerGovernor    *broker.Governor
+	
+	// Справочник инструментов, обновляется раз в сутки
+	catalog           *catalog.Catalog
+	
+	// Приведение заявок API к шагу цены и лотам инструмента
+	orderRules        *catalog.Rules
+	
+	// Ответы на заявки с ключом идемпотентности
+	idempotency       *idempotency.Service
+	
 	// Данные
 	accounts              []string
 	positions             map[string]interface{}
@@ -95,7 +156,9 @@ func NewTradingServer() (*TradingServer, error) {
 
 	// Настраиваем HTTP роутер
 	gin.SetMode(gin.ReleaseMode)
-	router := gin.Default()
+	// Журнал и восстановление после паники - свои, из middleware: журнал gin
+	// записал бы access_token из строки запроса WebSocket
+	router := gin.New()
 
 	server := &TradingServer{
 		client:     client,
@@ -125,13 +188,54 @@ func NewTradingServer() (*TradingServer, error) {
 func (ts *TradingServer) initializeServices() error {
 	ts.logger.Info("Initializing API services...")
 
-	// Создаем все сервисы
-	ts.usersService = ts.client.NewUsersServiceClient()
-	ts.ordersService = ts.client.NewOrdersServiceClient()
-	ts.instrumentsService = ts.client.NewInstrumentsServiceClient()
-	ts.marketDataService = ts.client.NewMarketDataServiceClient()
-	ts.sandboxService = ts.client.NewSandboxServiceClient()
-	ts.operationsService = ts.client.NewOperationsServiceClient()
+	// Загружаем настройки приложения
+	appConfig, err := config.Load("config.yaml")
+	if err != nil {
+		return fmt.Errorf("app config loading error: %w", err)
+	}
+	ts.appConfig = appConfig
+
+	// Подключаемся к базе
+	if err := ts.openStore(); err != nil {
+		return err
+	}
+
+	// Создаем сервис аутентификации
+	if err := ts.setupAuth(); err != nil {
+		return fmt.Errorf("auth setup error: %w", err)
+	}
+	ts.rateLimiter, err = ratelimit.New(ts.appConfig.Security.RateLimiting, ts.appConfig.Redis, ts.logger)
+	if err != nil {
+		return fmt.Errorf("rate limiter setup error: %w", err)
+	}
+
+	// Создаем сервисы брокера, заявки проходят через риск-движок
+	// и сохраняются в базу, а все вызовы investAPI - через квоты сервисов
+	riskEngine := risk.NewEngine(risk.LimitsFromConfig(ts.appConfig.Trading), ts.logger)
+	riskEngine.SetStore(ts.store)
+	if err := riskEngine.Restore(ts.ctx); err != nil {
+		return err
+	}
+	killSwitch, err := risk.NewKillSwitch(ts.appConfig.Trading.KillSwitch.StateFile)
+	if err != nil {
+		return err
+	}
+	if state := killSwitch.State(); state.Global != nil || len(state.Accounts) > 0 {
+		ts.logger.Warnf("Kill switch is engaged: global=%v, accounts=%d", state.Global != nil, len(state.Accounts))
+	}
+	ts.brokerGovernor = broker.NewGovernor(ts.appConfig.BrokerAPI, ts.logger)
+	ts.catalog = catalog.New(broker.NewTinkoff(ts.client, ts.brokerGovernor), ts.appConfig.Cache.Instruments, ts.logger)
+	recorder := storage.NewOrderRecorder(ts.catalog, ts.store, ts.logger)
+	ts.riskGateway = risk.NewGateway(recorder, riskEngine, killSwitch, ts.logger)
+	// Исполнение, найденное при снятии заявки, журнал уже не увидит
+	recorder.OnFill(func(accountID string, fill bots.Fill) {
+		ts.riskGateway.RecordFill(ts.ctx, accountID, fill)
+	})
+	ts.useBroker(ts.riskGateway)
+	ts.orderRules, err = catalog.NewRules(ts.catalog, ts.marketData, ts.appConfig.Trading.PriceRounding)
+	if err != nil {
+		return err
+	}
 
 	// Создаем стримы
 	ts.marketDataStream = ts.client.NewMarketDataStreamClient()
@@ -140,9 +244,50 @@ func (ts *TradingServer) initializeServices() error {
 	// Создаем WebSocket хаб
 	ts.wsHub = websocket.NewHub(ts.logger)
 	go ts.wsHub.Run()
+	
+	// Состояние стримов рассылается клиентам хаба
+	ts.streamMonitor = streams.NewMonitor(streams.DefaultBackoff, ts.logger)
+	ts.streamMonitor.OnStatus(ts.broadcastStreamStatus)
+	
+	// Создаем менеджер стримов, он держит подписки клиентов хаба
+	ts.streamManager = websocket.NewStreamManager(ts.wsHub, ts.client, ts.marketData, ts.streamMonitor, ts.appConfig.Streams, ts.logger)
+	ts.wsHub.SetSubscriptionListener(ts.streamManager)
+	ts.wsHub.SetAccountAuthorizer(ts.authorizeAccount)
 
 	// Создаем менеджер ботов
-	ts.botManager = bots.NewBotManager(ts.client, ts.logger)
+	ts.botManager = bots.NewBotManager(ts.client, ts.riskGateway, ts.streamMonitor, ts.logger)
+	ts.botManager.SetStore(ts.store)
+	
+	// Синтетические заявки следят за ценами через собственный стрим
+	priceFeed := streams.NewMarketData("synthetic_orders", ts.client, ts.marketData, ts.streamMonitor, 256, ts.logger)
+	ts.syntheticOrders = synthetic.NewManager(ts.orderGateway, ts.marketData, priceFeed, ts.store,
+		ts.appConfig.Trading.SyntheticOrders.PollInterval, ts.logger)
+	ts.syntheticOrders.OnAlert(ts.broadcastSyntheticAlert)
+	
+	// Журнал дополняет сохраненные заявки исполнением из стрима сделок
+	ts.orderJournal = journal.NewTracker(ts.orderGateway, ts.store, ts.client, ts.streamMonitor,
+		ts.appConfig.Trading.OrderJournal.PollInterval, ts.logger)
+	// Исполнение лимитных заявок после выставления учитывается в дневном убытке
+	ts.orderJournal.OnFill(func(accountID string, fill bots.Fill) {
+		ts.riskGateway.RecordFill(ts.ctx, accountID, fill)
+	})
+	
+	// Сверка позиций и заявок ботов и журнала с брокером, расхождения рассылаются клиентам хаба
+	ts.reconciler = reconcile.New(ts.orderGateway, ts.portfolioProvider, ts.botManager, ts.store,
+		ts.appConfig.Reconciliation, ts.logger)
+	ts.reconciler.OnEvent(ts.broadcastReconciliation)
+	if err := ts.setupPaperTrading(); err != nil {
+		return fmt.Errorf("paper trading setup error: %w", err)
+	}
+
+	// Создаем движок бэктестов
+	engine := backtest.NewEngine(
+		backtest.NewAPIHistory(ts.marketData),
+		"./data/history",
+		ts.appConfig.Trading.Fees.TotalPercent(),
+		ts.logger,
+	)
+	ts.backtests = backtest.NewJobManager(engine, 2, ts.logger)
 
 	// Получаем информацию об аккаунтах
 	if err := ts.loadAccountInfo(); err != nil {
@@ -153,15 +298,59 @@ func (ts *TradingServer) initializeServices() error {
 	return nil
 }
 
+// openStore - подключение к базе и применение миграций, если включено
+func (ts *TradingServer) openStore() error {
+	cfg := ts.appConfig.Database
+	store, err := storage.Open(cfg)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	ts.store = store
+	
+	if !cfg.AutoMigrate {
+		return nil
+	}
+	applied, err := store.Migrate(ts.ctx)
+	if err != nil {
+		return fmt.Errorf("database migration error: %w", err)
+	}
+	for _, m := range applied {
+		ts.logger.Infof("Applied migration %04d_%s", m.Version, m.Name)
+	}
+	return nil
+}
+
+// setupAuth - сервис JWT токенов и начальный администратор
+func (ts *TradingServer) setupAuth() error {
+	security := ts.appConfig.Security
+	service, err := auth.NewService(ts.ctx, security, ts.store, ts.logger)
+	if err != nil {
+		return err
+	}
+	ts.auth = service
+	ts.idempotency = idempotency.NewService(ts.store, ts.appConfig.Trading.Idempotency.Retention, ts.logger)
+	
+	return ts.auth.EnsureAdmin(ts.ctx, security.BootstrapAdmin)
+}
+
+// useBroker - подключение всех сервисов брокера из одной реализации
+func (ts *TradingServer) useBroker(b broker.Broker) {
+	ts.orderGateway = b
+	ts.stopOrders = b
+	ts.marketData = b
+	ts.portfolioProvider = b
+	ts.instruments = b
+}
+
 // loadAccountInfo - загрузка информации об аккаунтах
 func (ts *TradingServer) loadAccountInfo() error {
-	accountsResp, err := ts.usersService.GetAccounts()
+	accounts, err := ts.portfolioProvider.GetAccounts(ts.ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get accounts: %w", err)
 	}
 
 	ts.accounts = make([]string, 0)
-	for _, acc := range accountsResp.GetAccounts() {
+	for _, acc := range accounts {
 		ts.accounts = append(ts.accounts, acc.GetId())
 		ts.logger.Infof("Found account: %s", acc.GetId())
 	}
@@ -173,6 +362,149 @@ func (ts *TradingServer) loadAccountInfo() error {
 	return nil
 }
 
+// authorizeAccount - доступ клиента WebSocket к данным счета токена сервера
+func (ts *TradingServer) authorizeAccount(principal *auth.Principal, accountID string) bool {
+	known := false
+	for _, id := range ts.accounts {
+		if id == accountID {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return false
+	}
+	
+	allowed, err := ts.auth.CanAccessAccount(ts.ctx, principal, accountID)
+	if err != nil {
+		ts.logger.Errorf("Failed to check account access: %v", err)
+		return false
+	}
+	return allowed
+}
+
+// requireAccount - проверка доступа субъекта запроса к счету, при отказе отвечает 403
+func (ts *TradingServer) requireAccount(c *gin.Context, accountID string) bool {
+	allowed, err := ts.auth.CanAccessAccount(c.Request.Context(), middleware.Principal(c), accountID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "access to account " + accountID + " denied"})
+		return false
+	}
+	return true
+}
+
+// canAccessBot - доступ к боту: владельцу, администратору и пользователям со счетом бота
+func (ts *TradingServer) canAccessBot(ctx context.Context, principal *auth.Principal, config bots.BotConfig) (bool, error) {
+	if principal.HasRole(auth.RoleAdmin) {
+		return true, nil
+	}
+	if principal != nil && principal.UserID != "" && principal.UserID == config.OwnerID {
+		return true, nil
+	}
+	return ts.auth.CanAccessAccount(ctx, principal, config.AccountID)
+}
+
+// requireBot - бот с проверкой доступа, при отказе отвечает 404 или 403
+func (ts *TradingServer) requireBot(c *gin.Context, botID string) (*bots.Bot, bool) {
+	bot, exists := ts.botManager.GetBot(botID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Bot not found"})
+		return nil, false
+	}
+	
+	allowed, err := ts.canAccessBot(c.Request.Context(), middleware.Principal(c), bot.Config())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "access to bot " + botID + " denied"})
+		return nil, false
+	}
+	return bot, true
+}
+
+// setupPaperTrading - регистрация режима исполнения paper для ботов
+func (ts *TradingServer) setupPaperTrading() error {
+	cfg := ts.appConfig.PaperTrading
+	ts.paperBroker = paper.NewBroker(ts.appConfig.Trading.Fees.TotalPercent(), ts.logger)
+	// Отдельный риск-движок: позиции бумажных счетов не должны закрываться
+	// через реального брокера, аварийная блокировка общая
+	guard := risk.NewGuard(
+		risk.NewEngine(risk.LimitsFromConfig(ts.appConfig.Trading), ts.logger),
+		ts.riskGateway.KillSwitch(),
+		ts.logger,
+	)
+
+	var upstream bots.MarketFeed
+	switch cfg.Feed {
+	case "", "live":
+		upstream = bots.NewLiveFeed(ts.client, ts.marketData, ts.streamMonitor, ts.logger)
+	case "replay":
+		upstream = paper.NewReplayFeed(cfg.ReplayDir, cfg.ReplayInterval, ts.logger)
+	default:
+		return fmt.Errorf("unknown paper trading feed %q", cfg.Feed)
+	}
+
+	ts.botManager.RegisterExecutionMode(bots.ExecutionModePaper, bots.ExecutionBackend{
+		NewExecutor: func(botConfig bots.BotConfig) (bots.Executor, error) {
+			// Лотность берется из справочника, иначе симулятор считает лот равным 1
+			for _, instrumentID := range botConfig.Instruments {
+				instrument, err := ts.catalog.Lookup(context.Background(), instrumentID, "")
+				if err != nil {
+					return nil, fmt.Errorf("failed to get instrument %s: %w", instrumentID, err)
+				}
+				ts.paperBroker.SetLotSize(instrumentID, instrument.Lot)
+			}
+			
+			accountID := paperAccountID(botConfig)
+			ts.paperBroker.OpenAccount(accountID, cfg.Currency, cfg.InitialBalance)
+			return paper.NewExecutor(ts.paperBroker, guard, accountID), nil
+		},
+		Feed: paper.NewFeed(upstream, ts.paperBroker),
+	})
+
+	ts.logger.Infof("Paper trading enabled with %s feed", cfg.Feed)
+	return nil
+}
+
+// paperAccountID - виртуальный счет бумажного бота. Счета пользователей
+// разделены по владельцу, чтобы один пользователь не торговал в чужом счете
+// с тем же AccountID. У ботов API ключей владельца нет, доступ к их счету
+// проверяется как к реальному.
+func paperAccountID(config bots.BotConfig) string {
+	if config.OwnerID == "" {
+		return config.AccountID
+	}
+	return config.OwnerID + "/" + config.AccountID
+}
+
+// rateLimitClass - бюджет лимита запросов: изменение заявок считается отдельно от чтения
+func rateLimitClass(c *gin.Context) string {
+	if c.Request.Method == http.MethodGet {
+		return ratelimit.ClassDefault
+	}
+	for _, prefix := range []string{"/api/v1/orders", "/api/v1/stop-orders", "/api/v1/synthetic-orders"} {
+		if strings.HasPrefix(c.FullPath(), prefix) {
+			return ratelimit.ClassOrders
+		}
+	}
+	return ratelimit.ClassDefault
+}
+
+// brokerPriority - чтение через HTTP API идет к брокеру с низким приоритетом,
+// чтобы дашборд не задерживал заявки и ботов при нехватке квоты
+func brokerPriority(c *gin.Context) {
+	if c.Request.Method == http.MethodGet {
+		c.Request = c.Request.WithContext(broker.WithPriority(c.Request.Context(), broker.PriorityLow))
+	}
+	c.Next()
+}
+
 // setupRoutes - настройка HTTP маршрутов
 func (ts *TradingServer) setupRoutes() {
 	// Подключаем middleware
@@ -181,7 +513,9 @@ func (ts *TradingServer) setupRoutes() {
 	ts.router.Use(middleware.CORS())
 	ts.router.Use(middleware.SecurityHeaders())
 	ts.router.Use(middleware.RequestID())
-	ts.router.Use(middleware.RateLimit(100)) // 100 запросов в минуту
+	
+	// Лимит запросов ставится после аутентификации, чтобы считать его по ключу или пользователю
+	rateLimit := middleware.RateLimit(ts.rateLimiter, rateLimitClass)
 	
 	// Статические файлы для веб-интерфейса
 	ts.router.Static("/static", "./web/static")
@@ -196,60 +530,114 @@ func (ts *TradingServer) setupRoutes() {
 	
 	// Публичные маршруты
 	public := ts.router.Group("/api/v1")
+	public.Use(rateLimit)
 	public.GET("/status", ts.handleStatus)
 	public.POST("/auth/login", ts.handleLogin)
+	public.POST("/auth/refresh", ts.handleRefresh)
 	
 	// Защищенные маршруты (требуют аутентификации)
+	// JWT токен или API ключ из security.api_keys и /admin/api-keys
+	authenticate := middleware.Auth(ts.auth)
 	protected := ts.router.Group("/api/v1")
-	protected.Use(middleware.Auth([]string{"demo-api-key"})) // В продакшене использовать реальные ключи
+	protected.Use(authenticate, rateLimit, brokerPriority)
+	
+	protected.POST("/auth/logout", ts.handleLogout)
+	
+	// Роли: просмотр доступен любой роли, заявки - трейдеру, боты - оператору ботов
+	viewer := protected.Group("", middleware.RequireRole(auth.RoleViewer, auth.RoleTrader, auth.RoleBotOperator))
+	trader := protected.Group("", middleware.RequireRole(auth.RoleTrader))
+	operator := protected.Group("", middleware.RequireRole(auth.RoleBotOperator))
 	
 	// Информация об аккаунтах
-	protected.GET("/accounts", ts.handleGetAccounts)
-	protected.GET("/accounts/:id/portfolio", ts.handleGetPortfolio)
-	protected.GET("/accounts/:id/positions", ts.handleGetPositions)
-	protected.GET("/accounts/:id/operations", ts.handleGetOperations)
+	viewer.GET("/accounts", ts.handleGetAccounts)
+	viewer.GET("/accounts/:id/portfolio", ts.handleGetPortfolio)
+	viewer.GET("/accounts/:id/positions", ts.handleGetPositions)
+	viewer.GET("/accounts/:id/operations", ts.handleGetOperations)
+	
+	// Риски
+	viewer.GET("/risk/:account_id", ts.handleGetRisk)
 	
 	// Ордера
-	protected.POST("/orders/buy", ts.handleBuyOrder)
-	protected.POST("/orders/sell", ts.handleSellOrder)
-	protected.GET("/orders", ts.handleGetOrders)
-	protected.GET("/orders/:id", ts.handleGetOrder)
-	protected.DELETE("/orders/:id", ts.handleCancelOrder)
+	trader.POST("/orders", ts.handleCreateOrder)
+	trader.POST("/orders/buy", ts.handleBuyOrder)
+	trader.POST("/orders/sell", ts.handleSellOrder)
+	viewer.GET("/orders", ts.handleGetOrders)
+	viewer.GET("/orders/history", ts.handleGetOrderHistory)
+	viewer.GET("/orders/:id", ts.handleGetOrder)
+	trader.PATCH("/orders/:id", ts.handleReplaceOrder)
+	trader.DELETE("/orders/:id", ts.handleCancelOrder)
+	
+	// Стоп-заявки
+	trader.POST("/stop-orders", ts.handlePostStopOrder)
+	viewer.GET("/stop-orders", ts.handleGetStopOrders)
+	trader.DELETE("/stop-orders/:id", ts.handleCancelStopOrder)
+	
+	// Синтетические заявки, активные видны в GET /orders
+	trader.POST("/synthetic-orders", ts.handleCreateSyntheticOrder)
+	trader.DELETE("/synthetic-orders/:id", ts.handleCancelSyntheticOrder)
 	
 	// Инструменты
-	protected.GET("/instruments/search", ts.handleSearchInstruments)
-	protected.GET("/instruments/:figi", ts.handleGetInstrument)
-	protected.GET("/instruments/shares", ts.handleGetShares)
-	protected.GET("/instruments/bonds", ts.handleGetBonds)
-	protected.GET("/instruments/etfs", ts.handleGetETFs)
+	viewer.GET("/instruments/search", ts.handleSearchInstruments)
+	viewer.GET("/instruments/:figi", ts.handleGetInstrument)
+	viewer.GET("/instruments/shares", ts.handleGetShares)
+	viewer.GET("/instruments/bonds", ts.handleGetBonds)
+	viewer.GET("/instruments/etfs", ts.handleGetETFs)
+	viewer.GET("/instruments/futures", ts.handleGetFutures)
+	viewer.GET("/instruments/currencies", ts.handleGetCurrencies)
+	viewer.GET("/instruments/options", ts.handleGetOptions)
 	
 	// Маркетдата
-	protected.GET("/marketdata/candles", ts.handleGetCandles)
-	protected.GET("/marketdata/orderbook", ts.handleGetOrderBook)
-	protected.GET("/marketdata/last-prices", ts.handleGetLastPrices)
-	protected.GET("/marketdata/trading-status", ts.handleGetTradingStatus)
+	viewer.GET("/marketdata/candles", ts.handleGetCandles)
+	viewer.GET("/marketdata/orderbook", ts.handleGetOrderBook)
+	viewer.GET("/marketdata/last-prices", ts.handleGetLastPrices)
+	viewer.GET("/marketdata/trading-status", ts.handleGetTradingStatus)
 	
 	// Боты
-	protected.GET("/bots", ts.handleGetBots)
-	protected.POST("/bots", ts.handleCreateBot)
-	protected.GET("/bots/:id", ts.handleGetBot)
-	protected.PUT("/bots/:id", ts.handleUpdateBot)
-	protected.DELETE("/bots/:id", ts.handleDeleteBot)
-	protected.POST("/bots/:id/start", ts.handleStartBot)
-	protected.POST("/bots/:id/stop", ts.handleStopBot)
-	protected.POST("/bots/:id/pause", ts.handlePauseBot)
-	protected.POST("/bots/:id/resume", ts.handleResumeBot)
-	protected.GET("/bots/:id/stats", ts.handleGetBotStats)
+	viewer.GET("/bots/strategies", ts.handleGetStrategies)
+	viewer.GET("/bots", ts.handleGetBots)
+	operator.POST("/bots", ts.handleCreateBot)
+	viewer.GET("/bots/:id", ts.handleGetBot)
+	operator.PUT("/bots/:id", ts.handleUpdateBot)
+	operator.DELETE("/bots/:id", ts.handleDeleteBot)
+	operator.POST("/bots/:id/start", ts.handleStartBot)
+	operator.POST("/bots/:id/stop", ts.handleStopBot)
+	operator.POST("/bots/:id/pause", ts.handlePauseBot)
+	operator.POST("/bots/:id/resume", ts.handleResumeBot)
+	viewer.GET("/bots/:id/stats", ts.handleGetBotStats)
+	
+	// Бэктесты
+	protected.POST("/backtests", middleware.RequireRole(auth.RoleTrader, auth.RoleBotOperator), ts.handleCreateBacktest)
+	viewer.GET("/backtests", ts.handleGetBacktests)
+	viewer.GET("/backtests/:id", ts.handleGetBacktest)
 	
 	// WebSocket для стримов
-	protected.GET("/ws", ts.handleWebSocket)
+	viewer.GET("/ws", ts.handleWebSocket)
 	
 	// Административные маршруты
 	admin := ts.router.Group("/admin")
-	admin.Use(middleware.Auth([]string{"admin-api-key"}))
+	admin.Use(authenticate, rateLimit, middleware.RequireRole(auth.RoleAdmin))
 	admin.GET("/metrics", ts.handleMetrics)
 	admin.GET("/health", ts.handleHealthCheck)
 	admin.POST("/reload-config", ts.handleReloadConfig)
+	admin.GET("/kill-switch", ts.handleGetKillSwitch)
+	admin.POST("/kill-switch", ts.handleEngageKillSwitch)
+	admin.POST("/kill-switch/:account_id", ts.handleEngageKillSwitch)
+	admin.DELETE("/kill-switch", ts.handleRearmKillSwitch)
+	admin.DELETE("/kill-switch/:account_id", ts.handleRearmKillSwitch)
+	admin.GET("/reconciliation", ts.handleGetReconciliation)
+	admin.POST("/reconciliation", ts.handleRunReconciliation)
+	
+	// Пользователи и доступ к счетам
+	admin.GET("/users", ts.handleGetUsers)
+	admin.POST("/users", ts.handleCreateUser)
+	admin.PUT("/users/:id", ts.handleUpdateUser)
+	admin.PUT("/users/:id/accounts/:account_id", ts.handleGrantAccount)
+	admin.DELETE("/users/:id/accounts/:account_id", ts.handleRevokeAccount)
+	
+	// API ключи
+	admin.GET("/api-keys", ts.handleGetAPIKeys)
+	admin.POST("/api-keys", ts.handleCreateAPIKey)
+	admin.DELETE("/api-keys/:id", ts.handleRevokeAPIKey)
 }
 
 // HTTP обработчики
@@ -257,93 +645,413 @@ func (ts *TradingServer) handleGetAccounts(c *gin.Context) {
 	ts.mu.RLock()
 	defer ts.mu.RUnlock()
 	
+	accounts, err := ts.auth.FilterAccounts(c.Request.Context(), middleware.Principal(c), ts.accounts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	
 	c.JSON(http.StatusOK, gin.H{
-		"accounts": ts.accounts,
-		"count":    len(ts.accounts),
+		"accounts": accounts,
+		"count":    len(accounts),
 	})
 }
 
 func (ts *TradingServer) handleGetPortfolio(c *gin.Context) {
 	accountId := c.Param("id")
+	if !ts.requireAccount(c, accountId) {
+		return
+	}
 	
-	portfolioResp, err := ts.operationsService.GetPortfolio(accountId)
+	portfolio, err := ts.portfolioProvider.GetPortfolio(c.Request.Context(), accountId)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	
-	c.JSON(http.StatusOK, portfolioResp)
+	c.JSON(http.StatusOK, portfolio)
+}
+
+// orderBody - тело запроса на выставление заявки
+type orderBody struct {
+	AccountId     string           `json:"account_id" binding:"required"`
+	InstrumentId  string           `json:"instrument_id" binding:"required"`
+	Direction     string           `json:"direction"`
+	// OrderType - market, limit или bestprice; по умолчанию limit, если задана цена
+	OrderType     string           `json:"order_type"`
+	TimeInForce   string           `json:"time_in_force"`
+	Price         *decimal.Decimal `json:"price"`
+	// PriceType - currency (по умолчанию) или points
+	PriceType     string           `json:"price_type"`
+	Quantity      int64            `json:"quantity" binding:"required"`
+	// QuantityUnit - lots (по умолчанию) или shares
+	QuantityUnit  string           `json:"quantity_unit"`
+	ClientOrderId string           `json:"client_order_id"`
+	// AttachStops - выставить стоп-лосс и тейк-профит по настройкам риск-менеджмента
+	AttachStops   bool             `json:"attach_stops"`
+}
+
+// orderPayload - тело заявки для сравнения повторов по ключу идемпотентности
+type orderPayload struct {
+	broker.OrderRequest
+	AttachStops bool `json:",omitempty"`
+}
+
+// orderWithStops - ответ на заявку с присоединенными стоп-заявками
+type orderWithStops struct {
+	*pb.PostOrderResponse
+	StopOrders      []risk.AttachedStop `json:"stop_orders"`
+	StopOrdersError string              `json:"stop_orders_error,omitempty"`
+}
+
+func (ts *TradingServer) handleCreateOrder(c *gin.Context) {
+	var body orderBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ts.placeOrder(c, body)
 }
 
+// handleBuyOrder - совместимость с прежним API, то же что POST /orders с direction=buy
 func (ts *TradingServer) handleBuyOrder(c *gin.Context) {
-	var orderReq struct {
-		InstrumentId string  `json:"instrument_id" binding:"required"`
-		Quantity     int64   `json:"quantity" binding:"required"`
-		Price        *float64 `json:"price"`
-		AccountId    string  `json:"account_id" binding:"required"`
+	ts.postDirectedOrder(c, "buy")
+}
+
+// handleSellOrder - совместимость с прежним API, то же что POST /orders с direction=sell
+func (ts *TradingServer) handleSellOrder(c *gin.Context) {
+	ts.postDirectedOrder(c, "sell")
+}
+
+func (ts *TradingServer) postDirectedOrder(c *gin.Context, direction string) {
+	var body orderBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	body.Direction = direction
+	ts.placeOrder(c, body)
+}
+
+// placeOrder - выставление заявки из тела запроса.
+// С ключом идемпотентности повтор запроса возвращает первый ответ.
+func (ts *TradingServer) placeOrder(c *gin.Context, body orderBody) {
+	if !ts.requireAccount(c, body.AccountId) {
+		return
 	}
 	
-	if err := c.ShouldBindJSON(&orderReq); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	req, status, err := ts.orderRequest(c.Request.Context(), body)
+	if err != nil {
+		c.JSON(requestErrorResponse(status, err))
 		return
 	}
+	req.Origin = broker.OriginAPI
+	req.OriginID = middleware.Principal(c).Subject()
 	
-	orderType := pb.OrderType_ORDER_TYPE_MARKET
-	if orderReq.Price != nil {
-		orderType = pb.OrderType_ORDER_TYPE_LIMIT
+	key, ok := idempotencyKey(c, body.ClientOrderId)
+	if !ok {
+		return
+	}
+	if key == "" {
+		c.JSON(ts.submitOrder(c.Request.Context(), req, body.AttachStops))
+		return
 	}
 	
-	buyResp, err := ts.ordersService.Buy(&investgo.PostOrderRequestShort{
-		InstrumentId: orderReq.InstrumentId,
-		Quantity:     orderReq.Quantity,
-		Price:        orderReq.Price,
-		AccountId:    orderReq.AccountId,
-		OrderType:    orderType,
-		OrderId:      investgo.CreateUid(),
+	// Повтор получает тот же OrderId, и брокер не выставит заявку дважды
+	ts.idempotent(c, key, orderPayload{req, body.AttachStops}, func() (int, interface{}) {
+		req.OrderID = idempotency.OrderID(middleware.Principal(c).Subject(), key)
+		return ts.submitOrder(c.Request.Context(), req, body.AttachStops)
 	})
+}
+
+// orderRequest - заявка для брокера из тела запроса: количество переводится
+// в лоты, цена - в пункты котировки с округлением до шага цены.
+// Возвращает код ответа при ошибке, см. requestErrorResponse.
+func (ts *TradingServer) orderRequest(ctx context.Context, body orderBody) (broker.OrderRequest, int, error) {
+	direction, err := broker.ParseDirection(body.Direction)
+	if err != nil {
+		return broker.OrderRequest{}, http.StatusBadRequest, err
+	}
 	
+	orderTypeName := body.OrderType
+	if orderTypeName == "" {
+		orderTypeName = "market"
+		if body.Price != nil {
+			orderTypeName = "limit"
+		}
+	}
+	orderType, err := broker.ParseOrderType(orderTypeName)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return broker.OrderRequest{}, http.StatusBadRequest, err
+	}
+	switch {
+	case orderType == pb.OrderType_ORDER_TYPE_LIMIT && body.Price == nil:
+		return broker.OrderRequest{}, http.StatusBadRequest, errors.New("price is required for limit order")
+	case orderType != pb.OrderType_ORDER_TYPE_LIMIT && body.Price != nil:
+		return broker.OrderRequest{}, http.StatusBadRequest, fmt.Errorf("price is not allowed for %s order", orderTypeName)
+	}
+	
+	timeInForce, err := broker.ParseTimeInForce(body.TimeInForce)
+	if err != nil {
+		return broker.OrderRequest{}, http.StatusBadRequest, err
+	}
+	priceType, err := broker.ParsePriceType(body.PriceType)
+	if err != nil {
+		return broker.OrderRequest{}, http.StatusBadRequest, err
+	}
+	
+	inShares, err := quantityInShares(body.Quantity, body.QuantityUnit)
+	if err != nil {
+		return broker.OrderRequest{}, http.StatusBadRequest, err
+	}
+	
+	// Нарушения правил инструмента отклоняются до отправки брокеру
+	normalized, err := ts.orderRules.Normalize(ctx, catalog.Order{
+		InstrumentID: body.InstrumentId,
+		Direction:    direction,
+		OrderType:    orderType,
+		Quantity:     body.Quantity,
+		InShares:     inShares,
+		Prices:       map[string]*decimal.Decimal{"price": body.Price},
+		PriceType:    priceType,
+	})
+	if err != nil {
+		return broker.OrderRequest{}, http.StatusInternalServerError, err
 	}
 	
-	c.JSON(http.StatusOK, buyResp)
+	return broker.OrderRequest{
+		AccountID:    body.AccountId,
+		InstrumentID: body.InstrumentId,
+		Direction:    direction,
+		OrderType:    orderType,
+		Lots:         normalized.Lots,
+		Price:        normalized.Prices["price"],
+		TimeInForce:  timeInForce,
+	}, http.StatusOK, nil
 }
 
-func (ts *TradingServer) handleSellOrder(c *gin.Context) {
-	// Аналогично handleBuyOrder, но для продажи
-	var orderReq struct {
-		InstrumentId string  `json:"instrument_id" binding:"required"`
-		Quantity     int64   `json:"quantity" binding:"required"`
-		Price        *float64 `json:"price"`
-		AccountId    string  `json:"account_id" binding:"required"`
+// requestErrorResponse - ответ на ошибку разбора заявки: нарушения правил
+// инструмента возвращаются с кодом 422 и ошибками по полям
+func requestErrorResponse(status int, err error) (int, interface{}) {
+	var invalid *catalog.ValidationError
+	if errors.As(err, &invalid) {
+		return http.StatusUnprocessableEntity, gin.H{
+			"error":  invalid.Error(),
+			"fields": invalid.Fields,
+		}
+	}
+	return status, gin.H{"error": err.Error()}
+}
+
+// pointValue - делитель для перевода цены из запроса в пункты котировки:
+// стоимость пункта для цены в валюте, 1 для цены в пунктах.
+// Для акций пункт равен единице валюты. Возвращает код ответа при ошибке.
+func (ts *TradingServer) pointValue(ctx context.Context, instrumentID string, priceType pb.PriceType) (decimal.Decimal, int, error) {
+	if priceType == pb.PriceType_PRICE_TYPE_POINT {
+		return decimal.NewFromInt(1), http.StatusOK, nil
+	}
+	pointValue, err := ts.instruments.PointValue(ctx, instrumentID)
+	if err != nil {
+		return decimal.Zero, http.StatusInternalServerError, err
+	}
+	if !pointValue.IsPositive() {
+		return decimal.Zero, http.StatusUnprocessableEntity, fmt.Errorf("instrument %s has no point value", instrumentID)
+	}
+	return pointValue, http.StatusOK, nil
+}
+
+// quantityInShares - задано ли количество в штуках: unit lots (по умолчанию) или shares.
+// Перевод в лоты с проверкой кратности - в catalog.Rules.
+func quantityInShares(quantity int64, unit string) (bool, error) {
+	if quantity <= 0 {
+		return false, errors.New("quantity must be positive")
+	}
+	switch unit {
+	case "", "lots":
+		return false, nil
+	case "shares":
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown quantity unit %q, expected lots or shares", unit)
+}
+
+// handleReplaceOrder - изменение количества и цены активной заявки.
+// Брокер снимает заявку и выставляет новую, в ответе ее новый order_id.
+func (ts *TradingServer) handleReplaceOrder(c *gin.Context) {
+	orderID := c.Param("id")
+	var body struct {
+		AccountId     string           `json:"account_id" binding:"required"`
+		Quantity      int64            `json:"quantity" binding:"required"`
+		QuantityUnit  string           `json:"quantity_unit"`
+		Price         *decimal.Decimal `json:"price"`
+		PriceType     string           `json:"price_type"`
+		ClientOrderId string           `json:"client_order_id"`
 	}
 	
-	if err := c.ShouldBindJSON(&orderReq); err != nil {
+	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !ts.requireAccount(c, body.AccountId) {
+		return
+	}
 	
-	orderType := pb.OrderType_ORDER_TYPE_MARKET
-	if orderReq.Price != nil {
-		orderType = pb.OrderType_ORDER_TYPE_LIMIT
+	priceType, err := broker.ParsePriceType(body.PriceType)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 	
-	sellResp, err := ts.ordersService.Sell(&investgo.PostOrderRequestShort{
-		InstrumentId: orderReq.InstrumentId,
-		Quantity:     orderReq.Quantity,
-		Price:        orderReq.Price,
-		AccountId:    orderReq.AccountId,
-		OrderType:    orderType,
-		OrderId:      investgo.CreateUid(),
+	inShares, err := quantityInShares(body.Quantity, body.QuantityUnit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	// Новая цена приводится к шагу цены по инструменту и направлению заявки
+	state, err := ts.orderGateway.GetOrderState(c.Request.Context(), body.AccountId, orderID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	instrumentID := state.GetInstrumentUid()
+	if instrumentID == "" {
+		instrumentID = state.GetFigi()
+	}
+	normalized, err := ts.orderRules.Normalize(c.Request.Context(), catalog.Order{
+		InstrumentID: instrumentID,
+		Direction:    state.GetDirection(),
+		OrderType:    pb.OrderType_ORDER_TYPE_LIMIT,
+		Quantity:     body.Quantity,
+		InShares:     inShares,
+		Prices:       map[string]*decimal.Decimal{"price": body.Price},
+		PriceType:    priceType,
 	})
+	if err != nil {
+		c.JSON(requestErrorResponse(http.StatusInternalServerError, err))
+		return
+	}
+	
+	req := broker.ReplaceRequest{
+		AccountID: body.AccountId,
+		OrderID:   orderID,
+		Lots:      normalized.Lots,
+		Price:     normalized.Prices["price"],
+		PriceType: pb.PriceType_PRICE_TYPE_POINT,
+	}
+	
+	key, ok := idempotencyKey(c, body.ClientOrderId)
+	if !ok {
+		return
+	}
+	if key == "" {
+		c.JSON(ts.replaceOrder(c.Request.Context(), req))
+		return
+	}
+	
+	ts.idempotent(c, key, req, func() (int, interface{}) {
+		req.NewOrderID = idempotency.OrderID(middleware.Principal(c).Subject(), key)
+		return ts.replaceOrder(c.Request.Context(), req)
+	})
+}
+
+// replaceOrder - изменение заявки, возвращает код и тело ответа
+func (ts *TradingServer) replaceOrder(ctx context.Context, req broker.ReplaceRequest) (int, interface{}) {
+	orderResp, err := ts.orderGateway.ReplaceOrder(ctx, req)
+	if err != nil {
+		return orderErrorResponse(err)
+	}
+	return http.StatusOK, orderResp
+}
+
+// submitOrder - выставление заявки, возвращает код и тело ответа.
+// С attachStops на исполненную часть выставляются стоп-лосс и тейк-профит;
+// заявка уже выставлена, поэтому ошибка стоп-заявок возвращается в теле ответа.
+func (ts *TradingServer) submitOrder(ctx context.Context, req broker.OrderRequest, attachStops bool) (int, interface{}) {
+	orderResp, err := ts.orderGateway.PostOrder(ctx, req)
+	if err != nil {
+		return orderErrorResponse(err)
+	}
+	if !attachStops {
+		return http.StatusOK, orderResp
+	}
 	
+	result := orderWithStops{PostOrderResponse: orderResp}
+	result.StopOrders, err = ts.riskGateway.AttachStops(ctx, req, orderResp)
 	if err != nil {
+		result.StopOrdersError = err.Error()
+	}
+	return http.StatusOK, result
+}
+
+// idempotencyKey - ключ из заголовка Idempotency-Key или поля client_order_id;
+// пустой, если клиент его не передал
+func idempotencyKey(c *gin.Context, clientOrderID string) (string, bool) {
+	key := c.GetHeader("Idempotency-Key")
+	if key != "" && clientOrderID != "" && key != clientOrderID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key header and client_order_id differ"})
+		return "", false
+	}
+	if key == "" {
+		key = clientOrderID
+	}
+	return key, true
+}
+
+// idempotent - выполнение запроса с ключом идемпотентности. Повтор с тем же
+// телом получает сохраненный ответ, с другим телом - 409. Ответ 5xx
+// не сохраняется, чтобы запрос можно было повторить.
+func (ts *TradingServer) idempotent(c *gin.Context, key string, payload interface{}, handle func() (int, interface{})) {
+	ctx := c.Request.Context()
+	owner := middleware.Principal(c).Subject()
+	
+	record, err := ts.idempotency.Begin(ctx, owner, key, payload)
+	switch {
+	case errors.Is(err, idempotency.ErrPayloadMismatch), errors.Is(err, idempotency.ErrInProgress):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, idempotency.ErrInvalidKey):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
+	case record != nil:
+		c.Header("Idempotent-Replayed", "true")
+		c.Data(record.StatusCode, "application/json; charset=utf-8", record.Response)
+		return
 	}
 	
-	c.JSON(http.StatusOK, sellResp)
+	status, body := handle()
+	if status >= http.StatusInternalServerError {
+		if err := ts.idempotency.Release(ctx, owner, key); err != nil {
+			ts.logger.Warnf("Failed to release idempotency key %s: %v", key, err)
+		}
+	} else if err := ts.idempotency.Complete(ctx, owner, key, status, body); err != nil {
+		ts.logger.Warnf("Failed to save response for idempotency key %s: %v", key, err)
+	}
+	c.JSON(status, body)
+}
+
+// orderErrorResponse - код и тело ответа на ошибку выставления заявки,
+// отказ риск-движка отдается с кодом причины
+func orderErrorResponse(err error) (int, interface{}) {
+	var rejection *risk.RejectError
+	if errors.As(err, &rejection) {
+		return http.StatusUnprocessableEntity, gin.H{
+			"error": rejection.Message,
+			"code":  rejection.Code,
+		}
+	}
+	return http.StatusInternalServerError, gin.H{"error": err.Error()}
+}
+
+func (ts *TradingServer) handleGetRisk(c *gin.Context) {
+	accountId := c.Param("account_id")
+	if !ts.requireAccount(c, accountId) {
+		return
+	}
+	c.JSON(http.StatusOK, ts.riskGateway.Engine().Snapshot(accountId))
 }
 
 func (ts *TradingServer) handleSearchInstruments(c *gin.Context) {
@@ -353,13 +1061,13 @@ func (ts *TradingServer) handleSearchInstruments(c *gin.Context) {
 		return
 	}
 	
-	searchResp, err := ts.instrumentsService.FindInstrument(query)
+	instruments, err := ts.instruments.FindInstrument(c.Request.Context(), query)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	
-	c.JSON(http.StatusOK, searchResp)
+	c.JSON(http.StatusOK, gin.H{"instruments": instruments})
 }
 
 func (ts *TradingServer) handleStatus(c *gin.Context) {
@@ -370,41 +1078,34 @@ func (ts *TradingServer) handleStatus(c *gin.Context) {
 	})
 }
 
-// Заглушки для остальных обработчиков
-func (ts *TradingServer) handleGetPositions(c *gin.Context) {
-	accountId := c.Param("id")
-	positionsResp, err := ts.operationsService.GetPositions(accountId)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+// Обработчики для ботов
+func (ts *TradingServer) handleGetBots(c *gin.Context) {
+	principal := middleware.Principal(c)
+	
+	visible := make(map[string]bots.BotConfig)
+	for id, config := range ts.botManager.GetBots() {
+		allowed, err := ts.canAccessBot(c.Request.Context(), principal, config)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if allowed {
+			visible[id] = config
+		}
 	}
-	c.JSON(http.StatusOK, positionsResp)
-}
-
-func (ts *TradingServer) handleGetOrders(c *gin.Context) {
-	// Реализация получения ордеров
-	c.JSON(http.StatusOK, gin.H{"orders": []interface{}{}})
-}
-
-func (ts *TradingServer) handleGetInstrument(c *gin.Context) {
-	// Реализация получения инструмента по FIGI
-	c.JSON(http.StatusOK, gin.H{"instrument": nil})
+	c.JSON(http.StatusOK, gin.H{"bots": visible})
 }
 
-func (ts *TradingServer) handleGetCandles(c *gin.Context) {
-	// Реализация получения свечей
-	c.JSON(http.StatusOK, gin.H{"candles": []interface{}{}})
-}
-
-func (ts *TradingServer) handleGetOrderBook(c *gin.Context) {
-	// Реализация получения стакана
-	c.JSON(http.StatusOK, gin.H{"orderbook": nil})
-}
-
-// Обработчики для ботов
-func (ts *TradingServer) handleGetBots(c *gin.Context) {
-	bots := ts.botManager.GetBots()
-	c.JSON(http.StatusOK, gin.H{"bots": bots})
+func (ts *TradingServer) handleGetStrategies(c *gin.Context) {
+	strategies := make([]gin.H, 0)
+	for _, def := range bots.RegisteredStrategies() {
+		strategies = append(strategies, gin.H{
+			"type":        def.Name,
+			"description": def.Description,
+			"defaults":    def.NewConfig(),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"strategies": strategies})
 }
 
 func (ts *TradingServer) handleCreateBot(c *gin.Context) {
@@ -413,41 +1114,68 @@ func (ts *TradingServer) handleCreateBot(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	config.OwnerID = middleware.UserID(c)
+	// Счет бумажного бота пользователя виртуальный и принадлежит только ему
+	if !ownPaperAccount(config) && !ts.requireAccount(c, config.AccountID) {
+		return
+	}
 
 	botID, err := ts.botManager.CreateBot(config)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(botErrorResponse(err))
 		return
 	}
 
 	c.JSON(http.StatusCreated, gin.H{"bot_id": botID})
 }
 
+// ownPaperAccount - бумажный бот с владельцем торгует в собственном виртуальном счете
+func ownPaperAccount(config bots.BotConfig) bool {
+	return config.ExecutionMode == bots.ExecutionModePaper && config.OwnerID != ""
+}
+
+// botErrorResponse - ответ на ошибку создания или изменения бота:
+// недопустимая конфигурация - ошибка запроса, а не сервера
+func botErrorResponse(err error) (int, interface{}) {
+	var invalid *bots.ConfigError
+	if errors.As(err, &invalid) {
+		return http.StatusBadRequest, gin.H{"error": invalid.Error()}
+	}
+	return http.StatusInternalServerError, gin.H{"error": err.Error()}
+}
+
 func (ts *TradingServer) handleGetBot(c *gin.Context) {
 	botID := c.Param("id")
 	
-	allBots := ts.botManager.GetBots()
-	bot, exists := allBots[botID]
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Bot not found"})
+	bot, ok := ts.requireBot(c, botID)
+	if !ok {
 		return
 	}
 
-	c.JSON(http.StatusOK, bot)
+	c.JSON(http.StatusOK, bot.Config())
 }
 
 func (ts *TradingServer) handleUpdateBot(c *gin.Context) {
 	botID := c.Param("id")
+	bot, ok := ts.requireBot(c, botID)
+	if !ok {
+		return
+	}
 	
 	var config bots.BotConfig
 	if err := c.ShouldBindJSON(&config); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	// Владелец бота не меняется, бумажный счет остается в его пространстве
+	config.OwnerID = bot.Config().OwnerID
+	if !ownPaperAccount(config) && !ts.requireAccount(c, config.AccountID) {
+		return
+	}
 
 	err := ts.botManager.UpdateBotConfig(botID, config)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(botErrorResponse(err))
 		return
 	}
 
@@ -456,6 +1184,9 @@ func (ts *TradingServer) handleUpdateBot(c *gin.Context) {
 
 func (ts *TradingServer) handleDeleteBot(c *gin.Context) {
 	botID := c.Param("id")
+	if _, ok := ts.requireBot(c, botID); !ok {
+		return
+	}
 	
 	err := ts.botManager.DeleteBot(botID)
 	if err != nil {
@@ -468,6 +1199,16 @@ func (ts *TradingServer) handleDeleteBot(c *gin.Context) {
 
 func (ts *TradingServer) handleStartBot(c *gin.Context) {
 	botID := c.Param("id")
+	bot, ok := ts.requireBot(c, botID)
+	if !ok {
+		return
+	}
+	
+	// Пока действует аварийная блокировка, боты счета не запускаются
+	if halt, halted := ts.riskGateway.KillSwitch().Halted(bot.Config().AccountID); halted {
+		c.JSON(http.StatusConflict, gin.H{"error": "trading is halted: " + halt.Reason})
+		return
+	}
 	
 	err := ts.botManager.StartBot(botID)
 	if err != nil {
@@ -480,6 +1221,9 @@ func (ts *TradingServer) handleStartBot(c *gin.Context) {
 
 func (ts *TradingServer) handleStopBot(c *gin.Context) {
 	botID := c.Param("id")
+	if _, ok := ts.requireBot(c, botID); !ok {
+		return
+	}
 	
 	err := ts.botManager.StopBot(botID)
 	if err != nil {
@@ -493,9 +1237,8 @@ func (ts *TradingServer) handleStopBot(c *gin.Context) {
 func (ts *TradingServer) handlePauseBot(c *gin.Context) {
 	botID := c.Param("id")
 	
-	bot, exists := ts.botManager.GetBot(botID)
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Bot not found"})
+	bot, ok := ts.requireBot(c, botID)
+	if !ok {
 		return
 	}
 
@@ -511,9 +1254,8 @@ func (ts *TradingServer) handlePauseBot(c *gin.Context) {
 func (ts *TradingServer) handleResumeBot(c *gin.Context) {
 	botID := c.Param("id")
 	
-	bot, exists := ts.botManager.GetBot(botID)
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Bot not found"})
+	bot, ok := ts.requireBot(c, botID)
+	if !ok {
 		return
 	}
 
@@ -528,6 +1270,9 @@ func (ts *TradingServer) handleResumeBot(c *gin.Context) {
 
 func (ts *TradingServer) handleGetBotStats(c *gin.Context) {
 	botID := c.Param("id")
+	if _, ok := ts.requireBot(c, botID); !ok {
+		return
+	}
 	
 	stats, err := ts.botManager.GetBotStats(botID)
 	if err != nil {
@@ -538,163 +1283,584 @@ func (ts *TradingServer) handleGetBotStats(c *gin.Context) {
 	c.JSON(http.StatusOK, stats)
 }
 
+// Обработчики для бэктестов
+func (ts *TradingServer) handleCreateBacktest(c *gin.Context) {
+	var req backtest.Request
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	job, err := ts.backtests.Submit(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"job_id": job.ID,
+		"status": job.Status,
+	})
+}
+
+func (ts *TradingServer) handleGetBacktests(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"backtests": ts.backtests.List()})
+}
+
+func (ts *TradingServer) handleGetBacktest(c *gin.Context) {
+	jobID := c.Param("id")
+	
+	job, exists := ts.backtests.Get(jobID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Backtest not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
 func (ts *TradingServer) handleWebSocket(c *gin.Context) {
 	websocket.WebSocketHandler(ts.wsHub, ts)(c)
 }
 
-// Заглушки для остальных обработчиков
+// Обработчики счетов, ордеров, инструментов и маркетдаты
 func (ts *TradingServer) handleGetPositions(c *gin.Context) {
 	accountId := c.Param("id")
-	positionsResp, err := ts.operationsService.GetPositions(accountId)
+	if !ts.requireAccount(c, accountId) {
+		return
+	}
+	
+	positions, err := ts.portfolioProvider.GetPositions(c.Request.Context(), accountId)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, positionsResp)
+	c.JSON(http.StatusOK, positions)
 }
 
 func (ts *TradingServer) handleGetOperations(c *gin.Context) {
 	accountId := c.Param("id")
+	if !ts.requireAccount(c, accountId) {
+		return
+	}
 	
 	// Получаем операции за последние 30 дней
 	to := time.Now()
 	from := to.AddDate(0, 0, -30)
 	
-	operationsResp, err := ts.operationsService.GetOperations(&investgo.OperationsRequest{
-		AccountId: accountId,
-		From:      from,
-		To:        to,
+	operations, err := ts.portfolioProvider.GetOperations(c.Request.Context(), accountId, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"operations": operations})
+}
+
+func (ts *TradingServer) handleGetOrders(c *gin.Context) {
+	accountId := c.Query("account_id")
+	if accountId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "account_id parameter required"})
+		return
+	}
+	if !ts.requireAccount(c, accountId) {
+		return
+	}
+	
+	orders, err := ts.orderGateway.GetOrders(c.Request.Context(), accountId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"orders":           orders,
+		"synthetic_orders": ts.syntheticOrders.Active(accountId),
+	})
+}
+
+// handleGetOrderHistory - журнал заявок счета, выставленных через сервер, с событиями
+// жизненного цикла. Фильтры: status (текущий: submitted, partially_filled, filled,
+// cancelled, rejected), instrument_id, origin, origin_id, bot_id, from и to в RFC 3339.
+func (ts *TradingServer) handleGetOrderHistory(c *gin.Context) {
+	accountId := c.Query("account_id")
+	if accountId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "account_id parameter required"})
+		return
+	}
+	if !ts.requireAccount(c, accountId) {
+		return
+	}
+	
+	filter := storage.OrderFilter{
+		AccountID:    accountId,
+		BotID:        c.Query("bot_id"),
+		InstrumentID: c.Query("instrument_id"),
+		Origin:       c.Query("origin"),
+		OriginID:     c.Query("origin_id"),
+	}
+	for _, event := range c.QueryArray("status") {
+		status := storage.OrderStatusForEvent(event)
+		if status == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown status %q, expected submitted, partially_filled, filled, cancelled or rejected", event)})
+			return
+		}
+		filter.Statuses = append(filter.Statuses, status)
+	}
+	
+	var err error
+	if filter.From, err = queryTime(c, "from"); err == nil {
+		filter.To, err = queryTime(c, "to")
+	}
+	if err == nil {
+		filter.Limit, err = queryInt(c, "limit", 50, 1, 500)
+	}
+	if err == nil {
+		filter.Offset, err = queryInt(c, "offset", 0, 0, math.MaxInt32)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	// Лишняя заявка показывает, есть ли следующая страница
+	limit := filter.Limit
+	filter.Limit++
+	orders, err := ts.orderJournal.History(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	hasMore := len(orders) > limit
+	if hasMore {
+		orders = orders[:limit]
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"orders":   orders,
+		"limit":    limit,
+		"offset":   filter.Offset,
+		"has_more": hasMore,
+	})
+}
+
+// queryTime - время из параметра запроса в RFC 3339, нулевое если параметра нет
+func queryTime(c *gin.Context, name string) (time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s, expected RFC 3339 time: %w", name, err)
+	}
+	return t, nil
+}
+
+// queryInt - целое из параметра запроса от low до high, def если параметра нет
+func queryInt(c *gin.Context, name string, def, low, high int) (int, error) {
+	value := c.Query(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < low || n > high {
+		return 0, fmt.Errorf("%s must be an integer from %d to %d", name, low, high)
+	}
+	return n, nil
+}
+
+func (ts *TradingServer) handleGetOrder(c *gin.Context) {
+	orderID := c.Param("id")
+	accountId := c.Query("account_id")
+	
+	if accountId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "account_id parameter required"})
+		return
+	}
+	if !ts.requireAccount(c, accountId) {
+		return
+	}
+	
+	orderState, err := ts.orderGateway.GetOrderState(c.Request.Context(), accountId, orderID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, orderState)
+}
+
+func (ts *TradingServer) handleCancelOrder(c *gin.Context) {
+	orderID := c.Param("id")
+	accountId := c.Query("account_id")
+	
+	if accountId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "account_id parameter required"})
+		return
+	}
+	if !ts.requireAccount(c, accountId) {
+		return
+	}
+	
+	cancelResp, err := ts.orderGateway.CancelOrder(c.Request.Context(), accountId, orderID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, cancelResp)
+}
+
+// stopOrderBody - тело запроса на выставление стоп-заявки
+type stopOrderBody struct {
+	AccountId    string           `json:"account_id" binding:"required"`
+	InstrumentId string           `json:"instrument_id" binding:"required"`
+	Direction    string           `json:"direction" binding:"required"`
+	// Type - stop_loss, take_profit или stop_limit
+	Type         string           `json:"type" binding:"required"`
+	Quantity     int64            `json:"quantity" binding:"required"`
+	QuantityUnit string           `json:"quantity_unit"`
+	StopPrice    *decimal.Decimal `json:"stop_price" binding:"required"`
+	// Price - цена лимитной заявки для stop_limit
+	Price        *decimal.Decimal `json:"price"`
+	PriceType    string           `json:"price_type"`
+	// Expiration - gtc (по умолчанию) или gtd с датой expire_date
+	Expiration   string           `json:"expiration"`
+	ExpireDate   *time.Time       `json:"expire_date"`
+}
+
+func (ts *TradingServer) handlePostStopOrder(c *gin.Context) {
+	var body stopOrderBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !ts.requireAccount(c, body.AccountId) {
+		return
+	}
+	
+	req, status, err := ts.stopOrderRequest(c.Request.Context(), body)
+	if err != nil {
+		c.JSON(requestErrorResponse(status, err))
+		return
+	}
+	
+	stopResp, err := ts.stopOrders.PostStopOrder(c.Request.Context(), req)
+	if err != nil {
+		c.JSON(orderErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, stopResp)
+}
+
+// stopOrderRequest - стоп-заявка для брокера из тела запроса: количество
+// переводится в лоты, цены - в пункты котировки с округлением до шага цены.
+// Возвращает код ответа при ошибке, см. requestErrorResponse.
+func (ts *TradingServer) stopOrderRequest(ctx context.Context, body stopOrderBody) (broker.StopOrderRequest, int, error) {
+	direction, err := broker.ParseDirection(body.Direction)
+	if err != nil {
+		return broker.StopOrderRequest{}, http.StatusBadRequest, err
+	}
+	stopType, err := broker.ParseStopOrderType(body.Type)
+	if err != nil {
+		return broker.StopOrderRequest{}, http.StatusBadRequest, err
+	}
+	switch {
+	case stopType == pb.StopOrderType_STOP_ORDER_TYPE_STOP_LIMIT && body.Price == nil:
+		return broker.StopOrderRequest{}, http.StatusBadRequest, errors.New("price is required for stop_limit order")
+	case stopType != pb.StopOrderType_STOP_ORDER_TYPE_STOP_LIMIT && body.Price != nil:
+		return broker.StopOrderRequest{}, http.StatusBadRequest, fmt.Errorf("price is not allowed for %s order", body.Type)
+	case !body.StopPrice.IsPositive():
+		return broker.StopOrderRequest{}, http.StatusBadRequest, errors.New("stop_price must be positive")
+	}
+	
+	expirationType, err := broker.ParseStopExpiration(body.Expiration)
+	if err != nil {
+		return broker.StopOrderRequest{}, http.StatusBadRequest, err
+	}
+	req := broker.StopOrderRequest{
+		AccountID:      body.AccountId,
+		InstrumentID:   body.InstrumentId,
+		Direction:      broker.StopDirection(direction),
+		Type:           stopType,
+		ExpirationType: expirationType,
+	}
+	if expirationType == pb.StopOrderExpirationType_STOP_ORDER_EXPIRATION_TYPE_GOOD_TILL_DATE {
+		if body.ExpireDate == nil || !body.ExpireDate.After(time.Now()) {
+			return broker.StopOrderRequest{}, http.StatusBadRequest, errors.New("expire_date in the future is required for gtd expiration")
+		}
+		req.ExpireDate = body.ExpireDate.UTC()
+	} else if body.ExpireDate != nil {
+		return broker.StopOrderRequest{}, http.StatusBadRequest, errors.New("expire_date is only allowed for gtd expiration")
+	}
+	
+	priceType, err := broker.ParsePriceType(body.PriceType)
+	if err != nil {
+		return broker.StopOrderRequest{}, http.StatusBadRequest, err
+	}
+	inShares, err := quantityInShares(body.Quantity, body.QuantityUnit)
+	if err != nil {
+		return broker.StopOrderRequest{}, http.StatusBadRequest, err
+	}
+	
+	// Стоп-заявки принимаются и вне торговой сессии, режим торгов не проверяется
+	normalized, err := ts.orderRules.Normalize(ctx, catalog.Order{
+		InstrumentID: body.InstrumentId,
+		Direction:    direction,
+		Quantity:     body.Quantity,
+		InShares:     inShares,
+		Prices:       map[string]*decimal.Decimal{"stop_price": body.StopPrice, "price": body.Price},
+		PriceType:    priceType,
 	})
+	if err != nil {
+		return broker.StopOrderRequest{}, http.StatusInternalServerError, err
+	}
+	req.Lots = normalized.Lots
+	req.StopPrice = *normalized.Prices["stop_price"]
+	req.Price = normalized.Prices["price"]
+	return req, http.StatusOK, nil
+}
+
+func (ts *TradingServer) handleGetStopOrders(c *gin.Context) {
+	accountId := c.Query("account_id")
+	
+	if accountId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "account_id parameter required"})
+		return
+	}
+	if !ts.requireAccount(c, accountId) {
+		return
+	}
+	
+	stopOrders, err := ts.stopOrders.GetStopOrders(c.Request.Context(), accountId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"stop_orders": stopOrders})
+}
+
+func (ts *TradingServer) handleCancelStopOrder(c *gin.Context) {
+	stopOrderID := c.Param("id")
+	accountId := c.Query("account_id")
+	
+	if accountId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "account_id parameter required"})
+		return
+	}
+	if !ts.requireAccount(c, accountId) {
+		return
+	}
+	
+	cancelResp, err := ts.stopOrders.CancelStopOrder(c.Request.Context(), accountId, stopOrderID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, operationsResp)
+	c.JSON(http.StatusOK, cancelResp)
+}
+
+// syntheticOrderBody - тело запроса на создание синтетической заявки
+type syntheticOrderBody struct {
+	// Kind - oco, bracket или trailing_stop
+	Kind         string           `json:"kind" binding:"required"`
+	AccountId    string           `json:"account_id" binding:"required"`
+	InstrumentId string           `json:"instrument_id" binding:"required"`
+	// Direction - направление входа для bracket, выхода для oco и trailing_stop
+	Direction    string           `json:"direction" binding:"required"`
+	Quantity     int64            `json:"quantity" binding:"required"`
+	QuantityUnit string           `json:"quantity_unit"`
+	PriceType    string           `json:"price_type"`
+	// EntryPrice - цена входа bracket, без нее вход рыночный
+	EntryPrice   *decimal.Decimal `json:"entry_price"`
+	TakeProfit   *decimal.Decimal `json:"take_profit"`
+	StopLoss     *decimal.Decimal `json:"stop_loss"`
+	TrailPercent decimal.Decimal  `json:"trail_percent"`
+	// TrailStep - отступ трейлинг-стопа в единицах цены
+	TrailStep    decimal.Decimal  `json:"trail_step"`
+}
+
+func (ts *TradingServer) handleCreateSyntheticOrder(c *gin.Context) {
+	var body syntheticOrderBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !ts.requireAccount(c, body.AccountId) {
+		return
+	}
+	
+	direction, err := broker.ParseDirection(body.Direction)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	priceType, err := broker.ParsePriceType(body.PriceType)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	inShares, err := quantityInShares(body.Quantity, body.QuantityUnit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	// Ноги выставляются позже сервером, режим торгов проверяется при выставлении
+	normalized, err := ts.orderRules.Normalize(c.Request.Context(), catalog.Order{
+		InstrumentID: body.InstrumentId,
+		Direction:    direction,
+		Quantity:     body.Quantity,
+		InShares:     inShares,
+		Prices: map[string]*decimal.Decimal{
+			"entry_price": body.EntryPrice,
+			"take_profit": body.TakeProfit,
+			"stop_loss":   body.StopLoss,
+		},
+		PriceType: priceType,
+	})
+	if err != nil {
+		c.JSON(requestErrorResponse(http.StatusInternalServerError, err))
+		return
+	}
+	pointValue, status, err := ts.pointValue(c.Request.Context(), body.InstrumentId, priceType)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	
+	order, err := ts.syntheticOrders.Create(c.Request.Context(), synthetic.Spec{
+		Kind:         synthetic.Kind(body.Kind),
+		AccountID:    body.AccountId,
+		InstrumentID: body.InstrumentId,
+		Direction:    direction,
+		Lots:         normalized.Lots,
+		EntryPrice:   normalized.Prices["entry_price"],
+		TakeProfit:   normalized.Prices["take_profit"],
+		StopLoss:     normalized.Prices["stop_loss"],
+		TrailPercent: body.TrailPercent,
+		TrailStep:    body.TrailStep.Div(pointValue),
+	})
+	var invalid *synthetic.SpecError
+	switch {
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(orderErrorResponse(err))
+		return
+	}
+	c.JSON(http.StatusCreated, order)
 }
 
-func (ts *TradingServer) handleGetOrders(c *gin.Context) {
+func (ts *TradingServer) handleCancelSyntheticOrder(c *gin.Context) {
+	orderID := c.Param("id")
 	accountId := c.Query("account_id")
+	
 	if accountId == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "account_id parameter required"})
 		return
 	}
+	if !ts.requireAccount(c, accountId) {
+		return
+	}
 	
-	ordersResp, err := ts.ordersService.GetOrders(accountId)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	order, err := ts.syntheticOrders.Cancel(c.Request.Context(), accountId, orderID)
+	if errors.Is(err, synthetic.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, ordersResp)
+	c.JSON(http.StatusOK, order)
 }
 
-func (ts *TradingServer) handleGetOrder(c *gin.Context) {
-	orderID := c.Param("id")
-	accountId := c.Query("account_id")
+// handleGetInstrument - инструмент справочника по FIGI, UID или ISIN;
+// с параметром class_code в пути передается тикер
+func (ts *TradingServer) handleGetInstrument(c *gin.Context) {
+	id := c.Param("figi")
 	
-	if accountId == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "account_id parameter required"})
+	instrument, err := ts.catalog.Lookup(c.Request.Context(), id, c.Query("class_code"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
-	
-	orderResp, err := ts.ordersService.GetOrderState(&investgo.GetOrderStateRequest{
-		AccountId: accountId,
-		OrderId:   orderID,
-	})
+	c.JSON(http.StatusOK, gin.H{"instrument": instrument})
+}
+
+func (ts *TradingServer) handleGetShares(c *gin.Context) {
+	shares, err := ts.instruments.Shares(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, orderResp)
+	c.JSON(http.StatusOK, gin.H{"instruments": shares})
 }
 
-func (ts *TradingServer) handleCancelOrder(c *gin.Context) {
-	orderID := c.Param("id")
-	accountId := c.Query("account_id")
-	
-	if accountId == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "account_id parameter required"})
+func (ts *TradingServer) handleGetBonds(c *gin.Context) {
+	bonds, err := ts.instruments.Bonds(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	
-	cancelResp, err := ts.ordersService.CancelOrder(&investgo.CancelOrderRequest{
-		AccountId: accountId,
-		OrderId:   orderID,
-	})
+	c.JSON(http.StatusOK, gin.H{"instruments": bonds})
+}
+
+func (ts *TradingServer) handleGetETFs(c *gin.Context) {
+	etfs, err := ts.instruments.Etfs(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, cancelResp)
+	c.JSON(http.StatusOK, gin.H{"instruments": etfs})
 }
 
-func (ts *TradingServer) handleGetShares(c *gin.Context) {
-	sharesResp, err := ts.instrumentsService.Shares(pb.InstrumentStatus_INSTRUMENT_STATUS_BASE)
+func (ts *TradingServer) handleGetFutures(c *gin.Context) {
+	futures, err := ts.instruments.Futures(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, sharesResp)
+	c.JSON(http.StatusOK, gin.H{"instruments": futures})
 }
 
-func (ts *TradingServer) handleGetBonds(c *gin.Context) {
-	bondsResp, err := ts.instrumentsService.Bonds(pb.InstrumentStatus_INSTRUMENT_STATUS_BASE)
+func (ts *TradingServer) handleGetCurrencies(c *gin.Context) {
+	currencies, err := ts.instruments.Currencies(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, bondsResp)
+	c.JSON(http.StatusOK, gin.H{"instruments": currencies})
 }
 
-func (ts *TradingServer) handleGetETFs(c *gin.Context) {
-	etfsResp, err := ts.instrumentsService.Etfs(pb.InstrumentStatus_INSTRUMENT_STATUS_BASE)
+func (ts *TradingServer) handleGetOptions(c *gin.Context) {
+	options, err := ts.instruments.Options(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, etfsResp)
+	c.JSON(http.StatusOK, gin.H{"instruments": options})
 }
 
 func (ts *TradingServer) handleGetCandles(c *gin.Context) {
 	figi := c.Query("figi")
-	interval := c.Query("interval")
+	interval := c.DefaultQuery("interval", "day")
 	
 	if figi == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "figi parameter required"})
 		return
 	}
 	
-	// Преобразуем строку интервала в enum
-	var candleInterval pb.CandleInterval
-	switch interval {
-	case "1min":
-		candleInterval = pb.CandleInterval_CANDLE_INTERVAL_1_MIN
-	case "5min":
-		candleInterval = pb.CandleInterval_CANDLE_INTERVAL_5_MIN
-	case "15min":
-		candleInterval = pb.CandleInterval_CANDLE_INTERVAL_15_MIN
-	case "hour":
-		candleInterval = pb.CandleInterval_CANDLE_INTERVAL_HOUR
-	case "day":
-		candleInterval = pb.CandleInterval_CANDLE_INTERVAL_DAY
-	default:
-		candleInterval = pb.CandleInterval_CANDLE_INTERVAL_DAY
+	candleInterval, _, err := backtest.ParseCandleInterval(interval)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 	
 	// Получаем свечи за последние 30 дней
 	to := time.Now()
 	from := to.AddDate(0, 0, -30)
 	
-	candlesResp, err := ts.marketDataService.GetCandles(&investgo.GetCandlesRequest{
-		InstrumentId: figi,
-		From:         from,
-		To:           to,
-		Interval:     candleInterval,
-	})
+	candles, err := ts.marketData.GetCandles(c.Request.Context(), figi, candleInterval, from, to)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, candlesResp)
+	c.JSON(http.StatusOK, gin.H{"candles": candles})
 }
 
 func (ts *TradingServer) handleGetOrderBook(c *gin.Context) {
@@ -713,15 +1879,12 @@ func (ts *TradingServer) handleGetOrderBook(c *gin.Context) {
 		}
 	}
 	
-	orderBookResp, err := ts.marketDataService.GetOrderBook(&investgo.GetOrderBookRequest{
-		InstrumentId: figi,
-		Depth:        depthInt,
-	})
+	orderBook, err := ts.marketData.GetOrderBook(c.Request.Context(), figi, depthInt)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, orderBookResp)
+	c.JSON(http.StatusOK, gin.H{"orderbook": orderBook})
 }
 
 func (ts *TradingServer) handleGetLastPrices(c *gin.Context) {
@@ -732,12 +1895,12 @@ func (ts *TradingServer) handleGetLastPrices(c *gin.Context) {
 		return
 	}
 	
-	lastPricesResp, err := ts.marketDataService.GetLastPrices(figis)
+	lastPrices, err := ts.marketData.GetLastPrices(c.Request.Context(), figis)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, lastPricesResp)
+	c.JSON(http.StatusOK, gin.H{"last_prices": lastPrices})
 }
 
 func (ts *TradingServer) handleGetTradingStatus(c *gin.Context) {
@@ -748,14 +1911,12 @@ func (ts *TradingServer) handleGetTradingStatus(c *gin.Context) {
 		return
 	}
 	
-	tradingStatusResp, err := ts.marketDataService.GetTradingStatus(&investgo.GetTradingStatusRequest{
-		InstrumentId: figi,
-	})
+	tradingStatus, err := ts.marketData.GetTradingStatus(c.Request.Context(), figi)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, tradingStatusResp)
+	c.JSON(http.StatusOK, tradingStatus)
 }
 
 func (ts *TradingServer) handleMetrics(c *gin.Context) {
@@ -766,6 +1927,10 @@ func (ts *TradingServer) handleMetrics(c *gin.Context) {
 		"bots_count":        len(ts.botManager.GetBots()),
 		"active_bots_count": ts.countActiveBots(),
 		"memory_usage":      "unknown", // Можно добавить runtime.MemStats
+		"stream_reconnects": ts.streamMonitor.TotalReconnects(),
+		"streams":           ts.streamMonitor.Stats(),
+		"broker_api_queue":  ts.brokerGovernor.QueueDepth(),
+		"broker_api":        ts.brokerGovernor.Stats(),
 	}
 	c.JSON(http.StatusOK, metrics)
 }
@@ -788,22 +1953,326 @@ func (ts *TradingServer) handleReloadConfig(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Config reloaded successfully"})
 }
 
-func (ts *TradingServer) handleLogin(c *gin.Context) {
-	// Простая аутентификация для демо
+func (ts *TradingServer) handleGetKillSwitch(c *gin.Context) {
+	c.JSON(http.StatusOK, ts.riskGateway.KillSwitch().State())
+}
+
+// handleEngageKillSwitch - блокировка заявок, остановка ботов и отмена заявок
+// всех счетов или счета из пути; flatten закрывает позиции рыночными заявками
+func (ts *TradingServer) handleEngageKillSwitch(c *gin.Context) {
+	var req struct {
+		Reason  string `json:"reason"`
+		Flatten bool   `json:"flatten"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Reason == "" {
+		req.Reason = "manual"
+	}
+	
+	accountId := c.Param("account_id")
+	accounts := []string{accountId}
+	if accountId == "" {
+		ts.mu.RLock()
+		accounts = append([]string(nil), ts.accounts...)
+		ts.mu.RUnlock()
+	}
+	
+	// Сначала блокируем заявки, чтобы остановленные боты и клиенты не выставили новые
+	if err := ts.riskGateway.KillSwitch().Engage(accountId, req.Reason); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ts.logger.Warnf("Kill switch engaged (account=%q): %s", accountId, req.Reason)
+	
+	stoppedBots := ts.botManager.StopBots(accountId)
+	reports := make([]risk.LiquidationReport, 0, len(accounts))
+	for _, account := range accounts {
+		reports = append(reports, ts.riskGateway.Liquidate(c.Request.Context(), account, req.Flatten))
+	}
+	
+	state := ts.riskGateway.KillSwitch().State()
+	ts.broadcastKillSwitch("engaged", state)
+	
 	c.JSON(http.StatusOK, gin.H{
-		"token": "demo-token",
-		"message": "Login successful",
+		"state":        state,
+		"stopped_bots": stoppedBots,
+		"accounts":     reports,
+	})
+}
+
+func (ts *TradingServer) handleRearmKillSwitch(c *gin.Context) {
+	accountId := c.Param("account_id")
+	
+	if err := ts.riskGateway.KillSwitch().Rearm(accountId); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ts.logger.Warnf("Kill switch re-armed (account=%q)", accountId)
+	
+	state := ts.riskGateway.KillSwitch().State()
+	ts.broadcastKillSwitch("rearmed", state)
+	c.JSON(http.StatusOK, gin.H{"state": state})
+}
+
+// broadcastKillSwitch - уведомление WebSocket клиентов о смене состояния блокировки
+func (ts *TradingServer) broadcastKillSwitch(action string, state risk.KillSwitchState) {
+	ts.wsHub.Broadcast(websocket.Message{
+		Type:      "kill_switch",
+		Action:    action,
+		Data:      state,
+		Timestamp: time.Now().Unix(),
+	})
+}
+
+// handleGetReconciliation - расхождения последней сверки с брокером
+func (ts *TradingServer) handleGetReconciliation(c *gin.Context) {
+	c.JSON(http.StatusOK, ts.reconciler.Report())
+}
+
+// handleRunReconciliation - внеочередная сверка с брокером. Расхождение
+// подтверждается повторной сверкой, поэтому новое появляется в отчете со второго запуска
+func (ts *TradingServer) handleRunReconciliation(c *gin.Context) {
+	c.JSON(http.StatusOK, ts.reconciler.Check(c.Request.Context(), ts.accounts))
+}
+
+// broadcastReconciliation - уведомление клиентов о расхождениях с брокером
+func (ts *TradingServer) broadcastReconciliation(event reconcile.Event) {
+	ts.wsHub.Broadcast(websocket.Message{
+		Type:      "reconciliation",
+		Action:    event.Action,
+		Data:      event.Discrepancy,
+		Timestamp: time.Now().Unix(),
+	})
+}
+
+// broadcastSyntheticAlert - уведомление клиентов об отклоненной заявке стоп-ноги
+func (ts *TradingServer) broadcastSyntheticAlert(alert synthetic.Alert) {
+	ts.wsHub.Broadcast(websocket.Message{
+		Type:      "synthetic_order",
+		Action:    "stop_rejected",
+		Data:      alert,
+		Timestamp: time.Now().Unix(),
+	})
+}
+
+// broadcastStreamStatus - уведомление клиентов о разрыве и восстановлении стримов
+func (ts *TradingServer) broadcastStreamStatus(status streams.Status) {
+	ts.wsHub.Broadcast(websocket.Message{
+		Type:      "stream_status",
+		Action:    string(status.State),
+		Data:      status,
+		Timestamp: time.Now().Unix(),
 	})
 }
 
+// Обработчики аутентификации
 func (ts *TradingServer) handleLogin(c *gin.Context) {
-	// Простая аутентификация для демо
-	c.JSON(http.StatusOK, gin.H{
-		"token": "demo-token",
-		"message": "Login successful",
+	var req struct {
+		Username string `json:"username" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	tokens, err := ts.auth.Login(c.Request.Context(), req.Username, req.Password)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ts.logger.Errorf("Login failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "login failed"})
+		return
+	}
+	
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (ts *TradingServer) handleRefresh(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	tokens, err := ts.auth.Refresh(c.Request.Context(), req.RefreshToken)
+	if errors.Is(err, auth.ErrInvalidToken) || errors.Is(err, auth.ErrTokenRevoked) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ts.logger.Errorf("Token refresh failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "token refresh failed"})
+		return
+	}
+	
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (ts *TradingServer) handleLogout(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	
+	err := ts.auth.Logout(c.Request.Context(), middleware.Claims(c), req.RefreshToken)
+	if errors.Is(err, auth.ErrInvalidToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ts.logger.Errorf("Logout failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "logout failed"})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// Обработчики пользователей
+func (ts *TradingServer) handleGetUsers(c *gin.Context) {
+	users, err := ts.auth.ListUsers(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"users": users})
+}
+
+func (ts *TradingServer) handleCreateUser(c *gin.Context) {
+	var req struct {
+		Username string   `json:"username" binding:"required"`
+		Password string   `json:"password" binding:"required"`
+		Roles    []string `json:"roles" binding:"required,min=1"`
+		Accounts []string `json:"accounts"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	user, err := ts.auth.CreateUser(c.Request.Context(), req.Username, req.Password, req.Roles)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, accountID := range req.Accounts {
+		if err := ts.auth.GrantAccount(c.Request.Context(), user.ID, accountID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	
+	ts.logger.Infof("User %s created by %s with roles %v", user.Username, middleware.Principal(c).Name(), user.Roles)
+	c.JSON(http.StatusCreated, user)
+}
+
+func (ts *TradingServer) handleUpdateUser(c *gin.Context) {
+	var req struct {
+		Roles    []string `json:"roles" binding:"required,min=1"`
+		Disabled bool     `json:"disabled"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	user, err := ts.auth.UpdateUser(c.Request.Context(), c.Param("id"), req.Roles, req.Disabled)
+	if err != nil {
+		ts.respondUserError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+func (ts *TradingServer) handleGrantAccount(c *gin.Context) {
+	if err := ts.auth.GrantAccount(c.Request.Context(), c.Param("id"), c.Param("account_id")); err != nil {
+		ts.respondUserError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Account access granted"})
+}
+
+func (ts *TradingServer) handleRevokeAccount(c *gin.Context) {
+	if err := ts.auth.RevokeAccount(c.Request.Context(), c.Param("id"), c.Param("account_id")); err != nil {
+		ts.respondUserError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Account access revoked"})
+}
+
+// Обработчики API ключей
+func (ts *TradingServer) handleGetAPIKeys(c *gin.Context) {
+	keys, err := ts.auth.ListAPIKeys(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+func (ts *TradingServer) handleCreateAPIKey(c *gin.Context) {
+	var req auth.NewAPIKey
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	principal := middleware.Principal(c)
+	secret, key, err := ts.auth.CreateAPIKey(c.Request.Context(), req, principal.Name())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	ts.logger.Infof("API key %s (%s) created by %s with scopes %v", key.ID, key.Name, principal.Name(), key.Scopes)
+	// Секрет показывается только при создании
+	c.JSON(http.StatusCreated, gin.H{
+		"key":     secret,
+		"api_key": key,
 	})
 }
 
+func (ts *TradingServer) handleRevokeAPIKey(c *gin.Context) {
+	keyID := c.Param("id")
+	
+	err := ts.auth.RevokeAPIKey(c.Request.Context(), keyID)
+	if errors.Is(err, auth.ErrAPIKeyNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	
+	ts.logger.Infof("API key %s revoked by %s", keyID, middleware.Principal(c).Name())
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
+
+// respondUserError - ответ на ошибку изменения пользователя
+func (ts *TradingServer) respondUserError(c *gin.Context, err error) {
+	if errors.Is(err, auth.ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
 // Вспомогательные функции
 func (ts *TradingServer) countActiveBots() int {
 	count := 0
@@ -813,12 +2282,6 @@ func (ts *TradingServer) countActiveBots() int {
 		}
 	}
 	return count
-} range ts.botManager.GetBots() {
-		if config.IsActive {
-			count++
-		}
-	}
-	return count
 }
 
 // Start - запуск сервера
@@ -834,6 +2297,44 @@ func (ts *TradingServer) Start(port string) error {
 	// Запускаем стримы в отдельных горутинах
 	ts.startStreams()
 	
+	// Восстанавливаем ботов, работавших до перезапуска
+	ts.restoreBots()
+	
+	// Загружаем справочник инструментов и обновляем его раз в сутки
+	ts.wg.Add(1)
+	go func() {
+		defer ts.wg.Done()
+		ts.catalog.Run(ts.ctx)
+	}()
+	
+	// Следим за стоп-лоссом и тейк-профитом открытых позиций
+	ts.wg.Add(1)
+	go func() {
+		defer ts.wg.Done()
+		ts.riskGateway.WatchExits(ts.ctx, 10*time.Second)
+	}()
+	
+	// Ведем синтетические заявки, в том числе созданные до перезапуска
+	ts.wg.Add(1)
+	go func() {
+		defer ts.wg.Done()
+		ts.syntheticOrders.Run(ts.ctx)
+	}()
+	
+	// Отслеживаем исполнение заявок для журнала
+	ts.wg.Add(1)
+	go func() {
+		defer ts.wg.Done()
+		ts.orderJournal.Run(ts.ctx, ts.accounts)
+	}()
+	
+	// Периодически сверяем состояние с брокером
+	ts.wg.Add(1)
+	go func() {
+		defer ts.wg.Done()
+		ts.reconciler.Run(ts.ctx, ts.accounts)
+	}()
+	
 	// Запускаем HTTP сервер
 	go func() {
 		if err := ts.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -852,9 +2353,37 @@ func (ts *TradingServer) Start(port string) error {
 
 // startStreams - запуск стримов данных
 func (ts *TradingServer) startStreams() {
-	// Здесь можно запустить стримы маркетдаты и операций
-	// в отдельных горутинах для получения данных в реальном времени
 	ts.logger.Info("Starting data streams...")
+	
+	ts.streamManager.SetAccounts(ts.accounts)
+	if err := ts.streamManager.Start(); err != nil {
+		ts.logger.Errorf("Failed to start streams: %v", err)
+	}
+}
+
+// restoreBots - восстановление ботов из базы по политике из конфига
+func (ts *TradingServer) restoreBots() {
+	policy, err := bots.ParseRestorePolicy(ts.appConfig.Bots.RestorePolicy)
+	if err != nil {
+		ts.logger.Errorf("Bots are not restored: %v", err)
+		return
+	}
+	
+	restored, err := ts.botManager.Restore(ts.ctx, policy)
+	if err != nil {
+		ts.logger.Errorf("Failed to restore bots: %v", err)
+		return
+	}
+	for _, bot := range restored {
+		switch {
+		case bot.Error != "":
+			ts.logger.Errorf("Bot %s restore error: %s", bot.BotID, bot.Error)
+		case len(bot.Issues) > 0:
+			ts.logger.Warnf("Bot %s left stopped, reconciliation issues: %v", bot.BotID, bot.Issues)
+		default:
+			ts.logger.Infof("Bot %s restored: %s -> %s, missed fills: %d", bot.BotID, bot.PreviousState, bot.State, bot.MissedFills)
+		}
+	}
 }
 
 // Stop - остановка сервера
@@ -871,6 +2400,16 @@ func (ts *TradingServer) Stop() error {
 		}
 	}
 	
+	// Отменяем бэктесты
+	if ts.backtests != nil {
+		ts.backtests.Shutdown()
+	}
+	
+	// Останавливаем стримы
+	if ts.streamManager != nil {
+		ts.streamManager.Stop()
+	}
+	
 	// Останавливаем HTTP сервер
 	if ts.httpServer != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -890,6 +2429,18 @@ func (ts *TradingServer) Stop() error {
 	// Ждем завершения всех горутин
 	ts.wg.Wait()
 	
+	// Закрываем базу после остановки ботов
+	if ts.store != nil {
+		if err := ts.store.Close(); err != nil {
+			ts.logger.Errorf("Database close error: %v", err)
+		}
+	}
+	if ts.rateLimiter != nil {
+		if err := ts.rateLimiter.Close(); err != nil {
+			ts.logger.Errorf("Rate limiter close error: %v", err)
+		}
+	}
+	
 	// Синхронизируем логгер
 	if err := ts.logger.Sync(); err != nil {
 		log.Printf("Logger sync error: %v", err)
@@ -899,8 +2450,64 @@ func (ts *TradingServer) Stop() error {
 	return nil
 }
 
+// runMigrate - подкоманда migrate: up применяет новые миграции, status показывает их состояние
+func runMigrate(args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	
+	appConfig, err := config.Load("config.yaml")
+	if err != nil {
+		return err
+	}
+	store, err := storage.Open(appConfig.Database)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	
+	ctx := context.Background()
+	switch command {
+	case "up":
+		applied, err := store.Migrate(ctx)
+		for _, m := range applied {
+			fmt.Printf("Applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("Database schema is up to date")
+		}
+	case "status":
+		migrations, err := store.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			status := "pending"
+			if m.AppliedAt != nil {
+				status = "applied " + m.AppliedAt.Format(time.DateTime)
+			}
+			fmt.Printf("%04d_%s\t%s\n", m.Version, m.Name, status)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up or status", command)
+	}
+	return nil
+}
+
 // main функция
 func main() {
+	// Миграции базы запускаются без подключения к API брокера
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			log.Fatalf("Migration error: %v", err)
+		}
+		return
+	}
+	
 	server, err := NewTradingServer()
 	if err != nil {
 		log.Fatalf("Failed to create trading server: %v", err)
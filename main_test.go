@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"trading-bot-web/auth"
+	"trading-bot-web/broker"
+	"trading-bot-web/catalog"
+	"trading-bot-web/config"
+	"trading-bot-web/middleware"
+	"trading-bot-web/money"
+)
+
+const testFigi = "BBG004730N88"
+
+// newTestServer - сервер с брокером в памяти и акцией testFigi: лот 10, шаг цены 0.05
+func newTestServer(t *testing.T) (*TradingServer, *broker.Fake) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	fake := broker.NewFake()
+	fake.AddInstrument(&pb.Instrument{
+		Figi:                  testFigi,
+		Ticker:                "SBER",
+		ClassCode:             "TQBR",
+		InstrumentType:        catalog.TypeShare,
+		Lot:                   10,
+		MinPriceIncrement:     money.ToQuotation(decimal.RequireFromString("0.05")),
+		ApiTradeAvailableFlag: true,
+		BuyAvailableFlag:      true,
+		SellAvailableFlag:     true,
+	})
+
+	logger := zap.NewNop().Sugar()
+	ts := &TradingServer{logger: logger, auth: &auth.Service{}}
+	ts.useBroker(fake)
+	ts.catalog = catalog.New(fake, config.CacheEntryConfig{TTL: time.Minute, MaxSize: 100}, logger)
+	rules, err := catalog.NewRules(ts.catalog, fake, config.PriceRoundingConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.orderRules = rules
+	return ts, fake
+}
+
+// serve - запрос к обработчику от имени principal
+func serve(principal *auth.Principal, method, route, target, body string, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Handle(method, route, func(c *gin.Context) {
+		c.Set(middleware.ContextPrincipal, principal)
+		c.Next()
+	}, handler)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+var testAdmin = &auth.Principal{UserID: "admin", Roles: []string{auth.RoleAdmin}}
+
+func TestHandleBuyOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		principal  *auth.Principal
+		body       string
+		brokerErr  error
+		wantStatus int
+		wantField  string
+		want       *broker.OrderRequest
+	}{
+		{
+			name:       "market order in shares",
+			principal:  testAdmin,
+			body:       `{"account_id":"acc","instrument_id":"` + testFigi + `","quantity":20,"quantity_unit":"shares"}`,
+			wantStatus: http.StatusOK,
+			want: &broker.OrderRequest{
+				AccountID:    "acc",
+				InstrumentID: testFigi,
+				Direction:    pb.OrderDirection_ORDER_DIRECTION_BUY,
+				OrderType:    pb.OrderType_ORDER_TYPE_MARKET,
+				Lots:         2,
+			},
+		},
+		{
+			name:       "limit price rounded to step",
+			principal:  testAdmin,
+			body:       `{"account_id":"acc","instrument_id":"` + testFigi + `","quantity":1,"price":"100.03"}`,
+			wantStatus: http.StatusOK,
+			want: &broker.OrderRequest{
+				AccountID:    "acc",
+				InstrumentID: testFigi,
+				Direction:    pb.OrderDirection_ORDER_DIRECTION_BUY,
+				OrderType:    pb.OrderType_ORDER_TYPE_LIMIT,
+				Lots:         1,
+				Price:        decimalPtr("100.05"),
+			},
+		},
+		{
+			name:       "quantity not a multiple of lot",
+			principal:  testAdmin,
+			body:       `{"account_id":"acc","instrument_id":"` + testFigi + `","quantity":15,"quantity_unit":"shares"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantField:  "quantity",
+		},
+		{
+			name:       "unknown instrument",
+			principal:  testAdmin,
+			body:       `{"account_id":"acc","instrument_id":"UNKNOWN","quantity":1}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantField:  "instrument_id",
+		},
+		{
+			name:       "limit order without price",
+			principal:  testAdmin,
+			body:       `{"account_id":"acc","instrument_id":"` + testFigi + `","quantity":1,"order_type":"limit"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "missing account",
+			principal:  testAdmin,
+			body:       `{"instrument_id":"` + testFigi + `","quantity":1}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "account of api key denied",
+			principal:  &auth.Principal{APIKey: true, APIKeyID: "key", Roles: []string{auth.RoleTrader}, Accounts: []string{"other"}},
+			body:       `{"account_id":"acc","instrument_id":"` + testFigi + `","quantity":1}`,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "broker unavailable",
+			principal:  testAdmin,
+			body:       `{"account_id":"acc","instrument_id":"` + testFigi + `","quantity":1}`,
+			brokerErr:  errors.New("connection refused"),
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, fake := newTestServer(t)
+			fake.FailWith(tt.brokerErr)
+
+			recorder := serve(tt.principal, http.MethodPost, "/orders/buy", "/orders/buy", tt.body, ts.handleBuyOrder)
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+
+			if tt.wantField != "" {
+				var response struct {
+					Fields []catalog.FieldError `json:"fields"`
+				}
+				if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+					t.Fatal(err)
+				}
+				if len(response.Fields) != 1 || response.Fields[0].Field != tt.wantField {
+					t.Errorf("fields = %+v, want error for %s", response.Fields, tt.wantField)
+				}
+			}
+
+			posted := fake.Posted()
+			if tt.want == nil {
+				if len(posted) != 0 {
+					t.Errorf("posted %d orders, want none", len(posted))
+				}
+				return
+			}
+			if len(posted) != 1 {
+				t.Fatalf("posted %d orders, want 1", len(posted))
+			}
+			assertOrderRequest(t, posted[0], *tt.want)
+
+			var response struct {
+				OrderID string `json:"order_id"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.OrderID == "" {
+				t.Errorf("response has no order_id: %s", recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandleGetCandles(t *testing.T) {
+	now := time.Now()
+	ts, fake := newTestServer(t)
+	fake.AddCandles(testFigi,
+		&pb.HistoricCandle{Time: timestamppb.New(now.AddDate(0, 0, -40)), IsComplete: true},
+		&pb.HistoricCandle{Time: timestamppb.New(now.AddDate(0, 0, -2)), IsComplete: true},
+		&pb.HistoricCandle{Time: timestamppb.New(now.AddDate(0, 0, -1)), IsComplete: true},
+	)
+
+	tests := []struct {
+		name        string
+		target      string
+		wantStatus  int
+		wantCandles int
+	}{
+		{name: "last 30 days", target: "/marketdata/candles?figi=" + testFigi, wantStatus: http.StatusOK, wantCandles: 2},
+		{name: "no candles", target: "/marketdata/candles?figi=OTHER&interval=day", wantStatus: http.StatusOK},
+		{name: "missing figi", target: "/marketdata/candles", wantStatus: http.StatusBadRequest},
+		{name: "unknown interval", target: "/marketdata/candles?figi=" + testFigi + "&interval=century", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := serve(testAdmin, http.MethodGet, "/marketdata/candles", tt.target, "", ts.handleGetCandles)
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var response struct {
+				Candles []json.RawMessage `json:"candles"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if len(response.Candles) != tt.wantCandles {
+				t.Errorf("got %d candles, want %d", len(response.Candles), tt.wantCandles)
+			}
+		})
+	}
+}
+
+func TestHandleGetPortfolio(t *testing.T) {
+	ts, fake := newTestServer(t)
+	fake.SetPortfolio("acc", &pb.PortfolioResponse{
+		AccountId:          "acc",
+		TotalAmountShares:  &pb.MoneyValue{Currency: "rub", Units: 1500},
+		TotalAmountBonds:   &pb.MoneyValue{Currency: "rub"},
+		TotalAmountEtf:     &pb.MoneyValue{Currency: "rub"},
+		TotalAmountFutures: &pb.MoneyValue{Currency: "rub"},
+	})
+
+	recorder := serve(testAdmin, http.MethodGet, "/accounts/:id/portfolio", "/accounts/acc/portfolio", "", ts.handleGetPortfolio)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body.String())
+	}
+	var response struct {
+		AccountID string `json:"account_id"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.AccountID != "acc" {
+		t.Errorf("account_id = %q, want acc", response.AccountID)
+	}
+
+	keyOfOther := &auth.Principal{APIKey: true, APIKeyID: "key", Roles: []string{auth.RoleViewer}, Accounts: []string{"other"}}
+	recorder = serve(keyOfOther, http.MethodGet, "/accounts/:id/portfolio", "/accounts/acc/portfolio", "", ts.handleGetPortfolio)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusForbidden)
+	}
+
+	fake.FailWith(errors.New("connection refused"))
+	recorder = serve(testAdmin, http.MethodGet, "/accounts/:id/portfolio", "/accounts/acc/portfolio", "", ts.handleGetPortfolio)
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusInternalServerError)
+	}
+}
+
+// assertOrderRequest - сравнение заявки, переданной брокеру, с ожидаемой
+func assertOrderRequest(t *testing.T, got, want broker.OrderRequest) {
+	t.Helper()
+	if got.AccountID != want.AccountID || got.InstrumentID != want.InstrumentID ||
+		got.Direction != want.Direction || got.OrderType != want.OrderType || got.Lots != want.Lots {
+		t.Errorf("order = %+v, want %+v", got, want)
+	}
+	switch {
+	case want.Price == nil && got.Price != nil:
+		t.Errorf("price = %s, want none", got.Price)
+	case want.Price != nil && (got.Price == nil || !got.Price.Equal(*want.Price)):
+		t.Errorf("price = %v, want %s", got.Price, want.Price)
+	}
+}
+
+func decimalPtr(value string) *decimal.Decimal {
+	d := decimal.RequireFromString(value)
+	return &d
+}
@@ -1,13 +1,20 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
+
+	"trading-bot-web/auth"
+	"trading-bot-web/ratelimit"
 )
 
 // Logger - middleware для логирования запросов
@@ -34,7 +41,7 @@ func Logger(logger *zap.SugaredLogger) gin.HandlerFunc {
 		}
 
 		if raw != "" {
-			param.Path = path + "?" + raw
+			param.Path = path + "?" + redactQuery(raw)
 		} else {
 			param.Path = path
 		}
@@ -52,13 +59,39 @@ func Logger(logger *zap.SugaredLogger) gin.HandlerFunc {
 	})
 }
 
+// secretParams - параметры запроса с секретами, их значения не пишутся в журнал
+var secretParams = []string{"access_token"}
+
+// redactQuery - строка запроса со скрытыми значениями секретных параметров.
+// Нераспознанная строка с секретом не пишется совсем.
+func redactQuery(raw string) string {
+	secret := false
+	for _, name := range secretParams {
+		secret = secret || strings.Contains(raw, name)
+	}
+	if !secret {
+		return raw
+	}
+
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		return "[redacted]"
+	}
+	for _, name := range secretParams {
+		if _, exists := values[name]; exists {
+			values.Set(name, "[redacted]")
+		}
+	}
+	return values.Encode()
+}
+
 // CORS - middleware для настройки CORS
 func CORS() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key, Idempotency-Key, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, PATCH, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -69,69 +102,120 @@ func CORS() gin.HandlerFunc {
 	}
 }
 
-// RateLimit - middleware для ограничения частоты запросов
-func RateLimit(requestsPerMinute int) gin.HandlerFunc {
-	// Простая реализация rate limiting в памяти
-	// В продакшене лучше использовать Redis
-	clients := make(map[string][]time.Time)
-	
+// RateLimit - middleware для ограничения частоты запросов (token bucket).
+// Лимит считается по API ключу или пользователю, если Auth уже пройден, иначе по IP.
+// classify выбирает бюджет маршрута. Без limiter запросы не ограничиваются.
+func RateLimit(limiter *ratelimit.Limiter, classify func(c *gin.Context) string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		clientIP := c.ClientIP()
-		now := time.Now()
+		if limiter == nil {
+			c.Next()
+			return
+		}
 		
-		// Очищаем старые записи
-		if requests, exists := clients[clientIP]; exists {
-			var validRequests []time.Time
-			for _, reqTime := range requests {
-				if now.Sub(reqTime) < time.Minute {
-					validRequests = append(validRequests, reqTime)
-				}
+		ctx := c.Request.Context()
+		identity := rateLimitIdentity(c)
+		
+		// Собственный лимит API ключа проверяется в дополнение к бюджету маршрута
+		if principal := Principal(c); principal != nil && principal.RateLimit > 0 {
+			limit := ratelimit.Limit{PerMinute: principal.RateLimit, Burst: principal.RateLimit}
+			if result := limiter.AllowLimit(ctx, ratelimit.ClassAPIKey, identity, limit); !result.Allowed {
+				rejectRateLimited(c, result)
+				return
 			}
-			clients[clientIP] = validRequests
 		}
 		
-		// Проверяем лимит
-		if len(clients[clientIP]) >= requestsPerMinute {
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error": "Rate limit exceeded",
-				"retry_after": 60,
-			})
-			c.Abort()
+		result := limiter.Allow(ctx, classify(c), identity)
+		c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		if !result.Allowed {
+			rejectRateLimited(c, result)
 			return
 		}
 		
-		// Добавляем текущий запрос
-		clients[clientIP] = append(clients[clientIP], now)
-		
 		c.Next()
 	}
 }
 
+// rateLimitIdentity - по кому считается лимит запроса
+func rateLimitIdentity(c *gin.Context) string {
+	if subject := Principal(c).Subject(); subject != "" {
+		return subject
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// rejectRateLimited - ответ 429 с временем до следующей попытки
+func rejectRateLimited(c *gin.Context, result ratelimit.Result) {
+	retryAfter := ceilSeconds(result.RetryAfter)
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error": "Rate limit exceeded",
+		"retry_after": retryAfter,
+	})
+	c.Abort()
+}
+
+// ceilSeconds - длительность в целых секундах с округлением вверх
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// Ключи контекста gin с данными аутентифицированного пользователя
+const (
+	ContextUserID    = "user_id"
+	ContextRoles     = "roles"
+	ContextClaims    = "claims"
+	ContextPrincipal = "principal"
+)
+
+// Authenticator - проверка access токенов и API ключей
+type Authenticator interface {
+	ValidateAccessToken(token string) (*auth.Claims, error)
+	AuthenticateAPIKey(ctx context.Context, key string) (*auth.Principal, error)
+}
+
 // Auth - middleware для проверки аутентификации
-func Auth(validAPIKeys []string) gin.HandlerFunc {
+func Auth(authenticator Authenticator) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Проверяем API ключ в заголовке
 		apiKey := c.GetHeader("X-API-Key")
 		if apiKey != "" {
-			for _, validKey := range validAPIKeys {
-				if apiKey == validKey {
-					c.Next()
-					return
-				}
+			principal, err := authenticator.AuthenticateAPIKey(c.Request.Context(), apiKey)
+			if err != nil && !errors.Is(err, auth.ErrInvalidAPIKey) {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				c.Abort()
+				return
+			}
+			if err == nil {
+				c.Set(ContextRoles, principal.Roles)
+				c.Set(ContextPrincipal, principal)
+				c.Next()
+				return
 			}
 		}
 		
-		// Проверяем Bearer token
+		// Проверяем Bearer token, для WebSocket допускается параметр access_token
+		token := ""
 		authHeader := c.GetHeader("Authorization")
 		if authHeader != "" {
 			parts := strings.Split(authHeader, " ")
 			if len(parts) == 2 && parts[0] == "Bearer" {
-				// Здесь должна быть проверка JWT токена
-				token := parts[1]
-				if validateJWT(token) {
-					c.Next()
-					return
-				}
+				token = parts[1]
+			}
+		} else if c.IsWebsocket() {
+			token = c.Query("access_token")
+		}
+		
+		if token != "" {
+			claims, err := authenticator.ValidateAccessToken(token)
+			if err == nil {
+				c.Set(ContextUserID, claims.UserID())
+				c.Set(ContextRoles, claims.Roles)
+				c.Set(ContextClaims, claims)
+				c.Set(ContextPrincipal, auth.PrincipalFromClaims(claims))
+				c.Next()
+				return
 			}
 		}
 		
@@ -143,11 +227,51 @@ func Auth(validAPIKeys []string) gin.HandlerFunc {
 	}
 }
 
-// validateJWT - проверка JWT токена (заглушка)
-func validateJWT(token string) bool {
-	// Здесь должна быть реальная проверка JWT
-	// Для примера просто проверяем, что токен не пустой
-	return token != ""
+// RequireRole - middleware для проверки роли субъекта запроса, ставится после Auth.
+// Администратору доступны все маршруты.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !Principal(c).HasRole(roles...) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Forbidden",
+				"message": "One of the roles is required: " + strings.Join(roles, ", "),
+			})
+			c.Abort()
+			return
+		}
+		
+		c.Next()
+	}
+}
+
+// Principal - субъект запроса, nil до проверки аутентификации
+func Principal(c *gin.Context) *auth.Principal {
+	value, exists := c.Get(ContextPrincipal)
+	if !exists {
+		return nil
+	}
+	principal, _ := value.(*auth.Principal)
+	return principal
+}
+
+// UserID - пользователь запроса, пустой для доступа по API ключу
+func UserID(c *gin.Context) string {
+	return c.GetString(ContextUserID)
+}
+
+// Roles - роли пользователя запроса
+func Roles(c *gin.Context) []string {
+	return c.GetStringSlice(ContextRoles)
+}
+
+// Claims - данные токена запроса, nil для доступа по API ключу
+func Claims(c *gin.Context) *auth.Claims {
+	value, exists := c.Get(ContextClaims)
+	if !exists {
+		return nil
+	}
+	claims, _ := value.(*auth.Claims)
+	return claims
 }
 
 // Recovery - middleware для обработки паники
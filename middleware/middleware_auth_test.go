@@ -0,0 +1,24 @@
+package middleware
+
+import "testing"
+
+func TestRedactQuery(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{name: "no secrets", raw: "figi=BBG004730N88&interval=day", want: "figi=BBG004730N88&interval=day"},
+		{name: "access token", raw: "access_token=eyJhbGciOi.payload.sig", want: "access_token=%5Bredacted%5D"},
+		{name: "access token among others", raw: "channel=orders&access_token=abc", want: "access_token=%5Bredacted%5D&channel=orders"},
+		{name: "unparsable", raw: "access_token=abc;%zz", want: "[redacted]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactQuery(tt.raw); got != tt.want {
+				t.Errorf("redactQuery(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
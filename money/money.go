@@ -0,0 +1,70 @@
+package money
+
+import (
+	"github.com/shopspring/decimal"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+)
+
+// Цены и суммы сервера - decimal.Decimal: арифметика без ошибок двоичного
+// округления, в JSON значение пишется строкой ("250.01"), а при чтении
+// принимается и строка, и число. Quotation и MoneyValue investAPI хранят
+// дробную часть в миллиардных долях, поэтому перевод в обе стороны точен
+// до девятого знака.
+
+// nanoExp - порядок дробной части Quotation и MoneyValue
+const nanoExp = -9
+
+// nanoScale - число знаков после запятой, представимых в Quotation
+const nanoScale = 9
+
+// FromQuotation - значение Quotation, nil - ноль
+func FromQuotation(q *pb.Quotation) decimal.Decimal {
+	return fromUnitsNano(q.GetUnits(), q.GetNano())
+}
+
+// FromMoneyValue - сумма MoneyValue без валюты, nil - ноль
+func FromMoneyValue(m *pb.MoneyValue) decimal.Decimal {
+	return fromUnitsNano(m.GetUnits(), m.GetNano())
+}
+
+// ToQuotation - значение в Quotation. Знаки после девятого округляются,
+// units и nano всегда одного знака, как того требует investAPI.
+func ToQuotation(value decimal.Decimal) *pb.Quotation {
+	units, nano := unitsNano(value)
+	return &pb.Quotation{Units: units, Nano: nano}
+}
+
+// ToMoneyValue - сумма в MoneyValue в валюте currency
+func ToMoneyValue(value decimal.Decimal, currency string) *pb.MoneyValue {
+	units, nano := unitsNano(value)
+	return &pb.MoneyValue{Currency: currency, Units: units, Nano: nano}
+}
+
+// OptionalQuotation - Quotation для необязательной цены, nil остается nil
+func OptionalQuotation(value *decimal.Decimal) *pb.Quotation {
+	if value == nil {
+		return nil
+	}
+	return ToQuotation(*value)
+}
+
+// RoundToStep - ближайшее к value кратное step, при step <= 0 value без изменений
+func RoundToStep(value, step decimal.Decimal) decimal.Decimal {
+	if !step.IsPositive() {
+		return value
+	}
+	return value.Div(step).Round(0).Mul(step)
+}
+
+// fromUnitsNano - число из целой части и миллиардных долей
+func fromUnitsNano(units int64, nano int32) decimal.Decimal {
+	return decimal.NewFromInt(units).Add(decimal.New(int64(nano), nanoExp))
+}
+
+// unitsNano - целая часть и миллиардные доли числа, округленного до nanoScale знаков
+func unitsNano(value decimal.Decimal) (int64, int32) {
+	value = value.Round(nanoScale)
+	units := value.IntPart()
+	nano := value.Sub(decimal.NewFromInt(units)).Shift(nanoScale).IntPart()
+	return units, int32(nano)
+}
@@ -0,0 +1,112 @@
+package money
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+)
+
+func TestFromQuotation(t *testing.T) {
+	tests := []struct {
+		name string
+		q    *pb.Quotation
+		want string
+	}{
+		{name: "nil", q: nil, want: "0"},
+		{name: "units and nano", q: &pb.Quotation{Units: 250, Nano: 10000000}, want: "250.01"},
+		{name: "negative", q: &pb.Quotation{Units: -1, Nano: -500000000}, want: "-1.5"},
+		{name: "negative nano only", q: &pb.Quotation{Units: 0, Nano: -1}, want: "-0.000000001"},
+		{name: "max nano", q: &pb.Quotation{Units: 0, Nano: 999999999}, want: "0.999999999"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FromQuotation(tt.q)
+			if !got.Equal(decimal.RequireFromString(tt.want)) {
+				t.Errorf("FromQuotation(%v) = %s, want %s", tt.q, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToQuotation(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		wantUnits int64
+		wantNano  int32
+	}{
+		{name: "zero", value: "0", wantUnits: 0, wantNano: 0},
+		{name: "units and nano", value: "250.01", wantUnits: 250, wantNano: 10000000},
+		{name: "negative", value: "-1.5", wantUnits: -1, wantNano: -500000000},
+		{name: "negative below one", value: "-0.25", wantUnits: 0, wantNano: -250000000},
+		{name: "tenth digit rounded", value: "0.0000000014", wantUnits: 0, wantNano: 1},
+		{name: "carry at 1e9", value: "0.9999999996", wantUnits: 1, wantNano: 0},
+		{name: "negative carry at 1e9", value: "-2.9999999996", wantUnits: -3, wantNano: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ToQuotation(decimal.RequireFromString(tt.value))
+			if got.GetUnits() != tt.wantUnits || got.GetNano() != tt.wantNano {
+				t.Errorf("ToQuotation(%s) = {%d %d}, want {%d %d}", tt.value, got.GetUnits(), got.GetNano(), tt.wantUnits, tt.wantNano)
+			}
+
+			mv := ToMoneyValue(decimal.RequireFromString(tt.value), "rub")
+			if mv.GetUnits() != tt.wantUnits || mv.GetNano() != tt.wantNano || mv.GetCurrency() != "rub" {
+				t.Errorf("ToMoneyValue(%s) = %v, want {rub %d %d}", tt.value, mv, tt.wantUnits, tt.wantNano)
+			}
+		})
+	}
+}
+
+func TestQuotationRoundTrip(t *testing.T) {
+	for _, value := range []string{"0.000000001", "-0.000000001", "123456789.987654321", "-42.05"} {
+		want := decimal.RequireFromString(value)
+		if got := FromQuotation(ToQuotation(want)); !got.Equal(want) {
+			t.Errorf("round trip of %s = %s", value, got)
+		}
+		if got := FromMoneyValue(ToMoneyValue(want, "usd")); !got.Equal(want) {
+			t.Errorf("money round trip of %s = %s", value, got)
+		}
+	}
+}
+
+func TestRoundToStep(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		step  string
+		want  string
+	}{
+		{name: "down", value: "100.02", step: "0.05", want: "100"},
+		{name: "up", value: "100.03", step: "0.05", want: "100.05"},
+		{name: "half away from zero", value: "100.025", step: "0.05", want: "100.05"},
+		{name: "already on step", value: "99.95", step: "0.05", want: "99.95"},
+		{name: "integer step", value: "1234", step: "10", want: "1230"},
+		{name: "negative value", value: "-100.03", step: "0.05", want: "-100.05"},
+		{name: "fine step", value: "0.123456789", step: "0.0001", want: "0.1235"},
+		{name: "zero step", value: "100.03", step: "0", want: "100.03"},
+		{name: "negative step", value: "100.03", step: "-0.05", want: "100.03"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := RoundToStep(decimal.RequireFromString(tt.value), decimal.RequireFromString(tt.step))
+			if !got.Equal(decimal.RequireFromString(tt.want)) {
+				t.Errorf("RoundToStep(%s, %s) = %s, want %s", tt.value, tt.step, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOptionalQuotation(t *testing.T) {
+	if got := OptionalQuotation(nil); got != nil {
+		t.Errorf("OptionalQuotation(nil) = %v, want nil", got)
+	}
+	value := decimal.RequireFromString("7.5")
+	if got := OptionalQuotation(&value); got.GetUnits() != 7 || got.GetNano() != 500000000 {
+		t.Errorf("OptionalQuotation(7.5) = %v", got)
+	}
+}
@@ -0,0 +1,528 @@
+package paper
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/tinkoff/invest-api-go-sdk/investgo"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"trading-bot-web/bots"
+	"trading-bot-web/money"
+)
+
+// Broker - внутрипроцессный симулятор брокера для бумажной торговли.
+// Повторяет методы OrdersServiceClient и OperationsServiceClient, которые
+// использует сервер, и сводит лимитные заявки с поступающими ценами.
+// Все инструменты считаются торгуемыми в валюте счета, плечо не моделируется.
+type Broker struct {
+	commissionPct decimal.Decimal
+	logger        *zap.SugaredLogger
+
+	mu       sync.Mutex
+	accounts map[string]*account
+	orders   map[string]*order
+	prices   map[string]decimal.Decimal
+	lots     map[string]int64
+	seq      int
+}
+
+// account - бумажный счет
+type account struct {
+	id        string
+	currency  string
+	money     decimal.Decimal
+	positions map[string]*position
+	// requests - ID заявок по клиентскому ключу для идемпотентности
+	requests map[string]string
+}
+
+// position - позиция по инструменту в штуках
+type position struct {
+	quantity     int64
+	averagePrice decimal.Decimal
+}
+
+// order - заявка на бумажном счете
+type order struct {
+	id            string
+	requestID     string
+	accountID     string
+	instrumentID  string
+	direction     pb.OrderDirection
+	orderType     pb.OrderType
+	limitPrice    decimal.Decimal
+	lotsRequested int64
+	lotsExecuted  int64
+	executedPrice decimal.Decimal
+	commission    decimal.Decimal
+	status        pb.OrderExecutionReportStatus
+	createdAt     time.Time
+	// notify - уведомление исполнителя бота об отложенном исполнении
+	notify func(bots.Fill)
+}
+
+// NewBroker - создание симулятора брокера
+func NewBroker(commissionPct float64, logger *zap.SugaredLogger) *Broker {
+	return &Broker{
+		commissionPct: decimal.NewFromFloat(commissionPct),
+		logger:        logger,
+		accounts:      make(map[string]*account),
+		orders:        make(map[string]*order),
+		prices:        make(map[string]decimal.Decimal),
+		lots:          make(map[string]int64),
+	}
+}
+
+// OpenAccount - открытие бумажного счета, повторный вызов для существующего счета ничего не меняет
+func (b *Broker) OpenAccount(accountID, currency string, balance float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, exists := b.accounts[accountID]; exists {
+		return
+	}
+	b.accounts[accountID] = &account{
+		id:        accountID,
+		currency:  strings.ToLower(currency),
+		money:     decimal.NewFromFloat(balance),
+		positions: make(map[string]*position),
+		requests:  make(map[string]string),
+	}
+	b.logger.Infof("Paper account %s opened with %.2f %s", accountID, balance, currency)
+}
+
+// HasAccount - проверка, что счет бумажный
+func (b *Broker) HasAccount(accountID string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, exists := b.accounts[accountID]
+	return exists
+}
+
+// SetLotSize - размер лота инструмента, по умолчанию 1
+func (b *Broker) SetLotSize(instrumentID string, lot int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lots[instrumentID] = lot
+}
+
+// LotSize - размер лота инструмента
+func (b *Broker) LotSize(instrumentID string) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lotSize(instrumentID)
+}
+
+// LastPrice - последняя цена инструмента в симуляторе
+func (b *Broker) LastPrice(instrumentID string) (decimal.Decimal, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	price, exists := b.prices[instrumentID]
+	return price, exists
+}
+
+// UpdatePrice - новая рыночная цена инструмента и сведение лимитных заявок
+func (b *Broker) UpdatePrice(instrumentID string, price decimal.Decimal) {
+	if !price.IsPositive() {
+		return
+	}
+
+	b.mu.Lock()
+	b.prices[instrumentID] = price
+
+	var notifications []func()
+	for _, o := range b.orders {
+		if o.instrumentID != instrumentID || !o.active() {
+			continue
+		}
+		buyHit := o.direction == pb.OrderDirection_ORDER_DIRECTION_BUY && price.LessThanOrEqual(o.limitPrice)
+		sellHit := o.direction == pb.OrderDirection_ORDER_DIRECTION_SELL && price.GreaterThanOrEqual(o.limitPrice)
+		if !buyHit && !sellHit {
+			continue
+		}
+
+		if err := b.execute(o, o.limitPrice); err != nil {
+			o.status = pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_REJECTED
+			b.logger.Warnf("Paper order %s rejected: %v", o.id, err)
+			continue
+		}
+		if o.notify != nil {
+			notify, fill := o.notify, b.fillOf(o)
+			notifications = append(notifications, func() { notify(fill) })
+		}
+	}
+	b.mu.Unlock()
+
+	// Уведомления вызываются без блокировки, чтобы бот мог сразу выставить новую заявку
+	for _, notify := range notifications {
+		notify()
+	}
+}
+
+// PostOrder - выставление заявки
+func (b *Broker) PostOrder(req *investgo.PostOrderRequest) (*investgo.PostOrderResponse, error) {
+	o, err := b.submit(req, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &investgo.PostOrderResponse{PostOrderResponse: o}, nil
+}
+
+// Buy - заявка на покупку
+func (b *Broker) Buy(req *investgo.PostOrderRequestShort) (*investgo.PostOrderResponse, error) {
+	return b.PostOrder(fromShort(req, pb.OrderDirection_ORDER_DIRECTION_BUY))
+}
+
+// Sell - заявка на продажу
+func (b *Broker) Sell(req *investgo.PostOrderRequestShort) (*investgo.PostOrderResponse, error) {
+	return b.PostOrder(fromShort(req, pb.OrderDirection_ORDER_DIRECTION_SELL))
+}
+
+// GetOrders - активные заявки счета
+func (b *Broker) GetOrders(accountId string) (*investgo.GetOrdersResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, err := b.account(accountId); err != nil {
+		return nil, err
+	}
+
+	var orders []*order
+	for _, o := range b.orders {
+		if o.accountID == accountId && o.active() {
+			orders = append(orders, o)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].createdAt.Before(orders[j].createdAt) })
+
+	states := make([]*pb.OrderState, 0, len(orders))
+	for _, o := range orders {
+		states = append(states, b.stateOf(o))
+	}
+	return &investgo.GetOrdersResponse{GetOrdersResponse: &pb.GetOrdersResponse{Orders: states}}, nil
+}
+
+// GetOrderState - состояние заявки
+func (b *Broker) GetOrderState(accountId, orderId string) (*investgo.GetOrderStateResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	o, exists := b.orders[orderId]
+	if !exists || o.accountID != accountId {
+		return nil, fmt.Errorf("order %s not found", orderId)
+	}
+	return &investgo.GetOrderStateResponse{OrderState: b.stateOf(o)}, nil
+}
+
+// CancelOrder - отмена активной заявки
+func (b *Broker) CancelOrder(accountId, orderId string) (*investgo.CancelOrderResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	o, exists := b.orders[orderId]
+	if !exists || o.accountID != accountId {
+		return nil, fmt.Errorf("order %s not found", orderId)
+	}
+	if !o.active() {
+		return nil, fmt.Errorf("order %s is not active", orderId)
+	}
+
+	o.status = pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_CANCELLED
+	return &investgo.CancelOrderResponse{
+		CancelOrderResponse: &pb.CancelOrderResponse{Time: timestamppb.Now()},
+	}, nil
+}
+
+// GetPortfolio - портфель счета по последним ценам
+func (b *Broker) GetPortfolio(accountId string, currency pb.PortfolioRequest_CurrencyRequest) (*investgo.PortfolioResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	acc, err := b.account(accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	total := acc.money
+	expectedYield := decimal.Zero
+	positions := make([]*pb.PortfolioPosition, 0, len(acc.positions))
+	for _, instrumentID := range sortedKeys(acc.positions) {
+		pos := acc.positions[instrumentID]
+		price, exists := b.prices[instrumentID]
+		if !exists {
+			price = pos.averagePrice
+		}
+		quantity := decimal.NewFromInt(pos.quantity)
+		total = total.Add(price.Mul(quantity))
+		yield := price.Sub(pos.averagePrice).Mul(quantity)
+		expectedYield = expectedYield.Add(yield)
+
+		positions = append(positions, &pb.PortfolioPosition{
+			Figi:                 instrumentID,
+			InstrumentType:       "share",
+			Quantity:             money.ToQuotation(quantity),
+			QuantityLots:         money.ToQuotation(quantity.Div(decimal.NewFromInt(b.lotSize(instrumentID)))),
+			AveragePositionPrice: money.ToMoneyValue(pos.averagePrice, acc.currency),
+			CurrentPrice:         money.ToMoneyValue(price, acc.currency),
+			ExpectedYield:        money.ToQuotation(yield),
+		})
+	}
+
+	return &investgo.PortfolioResponse{PortfolioResponse: &pb.PortfolioResponse{
+		AccountId:             accountId,
+		Positions:             positions,
+		TotalAmountCurrencies: money.ToMoneyValue(acc.money, acc.currency),
+		TotalAmountPortfolio:  money.ToMoneyValue(total, acc.currency),
+		ExpectedYield:         money.ToQuotation(expectedYield),
+	}}, nil
+}
+
+// GetPositions - денежные средства и бумаги счета
+func (b *Broker) GetPositions(accountId string) (*investgo.PositionsResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	acc, err := b.account(accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	securities := make([]*pb.PositionsSecurities, 0, len(acc.positions))
+	for _, instrumentID := range sortedKeys(acc.positions) {
+		securities = append(securities, &pb.PositionsSecurities{
+			Figi:           instrumentID,
+			Balance:        acc.positions[instrumentID].quantity,
+			InstrumentType: "share",
+		})
+	}
+
+	return &investgo.PositionsResponse{PositionsResponse: &pb.PositionsResponse{
+		Money:      []*pb.MoneyValue{money.ToMoneyValue(acc.money, acc.currency)},
+		Securities: securities,
+	}}, nil
+}
+
+// submit - прием заявки: рыночные исполняются сразу, лимитные ждут цены
+func (b *Broker) submit(req *investgo.PostOrderRequest, notify func(bots.Fill)) (*pb.PostOrderResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	acc, err := b.account(req.AccountId)
+	if err != nil {
+		return nil, err
+	}
+	if req.Quantity < 1 {
+		return nil, fmt.Errorf("quantity must be positive")
+	}
+	if req.Direction != pb.OrderDirection_ORDER_DIRECTION_BUY && req.Direction != pb.OrderDirection_ORDER_DIRECTION_SELL {
+		return nil, fmt.Errorf("order direction is required")
+	}
+
+	// Повтор с тем же клиентским ID возвращает исходную заявку
+	if req.OrderId != "" {
+		if orderID, exists := acc.requests[req.OrderId]; exists {
+			return b.responseOf(b.orders[orderID]), nil
+		}
+	}
+
+	b.seq++
+	o := &order{
+		id:            fmt.Sprintf("paper_%d", b.seq),
+		requestID:     req.OrderId,
+		accountID:     req.AccountId,
+		instrumentID:  req.InstrumentId,
+		direction:     req.Direction,
+		orderType:     req.OrderType,
+		lotsRequested: req.Quantity,
+		status:        pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_NEW,
+		createdAt:     time.Now(),
+		notify:        notify,
+	}
+
+	price, hasPrice := b.prices[req.InstrumentId]
+	switch req.OrderType {
+	case pb.OrderType_ORDER_TYPE_LIMIT:
+		if req.Price == nil {
+			return nil, fmt.Errorf("price is required for limit order")
+		}
+		o.limitPrice = money.FromQuotation(req.Price)
+		if !o.limitPrice.IsPositive() {
+			return nil, fmt.Errorf("price must be positive")
+		}
+	case pb.OrderType_ORDER_TYPE_MARKET, pb.OrderType_ORDER_TYPE_BESTPRICE, pb.OrderType_ORDER_TYPE_UNSPECIFIED:
+		if !hasPrice {
+			return nil, fmt.Errorf("no market price for %s", req.InstrumentId)
+		}
+		o.orderType = pb.OrderType_ORDER_TYPE_MARKET
+	default:
+		return nil, fmt.Errorf("unsupported order type %v", req.OrderType)
+	}
+
+	// Рыночная заявка или лимитная, пересекающая рынок, исполняется сразу
+	marketable := o.orderType == pb.OrderType_ORDER_TYPE_MARKET ||
+		(hasPrice && o.direction == pb.OrderDirection_ORDER_DIRECTION_BUY && price.LessThanOrEqual(o.limitPrice)) ||
+		(hasPrice && o.direction == pb.OrderDirection_ORDER_DIRECTION_SELL && price.GreaterThanOrEqual(o.limitPrice))
+	if marketable {
+		if err := b.execute(o, price); err != nil {
+			return nil, err
+		}
+	}
+
+	b.orders[o.id] = o
+	if o.requestID != "" {
+		acc.requests[o.requestID] = o.id
+	}
+	return b.responseOf(o), nil
+}
+
+// execute - исполнение заявки целиком с движением денег и бумаг
+func (b *Broker) execute(o *order, price decimal.Decimal) error {
+	acc := b.accounts[o.accountID]
+	quantity := o.lotsRequested * b.lotSize(o.instrumentID)
+	amount := price.Mul(decimal.NewFromInt(quantity))
+	commission := amount.Mul(b.commissionPct).Div(decimal.NewFromInt(100))
+
+	pos, exists := acc.positions[o.instrumentID]
+	if !exists {
+		pos = &position{}
+	}
+
+	if o.direction == pb.OrderDirection_ORDER_DIRECTION_BUY {
+		if acc.money.LessThan(amount.Add(commission)) {
+			return fmt.Errorf("not enough money: need %s, available %s", amount.Add(commission), acc.money)
+		}
+		acc.money = acc.money.Sub(amount.Add(commission))
+		cost := pos.averagePrice.Mul(decimal.NewFromInt(pos.quantity)).Add(amount)
+		pos.averagePrice = cost.Div(decimal.NewFromInt(pos.quantity + quantity))
+		pos.quantity += quantity
+	} else {
+		if pos.quantity < quantity {
+			return fmt.Errorf("not enough %s: need %d, available %d", o.instrumentID, quantity, pos.quantity)
+		}
+		acc.money = acc.money.Add(amount.Sub(commission))
+		pos.quantity -= quantity
+	}
+
+	if pos.quantity == 0 {
+		delete(acc.positions, o.instrumentID)
+	} else {
+		acc.positions[o.instrumentID] = pos
+	}
+
+	o.lotsExecuted = o.lotsRequested
+	o.executedPrice = price
+	o.commission = commission
+	o.status = pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_FILL
+	return nil
+}
+
+// account - поиск счета, вызывается под блокировкой
+func (b *Broker) account(accountID string) (*account, error) {
+	acc, exists := b.accounts[accountID]
+	if !exists {
+		return nil, fmt.Errorf("paper account %s not found", accountID)
+	}
+	return acc, nil
+}
+
+// lotSize - размер лота, вызывается под блокировкой
+func (b *Broker) lotSize(instrumentID string) int64 {
+	if lot, exists := b.lots[instrumentID]; exists && lot > 0 {
+		return lot
+	}
+	return 1
+}
+
+// active - заявка ожидает исполнения
+func (o *order) active() bool {
+	return o.status == pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_NEW ||
+		o.status == pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_PARTIALLYFILL
+}
+
+// fillOf - исполнение заявки в формате бота
+func (b *Broker) fillOf(o *order) bots.Fill {
+	return bots.Fill{
+		OrderID:      o.id,
+		InstrumentID: o.instrumentID,
+		Direction:    o.direction,
+		Lots:         o.lotsExecuted,
+		Quantity:     o.lotsExecuted * b.lotSize(o.instrumentID),
+		Price:        o.executedPrice,
+		Commission:   o.commission,
+		Time:         time.Now(),
+	}
+}
+
+// responseOf - ответ на выставление заявки
+func (b *Broker) responseOf(o *order) *pb.PostOrderResponse {
+	currency := b.accounts[o.accountID].currency
+	executedAmount := o.executedPrice.Mul(decimal.NewFromInt(o.lotsExecuted * b.lotSize(o.instrumentID)))
+
+	return &pb.PostOrderResponse{
+		OrderId:               o.id,
+		ExecutionReportStatus: o.status,
+		LotsRequested:         o.lotsRequested,
+		LotsExecuted:          o.lotsExecuted,
+		InitialOrderPrice:     money.ToMoneyValue(o.limitPrice.Mul(decimal.NewFromInt(o.lotsRequested*b.lotSize(o.instrumentID))), currency),
+		ExecutedOrderPrice:    money.ToMoneyValue(o.executedPrice, currency),
+		TotalOrderAmount:      money.ToMoneyValue(executedAmount.Add(o.commission), currency),
+		ExecutedCommission:    money.ToMoneyValue(o.commission, currency),
+		Figi:                  o.instrumentID,
+		InstrumentUid:         o.instrumentID,
+		Direction:             o.direction,
+		OrderType:             o.orderType,
+	}
+}
+
+// stateOf - состояние заявки в формате OrdersService
+func (b *Broker) stateOf(o *order) *pb.OrderState {
+	currency := b.accounts[o.accountID].currency
+
+	return &pb.OrderState{
+		OrderId:               o.id,
+		OrderRequestId:        o.requestID,
+		ExecutionReportStatus: o.status,
+		LotsRequested:         o.lotsRequested,
+		LotsExecuted:          o.lotsExecuted,
+		InitialSecurityPrice:  money.ToMoneyValue(o.limitPrice, currency),
+		ExecutedOrderPrice:    money.ToMoneyValue(o.executedPrice, currency),
+		ExecutedCommission:    money.ToMoneyValue(o.commission, currency),
+		Figi:                  o.instrumentID,
+		InstrumentUid:         o.instrumentID,
+		Direction:             o.direction,
+		OrderType:             o.orderType,
+		Currency:              currency,
+		OrderDate:             timestamppb.New(o.createdAt),
+	}
+}
+
+// fromShort - короткий запрос заявки в полный с направлением
+func fromShort(req *investgo.PostOrderRequestShort, direction pb.OrderDirection) *investgo.PostOrderRequest {
+	return &investgo.PostOrderRequest{
+		InstrumentId: req.InstrumentId,
+		Quantity:     req.Quantity,
+		Price:        req.Price,
+		Direction:    direction,
+		AccountId:    req.AccountId,
+		OrderType:    req.OrderType,
+		OrderId:      req.OrderId,
+	}
+}
+
+// sortedKeys - инструменты позиций в стабильном порядке
+func sortedKeys(positions map[string]*position) []string {
+	keys := make([]string, 0, len(positions))
+	for key := range positions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
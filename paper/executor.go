@@ -0,0 +1,152 @@
+package paper
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/tinkoff/invest-api-go-sdk/investgo"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+
+	"trading-bot-web/bots"
+	"trading-bot-web/money"
+	"trading-bot-web/risk"
+)
+
+// Executor - исполнение заявок бота на бумажном счете
+type Executor struct {
+	broker    *Broker
+	guard     *risk.Guard
+	accountID string
+
+	mu      sync.RWMutex
+	handler func(bots.Fill)
+}
+
+// NewExecutor - создание исполнителя для бумажного счета. Заявки проходят
+// аварийную блокировку и лимиты guard так же, как заявки реальных счетов.
+func NewExecutor(broker *Broker, guard *risk.Guard, accountID string) *Executor {
+	return &Executor{broker: broker, guard: guard, accountID: accountID}
+}
+
+// PlaceOrder - проверка заявки и выставление в симулятор
+func (e *Executor) PlaceOrder(ctx context.Context, req bots.OrderRequest) (*bots.OrderResult, error) {
+	orderType := pb.OrderType_ORDER_TYPE_MARKET
+	if req.Price != nil {
+		orderType = pb.OrderType_ORDER_TYPE_LIMIT
+	}
+	if err := e.check(req); err != nil {
+		return nil, err
+	}
+
+	resp, err := e.broker.submit(&investgo.PostOrderRequest{
+		InstrumentId: req.InstrumentID,
+		Quantity:     req.Lots,
+		Price:        money.OptionalQuotation(req.Price),
+		Direction:    req.Direction,
+		AccountId:    e.accountID,
+		OrderType:    orderType,
+	}, e.notify)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &bots.OrderResult{
+		OrderID:       resp.GetOrderId(),
+		Status:        resp.GetExecutionReportStatus(),
+		ExecutedLots:  resp.GetLotsExecuted(),
+		ExecutedPrice: money.FromMoneyValue(resp.GetExecutedOrderPrice()),
+		Commission:    money.FromMoneyValue(resp.GetExecutedCommission()),
+	}
+	if result.ExecutedLots > 0 {
+		lot := e.broker.LotSize(req.InstrumentID)
+		e.guard.RecordFill(e.accountID, bots.Fill{
+			OrderID:      result.OrderID,
+			InstrumentID: req.InstrumentID,
+			Direction:    req.Direction,
+			Lots:         result.ExecutedLots,
+			Quantity:     result.ExecutedLots * lot,
+			Price:        result.ExecutedPrice,
+			Commission:   result.Commission,
+			Time:         time.Now(),
+		})
+	}
+	return result, nil
+}
+
+// check - аварийная блокировка и лимиты для заявки бумажного счета.
+// Цена рыночной заявки оценивается по последней цене симулятора.
+func (e *Executor) check(req bots.OrderRequest) error {
+	order := risk.Order{
+		AccountID:    e.accountID,
+		InstrumentID: req.InstrumentID,
+		Direction:    req.Direction,
+		Quantity:     req.Lots * e.broker.LotSize(req.InstrumentID),
+	}
+	if req.Price != nil {
+		order.Price = *req.Price
+	} else if price, exists := e.broker.LastPrice(req.InstrumentID); exists {
+		order.Price = price
+	}
+
+	positions, err := e.broker.GetPositions(e.accountID)
+	if err != nil {
+		return err
+	}
+	order.OpenPositions = make(map[string]bool)
+	for _, security := range positions.GetSecurities() {
+		if security.GetBalance() != 0 {
+			order.OpenPositions[security.GetFigi()] = true
+		}
+	}
+	return e.guard.Check(order)
+}
+
+// CancelOrder - отмена заявки
+func (e *Executor) CancelOrder(ctx context.Context, orderID string) error {
+	_, err := e.broker.CancelOrder(e.accountID, orderID)
+	return err
+}
+
+// AvailableMoney - свободные средства бумажного счета
+func (e *Executor) AvailableMoney(ctx context.Context, currency string) (decimal.Decimal, error) {
+	positions, err := e.broker.GetPositions(e.accountID)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	total := decimal.Zero
+	for _, balance := range positions.GetMoney() {
+		if strings.EqualFold(balance.GetCurrency(), currency) {
+			total = total.Add(money.FromMoneyValue(balance))
+		}
+	}
+	return total, nil
+}
+
+// LotSize - размер лота инструмента в симуляторе
+func (e *Executor) LotSize(instrumentID string) int64 {
+	return e.broker.LotSize(instrumentID)
+}
+
+// SetFillHandler - обработчик исполнения лимитных заявок
+func (e *Executor) SetFillHandler(handler func(bots.Fill)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.handler = handler
+}
+
+// notify - учет исполнения лимитной заявки и передача боту, если он подписан
+func (e *Executor) notify(fill bots.Fill) {
+	e.guard.RecordFill(e.accountID, fill)
+
+	e.mu.RLock()
+	handler := e.handler
+	e.mu.RUnlock()
+
+	if handler != nil {
+		handler(fill)
+	}
+}
@@ -0,0 +1,98 @@
+package paper
+
+import (
+	"context"
+
+	"github.com/shopspring/decimal"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+
+	"trading-bot-web/bots"
+	"trading-bot-web/money"
+)
+
+// Feed - источник данных для бумажных ботов: пробрасывает данные исходного
+// источника и обновляет по ним цены в симуляторе, по которым сводятся заявки
+type Feed struct {
+	upstream bots.MarketFeed
+	broker   *Broker
+}
+
+// NewFeed - обертка над источником данных, питающая симулятор ценами
+func NewFeed(upstream bots.MarketFeed, broker *Broker) *Feed {
+	return &Feed{upstream: upstream, broker: broker}
+}
+
+// Subscribe - подписка на данные бота; свечи запрашиваются всегда,
+// чтобы у симулятора была цена, но бот получает только то, что просил
+func (f *Feed) Subscribe(ctx context.Context, instruments []string, req bots.DataRequest) (*bots.Feed, error) {
+	upstreamReq := req
+	upstreamReq.Candles = true
+
+	src, err := f.upstream.Subscribe(ctx, instruments, upstreamReq)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	out := &bots.Feed{Close: func() {
+		cancel()
+		src.Close()
+	}}
+
+	candles := make(chan *pb.Candle, 16)
+	go pump(ctx, src.Candles, candles, req.Candles, func(candle *pb.Candle) {
+		f.broker.UpdatePrice(candle.GetFigi(), money.FromQuotation(candle.GetClose()))
+	})
+	if req.Candles {
+		out.Candles = candles
+	}
+
+	if src.OrderBooks != nil {
+		orderBooks := make(chan *pb.OrderBook, 16)
+		go pump(ctx, src.OrderBooks, orderBooks, true, func(orderBook *pb.OrderBook) {
+			if price, ok := midPrice(orderBook); ok {
+				f.broker.UpdatePrice(orderBook.GetFigi(), price)
+			}
+		})
+		out.OrderBooks = orderBooks
+	}
+
+	if src.Trades != nil {
+		trades := make(chan *pb.Trade, 16)
+		go pump(ctx, src.Trades, trades, true, func(trade *pb.Trade) {
+			f.broker.UpdatePrice(trade.GetFigi(), money.FromQuotation(trade.GetPrice()))
+		})
+		out.Trades = trades
+	}
+
+	return out, nil
+}
+
+// pump - передача событий боту с обновлением цен симулятора до отписки или закрытия источника
+func pump[T any](ctx context.Context, in <-chan T, out chan<- T, deliver bool, observe func(T)) {
+	defer close(out)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case value, ok := <-in:
+			if !ok {
+				return
+			}
+			observe(value)
+			if deliver && !forward(ctx, out, value) {
+				return
+			}
+		}
+	}
+}
+
+// midPrice - середина спреда лучших заявок стакана
+func midPrice(orderBook *pb.OrderBook) (decimal.Decimal, bool) {
+	bids, asks := orderBook.GetBids(), orderBook.GetAsks()
+	if len(bids) == 0 || len(asks) == 0 {
+		return decimal.Zero, false
+	}
+	sum := money.FromQuotation(bids[0].GetPrice()).Add(money.FromQuotation(asks[0].GetPrice()))
+	return sum.Div(decimal.NewFromInt(2)), true
+}
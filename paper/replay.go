@@ -0,0 +1,111 @@
+package paper
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+	"go.uber.org/zap"
+
+	"trading-bot-web/backtest"
+	"trading-bot-web/bots"
+)
+
+// ReplayFeed - офлайн-источник данных: проигрывает свечи из CSV-файлов
+// каталога истории (<instrument>.csv) с заданным темпом
+type ReplayFeed struct {
+	history *backtest.FileHistory
+	pace    time.Duration
+	logger  *zap.SugaredLogger
+}
+
+// NewReplayFeed - создание источника, pace - пауза между свечами
+func NewReplayFeed(dir string, pace time.Duration, logger *zap.SugaredLogger) *ReplayFeed {
+	if pace <= 0 {
+		pace = time.Second
+	}
+	return &ReplayFeed{
+		history: backtest.NewFileHistory(dir, ""),
+		pace:    pace,
+		logger:  logger,
+	}
+}
+
+// Subscribe - загрузка истории инструментов и запуск проигрывания.
+// Стакан приближается по свече, сделки не моделируются.
+func (f *ReplayFeed) Subscribe(ctx context.Context, instruments []string, req bots.DataRequest) (*bots.Feed, error) {
+	type replayCandle struct {
+		instrumentID string
+		candle       *pb.HistoricCandle
+	}
+
+	var candles []replayCandle
+	for _, instrumentID := range instruments {
+		history, err := f.history.Candles(ctx, instrumentID, pb.CandleInterval_CANDLE_INTERVAL_UNSPECIFIED, time.Time{}, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("replay history for %s: %w", instrumentID, err)
+		}
+		for _, candle := range history {
+			candles = append(candles, replayCandle{instrumentID: instrumentID, candle: candle})
+		}
+	}
+	if len(candles) == 0 {
+		return nil, fmt.Errorf("no replay candles for %v", instruments)
+	}
+	sort.SliceStable(candles, func(i, j int) bool {
+		return candles[i].candle.GetTime().AsTime().Before(candles[j].candle.GetTime().AsTime())
+	})
+
+	ctx, cancel := context.WithCancel(ctx)
+	candleCh := make(chan *pb.Candle, 16)
+	orderBookCh := make(chan *pb.OrderBook, 16)
+
+	go func() {
+		defer close(candleCh)
+		defer close(orderBookCh)
+
+		ticker := time.NewTicker(f.pace)
+		defer ticker.Stop()
+
+		for _, c := range candles {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if req.Candles || !req.OrderBook {
+				if !forward(ctx, candleCh, backtest.ToStreamCandle(c.instrumentID, c.candle)) {
+					return
+				}
+			}
+			if req.OrderBook {
+				if !forward(ctx, orderBookCh, backtest.SyntheticOrderBook(c.instrumentID, c.candle, req.OrderBookDepth)) {
+					return
+				}
+			}
+		}
+		f.logger.Infof("Replay of %v finished", instruments)
+	}()
+
+	feed := &bots.Feed{Close: cancel}
+	if req.Candles || !req.OrderBook {
+		feed.Candles = candleCh
+	}
+	if req.OrderBook {
+		feed.OrderBooks = orderBookCh
+	}
+	return feed, nil
+}
+
+// forward - передача события подписчику, false после отписки
+func forward[T any](ctx context.Context, ch chan<- T, value T) bool {
+	select {
+	case ch <- value:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
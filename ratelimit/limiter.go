@@ -0,0 +1,176 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"trading-bot-web/config"
+)
+
+// Классы маршрутов с отдельными бюджетами
+const (
+	ClassDefault = "default"
+	// ClassOrders - выставление, изменение и отмена заявок
+	ClassOrders = "orders"
+	// ClassAPIKey - собственный лимит API ключа, в дополнение к бюджету класса
+	ClassAPIKey = "api_key"
+)
+
+// Limit - параметры корзины: пополнение в минуту и емкость
+type Limit struct {
+	PerMinute int
+	Burst     int
+}
+
+// rate - пополнение корзины в токенах за секунду
+func (l Limit) rate() float64 {
+	return float64(l.PerMinute) / 60
+}
+
+// capacity - емкость корзины, не меньше одного токена
+func (l Limit) capacity() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return math.Max(1, float64(l.PerMinute))
+}
+
+// Result - решение по запросу
+type Result struct {
+	Allowed bool
+	// Limit - емкость корзины
+	Limit int
+	// Remaining - целых токенов после запроса
+	Remaining int
+	// Reset - через сколько корзина наполнится полностью
+	Reset time.Duration
+	// RetryAfter - через сколько появится токен, если запрос отклонен
+	RetryAfter time.Duration
+}
+
+// Backend - хранилище корзин
+type Backend interface {
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+}
+
+// Limiter - лимиты запросов по классам маршрутов
+type Limiter struct {
+	backend Backend
+	limits  map[string]Limit
+	logger  *zap.SugaredLogger
+}
+
+// New - лимитер из настроек; без включенных лимитов возвращает nil
+func New(cfg config.RateLimitingConfig, redisCfg config.RedisConfig, logger *zap.SugaredLogger) (*Limiter, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if cfg.RequestsPerMinute <= 0 || cfg.Orders.RequestsPerMinute <= 0 {
+		return nil, errors.New("rate limits must be positive")
+	}
+
+	var backend Backend
+	switch cfg.Backend {
+	case "", "memory":
+		backend = NewMemoryBackend(cfg.IdleTimeout)
+	case "redis":
+		client := redis.NewClient(&redis.Options{
+			Addr:         redisCfg.Addr(),
+			Password:     redisCfg.Password,
+			DB:           redisCfg.Database,
+			PoolSize:     redisCfg.PoolSize,
+			MinIdleConns: redisCfg.MinIdleConns,
+			DialTimeout:  redisCfg.DialTimeout,
+			ReadTimeout:  redisCfg.ReadTimeout,
+			WriteTimeout: redisCfg.WriteTimeout,
+			PoolTimeout:  redisCfg.PoolTimeout,
+		})
+		backend = NewRedisBackend(client, cfg.IdleTimeout)
+	default:
+		return nil, fmt.Errorf("unknown rate limiting backend %q", cfg.Backend)
+	}
+
+	return &Limiter{
+		backend: backend,
+		limits: map[string]Limit{
+			ClassDefault: {PerMinute: cfg.RequestsPerMinute, Burst: cfg.Burst},
+			ClassOrders:  {PerMinute: cfg.Orders.RequestsPerMinute, Burst: cfg.Orders.Burst},
+		},
+		logger: logger,
+	}, nil
+}
+
+// Allow - учет запроса клиента key в классе маршрутов
+func (l *Limiter) Allow(ctx context.Context, class, key string) Result {
+	limit, exists := l.limits[class]
+	if !exists {
+		limit = l.limits[ClassDefault]
+	}
+	return l.AllowLimit(ctx, class, key, limit)
+}
+
+// AllowLimit - учет запроса с явно заданным лимитом.
+// При недоступности хранилища запрос пропускается.
+func (l *Limiter) AllowLimit(ctx context.Context, class, key string, limit Limit) Result {
+	result, err := l.backend.Take(ctx, class+":"+key, limit, time.Now())
+	if err != nil {
+		l.logger.Warnf("Rate limiter backend error: %v", err)
+		return Result{Allowed: true, Limit: int(limit.capacity()), Remaining: int(limit.capacity())}
+	}
+	return result
+}
+
+// Close - закрытие подключения к хранилищу корзин
+func (l *Limiter) Close() error {
+	if closer, ok := l.backend.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// idleTTL - через сколько неактивная корзина удаляется.
+// Не раньше, чем она наполнится, иначе клиент получит лишние токены.
+func idleTTL(limit Limit, idleTimeout time.Duration) time.Duration {
+	refill := time.Duration(limit.capacity() / limit.rate() * float64(time.Second))
+	if idleTimeout < refill {
+		return refill
+	}
+	return idleTimeout
+}
+
+// take - расчет корзины: tokens токенов на момент updated
+func take(tokens float64, updated time.Time, limit Limit, now time.Time) (float64, Result) {
+	if elapsed := now.Sub(updated).Seconds(); elapsed > 0 {
+		tokens = math.Min(limit.capacity(), tokens+elapsed*limit.rate())
+	}
+
+	allowed := tokens >= 1
+	if allowed {
+		tokens--
+	}
+	return tokens, newResult(allowed, tokens, limit)
+}
+
+// newResult - решение по остатку токенов после запроса
+func newResult(allowed bool, tokens float64, limit Limit) Result {
+	capacity := limit.capacity()
+	rate := limit.rate()
+
+	result := Result{
+		Allowed:   allowed,
+		Limit:     int(capacity),
+		Remaining: int(tokens),
+		Reset:     time.Duration((capacity - tokens) / rate * float64(time.Second)),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+	return result
+}
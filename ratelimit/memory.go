@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryBackend - корзины в памяти процесса
+type MemoryBackend struct {
+	idleTimeout time.Duration
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// bucket - состояние корзины клиента
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// expires - когда корзину можно удалить
+	expires time.Time
+}
+
+// NewMemoryBackend - хранилище корзин в памяти
+func NewMemoryBackend(idleTimeout time.Duration) *MemoryBackend {
+	return &MemoryBackend{
+		idleTimeout: idleTimeout,
+		buckets:     make(map[string]*bucket),
+	}
+}
+
+// Take - списание токена из корзины
+func (m *MemoryBackend) Take(_ context.Context, key string, limit Limit, now time.Time) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sweep(now)
+
+	b, exists := m.buckets[key]
+	if !exists {
+		b = &bucket{tokens: limit.capacity(), updated: now}
+		m.buckets[key] = b
+	}
+
+	tokens, result := take(b.tokens, b.updated, limit, now)
+	b.tokens = tokens
+	b.updated = now
+	b.expires = now.Add(idleTTL(limit, m.idleTimeout))
+	return result, nil
+}
+
+// sweep - удаление неактивных корзин, не чаще раза в минуту
+func (m *MemoryBackend) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < time.Minute {
+		return
+	}
+	m.lastSweep = now
+
+	for key, b := range m.buckets {
+		if now.After(b.expires) {
+			delete(m.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisKeyPrefix - префикс ключей корзин в Redis
+const redisKeyPrefix = "ratelimit:"
+
+// takeScript - атомарное списание токена. Время берется у Redis,
+// чтобы расхождение часов реплик не влияло на пополнение.
+var takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local ttl = tonumber(ARGV[3])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) + tonumber(time[2]) / 1000000
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(state[1])
+local updated = tonumber(state[2])
+if tokens == nil or updated == nil then
+	tokens = capacity
+	updated = now
+end
+
+if now > updated then
+	tokens = math.min(capacity, tokens + (now - updated) * rate)
+end
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', tostring(now))
+redis.call('PEXPIRE', KEYS[1], ttl)
+return {allowed, tostring(tokens)}
+`)
+
+// RedisBackend - корзины в Redis, общие для всех реплик сервера
+type RedisBackend struct {
+	client      *redis.Client
+	idleTimeout time.Duration
+}
+
+// NewRedisBackend - хранилище корзин в Redis
+func NewRedisBackend(client *redis.Client, idleTimeout time.Duration) *RedisBackend {
+	return &RedisBackend{client: client, idleTimeout: idleTimeout}
+}
+
+// Take - списание токена из корзины
+func (r *RedisBackend) Take(ctx context.Context, key string, limit Limit, _ time.Time) (Result, error) {
+	ttl := idleTTL(limit, r.idleTimeout)
+	args := []interface{}{limit.rate(), limit.capacity(), ttl.Milliseconds()}
+
+	reply, err := takeScript.Run(ctx, r.client, []string{redisKeyPrefix + key}, args...).Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	if len(reply) != 2 {
+		return Result{}, fmt.Errorf("unexpected rate limit script reply: %v", reply)
+	}
+
+	allowed, _ := reply[0].(int64)
+	tokensStr, _ := reply[1].(string)
+	tokens, err := strconv.ParseFloat(tokensStr, 64)
+	if err != nil {
+		return Result{}, fmt.Errorf("invalid rate limit tokens %q: %w", tokensStr, err)
+	}
+	return newResult(allowed == 1, tokens, limit), nil
+}
+
+// Close - закрытие подключения к Redis
+func (r *RedisBackend) Close() error {
+	return r.client.Close()
+}
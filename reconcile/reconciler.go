@@ -0,0 +1,443 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+	"go.uber.org/zap"
+
+	"trading-bot-web/bots"
+	"trading-bot-web/broker"
+	"trading-bot-web/config"
+	"trading-bot-web/money"
+	"trading-bot-web/storage"
+)
+
+// Виды расхождений
+const (
+	// KindPosition - суммарная позиция ботов больше позиции на счете или другого знака
+	KindPosition = "position"
+	// KindBotOrder - бот ждет исполнения заявки, которой у брокера нет среди активных
+	KindBotOrder = "bot_order"
+	// KindJournalOrder - журнал считает заявку активной, а у брокера ее нет среди активных
+	KindJournalOrder = "journal_order"
+	// KindUnknownOrder - активная заявка счета, выставленная не через сервер
+	KindUnknownOrder = "unknown_order"
+)
+
+// Discrepancy - расхождение состояния сервера с брокером
+type Discrepancy struct {
+	Kind         string `json:"kind"`
+	AccountID    string `json:"account_id"`
+	InstrumentID string `json:"instrument_id,omitempty"`
+	OrderID      string `json:"order_id,omitempty"`
+	// BotIDs - боты, которых касается расхождение
+	BotIDs []string `json:"bot_ids,omitempty"`
+	// Expected и Actual - количество в штуках по данным сервера и брокера для KindPosition
+	Expected int64 `json:"expected,omitempty"`
+	Actual   int64 `json:"actual,omitempty"`
+	// Value - стоимость расхождения позиции по текущей цене портфеля
+	Value   *decimal.Decimal `json:"value,omitempty"`
+	Message string           `json:"message"`
+	// DetectedAt - первая проверка, на которой найдено расхождение
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// key - расхождение одного вида по одному объекту считается тем же между проверками
+func (d Discrepancy) key() string {
+	return strings.Join([]string{d.Kind, d.AccountID, d.InstrumentID, d.OrderID}, "/")
+}
+
+// Report - результат последней сверки
+type Report struct {
+	CheckedAt     time.Time     `json:"checked_at"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+	// PausedBots - боты, поставленные на паузу из-за текущих расхождений
+	PausedBots []string `json:"paused_bots,omitempty"`
+	Errors     []string `json:"errors,omitempty"`
+}
+
+// Event - появление или исчезновение подтвержденного расхождения
+type Event struct {
+	// Action - detected или resolved
+	Action      string      `json:"action"`
+	Discrepancy Discrepancy `json:"discrepancy"`
+}
+
+// Reconciler - периодическая сверка позиций и открытых заявок live-ботов
+// и журнала заявок с брокером. Расхождение подтверждается, если оно найдено
+// две проверки подряд: исполнение, которое еще не дошло до бота, не считается.
+type Reconciler struct {
+	orders     broker.OrderGateway
+	portfolio  broker.PortfolioProvider
+	botManager *bots.BotManager
+	store      storage.Repository
+	cfg        config.ReconciliationConfig
+	logger     *zap.SugaredLogger
+
+	mu     sync.RWMutex
+	report Report
+	// suspected - расхождения прошлой проверки, confirmed - подтвержденные
+	suspected map[string]Discrepancy
+	confirmed map[string]Discrepancy
+	// paused - боты, поставленные на паузу, по ключу расхождения
+	paused    map[string][]string
+	observers []func(Event)
+}
+
+// New - сверка состояния ботов botManager и журнала store со счетами брокера
+func New(orders broker.OrderGateway, portfolio broker.PortfolioProvider, botManager *bots.BotManager, store storage.Repository, cfg config.ReconciliationConfig, logger *zap.SugaredLogger) *Reconciler {
+	return &Reconciler{
+		orders:     orders,
+		portfolio:  portfolio,
+		botManager: botManager,
+		store:      store,
+		cfg:        cfg,
+		logger:     logger,
+		report:     Report{Discrepancies: make([]Discrepancy, 0)},
+		suspected:  make(map[string]Discrepancy),
+		confirmed:  make(map[string]Discrepancy),
+		paused:     make(map[string][]string),
+	}
+}
+
+// OnEvent - подписка на появление и исчезновение расхождений; задается до Run
+func (r *Reconciler) OnEvent(observer func(Event)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.observers = append(r.observers, observer)
+}
+
+// Report - результат последней сверки
+func (r *Reconciler) Report() Report {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.report
+}
+
+// Run - сверка счетов accounts с интервалом из настроек до отмены контекста
+func (r *Reconciler) Run(ctx context.Context, accounts []string) {
+	if !r.cfg.Enabled || r.cfg.Interval <= 0 {
+		r.logger.Info("Reconciliation is disabled")
+		return
+	}
+
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Check(ctx, accounts)
+		}
+	}
+}
+
+// Check - сверка счетов accounts, возвращает отчет с подтвержденными расхождениями
+func (r *Reconciler) Check(ctx context.Context, accounts []string) Report {
+	// Сверка не должна занимать квоту заявок, нужную ботам и API
+	ctx = broker.WithPriority(ctx, broker.PriorityLow)
+	now := time.Now()
+
+	found := make(map[string]Discrepancy)
+	var errs []string
+	liveBots := r.liveBots()
+	for _, accountID := range accounts {
+		discrepancies, err := r.checkAccount(ctx, accountID, liveBots[accountID])
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("account %s: %v", accountID, err))
+			// Расхождения счета, который не удалось проверить, остаются прежними
+			r.mu.RLock()
+			for key, d := range r.suspected {
+				if d.AccountID == accountID {
+					found[key] = d
+				}
+			}
+			r.mu.RUnlock()
+			continue
+		}
+		for _, d := range discrepancies {
+			d.DetectedAt = now
+			found[d.key()] = d
+		}
+	}
+
+	r.mu.Lock()
+	var events []Event
+	confirmed := make(map[string]Discrepancy)
+	for key, d := range found {
+		previous, seen := r.suspected[key]
+		if !seen {
+			continue
+		}
+		d.DetectedAt = previous.DetectedAt
+		confirmed[key] = d
+		if _, known := r.confirmed[key]; !known {
+			events = append(events, Event{Action: "detected", Discrepancy: d})
+		}
+	}
+	for key, d := range r.confirmed {
+		if _, still := confirmed[key]; !still {
+			events = append(events, Event{Action: "resolved", Discrepancy: d})
+			delete(r.paused, key)
+		}
+	}
+	r.suspected = found
+	r.confirmed = confirmed
+	observers := r.observers
+	r.mu.Unlock()
+
+	for _, event := range events {
+		d := event.Discrepancy
+		if event.Action == "detected" {
+			r.logger.Warnf("Reconciliation: %s", d.Message)
+			if r.cfg.PauseBots {
+				r.pauseBots(d)
+			}
+		} else {
+			r.logger.Infof("Reconciliation: resolved %s", d.Message)
+		}
+		for _, observer := range observers {
+			observer(event)
+		}
+	}
+
+	report := Report{
+		CheckedAt:     now,
+		Discrepancies: make([]Discrepancy, 0, len(confirmed)),
+		Errors:        errs,
+	}
+	for _, d := range confirmed {
+		report.Discrepancies = append(report.Discrepancies, d)
+	}
+	sort.Slice(report.Discrepancies, func(i, j int) bool {
+		return report.Discrepancies[i].key() < report.Discrepancies[j].key()
+	})
+
+	r.mu.Lock()
+	for _, botIDs := range r.paused {
+		report.PausedBots = append(report.PausedBots, botIDs...)
+	}
+	sort.Strings(report.PausedBots)
+	r.report = report
+	r.mu.Unlock()
+	return report
+}
+
+// pauseBots - пауза работающих ботов расхождения; бот, возобновленный
+// оператором, повторно из-за того же расхождения не останавливается
+func (r *Reconciler) pauseBots(d Discrepancy) {
+	var paused []string
+	for _, botID := range d.BotIDs {
+		bot, exists := r.botManager.GetBot(botID)
+		if !exists || bot.State() != bots.BotStateRunning {
+			continue
+		}
+		if err := bot.Pause(); err != nil {
+			r.logger.Errorf("Reconciliation: failed to pause bot %s: %v", botID, err)
+			continue
+		}
+		r.logger.Warnf("Reconciliation: bot %s paused until resumed by operator", botID)
+		paused = append(paused, botID)
+	}
+	if len(paused) == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	r.paused[d.key()] = paused
+	r.mu.Unlock()
+}
+
+// liveBots - live-боты по счетам; у бумажных ботов виртуальные счета
+func (r *Reconciler) liveBots() map[string][]*bots.Bot {
+	byAccount := make(map[string][]*bots.Bot)
+	for id, cfg := range r.botManager.GetBots() {
+		if cfg.ExecutionMode != "" && cfg.ExecutionMode != bots.ExecutionModeLive {
+			continue
+		}
+		if bot, exists := r.botManager.GetBot(id); exists {
+			byAccount[cfg.AccountID] = append(byAccount[cfg.AccountID], bot)
+		}
+	}
+	return byAccount
+}
+
+// checkAccount - расхождения одного счета
+func (r *Reconciler) checkAccount(ctx context.Context, accountID string, accountBots []*bots.Bot) ([]Discrepancy, error) {
+	active, err := r.orders.GetOrders(ctx, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get orders: %w", err)
+	}
+	positions, err := r.portfolio.GetPositions(ctx, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get positions: %w", err)
+	}
+	journal, err := r.store.ListOrders(ctx, storage.OrderFilter{
+		AccountID: accountID,
+		Statuses:  storage.OpenOrderStatuses,
+		From:      time.Now().Add(-24 * time.Hour),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	discrepancies := r.checkOrders(ctx, accountID, accountBots, active, journal)
+	positionDiscrepancies := checkPositions(accountID, accountBots, positions)
+	if len(positionDiscrepancies) > 0 {
+		// Стоимость расхождения помогает решить, с какого начинать
+		if portfolio, err := r.portfolio.GetPortfolio(ctx, accountID); err == nil {
+			prices := make(map[string]decimal.Decimal, len(portfolio.GetPositions()))
+			for _, pos := range portfolio.GetPositions() {
+				prices[pos.GetFigi()] = money.FromMoneyValue(pos.GetCurrentPrice())
+				prices[pos.GetInstrumentUid()] = money.FromMoneyValue(pos.GetCurrentPrice())
+			}
+			for i, d := range positionDiscrepancies {
+				price, exists := prices[d.InstrumentID]
+				if !exists {
+					continue
+				}
+				value := price.Mul(decimal.NewFromInt(d.Expected - d.Actual)).Abs()
+				positionDiscrepancies[i].Value = &value
+			}
+		}
+	}
+	return append(discrepancies, positionDiscrepancies...), nil
+}
+
+// checkOrders - сверка заявок ботов и журнала с активными заявками брокера
+func (r *Reconciler) checkOrders(ctx context.Context, accountID string, accountBots []*bots.Bot, active []*pb.OrderState, journal []storage.OrderRecord) []Discrepancy {
+	activeIDs := make(map[string]bool, len(active))
+	for _, state := range active {
+		activeIDs[state.GetOrderId()] = true
+	}
+
+	var discrepancies []Discrepancy
+	known := make(map[string]bool)
+	for _, bot := range accountBots {
+		for _, order := range bot.OpenOrders() {
+			known[order.OrderID] = true
+			if activeIDs[order.OrderID] {
+				continue
+			}
+			discrepancies = append(discrepancies, Discrepancy{
+				Kind:         KindBotOrder,
+				AccountID:    accountID,
+				InstrumentID: order.InstrumentID,
+				OrderID:      order.OrderID,
+				BotIDs:       []string{bot.ID()},
+				Message:      fmt.Sprintf("bot %s waits for order %s which is not active at the broker", bot.ID(), order.OrderID),
+			})
+		}
+	}
+
+	for _, record := range journal {
+		known[record.OrderID] = true
+		if activeIDs[record.OrderID] {
+			continue
+		}
+		d := Discrepancy{
+			Kind:         KindJournalOrder,
+			AccountID:    accountID,
+			InstrumentID: record.InstrumentID,
+			OrderID:      record.OrderID,
+			Message:      fmt.Sprintf("order %s is %s in the journal but not active at the broker", record.OrderID, record.Status),
+		}
+		if record.BotID != "" {
+			d.BotIDs = []string{record.BotID}
+		}
+		discrepancies = append(discrepancies, d)
+	}
+
+	for _, state := range active {
+		if known[state.GetOrderId()] {
+			continue
+		}
+		// Заявка из журнала, которую журнал уже считает завершенной, тоже не чужая
+		if record, err := r.store.GetOrder(ctx, state.GetOrderId()); err == nil && record != nil {
+			continue
+		}
+		discrepancies = append(discrepancies, Discrepancy{
+			Kind:         KindUnknownOrder,
+			AccountID:    accountID,
+			InstrumentID: state.GetFigi(),
+			OrderID:      state.GetOrderId(),
+			BotIDs:       botsTrading(accountBots, state.GetFigi(), state.GetInstrumentUid()),
+			Message:      fmt.Sprintf("order %s for %s was not placed through the server", state.GetOrderId(), state.GetFigi()),
+		})
+	}
+	return discrepancies
+}
+
+// checkPositions - суммарные позиции ботов должны быть покрыты позицией счета того же знака
+func checkPositions(accountID string, accountBots []*bots.Bot, positions *pb.PositionsResponse) []Discrepancy {
+	held := make(map[string]int64)
+	for _, security := range positions.GetSecurities() {
+		balance := security.GetBalance() + security.GetBlocked()
+		held[security.GetFigi()] = balance
+		if uid := security.GetInstrumentUid(); uid != "" {
+			held[uid] = balance
+		}
+	}
+	for _, future := range positions.GetFutures() {
+		balance := future.GetBalance() + future.GetBlocked()
+		held[future.GetFigi()] = balance
+		if uid := future.GetInstrumentUid(); uid != "" {
+			held[uid] = balance
+		}
+	}
+
+	expected := make(map[string]int64)
+	holders := make(map[string][]string)
+	for _, bot := range accountBots {
+		for instrumentID, pos := range bot.Positions() {
+			if pos.Quantity == 0 {
+				continue
+			}
+			expected[instrumentID] += pos.Quantity
+			holders[instrumentID] = append(holders[instrumentID], bot.ID())
+		}
+	}
+
+	var discrepancies []Discrepancy
+	for instrumentID, quantity := range expected {
+		actual := held[instrumentID]
+		if (quantity > 0 && actual >= quantity) || (quantity < 0 && actual <= quantity) {
+			continue
+		}
+		botIDs := holders[instrumentID]
+		sort.Strings(botIDs)
+		discrepancies = append(discrepancies, Discrepancy{
+			Kind:         KindPosition,
+			AccountID:    accountID,
+			InstrumentID: instrumentID,
+			BotIDs:       botIDs,
+			Expected:     quantity,
+			Actual:       actual,
+			Message:      fmt.Sprintf("position %s on account %s: bots hold %d, account holds %d", instrumentID, accountID, quantity, actual),
+		})
+	}
+	return discrepancies
+}
+
+// botsTrading - боты, торгующие инструментом по конфигурации
+func botsTrading(accountBots []*bots.Bot, ids ...string) []string {
+	var botIDs []string
+	for _, bot := range accountBots {
+		for _, instrumentID := range bot.Config().Instruments {
+			if instrumentID != "" && (instrumentID == ids[0] || instrumentID == ids[1]) {
+				botIDs = append(botIDs, bot.ID())
+				break
+			}
+		}
+	}
+	return botIDs
+}
@@ -0,0 +1,423 @@
+package risk
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+	"go.uber.org/zap"
+
+	"trading-bot-web/bots"
+	"trading-bot-web/config"
+)
+
+// Code - код причины отклонения заявки
+type Code string
+
+const (
+	CodeMaxOrderAmount   Code = "max_order_amount"
+	CodeOrderRate        Code = "max_orders_per_minute"
+	CodeMaxPositions     Code = "max_positions"
+	CodeDailyLoss        Code = "max_loss_per_day"
+	CodePriceUnavailable Code = "price_unavailable"
+	CodeKillSwitch       Code = "kill_switch"
+)
+
+// RejectError - отклонение заявки риск-движком
+type RejectError struct {
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RejectError) Error() string {
+	return fmt.Sprintf("order rejected by risk engine (%s): %s", e.Code, e.Message)
+}
+
+// reject - создание ошибки отклонения
+func reject(code Code, format string, args ...any) *RejectError {
+	return &RejectError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Limits - лимиты риск-движка, нулевое значение отключает проверку
+type Limits struct {
+	MaxOrderAmount     decimal.Decimal `json:"max_order_amount"`
+	MaxOrdersPerMinute int             `json:"max_orders_per_minute"`
+	MaxPositions       int             `json:"max_positions"`
+	MaxLossPerDay      decimal.Decimal `json:"max_loss_per_day"`
+	StopLossPercent    decimal.Decimal `json:"stop_loss_percent"`
+	TakeProfitPercent  decimal.Decimal `json:"take_profit_percent"`
+	// AutoClose - закрытие позиций по уровням выхода, см. Gateway.WatchExits
+	AutoClose bool `json:"auto_close"`
+}
+
+// ExitLevels - уровни стоп-лосса и тейк-профита для позиции, открытой по price.
+// Для короткой позиции long = false. Нулевой уровень - выход отключен.
+func (l Limits) ExitLevels(price decimal.Decimal, long bool) (stopLoss, takeProfit decimal.Decimal) {
+	// offset - отступ от цены входа на percent процентов в сторону прибыли
+	offset := func(percent decimal.Decimal) decimal.Decimal {
+		delta := price.Mul(percent).Div(decimal.NewFromInt(100))
+		if !long {
+			delta = delta.Neg()
+		}
+		return delta
+	}
+	if l.StopLossPercent.IsPositive() {
+		stopLoss = price.Sub(offset(l.StopLossPercent))
+	}
+	if l.TakeProfitPercent.IsPositive() {
+		takeProfit = price.Add(offset(l.TakeProfitPercent))
+	}
+	return stopLoss, takeProfit
+}
+
+// LimitsFromConfig - лимиты из разделов trading.limits и trading.risk_management.
+// Лимиты risk_management действуют только при enabled: true.
+func LimitsFromConfig(cfg config.TradingConfig) Limits {
+	limits := Limits{
+		MaxOrderAmount:     decimal.NewFromFloat(cfg.Limits.MaxOrderAmount),
+		MaxOrdersPerMinute: cfg.Limits.MaxOrdersPerMinute,
+		MaxPositions:       cfg.Limits.MaxPositions,
+	}
+	if cfg.RiskManagement.Enabled {
+		limits.MaxLossPerDay = decimal.NewFromFloat(cfg.RiskManagement.MaxLossPerDay)
+		limits.StopLossPercent = decimal.NewFromFloat(cfg.RiskManagement.StopLossPercent)
+		limits.TakeProfitPercent = decimal.NewFromFloat(cfg.RiskManagement.TakeProfitPercent)
+		limits.AutoClose = cfg.RiskManagement.AutoClose
+	}
+	return limits
+}
+
+// Order - заявка в терминах риск-движка
+type Order struct {
+	AccountID    string
+	InstrumentID string
+	Direction    pb.OrderDirection
+	// Quantity - количество в штуках
+	Quantity int64
+	// Price - оценка цены за штуку, 0 если неизвестна
+	Price decimal.Decimal
+	// OpenPositions - инструменты с ненулевой позицией на счете у брокера
+	OpenPositions map[string]bool
+}
+
+// DailyResult - реализованный результат счета за день
+type DailyResult struct {
+	AccountID   string          `json:"account_id"`
+	Day         string          `json:"day"`
+	RealizedPnL decimal.Decimal `json:"realized_pnl"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// Store - хранение дневных результатов, чтобы лимит убытка действовал после перезапуска
+type Store interface {
+	// SaveDailyResult - сохранение результата или обновление сохраненного за тот же день
+	SaveDailyResult(ctx context.Context, result DailyResult) error
+	// ListDailyResults - результаты всех счетов за день в формате 2006-01-02
+	ListDailyResults(ctx context.Context, day string) ([]DailyResult, error)
+}
+
+// Engine - предторговые проверки и учет дневного убытка по счетам
+type Engine struct {
+	limits Limits
+	logger *zap.SugaredLogger
+	now    func() time.Time
+	store  Store
+
+	mu       sync.Mutex
+	accounts map[string]*accountState
+
+	// saveMu - последовательное сохранение, чтобы последним записался актуальный результат
+	saveMu sync.Mutex
+}
+
+// accountState - состояние счета для проверок
+type accountState struct {
+	day      string
+	realized decimal.Decimal
+	orders   []time.Time
+	ledger   *bots.Ledger
+}
+
+// NewEngine - создание риск-движка
+func NewEngine(limits Limits, logger *zap.SugaredLogger) *Engine {
+	return &Engine{
+		limits:   limits,
+		logger:   logger,
+		now:      time.Now,
+		accounts: make(map[string]*accountState),
+	}
+}
+
+// Limits - действующие лимиты
+func (e *Engine) Limits() Limits {
+	return e.limits
+}
+
+// SetStore - хранилище дневных результатов; задается до первой заявки
+func (e *Engine) SetStore(store Store) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.store = store
+}
+
+// Restore - загрузка результатов текущего дня из хранилища
+func (e *Engine) Restore(ctx context.Context) error {
+	if e.store == nil {
+		return nil
+	}
+	day := e.now().Format("2006-01-02")
+	results, err := e.store.ListDailyResults(ctx, day)
+	if err != nil {
+		return fmt.Errorf("failed to load daily results: %w", err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, result := range results {
+		acc := e.account(result.AccountID, e.now())
+		acc.realized = result.RealizedPnL
+		if e.lossLimitReached(acc) {
+			e.logger.Warnf("Account %s has reached daily loss limit before restart: %s", result.AccountID, acc.realized.Neg())
+		}
+	}
+	return nil
+}
+
+// Check - проверка заявки; принятая заявка учитывается в лимите заявок в минуту
+func (e *Engine) Check(order Order) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := e.now()
+	acc := e.account(order.AccountID, now)
+	reducing := acc.reduces(order)
+
+	if e.limits.MaxOrderAmount.IsPositive() && !reducing {
+		if !order.Price.IsPositive() {
+			return reject(CodePriceUnavailable, "no price to evaluate order amount for %s", order.InstrumentID)
+		}
+		if amount := order.Price.Mul(decimal.NewFromInt(order.Quantity)); amount.GreaterThan(e.limits.MaxOrderAmount) {
+			return reject(CodeMaxOrderAmount, "order amount %s exceeds limit %s", amount, e.limits.MaxOrderAmount)
+		}
+	}
+
+	if !reducing && e.lossLimitReached(acc) {
+		return reject(CodeDailyLoss, "daily loss %s reached limit %s, only closing orders are allowed", acc.realized.Neg(), e.limits.MaxLossPerDay)
+	}
+
+	if e.limits.MaxPositions > 0 && !order.OpenPositions[order.InstrumentID] {
+		if _, tracked := acc.ledger.Position(order.InstrumentID); !tracked && len(order.OpenPositions) >= e.limits.MaxPositions {
+			return reject(CodeMaxPositions, "%d open positions, limit %d", len(order.OpenPositions), e.limits.MaxPositions)
+		}
+	}
+
+	if e.limits.MaxOrdersPerMinute > 0 {
+		acc.pruneOrders(now)
+		if len(acc.orders) >= e.limits.MaxOrdersPerMinute {
+			return reject(CodeOrderRate, "%d orders in the last minute, limit %d", len(acc.orders), e.limits.MaxOrdersPerMinute)
+		}
+	}
+	acc.orders = append(acc.orders, now)
+	return nil
+}
+
+// RecordFill - учет исполнения для дневного убытка и позиций
+func (e *Engine) RecordFill(accountID string, fill bots.Fill) {
+	e.mu.Lock()
+	acc := e.account(accountID, e.now())
+	realized := acc.ledger.Apply(fill)
+	acc.realized = acc.realized.Add(realized)
+
+	if realized.IsNegative() && e.lossLimitReached(acc) {
+		e.logger.Warnf("Account %s reached daily loss limit: %s", accountID, acc.realized.Neg())
+	}
+	e.mu.Unlock()
+
+	if !realized.IsZero() {
+		e.save(accountID)
+	}
+}
+
+// save - сохранение дневного результата счета, вызывается без блокировки e.mu
+func (e *Engine) save(accountID string) {
+	if e.store == nil {
+		return
+	}
+	e.saveMu.Lock()
+	defer e.saveMu.Unlock()
+
+	e.mu.Lock()
+	acc := e.account(accountID, e.now())
+	result := DailyResult{AccountID: accountID, Day: acc.day, RealizedPnL: acc.realized, UpdatedAt: e.now()}
+	e.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.store.SaveDailyResult(ctx, result); err != nil {
+		e.logger.Errorf("Failed to save daily result of %s: %v", accountID, err)
+	}
+}
+
+// lossLimitReached - дневной убыток счета достиг лимита, вызывается под блокировкой
+func (e *Engine) lossLimitReached(acc *accountState) bool {
+	return e.limits.MaxLossPerDay.IsPositive() && acc.realized.Neg().GreaterThanOrEqual(e.limits.MaxLossPerDay)
+}
+
+// PositionRisk - позиция с уровнями стоп-лосса и тейк-профита
+type PositionRisk struct {
+	bots.Position
+	StopLossPrice   decimal.Decimal `json:"stop_loss_price"`
+	TakeProfitPrice decimal.Decimal `json:"take_profit_price"`
+}
+
+// Snapshot - состояние рисков счета
+type Snapshot struct {
+	AccountID             string          `json:"account_id"`
+	Day                   string          `json:"day"`
+	RealizedPnL           decimal.Decimal `json:"realized_pnl"`
+	DailyLossLimitReached bool            `json:"daily_loss_limit_reached"`
+	OrdersLastMinute      int             `json:"orders_last_minute"`
+	Positions             []PositionRisk  `json:"positions"`
+	Limits                Limits          `json:"limits"`
+}
+
+// Snapshot - текущее состояние рисков счета
+func (e *Engine) Snapshot(accountID string) Snapshot {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := e.now()
+	acc := e.account(accountID, now)
+	acc.pruneOrders(now)
+
+	snapshot := Snapshot{
+		AccountID:             accountID,
+		Day:                   acc.day,
+		RealizedPnL:           acc.realized,
+		DailyLossLimitReached: e.lossLimitReached(acc),
+		OrdersLastMinute:      len(acc.orders),
+		Positions:             e.positions(acc),
+		Limits:                e.limits,
+	}
+	return snapshot
+}
+
+// Exit - позиция, достигшая уровня стоп-лосса или тейк-профита
+type Exit struct {
+	AccountID string
+	Position  bots.Position
+	Reason    string
+	Price     decimal.Decimal
+}
+
+// Exits - позиции, которые нужно закрыть по текущим ценам в валюте
+func (e *Engine) Exits(prices map[string]decimal.Decimal) []Exit {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var exits []Exit
+	for _, accountID := range e.accountIDs() {
+		for _, pos := range e.positions(e.accounts[accountID]) {
+			price, exists := prices[pos.InstrumentID]
+			if !exists || !price.IsPositive() {
+				continue
+			}
+			long := pos.Quantity > 0
+			switch {
+			case pos.StopLossPrice.IsPositive() && ((long && price.LessThanOrEqual(pos.StopLossPrice)) || (!long && price.GreaterThanOrEqual(pos.StopLossPrice))):
+				exits = append(exits, Exit{AccountID: accountID, Position: pos.Position, Reason: "stop_loss", Price: price})
+			case pos.TakeProfitPrice.IsPositive() && ((long && price.GreaterThanOrEqual(pos.TakeProfitPrice)) || (!long && price.LessThanOrEqual(pos.TakeProfitPrice))):
+				exits = append(exits, Exit{AccountID: accountID, Position: pos.Position, Reason: "take_profit", Price: price})
+			}
+		}
+	}
+	return exits
+}
+
+// TrackedInstruments - инструменты открытых позиций по всем счетам
+func (e *Engine) TrackedInstruments() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	seen := make(map[string]bool)
+	var instruments []string
+	for _, acc := range e.accounts {
+		for id := range acc.ledger.Stats().CurrentPositions {
+			if !seen[id] {
+				seen[id] = true
+				instruments = append(instruments, id)
+			}
+		}
+	}
+	sort.Strings(instruments)
+	return instruments
+}
+
+// account - состояние счета со сбросом дневных показателей, вызывается под блокировкой
+func (e *Engine) account(accountID string, now time.Time) *accountState {
+	day := now.Format("2006-01-02")
+	acc, exists := e.accounts[accountID]
+	if !exists {
+		acc = &accountState{day: day, ledger: bots.NewLedger()}
+		e.accounts[accountID] = acc
+	}
+	if acc.day != day {
+		acc.day = day
+		acc.realized = decimal.Zero
+	}
+	return acc
+}
+
+// accountIDs - счета в стабильном порядке, вызывается под блокировкой
+func (e *Engine) accountIDs() []string {
+	ids := make([]string, 0, len(e.accounts))
+	for id := range e.accounts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// positions - позиции счета с уровнями выхода, вызывается под блокировкой
+func (e *Engine) positions(acc *accountState) []PositionRisk {
+	current := acc.ledger.Stats().CurrentPositions
+	ids := make([]string, 0, len(current))
+	for id := range current {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	positions := make([]PositionRisk, 0, len(ids))
+	for _, id := range ids {
+		pos := PositionRisk{Position: current[id]}
+		pos.StopLossPrice, pos.TakeProfitPrice = e.limits.ExitLevels(pos.AveragePrice, pos.Quantity >= 0)
+		positions = append(positions, pos)
+	}
+	return positions
+}
+
+// reduces - заявка только сокращает позицию, учтенную движком
+func (a *accountState) reduces(order Order) bool {
+	pos, exists := a.ledger.Position(order.InstrumentID)
+	if !exists {
+		return false
+	}
+	if order.Direction == pb.OrderDirection_ORDER_DIRECTION_SELL {
+		return pos.Quantity > 0 && order.Quantity <= pos.Quantity
+	}
+	return pos.Quantity < 0 && order.Quantity <= -pos.Quantity
+}
+
+// pruneOrders - удаление заявок старше минуты
+func (a *accountState) pruneOrders(now time.Time) {
+	cutoff := now.Add(-time.Minute)
+	i := 0
+	for i < len(a.orders) && !a.orders[i].After(cutoff) {
+		i++
+	}
+	a.orders = a.orders[i:]
+}
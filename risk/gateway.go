@@ -0,0 +1,427 @@
+package risk
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+	"go.uber.org/zap"
+
+	"trading-bot-web/bots"
+	"trading-bot-web/broker"
+	"trading-bot-web/money"
+)
+
+// Gateway - брокер, пропускающий каждую заявку через аварийную блокировку и риск-движок.
+// Остальные методы брокера вызываются без изменений.
+type Gateway struct {
+	broker.Broker
+	engine     *Engine
+	killSwitch *KillSwitch
+	logger     *zap.SugaredLogger
+
+	mu   sync.Mutex
+	lots map[string]int64
+	// exiting - время последней заявки на закрытие по счету и инструменту
+	exiting map[string]time.Time
+}
+
+// NewGateway - обертка брокера риск-движком
+func NewGateway(next broker.Broker, engine *Engine, killSwitch *KillSwitch, logger *zap.SugaredLogger) *Gateway {
+	return &Gateway{
+		Broker:     next,
+		engine:     engine,
+		killSwitch: killSwitch,
+		logger:     logger,
+		lots:       make(map[string]int64),
+		exiting:    make(map[string]time.Time),
+	}
+}
+
+// Engine - риск-движок шлюза
+func (g *Gateway) Engine() *Engine {
+	return g.engine
+}
+
+// KillSwitch - аварийная блокировка шлюза
+func (g *Gateway) KillSwitch() *KillSwitch {
+	return g.killSwitch
+}
+
+// PostOrder - проверка заявки и передача брокеру
+func (g *Gateway) PostOrder(ctx context.Context, req broker.OrderRequest) (*pb.PostOrderResponse, error) {
+	lot, err := g.check(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := g.Broker.PostOrder(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	g.recordExecution(req, resp, lot)
+	return resp, nil
+}
+
+// ReplaceOrder - проверка заявки с новыми количеством и ценой и передача брокеру
+func (g *Gateway) ReplaceOrder(ctx context.Context, req broker.ReplaceRequest) (*pb.PostOrderResponse, error) {
+	state, err := g.Broker.GetOrderState(ctx, req.AccountID, req.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	order := broker.OrderRequest{
+		AccountID:    req.AccountID,
+		InstrumentID: state.GetFigi(),
+		Direction:    state.GetDirection(),
+		OrderType:    state.GetOrderType(),
+		Lots:         req.Lots,
+		Price:        req.Price,
+	}
+	lot, err := g.check(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := g.Broker.ReplaceOrder(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	g.recordExecution(order, resp, lot)
+	return resp, nil
+}
+
+// check - аварийная блокировка и лимиты риск-движка, возвращает размер лота
+func (g *Gateway) check(ctx context.Context, req broker.OrderRequest) (int64, error) {
+	if err := haltError(g.killSwitch, req.AccountID); err != nil {
+		return 0, err
+	}
+
+	lot := g.lotSize(ctx, req.InstrumentID)
+
+	order := Order{
+		AccountID:    req.AccountID,
+		InstrumentID: req.InstrumentID,
+		Direction:    req.Direction,
+		Quantity:     req.Lots * lot,
+	}
+	if g.engine.Limits().MaxOrderAmount.IsPositive() {
+		order.Price = g.estimatePrice(ctx, req)
+	}
+	if g.engine.Limits().MaxPositions > 0 {
+		openPositions, err := g.openPositions(ctx, req.AccountID)
+		if err != nil {
+			return 0, err
+		}
+		order.OpenPositions = openPositions
+	}
+
+	if err := g.engine.Check(order); err != nil {
+		g.logger.Warnf("Order for %s on account %s rejected: %v", req.InstrumentID, req.AccountID, err)
+		return 0, err
+	}
+	return lot, nil
+}
+
+// haltError - отклонение заявки, если торговля по счету остановлена аварийной блокировкой
+func haltError(killSwitch *KillSwitch, accountID string) error {
+	if halt, halted := killSwitch.Halted(accountID); halted {
+		return reject(CodeKillSwitch, "trading is halted since %s: %s", halt.EngagedAt.Format(time.RFC3339), halt.Reason)
+	}
+	return nil
+}
+
+// WatchExits - периодическое закрытие позиций по стоп-лоссу и тейк-профиту
+// рыночными заявками до отмены контекста. Работает только с auto_close.
+func (g *Gateway) WatchExits(ctx context.Context, interval time.Duration) {
+	limits := g.engine.Limits()
+	if !limits.AutoClose || (!limits.StopLossPercent.IsPositive() && !limits.TakeProfitPercent.IsPositive()) {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			g.checkExits(ctx)
+		}
+	}
+}
+
+// checkExits - закрытие позиций, достигших уровней выхода. Позиции,
+// защищенные стоп-заявками у брокера, закрывает брокер.
+func (g *Gateway) checkExits(ctx context.Context) {
+	instruments := g.engine.TrackedInstruments()
+	if len(instruments) == 0 {
+		return
+	}
+
+	lastPrices, err := g.Broker.GetLastPrices(ctx, instruments)
+	if err != nil {
+		g.logger.Errorf("Failed to get prices for exit check: %v", err)
+		return
+	}
+	// Средняя цена позиции в валюте, последняя цена - в пунктах котировки
+	prices := make(map[string]decimal.Decimal, len(lastPrices))
+	for _, price := range lastPrices {
+		pointValue, err := g.Broker.PointValue(ctx, price.GetFigi())
+		if err != nil || !pointValue.IsPositive() {
+			g.logger.Errorf("No point value for %s, exit check skipped: %v", price.GetFigi(), err)
+			continue
+		}
+		prices[price.GetFigi()] = money.FromQuotation(price.GetPrice()).Mul(pointValue)
+	}
+
+	protected := make(map[string]map[string]bool)
+	for _, exit := range g.engine.Exits(prices) {
+		stops, checked := protected[exit.AccountID]
+		if !checked {
+			if stops, err = g.stopProtected(ctx, exit.AccountID); err != nil {
+				g.logger.Errorf("Failed to get stop orders of %s for exit check: %v", exit.AccountID, err)
+			}
+			protected[exit.AccountID] = stops
+		}
+		// Без списка стоп-заявок неизвестно, закроет ли позицию брокер
+		if stops == nil || stops[exit.Position.InstrumentID] {
+			continue
+		}
+
+		direction := pb.OrderDirection_ORDER_DIRECTION_SELL
+		quantity := exit.Position.Quantity
+		if quantity < 0 {
+			direction = pb.OrderDirection_ORDER_DIRECTION_BUY
+			quantity = -quantity
+		}
+		lot := g.lotSize(ctx, exit.Position.InstrumentID)
+
+		req := broker.OrderRequest{
+			AccountID:    exit.AccountID,
+			InstrumentID: exit.Position.InstrumentID,
+			Direction:    direction,
+			OrderType:    pb.OrderType_ORDER_TYPE_MARKET,
+			Lots:         quantity / lot,
+			Origin:       broker.OriginRisk,
+			OriginID:     exit.Reason,
+		}
+		if _, halted := g.killSwitch.Halted(req.AccountID); halted {
+			continue
+		}
+		if req.Lots < 1 || !g.startExit(req.AccountID, req.InstrumentID) {
+			continue
+		}
+
+		g.logger.Warnf("Closing %s on account %s by %s at %s", req.InstrumentID, req.AccountID, exit.Reason, exit.Price)
+		resp, err := g.Broker.PostOrder(ctx, req)
+		if err != nil {
+			g.logger.Errorf("Failed to close %s on account %s: %v", req.InstrumentID, req.AccountID, err)
+			continue
+		}
+		g.recordExecution(req, resp, lot)
+	}
+}
+
+// stopProtected - инструменты счета с активными стоп-заявками
+func (g *Gateway) stopProtected(ctx context.Context, accountID string) (map[string]bool, error) {
+	stopOrders, err := g.Broker.GetStopOrders(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	protected := make(map[string]bool, len(stopOrders))
+	for _, stopOrder := range stopOrders {
+		protected[stopOrder.GetFigi()] = true
+		protected[stopOrder.GetInstrumentUid()] = true
+	}
+	return protected, nil
+}
+
+// LiquidationReport - результат отмены заявок и закрытия позиций счета
+type LiquidationReport struct {
+	AccountID           string   `json:"account_id"`
+	CancelledOrders     []string `json:"cancelled_orders"`
+	CancelledStopOrders []string `json:"cancelled_stop_orders,omitempty"`
+	ClosedPositions     []string `json:"closed_positions,omitempty"`
+	Errors              []string `json:"errors,omitempty"`
+}
+
+// Liquidate - отмена всех активных заявок и стоп-заявок счета и, если flatten, закрытие позиций
+// рыночными заявками в обход блокировки и лимитов
+func (g *Gateway) Liquidate(ctx context.Context, accountID string, flatten bool) LiquidationReport {
+	report := LiquidationReport{AccountID: accountID, CancelledOrders: make([]string, 0)}
+	fail := func(format string, args ...any) {
+		msg := fmt.Sprintf(format, args...)
+		g.logger.Errorf("Liquidation of %s: %s", accountID, msg)
+		report.Errors = append(report.Errors, msg)
+	}
+
+	orders, err := g.Broker.GetOrders(ctx, accountID)
+	if err != nil {
+		fail("failed to get orders: %v", err)
+	}
+	for _, order := range orders {
+		if _, err := g.Broker.CancelOrder(ctx, accountID, order.GetOrderId()); err != nil {
+			fail("failed to cancel order %s: %v", order.GetOrderId(), err)
+			continue
+		}
+		report.CancelledOrders = append(report.CancelledOrders, order.GetOrderId())
+	}
+
+	// Сработавшая позже стоп-заявка снова открыла бы позицию
+	stopOrders, err := g.Broker.GetStopOrders(ctx, accountID)
+	if err != nil {
+		fail("failed to get stop orders: %v", err)
+	}
+	for _, stopOrder := range stopOrders {
+		if _, err := g.Broker.CancelStopOrder(ctx, accountID, stopOrder.GetStopOrderId()); err != nil {
+			fail("failed to cancel stop order %s: %v", stopOrder.GetStopOrderId(), err)
+			continue
+		}
+		report.CancelledStopOrders = append(report.CancelledStopOrders, stopOrder.GetStopOrderId())
+	}
+
+	if !flatten {
+		return report
+	}
+
+	positions, err := g.Broker.GetPositions(ctx, accountID)
+	if err != nil {
+		fail("failed to get positions: %v", err)
+		return report
+	}
+	for _, security := range positions.GetSecurities() {
+		balance := security.GetBalance()
+		if balance == 0 {
+			continue
+		}
+		direction := pb.OrderDirection_ORDER_DIRECTION_SELL
+		if balance < 0 {
+			direction = pb.OrderDirection_ORDER_DIRECTION_BUY
+			balance = -balance
+		}
+		lot := g.lotSize(ctx, security.GetFigi())
+
+		req := broker.OrderRequest{
+			AccountID:    accountID,
+			InstrumentID: security.GetFigi(),
+			Direction:    direction,
+			OrderType:    pb.OrderType_ORDER_TYPE_MARKET,
+			Lots:         balance / lot,
+			Origin:       broker.OriginRisk,
+			OriginID:     "liquidation",
+		}
+		if req.Lots < 1 {
+			continue
+		}
+		resp, err := g.Broker.PostOrder(ctx, req)
+		if err != nil {
+			fail("failed to close %s: %v", req.InstrumentID, err)
+			continue
+		}
+		g.recordExecution(req, resp, lot)
+		report.ClosedPositions = append(report.ClosedPositions, req.InstrumentID)
+	}
+	return report
+}
+
+// startExit - защита от повторного закрытия, пока предыдущая заявка не исполнилась
+func (g *Gateway) startExit(accountID, instrumentID string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key := accountID + "/" + instrumentID
+	if last, exists := g.exiting[key]; exists && time.Since(last) < time.Minute {
+		return false
+	}
+	g.exiting[key] = time.Now()
+	return true
+}
+
+// RecordFill - учет исполнения, пришедшего после ответа на заявку, например
+// из журнала заявок. Количество в штуках считается по лоту инструмента.
+func (g *Gateway) RecordFill(ctx context.Context, accountID string, fill bots.Fill) {
+	if fill.Quantity == 0 {
+		fill.Quantity = fill.Lots * g.lotSize(ctx, fill.InstrumentID)
+	}
+	g.engine.RecordFill(accountID, fill)
+}
+
+// recordExecution - учет немедленно исполненной части заявки,
+// дальнейшее исполнение приходит через RecordFill
+func (g *Gateway) recordExecution(req broker.OrderRequest, resp *pb.PostOrderResponse, lot int64) {
+	if resp.GetLotsExecuted() == 0 {
+		return
+	}
+	g.engine.RecordFill(req.AccountID, bots.Fill{
+		OrderID:      resp.GetOrderId(),
+		InstrumentID: req.InstrumentID,
+		Direction:    req.Direction,
+		Lots:         resp.GetLotsExecuted(),
+		Quantity:     resp.GetLotsExecuted() * lot,
+		Price:        money.FromMoneyValue(resp.GetExecutedOrderPrice()),
+		Commission:   money.FromMoneyValue(resp.GetExecutedCommission()),
+		Time:         time.Now(),
+	})
+}
+
+// estimatePrice - цена за штуку в валюте для лимита суммы заявки: цена лимитной
+// заявки или последняя цена для рыночной, переведенные из пунктов котировки.
+// Ноль, если цену оценить не удалось.
+func (g *Gateway) estimatePrice(ctx context.Context, req broker.OrderRequest) decimal.Decimal {
+	price := decimal.Zero
+	if req.Price != nil {
+		price = *req.Price
+	} else {
+		prices, err := g.Broker.GetLastPrices(ctx, []string{req.InstrumentID})
+		if err != nil || len(prices) == 0 {
+			return decimal.Zero
+		}
+		price = money.FromQuotation(prices[0].GetPrice())
+	}
+
+	pointValue, err := g.Broker.PointValue(ctx, req.InstrumentID)
+	if err != nil || !pointValue.IsPositive() {
+		return decimal.Zero
+	}
+	return price.Mul(pointValue)
+}
+
+// openPositions - инструменты с ненулевым остатком на счете
+func (g *Gateway) openPositions(ctx context.Context, accountID string) (map[string]bool, error) {
+	positions, err := g.Broker.GetPositions(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	open := make(map[string]bool)
+	for _, security := range positions.GetSecurities() {
+		if security.GetBalance() != 0 {
+			open[security.GetFigi()] = true
+		}
+	}
+	return open, nil
+}
+
+// lotSize - размер лота инструмента, запрашивается один раз. Запрос к брокеру
+// идет без блокировки, чтобы медленный ответ не задерживал проверки других заявок.
+func (g *Gateway) lotSize(ctx context.Context, instrumentID string) int64 {
+	g.mu.Lock()
+	lot, exists := g.lots[instrumentID]
+	g.mu.Unlock()
+	if exists {
+		return lot
+	}
+
+	instrument, err := g.Broker.InstrumentByFigi(ctx, instrumentID)
+	if err != nil || instrument.GetLot() < 1 {
+		return 1
+	}
+	lot = int64(instrument.GetLot())
+	g.mu.Lock()
+	g.lots[instrumentID] = lot
+	g.mu.Unlock()
+	return lot
+}
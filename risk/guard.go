@@ -0,0 +1,44 @@
+package risk
+
+import (
+	"go.uber.org/zap"
+
+	"trading-bot-web/bots"
+)
+
+// Guard - аварийная блокировка и лимиты для заявок, которые исполняются
+// не брокером шлюза, например в бумажном симуляторе. Позиции и дневной
+// убыток таких счетов учитываются в отдельном риск-движке, чтобы они не
+// смешивались с реальными счетами и не закрывались через реального брокера.
+type Guard struct {
+	engine     *Engine
+	killSwitch *KillSwitch
+	logger     *zap.SugaredLogger
+}
+
+// NewGuard - проверки заявок с собственным учетом позиций
+func NewGuard(engine *Engine, killSwitch *KillSwitch, logger *zap.SugaredLogger) *Guard {
+	return &Guard{engine: engine, killSwitch: killSwitch, logger: logger}
+}
+
+// Engine - риск-движок проверок
+func (g *Guard) Engine() *Engine {
+	return g.engine
+}
+
+// Check - проверка заявки; цену и открытые позиции заполняет вызывающий
+func (g *Guard) Check(order Order) error {
+	if err := haltError(g.killSwitch, order.AccountID); err != nil {
+		return err
+	}
+	if err := g.engine.Check(order); err != nil {
+		g.logger.Warnf("Order for %s on account %s rejected: %v", order.InstrumentID, order.AccountID, err)
+		return err
+	}
+	return nil
+}
+
+// RecordFill - учет исполнения для дневного убытка и позиций
+func (g *Guard) RecordFill(accountID string, fill bots.Fill) {
+	g.engine.RecordFill(accountID, fill)
+}
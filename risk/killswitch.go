@@ -0,0 +1,127 @@
+package risk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Halt - причина и время включения аварийной блокировки
+type Halt struct {
+	Reason    string    `json:"reason"`
+	EngagedAt time.Time `json:"engaged_at"`
+}
+
+// KillSwitchState - состояние блокировки: глобальной и по счетам
+type KillSwitchState struct {
+	Global   *Halt           `json:"global,omitempty"`
+	Accounts map[string]Halt `json:"accounts"`
+}
+
+// KillSwitch - аварийная блокировка новых заявок.
+// Состояние сохраняется в файл и восстанавливается после перезапуска.
+type KillSwitch struct {
+	path string
+
+	mu    sync.RWMutex
+	state KillSwitchState
+}
+
+// NewKillSwitch - загрузка состояния блокировки из файла, отсутствие файла означает, что блокировки нет
+func NewKillSwitch(path string) (*KillSwitch, error) {
+	k := &KillSwitch{
+		path:  path,
+		state: KillSwitchState{Accounts: make(map[string]Halt)},
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return k, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kill switch state: %w", err)
+	}
+	if err := json.Unmarshal(data, &k.state); err != nil {
+		return nil, fmt.Errorf("failed to parse kill switch state: %w", err)
+	}
+	if k.state.Accounts == nil {
+		k.state.Accounts = make(map[string]Halt)
+	}
+	return k, nil
+}
+
+// Engage - включение блокировки счета, пустой accountID - глобальная блокировка
+func (k *KillSwitch) Engage(accountID, reason string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	halt := Halt{Reason: reason, EngagedAt: time.Now()}
+	if accountID == "" {
+		k.state.Global = &halt
+	} else {
+		k.state.Accounts[accountID] = halt
+	}
+	return k.save()
+}
+
+// Rearm - снятие блокировки счета, пустой accountID - снятие глобальной блокировки
+func (k *KillSwitch) Rearm(accountID string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if accountID == "" {
+		k.state.Global = nil
+	} else {
+		delete(k.state.Accounts, accountID)
+	}
+	return k.save()
+}
+
+// Halted - действующая блокировка для счета с учетом глобальной
+func (k *KillSwitch) Halted(accountID string) (Halt, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if k.state.Global != nil {
+		return *k.state.Global, true
+	}
+	halt, exists := k.state.Accounts[accountID]
+	return halt, exists
+}
+
+// State - копия текущего состояния
+func (k *KillSwitch) State() KillSwitchState {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	state := KillSwitchState{Accounts: make(map[string]Halt, len(k.state.Accounts))}
+	if k.state.Global != nil {
+		global := *k.state.Global
+		state.Global = &global
+	}
+	for id, halt := range k.state.Accounts {
+		state.Accounts[id] = halt
+	}
+	return state
+}
+
+// save - атомарная запись состояния, вызывается под блокировкой
+func (k *KillSwitch) save() error {
+	data, err := json.MarshalIndent(k.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(k.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create kill switch dir: %w", err)
+	}
+
+	tmp := k.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write kill switch state: %w", err)
+	}
+	return os.Rename(tmp, k.path)
+}
@@ -0,0 +1,104 @@
+package risk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+
+	"trading-bot-web/broker"
+	"trading-bot-web/money"
+)
+
+// AttachedStop - защитная стоп-заявка, выставленная на исполненную заявку
+type AttachedStop struct {
+	StopOrderID string `json:"stop_order_id"`
+	// Type - stop_loss или take_profit
+	Type string `json:"type"`
+	// StopPrice - цена активации в пунктах котировки
+	StopPrice decimal.Decimal `json:"stop_price"`
+	Lots      int64           `json:"lots"`
+}
+
+// PostStopOrder - стоп-заявка при включенной аварийной блокировке отклоняется
+func (g *Gateway) PostStopOrder(ctx context.Context, req broker.StopOrderRequest) (*pb.PostStopOrderResponse, error) {
+	if halt, halted := g.killSwitch.Halted(req.AccountID); halted {
+		return nil, reject(CodeKillSwitch, "trading is halted since %s: %s", halt.EngagedAt.Format(time.RFC3339), halt.Reason)
+	}
+	return g.Broker.PostStopOrder(ctx, req)
+}
+
+// AttachStops - стоп-лосс и тейк-профит на исполненную часть заявки.
+// Уровни считаются от цены исполнения по процентам trading.risk_management
+// и округляются до шага цены. Заявки независимы: после срабатывания одной
+// вторую нужно отменить отдельно.
+func (g *Gateway) AttachStops(ctx context.Context, req broker.OrderRequest, resp *pb.PostOrderResponse) ([]AttachedStop, error) {
+	attached := make([]AttachedStop, 0, 2)
+	lots := resp.GetLotsExecuted()
+	if lots == 0 {
+		return attached, fmt.Errorf("order %s is not filled yet, stop orders were not attached", resp.GetOrderId())
+	}
+
+	long := req.Direction == pb.OrderDirection_ORDER_DIRECTION_BUY
+	stopLoss, takeProfit := g.engine.Limits().ExitLevels(money.FromMoneyValue(resp.GetExecutedOrderPrice()), long)
+	if stopLoss.IsZero() && takeProfit.IsZero() {
+		return attached, errors.New("stop loss and take profit are not configured")
+	}
+
+	// Цена исполнения в валюте, стоп-цена - в пунктах котировки
+	pointValue, err := g.Broker.PointValue(ctx, req.InstrumentID)
+	if err != nil {
+		return attached, err
+	}
+	if !pointValue.IsPositive() {
+		pointValue = decimal.NewFromInt(1)
+	}
+	var step decimal.Decimal
+	if instrument, err := g.Broker.InstrumentByFigi(ctx, req.InstrumentID); err == nil {
+		step = money.FromQuotation(instrument.GetMinPriceIncrement())
+	}
+
+	// Закрытие позиции - в обратном направлении
+	direction := pb.StopOrderDirection_STOP_ORDER_DIRECTION_SELL
+	if !long {
+		direction = pb.StopOrderDirection_STOP_ORDER_DIRECTION_BUY
+	}
+
+	legs := []struct {
+		name      string
+		stopType  pb.StopOrderType
+		stopPrice decimal.Decimal
+	}{
+		{"stop_loss", pb.StopOrderType_STOP_ORDER_TYPE_STOP_LOSS, stopLoss},
+		{"take_profit", pb.StopOrderType_STOP_ORDER_TYPE_TAKE_PROFIT, takeProfit},
+	}
+	for _, leg := range legs {
+		if leg.stopPrice.IsZero() {
+			continue
+		}
+		stopReq := broker.StopOrderRequest{
+			AccountID:      req.AccountID,
+			InstrumentID:   req.InstrumentID,
+			Direction:      direction,
+			Type:           leg.stopType,
+			Lots:           lots,
+			StopPrice:      money.RoundToStep(leg.stopPrice.Div(pointValue), step),
+			ExpirationType: pb.StopOrderExpirationType_STOP_ORDER_EXPIRATION_TYPE_GOOD_TILL_CANCEL,
+		}
+		stopResp, err := g.Broker.PostStopOrder(ctx, stopReq)
+		if err != nil {
+			g.logger.Errorf("Failed to attach %s to order %s: %v", leg.name, resp.GetOrderId(), err)
+			return attached, fmt.Errorf("failed to attach %s: %w", leg.name, err)
+		}
+		attached = append(attached, AttachedStop{
+			StopOrderID: stopResp.GetStopOrderId(),
+			Type:        leg.name,
+			StopPrice:   stopReq.StopPrice,
+			Lots:        lots,
+		})
+	}
+	return attached, nil
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"trading-bot-web/auth"
+)
+
+// apiKeyColumns - колонки таблицы api_keys в порядке scanAPIKey
+const apiKeyColumns = `id, name, prefix, key_hash, scopes, accounts, rate_limit, created_by,
+	created_at, expires_at, last_used_at, revoked_at`
+
+// CreateAPIKey - сохранение выданного ключа
+func (s *SQLStore) CreateAPIKey(ctx context.Context, key auth.APIKey) error {
+	scopes, err := json.Marshal(key.Scopes)
+	if err != nil {
+		return fmt.Errorf("failed to encode api key scopes: %w", err)
+	}
+	accounts, err := json.Marshal(key.Accounts)
+	if err != nil {
+		return fmt.Errorf("failed to encode api key accounts: %w", err)
+	}
+
+	err = s.exec(ctx, `
+		INSERT INTO api_keys (id, name, prefix, key_hash, scopes, accounts, rate_limit, created_by, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		key.ID, key.Name, key.Prefix, key.KeyHash, string(scopes), string(accounts), key.RateLimit,
+		key.CreatedBy, key.CreatedAt, key.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create api key: %w", err)
+	}
+	return nil
+}
+
+// APIKeyByHash - ключ по хешу секрета, nil если его нет
+func (s *SQLStore) APIKeyByHash(ctx context.Context, hash string) (*auth.APIKey, error) {
+	query := "SELECT " + apiKeyColumns + " FROM api_keys WHERE key_hash = ?"
+	key, err := scanAPIKey(s.db.QueryRowContext(ctx, s.dialect.rebind(query), hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return key, err
+}
+
+// ListAPIKeys - все ключи, новые первыми
+func (s *SQLStore) ListAPIKeys(ctx context.Context) ([]auth.APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys ORDER BY created_at DESC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := make([]auth.APIKey, 0)
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey - отзыв ключа, повторный отзыв сохраняет первое время
+func (s *SQLStore) RevokeAPIKey(ctx context.Context, id string, at time.Time) error {
+	result, err := s.db.ExecContext(ctx,
+		s.dialect.rebind("UPDATE api_keys SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ?"), at, id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	if affected == 0 {
+		return auth.ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// TouchAPIKey - отметка о последнем использовании ключа
+func (s *SQLStore) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	if err := s.exec(ctx, "UPDATE api_keys SET last_used_at = ? WHERE id = ?", at, id); err != nil {
+		return fmt.Errorf("failed to touch api key: %w", err)
+	}
+	return nil
+}
+
+// scanAPIKey - разбор строки таблицы api_keys
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*auth.APIKey, error) {
+	var key auth.APIKey
+	var scopes, accounts []byte
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.KeyHash, &scopes, &accounts, &key.RateLimit,
+		&key.CreatedBy, &key.CreatedAt, &key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load api key: %w", err)
+	}
+
+	if err := json.Unmarshal(scopes, &key.Scopes); err != nil {
+		return nil, fmt.Errorf("failed to decode api key scopes: %w", err)
+	}
+	if err := json.Unmarshal(accounts, &key.Accounts); err != nil {
+		return nil, fmt.Errorf("failed to decode api key accounts: %w", err)
+	}
+	return &key, nil
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"trading-bot-web/idempotency"
+)
+
+// CreateIdempotencyRecord - занятие ключа, false если он уже занят
+func (s *SQLStore) CreateIdempotencyRecord(ctx context.Context, record idempotency.Record) (bool, error) {
+	result, err := s.db.ExecContext(ctx, s.dialect.rebind(`
+		INSERT INTO idempotency_keys (owner, idempotency_key, request_hash, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (owner, idempotency_key) DO NOTHING`),
+		record.Owner, record.Key, record.RequestHash, record.CreatedAt, record.ExpiresAt,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to create idempotency key: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to create idempotency key: %w", err)
+	}
+	return affected > 0, nil
+}
+
+// IdempotencyRecord - запись по ключу, nil если ее нет
+func (s *SQLStore) IdempotencyRecord(ctx context.Context, owner, key string) (*idempotency.Record, error) {
+	record := idempotency.Record{Owner: owner, Key: key}
+	var response []byte
+	err := s.db.QueryRowContext(ctx, s.dialect.rebind(`
+		SELECT request_hash, status_code, response, created_at, expires_at
+		FROM idempotency_keys WHERE owner = ? AND idempotency_key = ?`), owner, key,
+	).Scan(&record.RequestHash, &record.StatusCode, &response, &record.CreatedAt, &record.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load idempotency key: %w", err)
+	}
+	record.Response = response
+	return &record, nil
+}
+
+// CompleteIdempotencyRecord - сохранение ответа на запрос
+func (s *SQLStore) CompleteIdempotencyRecord(ctx context.Context, owner, key string, statusCode int, response json.RawMessage) error {
+	err := s.exec(ctx, "UPDATE idempotency_keys SET status_code = ?, response = ? WHERE owner = ? AND idempotency_key = ?",
+		statusCode, string(response), owner, key)
+	if err != nil {
+		return fmt.Errorf("failed to save idempotent response: %w", err)
+	}
+	return nil
+}
+
+// DeleteIdempotencyRecord - освобождение ключа
+func (s *SQLStore) DeleteIdempotencyRecord(ctx context.Context, owner, key string) error {
+	if err := s.exec(ctx, "DELETE FROM idempotency_keys WHERE owner = ? AND idempotency_key = ?", owner, key); err != nil {
+		return fmt.Errorf("failed to delete idempotency key: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpiredIdempotencyRecords - удаление ключей, истекших до before
+func (s *SQLStore) DeleteExpiredIdempotencyRecords(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, s.dialect.rebind("DELETE FROM idempotency_keys WHERE expires_at < ?"), before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge idempotency keys: %w", err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/shopspring/decimal"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+
+	"trading-bot-web/bots"
+	"trading-bot-web/money"
+)
+
+// События жизненного цикла заявки
+const (
+	EventSubmitted       = "submitted"
+	EventPartiallyFilled = "partially_filled"
+	EventFilled          = "filled"
+	EventCancelled       = "cancelled"
+	EventRejected        = "rejected"
+)
+
+// OrderEvent - событие жизненного цикла заявки с исполнением на момент события
+type OrderEvent struct {
+	ID      int64  `json:"id"`
+	OrderID string `json:"order_id"`
+	// Event - submitted, partially_filled, filled, cancelled или rejected
+	Event string `json:"event"`
+	// Status - статус заявки у брокера
+	Status        string          `json:"status"`
+	LotsExecuted  int64           `json:"lots_executed"`
+	ExecutedPrice decimal.Decimal `json:"executed_price"`
+	Commission    decimal.Decimal `json:"commission"`
+	// Message - подробности события, например новая заявка при изменении
+	Message   string    `json:"message,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// lifecycleEvents - событие журнала по статусу брокера
+var lifecycleEvents = map[string]string{
+	pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_NEW.String():           EventSubmitted,
+	pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_PARTIALLYFILL.String(): EventPartiallyFilled,
+	pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_FILL.String():          EventFilled,
+	pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_CANCELLED.String():     EventCancelled,
+	pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_REJECTED.String():      EventRejected,
+}
+
+// OpenOrderStatuses - статусы заявок, которые еще могут исполниться или быть сняты
+var OpenOrderStatuses = []string{
+	pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_NEW.String(),
+	pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_PARTIALLYFILL.String(),
+}
+
+// OrderStatusForEvent - статус брокера по событию журнала, пустой для неизвестного события
+func OrderStatusForEvent(event string) string {
+	for status, e := range lifecycleEvents {
+		if e == event {
+			return status
+		}
+	}
+	return ""
+}
+
+// ApplyOrderState - заявка журнала с текущим состоянием у брокера
+func ApplyOrderState(record OrderRecord, state *pb.OrderState) OrderRecord {
+	record.Status = state.GetExecutionReportStatus().String()
+	record.LotsExecuted = state.GetLotsExecuted()
+	record.Commission = money.FromMoneyValue(state.GetExecutedCommission())
+	if record.LotsExecuted > 0 {
+		// ExecutedOrderPrice состояния - сумма исполнения, цена за штуку - средняя цена
+		record.ExecutedPrice = money.FromMoneyValue(state.GetAveragePositionPrice())
+	}
+	return record
+}
+
+// FillBetween - исполнение между двумя состояниями заявки: цена - средняя
+// по новым лотам, выведенная из средних цен всей заявки. Quantity не заполняется,
+// лотность журналу неизвестна.
+func FillBetween(previous, updated OrderRecord) bots.Fill {
+	lots := updated.LotsExecuted - previous.LotsExecuted
+	price := updated.ExecutedPrice
+	if previous.LotsExecuted > 0 {
+		amount := updated.ExecutedPrice.Mul(decimal.NewFromInt(updated.LotsExecuted)).
+			Sub(previous.ExecutedPrice.Mul(decimal.NewFromInt(previous.LotsExecuted)))
+		price = amount.Div(decimal.NewFromInt(lots))
+	}
+	commission := updated.Commission.Sub(previous.Commission)
+	if commission.IsNegative() {
+		commission = decimal.Zero
+	}
+
+	return bots.Fill{
+		OrderID:      updated.OrderID,
+		InstrumentID: updated.InstrumentID,
+		Direction:    pb.OrderDirection(pb.OrderDirection_value[updated.Direction]),
+		Lots:         lots,
+		Price:        price,
+		Commission:   commission,
+		Time:         time.Now(),
+	}
+}
+
+// SaveOrderUpdate - сохранение заявки и события журнала, если изменились ее статус
+// или исполненное количество. previous - сохраненная ранее заявка, nil для новой.
+// Возвращает false, если сохранять было нечего.
+func SaveOrderUpdate(ctx context.Context, repo Repository, order OrderRecord, previous *OrderRecord, message string) (bool, error) {
+	if previous != nil && previous.Status == order.Status && previous.LotsExecuted == order.LotsExecuted {
+		return false, nil
+	}
+	if err := repo.SaveOrder(ctx, order); err != nil {
+		return false, err
+	}
+
+	current := OrderEvent{
+		OrderID:       order.OrderID,
+		Status:        order.Status,
+		LotsExecuted:  order.LotsExecuted,
+		ExecutedPrice: order.ExecutedPrice,
+		Commission:    order.Commission,
+		Message:       message,
+		CreatedAt:     time.Now(),
+	}
+	events := make([]OrderEvent, 0, 2)
+	event, known := lifecycleEvents[order.Status]
+	if previous == nil && event != EventSubmitted {
+		// Заявка исполнена или отклонена сразу: выставление записывается отдельно, до исполнения
+		submitted := current
+		submitted.Event = EventSubmitted
+		submitted.Status = pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_NEW.String()
+		submitted.LotsExecuted, submitted.ExecutedPrice, submitted.Commission = 0, decimal.Zero, decimal.Zero
+		events = append(events, submitted)
+	}
+	if known {
+		current.Event = event
+		events = append(events, current)
+	}
+	for _, e := range events {
+		if err := repo.AddOrderEvent(ctx, e); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
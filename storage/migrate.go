@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFiles - миграции схемы для каждой базы: migrations/<база>/NNNN_name.sql
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Migration - версия схемы базы
+type Migration struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+
+	script string
+}
+
+// migrations - миграции базы по возрастанию версии
+func (s *SQLStore) migrations() ([]Migration, error) {
+	dir := path.Join("migrations", s.dialect.name)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		if entry.IsDir() || name == entry.Name() {
+			continue
+		}
+		number, title, found := strings.Cut(name, "_")
+		version, err := strconv.Atoi(number)
+		if !found || err != nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+
+		script, err := fs.ReadFile(migrationFiles, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+		migrations = append(migrations, Migration{Version: version, Name: title, script: string(script)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// ensureMigrationsTable - создание таблицы примененных миграций
+func (s *SQLStore) ensureMigrationsTable(ctx context.Context) error {
+	timestamp := "TIMESTAMP"
+	if s.dialect == postgresDialect {
+		timestamp = "TIMESTAMPTZ"
+	}
+	return s.exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at `+timestamp+` NOT NULL
+		)`)
+}
+
+// applied - время применения миграций по версиям
+func (s *SQLStore) applied(ctx context.Context) (map[int]time.Time, error) {
+	if err := s.ensureMigrationsTable(ctx); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var (
+			version   int
+			appliedAt time.Time
+		)
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// MigrationStatus - все миграции с отметкой о применении
+func (s *SQLStore) MigrationStatus(ctx context.Context) ([]Migration, error) {
+	migrations, err := s.migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := s.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range migrations {
+		if appliedAt, exists := applied[migrations[i].Version]; exists {
+			migrations[i].AppliedAt = &appliedAt
+		}
+	}
+	return migrations, nil
+}
+
+// Migrate - применение новых миграций, каждая в своей транзакции
+func (s *SQLStore) Migrate(ctx context.Context) ([]Migration, error) {
+	migrations, err := s.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make([]Migration, 0)
+	for _, m := range migrations {
+		if m.AppliedAt != nil {
+			continue
+		}
+		if err := s.apply(ctx, m); err != nil {
+			return done, fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		now := time.Now()
+		m.AppliedAt = &now
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// apply - выполнение миграции и запись ее версии
+func (s *SQLStore) apply(ctx context.Context, m Migration) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, m.script); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		s.dialect.rebind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"),
+		m.Version, m.Name, time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,57 @@
+-- Боты: конфигурация целиком в config, состояние отдельно для восстановления
+CREATE TABLE bots (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    type TEXT NOT NULL,
+    account_id TEXT NOT NULL,
+    execution_mode TEXT NOT NULL,
+    state TEXT NOT NULL,
+    config JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+-- Заявки, выставленные через сервер
+CREATE TABLE orders (
+    order_id TEXT PRIMARY KEY,
+    account_id TEXT NOT NULL,
+    bot_id TEXT NOT NULL DEFAULT '',
+    instrument_id TEXT NOT NULL,
+    direction TEXT NOT NULL,
+    order_type TEXT NOT NULL,
+    lots BIGINT NOT NULL,
+    price DOUBLE PRECISION,
+    status TEXT NOT NULL,
+    lots_executed BIGINT NOT NULL DEFAULT 0,
+    executed_price DOUBLE PRECISION NOT NULL DEFAULT 0,
+    commission DOUBLE PRECISION NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX orders_account_created_idx ON orders (account_id, created_at);
+CREATE INDEX orders_bot_idx ON orders (bot_id);
+
+-- Исполнения заявок ботов
+CREATE TABLE fills (
+    id BIGSERIAL PRIMARY KEY,
+    bot_id TEXT NOT NULL,
+    account_id TEXT NOT NULL,
+    order_id TEXT NOT NULL,
+    instrument_id TEXT NOT NULL,
+    direction TEXT NOT NULL,
+    lots BIGINT NOT NULL,
+    quantity BIGINT NOT NULL,
+    price DOUBLE PRECISION NOT NULL,
+    commission DOUBLE PRECISION NOT NULL,
+    executed_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX fills_bot_executed_idx ON fills (bot_id, executed_at);
+
+-- Последняя статистика ботов
+CREATE TABLE bot_stats (
+    bot_id TEXT PRIMARY KEY,
+    stats JSONB NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
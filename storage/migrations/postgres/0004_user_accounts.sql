@@ -0,0 +1,7 @@
+-- Брокерские счета, к которым пользователю выдан доступ
+CREATE TABLE user_accounts (
+    user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    account_id TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (user_id, account_id)
+);
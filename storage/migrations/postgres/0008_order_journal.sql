@@ -0,0 +1,22 @@
+-- Источник заявки: api (субъект запроса), bot (ID бота), synthetic (ID синтетической заявки), risk
+ALTER TABLE orders ADD COLUMN origin TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN origin_id TEXT NOT NULL DEFAULT '';
+
+UPDATE orders SET origin = 'bot', origin_id = bot_id WHERE bot_id <> '';
+
+CREATE INDEX orders_status_idx ON orders (status);
+
+-- Жизненный цикл заявок: выставление, исполнение, отмена, отклонение
+CREATE TABLE order_events (
+    id BIGSERIAL PRIMARY KEY,
+    order_id TEXT NOT NULL,
+    event TEXT NOT NULL,
+    status TEXT NOT NULL,
+    lots_executed BIGINT NOT NULL DEFAULT 0,
+    executed_price DOUBLE PRECISION NOT NULL DEFAULT 0,
+    commission DOUBLE PRECISION NOT NULL DEFAULT 0,
+    message TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX order_events_order_idx ON order_events (order_id, id);
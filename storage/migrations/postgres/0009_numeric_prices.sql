@@ -0,0 +1,14 @@
+-- Цены и суммы хранятся точно, как decimal на стороне сервера. В SQLite
+-- столбцы остаются REAL: значения до 15 значащих цифр читаются без потерь.
+ALTER TABLE orders
+    ALTER COLUMN price TYPE NUMERIC,
+    ALTER COLUMN executed_price TYPE NUMERIC,
+    ALTER COLUMN commission TYPE NUMERIC;
+
+ALTER TABLE fills
+    ALTER COLUMN price TYPE NUMERIC,
+    ALTER COLUMN commission TYPE NUMERIC;
+
+ALTER TABLE order_events
+    ALTER COLUMN executed_price TYPE NUMERIC,
+    ALTER COLUMN commission TYPE NUMERIC;
@@ -0,0 +1,8 @@
+-- Дневной реализованный результат счетов: лимит убытка действует после перезапуска
+CREATE TABLE risk_daily_results (
+    account_id TEXT NOT NULL,
+    day TEXT NOT NULL,
+    realized_pnl NUMERIC NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (account_id, day)
+);
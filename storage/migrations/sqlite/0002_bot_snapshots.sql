@@ -0,0 +1,7 @@
+-- Состояние ботов для восстановления после перезапуска:
+-- учет позиций, открытые заявки и внутреннее состояние стратегии
+CREATE TABLE bot_snapshots (
+    bot_id TEXT PRIMARY KEY,
+    snapshot TEXT NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,12 @@
+-- Синтетические заявки (OCO, bracket, трейлинг-стоп), которые ведет сервер
+CREATE TABLE synthetic_orders (
+    id TEXT PRIMARY KEY,
+    account_id TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    status TEXT NOT NULL,
+    state TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX synthetic_orders_status_idx ON synthetic_orders (status);
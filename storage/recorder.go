@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+	"go.uber.org/zap"
+
+	"trading-bot-web/bots"
+	"trading-bot-web/broker"
+	"trading-bot-web/money"
+)
+
+// OrderRecorder - брокер, сохраняющий каждую выставленную и отмененную заявку
+// в журнал заявок. Ошибка записи не отменяет заявку, она только логируется.
+type OrderRecorder struct {
+	broker.Broker
+	repo   Repository
+	logger *zap.SugaredLogger
+
+	// fillObservers - получатели исполнения, найденного при снятии заявки
+	fillObservers []func(accountID string, fill bots.Fill)
+}
+
+// NewOrderRecorder - обертка брокера с записью заявок в хранилище
+func NewOrderRecorder(next broker.Broker, repo Repository, logger *zap.SugaredLogger) *OrderRecorder {
+	return &OrderRecorder{
+		Broker: next,
+		repo:   repo,
+		logger: logger,
+	}
+}
+
+// OnFill - подписка на исполнение, которое журнал еще не видел к моменту отмены
+// или изменения заявки: после снятия заявка больше не опрашивается. Задается
+// до выставления заявок.
+func (r *OrderRecorder) OnFill(observer func(accountID string, fill bots.Fill)) {
+	r.fillObservers = append(r.fillObservers, observer)
+}
+
+// PostOrder - выставление заявки и ее сохранение
+func (r *OrderRecorder) PostOrder(ctx context.Context, req broker.OrderRequest) (*pb.PostOrderResponse, error) {
+	resp, err := r.Broker.PostOrder(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	record := OrderRecord{
+		OrderID:       resp.GetOrderId(),
+		AccountID:     req.AccountID,
+		BotID:         req.BotID,
+		Origin:        req.Origin,
+		OriginID:      req.OriginID,
+		InstrumentID:  req.InstrumentID,
+		Direction:     req.Direction.String(),
+		OrderType:     req.OrderType.String(),
+		Lots:          req.Lots,
+		Price:         req.Price,
+		Status:        resp.GetExecutionReportStatus().String(),
+		LotsExecuted:  resp.GetLotsExecuted(),
+		ExecutedPrice: money.FromMoneyValue(resp.GetExecutedOrderPrice()),
+		Commission:    money.FromMoneyValue(resp.GetExecutedCommission()),
+		CreatedAt:     time.Now(),
+	}
+	r.record(context.WithoutCancel(ctx), record, "")
+	return resp, nil
+}
+
+// ReplaceOrder - изменение заявки: прежняя помечается отмененной, новая сохраняется
+// с источником прежней
+func (r *OrderRecorder) ReplaceOrder(ctx context.Context, req broker.ReplaceRequest) (*pb.PostOrderResponse, error) {
+	resp, err := r.Broker.ReplaceOrder(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	record := OrderRecord{
+		OrderID:       resp.GetOrderId(),
+		AccountID:     req.AccountID,
+		InstrumentID:  resp.GetFigi(),
+		Direction:     resp.GetDirection().String(),
+		OrderType:     resp.GetOrderType().String(),
+		Lots:          req.Lots,
+		Price:         req.Price,
+		Status:        resp.GetExecutionReportStatus().String(),
+		LotsExecuted:  resp.GetLotsExecuted(),
+		ExecutedPrice: money.FromMoneyValue(resp.GetExecutedOrderPrice()),
+		Commission:    money.FromMoneyValue(resp.GetExecutedCommission()),
+		CreatedAt:     time.Now(),
+	}
+	previous := r.cancelled(ctx, req.OrderID, "replaced by "+record.OrderID)
+	if previous != nil {
+		record.BotID, record.Origin, record.OriginID = previous.BotID, previous.Origin, previous.OriginID
+	}
+	r.record(ctx, record, "replaces "+req.OrderID)
+	return resp, nil
+}
+
+// CancelOrder - отмена заявки с обновлением ее статуса
+func (r *OrderRecorder) CancelOrder(ctx context.Context, accountID, orderID string) (*pb.CancelOrderResponse, error) {
+	resp, err := r.Broker.CancelOrder(ctx, accountID, orderID)
+	if err != nil {
+		return nil, err
+	}
+	r.cancelled(context.WithoutCancel(ctx), orderID, "")
+	return resp, nil
+}
+
+// record - сохранение новой заявки с событием выставления
+func (r *OrderRecorder) record(ctx context.Context, record OrderRecord, message string) {
+	if _, err := SaveOrderUpdate(ctx, r.repo, record, nil, message); err != nil {
+		r.logger.Errorf("Failed to record order %s: %v", record.OrderID, err)
+	}
+}
+
+// cancelled - запись итогового состояния снятой заявки, возвращает заявку до отмены;
+// nil, если заявка выставлена не через сервер. Лоты, исполненные после последнего
+// опроса журнала, видны только в состоянии у брокера, поэтому оно запрашивается
+// до записи отмены. Если состояние недоступно, заявка остается открытой
+// и ее завершит опрос журнала.
+func (r *OrderRecorder) cancelled(ctx context.Context, orderID, message string) *OrderRecord {
+	previous, err := r.repo.GetOrder(ctx, orderID)
+	if err != nil {
+		r.logger.Errorf("Failed to record cancellation of order %s: %v", orderID, err)
+		return nil
+	}
+	if previous == nil {
+		return nil
+	}
+
+	state, err := r.Broker.GetOrderState(ctx, previous.AccountID, orderID)
+	if err != nil {
+		r.logger.Warnf("Failed to get state of cancelled order %s, order journal will update it: %v", orderID, err)
+		return previous
+	}
+	order := ApplyOrderState(*previous, state)
+	if _, err := SaveOrderUpdate(ctx, r.repo, order, previous, message); err != nil {
+		r.logger.Errorf("Failed to record cancellation of order %s: %v", orderID, err)
+	}
+	if order.LotsExecuted > previous.LotsExecuted {
+		fill := FillBetween(*previous, order)
+		for _, observer := range r.fillObservers {
+			observer(order.AccountID, fill)
+		}
+	}
+	return previous
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+	"go.uber.org/zap"
+
+	"trading-bot-web/bots"
+	"trading-bot-web/broker"
+)
+
+func TestCancelOrderRecordsLateFill(t *testing.T) {
+	ctx := context.Background()
+	store, err := OpenSQLite(filepath.Join(t.TempDir(), "orders.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, err := store.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := broker.NewFake()
+	recorder := NewOrderRecorder(fake, store, zap.NewNop().Sugar())
+	var fills []bots.Fill
+	recorder.OnFill(func(accountID string, fill bots.Fill) {
+		if accountID != "acc" {
+			t.Errorf("fill for account %s, want acc", accountID)
+		}
+		fills = append(fills, fill)
+	})
+
+	price := decimal.NewFromInt(250)
+	resp, err := recorder.PostOrder(ctx, broker.OrderRequest{
+		AccountID:    "acc",
+		InstrumentID: "BBG004730N88",
+		Direction:    pb.OrderDirection_ORDER_DIRECTION_BUY,
+		OrderType:    pb.OrderType_ORDER_TYPE_LIMIT,
+		Lots:         5,
+		Price:        &price,
+		OrderID:      "req_1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Два лота исполнились после последнего опроса журнала
+	if err := fake.FillOrder(resp.GetOrderId(), 2, 249.5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recorder.CancelOrder(ctx, "acc", resp.GetOrderId()); err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := store.GetOrder(ctx, resp.GetOrderId())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Status != pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_CANCELLED.String() || record.LotsExecuted != 2 {
+		t.Errorf("order = %s with %d lots executed, want cancelled with 2", record.Status, record.LotsExecuted)
+	}
+	if !record.ExecutedPrice.Equal(decimal.RequireFromString("249.5")) {
+		t.Errorf("executed price = %s, want 249.5", record.ExecutedPrice)
+	}
+
+	if len(fills) != 1 || fills[0].Lots != 2 || !fills[0].Price.Equal(decimal.RequireFromString("249.5")) ||
+		fills[0].Direction != pb.OrderDirection_ORDER_DIRECTION_BUY {
+		t.Errorf("fills = %+v, want one buy of 2 lots at 249.5", fills)
+	}
+
+	events, err := store.ListOrderEvents(ctx, []string{resp.GetOrderId()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := events[len(events)-1]
+	if last.Event != EventCancelled || last.LotsExecuted != 2 {
+		t.Errorf("last event = %s with %d lots, want cancelled with 2", last.Event, last.LotsExecuted)
+	}
+}
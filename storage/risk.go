@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"trading-bot-web/risk"
+)
+
+// SaveDailyResult - сохранение дневного результата счета для риск-движка
+func (s *SQLStore) SaveDailyResult(ctx context.Context, result risk.DailyResult) error {
+	err := s.exec(ctx, `
+		INSERT INTO risk_daily_results (account_id, day, realized_pnl, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (account_id, day) DO UPDATE SET
+			realized_pnl = excluded.realized_pnl,
+			updated_at = excluded.updated_at`,
+		result.AccountID, result.Day, result.RealizedPnL, result.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save daily result: %w", err)
+	}
+	return nil
+}
+
+// ListDailyResults - дневные результаты всех счетов за день
+func (s *SQLStore) ListDailyResults(ctx context.Context, day string) ([]risk.DailyResult, error) {
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(`
+		SELECT account_id, day, realized_pnl, updated_at
+		FROM risk_daily_results WHERE day = ? ORDER BY account_id`), day)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list daily results: %w", err)
+	}
+	defer rows.Close()
+
+	results := make([]risk.DailyResult, 0)
+	for rows.Next() {
+		var result risk.DailyResult
+		if err := rows.Scan(&result.AccountID, &result.Day, &result.RealizedPnL, &result.UpdatedAt); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
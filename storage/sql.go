@@ -0,0 +1,465 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+
+	"trading-bot-web/bots"
+	"trading-bot-web/config"
+)
+
+// dialect - различия SQL между поддерживаемыми базами
+type dialect struct {
+	name string
+	// numbered - плейсхолдеры $1, $2 вместо ?
+	numbered bool
+}
+
+var (
+	postgresDialect = dialect{name: "postgres", numbered: true}
+	sqliteDialect   = dialect{name: "sqlite"}
+)
+
+// rebind - замена плейсхолдеров ? на принятые в базе
+func (d dialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// SQLStore - хранилище на database/sql, общее для PostgreSQL и SQLite
+type SQLStore struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// OpenPostgres - подключение к PostgreSQL
+func OpenPostgres(cfg config.PostgresConfig) (*SQLStore, error) {
+	db, err := sql.Open("postgres", cfg.DSN())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open postgres: %w", err)
+	}
+	if cfg.MaxConnections > 0 {
+		db.SetMaxOpenConns(cfg.MaxConnections)
+	}
+	if cfg.MaxIdleConnections > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConnections)
+	}
+	if cfg.ConnectionMaxLifetime > 0 {
+		db.SetConnMaxLifetime(cfg.ConnectionMaxLifetime)
+	}
+	return newSQLStore(db, postgresDialect)
+}
+
+// OpenSQLite - открытие встроенной базы SQLite, файл создается при необходимости
+func OpenSQLite(path string) (*SQLStore, error) {
+	if path == "" {
+		return nil, errors.New("sqlite path is empty")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite: %w", err)
+	}
+	// SQLite допускает одного писателя, поэтому запросы идут через одно соединение
+	db.SetMaxOpenConns(1)
+	return newSQLStore(db, sqliteDialect)
+}
+
+// newSQLStore - проверка подключения и создание хранилища
+func newSQLStore(db *sql.DB, d dialect) (*SQLStore, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s connection error: %w", d.name, err)
+	}
+	return &SQLStore{db: db, dialect: d}, nil
+}
+
+// Close - закрытие подключения к базе
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// exec - выполнение запроса с плейсхолдерами ?
+func (s *SQLStore) exec(ctx context.Context, query string, args ...interface{}) error {
+	_, err := s.db.ExecContext(ctx, s.dialect.rebind(query), args...)
+	return err
+}
+
+// SaveBot - сохранение конфигурации и состояния бота
+func (s *SQLStore) SaveBot(ctx context.Context, config bots.BotConfig) error {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to encode bot config: %w", err)
+	}
+
+	err = s.exec(ctx, `
+		INSERT INTO bots (id, name, type, account_id, execution_mode, state, config, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			name = excluded.name,
+			type = excluded.type,
+			account_id = excluded.account_id,
+			execution_mode = excluded.execution_mode,
+			state = excluded.state,
+			config = excluded.config,
+			updated_at = excluded.updated_at`,
+		config.ID, config.Name, config.Type, config.AccountID, config.ExecutionMode,
+		string(config.State), string(data), config.CreatedAt, time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save bot: %w", err)
+	}
+	return nil
+}
+
+// DeleteBot - удаление бота, его статистики и состояния, история заявок и исполнений сохраняется
+func (s *SQLStore) DeleteBot(ctx context.Context, botID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		"DELETE FROM bot_snapshots WHERE bot_id = ?",
+		"DELETE FROM bot_stats WHERE bot_id = ?",
+		"DELETE FROM bots WHERE id = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(query), botID); err != nil {
+			return fmt.Errorf("failed to delete bot: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// ListBots - все сохраненные боты с последним известным состоянием
+func (s *SQLStore) ListBots(ctx context.Context) ([]bots.BotConfig, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT config, state FROM bots ORDER BY created_at")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list bots: %w", err)
+	}
+	defer rows.Close()
+
+	configs := make([]bots.BotConfig, 0)
+	for rows.Next() {
+		var (
+			data  []byte
+			state string
+		)
+		if err := rows.Scan(&data, &state); err != nil {
+			return nil, err
+		}
+
+		var config bots.BotConfig
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("failed to decode bot config: %w", err)
+		}
+		config.State = bots.BotState(state)
+		config.IsActive = config.State != bots.BotStateStopped
+		configs = append(configs, config)
+	}
+	return configs, rows.Err()
+}
+
+// SaveFill - сохранение исполнения заявки бота
+func (s *SQLStore) SaveFill(ctx context.Context, botID, accountID string, fill bots.Fill) error {
+	err := s.exec(ctx, `
+		INSERT INTO fills (bot_id, account_id, order_id, instrument_id, direction, lots, quantity, price, commission, executed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		botID, accountID, fill.OrderID, fill.InstrumentID, fill.Direction.String(),
+		fill.Lots, fill.Quantity, fill.Price, fill.Commission, fill.Time,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save fill: %w", err)
+	}
+	return nil
+}
+
+// ListFills - исполнения бота в порядке времени
+func (s *SQLStore) ListFills(ctx context.Context, botID string) ([]FillRecord, error) {
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(`
+		SELECT id, bot_id, account_id, order_id, instrument_id, direction, lots, quantity, price, commission, executed_at
+		FROM fills WHERE bot_id = ? ORDER BY executed_at, id`), botID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list fills: %w", err)
+	}
+	defer rows.Close()
+
+	fills := make([]FillRecord, 0)
+	for rows.Next() {
+		var (
+			fill      FillRecord
+			direction string
+		)
+		err := rows.Scan(&fill.ID, &fill.BotID, &fill.AccountID, &fill.OrderID, &fill.InstrumentID, &direction,
+			&fill.Lots, &fill.Quantity, &fill.Price, &fill.Commission, &fill.Time)
+		if err != nil {
+			return nil, err
+		}
+		fill.Direction = pb.OrderDirection(pb.OrderDirection_value[direction])
+		fills = append(fills, fill)
+	}
+	return fills, rows.Err()
+}
+
+// SaveBotStats - сохранение статистики бота
+func (s *SQLStore) SaveBotStats(ctx context.Context, stats bots.BotStats) error {
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return fmt.Errorf("failed to encode bot stats: %w", err)
+	}
+
+	err = s.exec(ctx, `
+		INSERT INTO bot_stats (bot_id, stats, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (bot_id) DO UPDATE SET stats = excluded.stats, updated_at = excluded.updated_at`,
+		stats.BotID, string(data), time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save bot stats: %w", err)
+	}
+	return nil
+}
+
+// LoadBotStats - последняя сохраненная статистика бота
+func (s *SQLStore) LoadBotStats(ctx context.Context, botID string) (*bots.BotStats, error) {
+	var data []byte
+	err := s.db.QueryRowContext(ctx, s.dialect.rebind("SELECT stats FROM bot_stats WHERE bot_id = ?"), botID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load bot stats: %w", err)
+	}
+
+	var stats bots.BotStats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return nil, fmt.Errorf("failed to decode bot stats: %w", err)
+	}
+	return &stats, nil
+}
+
+// SaveSnapshot - сохранение состояния бота для восстановления
+func (s *SQLStore) SaveSnapshot(ctx context.Context, snapshot bots.BotSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to encode bot snapshot: %w", err)
+	}
+
+	err = s.exec(ctx, `
+		INSERT INTO bot_snapshots (bot_id, snapshot, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (bot_id) DO UPDATE SET snapshot = excluded.snapshot, updated_at = excluded.updated_at`,
+		snapshot.BotID, string(data), snapshot.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save bot snapshot: %w", err)
+	}
+	return nil
+}
+
+// LoadSnapshot - последнее сохраненное состояние бота
+func (s *SQLStore) LoadSnapshot(ctx context.Context, botID string) (*bots.BotSnapshot, error) {
+	var data []byte
+	err := s.db.QueryRowContext(ctx, s.dialect.rebind("SELECT snapshot FROM bot_snapshots WHERE bot_id = ?"), botID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load bot snapshot: %w", err)
+	}
+
+	var snapshot bots.BotSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode bot snapshot: %w", err)
+	}
+	return &snapshot, nil
+}
+
+// SaveOrder - сохранение заявки, повторное сохранение обновляет ее исполнение
+func (s *SQLStore) SaveOrder(ctx context.Context, order OrderRecord) error {
+	now := time.Now()
+	if order.CreatedAt.IsZero() {
+		order.CreatedAt = now
+	}
+
+	err := s.exec(ctx, `
+		INSERT INTO orders (order_id, account_id, bot_id, origin, origin_id, instrument_id, direction, order_type,
+			lots, price, status, lots_executed, executed_price, commission, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (order_id) DO UPDATE SET
+			status = excluded.status,
+			lots_executed = excluded.lots_executed,
+			executed_price = excluded.executed_price,
+			commission = excluded.commission,
+			updated_at = excluded.updated_at`,
+		order.OrderID, order.AccountID, order.BotID, order.Origin, order.OriginID, order.InstrumentID, order.Direction,
+		order.OrderType, order.Lots, order.Price, order.Status, order.LotsExecuted, order.ExecutedPrice, order.Commission,
+		order.CreatedAt, now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save order: %w", err)
+	}
+	return nil
+}
+
+// orderColumns - колонки заявки в порядке scanOrder
+const orderColumns = `order_id, account_id, bot_id, origin, origin_id, instrument_id, direction, order_type,
+	lots, price, status, lots_executed, executed_price, commission, created_at, updated_at`
+
+// scanOrder - чтение строки с колонками orderColumns
+func scanOrder(row interface{ Scan(...interface{}) error }) (OrderRecord, error) {
+	var order OrderRecord
+	err := row.Scan(&order.OrderID, &order.AccountID, &order.BotID, &order.Origin, &order.OriginID, &order.InstrumentID,
+		&order.Direction, &order.OrderType, &order.Lots, &order.Price, &order.Status, &order.LotsExecuted,
+		&order.ExecutedPrice, &order.Commission, &order.CreatedAt, &order.UpdatedAt)
+	return order, err
+}
+
+// GetOrder - сохраненная заявка, nil если ее нет
+func (s *SQLStore) GetOrder(ctx context.Context, orderID string) (*OrderRecord, error) {
+	row := s.db.QueryRowContext(ctx, s.dialect.rebind("SELECT "+orderColumns+" FROM orders WHERE order_id = ?"), orderID)
+	order, err := scanOrder(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+	return &order, nil
+}
+
+// ListOrders - заявки по фильтру, новые первыми
+func (s *SQLStore) ListOrders(ctx context.Context, filter OrderFilter) ([]OrderRecord, error) {
+	query := "SELECT " + orderColumns + " FROM orders WHERE 1 = 1"
+	args := make([]interface{}, 0, 8)
+	for _, cond := range []struct {
+		column string
+		value  string
+	}{
+		{"account_id", filter.AccountID},
+		{"bot_id", filter.BotID},
+		{"instrument_id", filter.InstrumentID},
+		{"origin", filter.Origin},
+		{"origin_id", filter.OriginID},
+	} {
+		if cond.value != "" {
+			query += " AND " + cond.column + " = ?"
+			args = append(args, cond.value)
+		}
+	}
+	if len(filter.Statuses) > 0 {
+		query += " AND status IN (?" + strings.Repeat(", ?", len(filter.Statuses)-1) + ")"
+		for _, status := range filter.Statuses {
+			args = append(args, status)
+		}
+	}
+	if !filter.From.IsZero() {
+		query += " AND created_at >= ?"
+		args = append(args, filter.From)
+	}
+	if !filter.To.IsZero() {
+		query += " AND created_at < ?"
+		args = append(args, filter.To)
+	}
+	query += " ORDER BY created_at DESC, order_id"
+	if filter.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, filter.Limit, filter.Offset)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list orders: %w", err)
+	}
+	defer rows.Close()
+
+	orders := make([]OrderRecord, 0)
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	return orders, rows.Err()
+}
+
+// AddOrderEvent - запись события жизненного цикла заявки
+func (s *SQLStore) AddOrderEvent(ctx context.Context, event OrderEvent) error {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	err := s.exec(ctx, `
+		INSERT INTO order_events (order_id, event, status, lots_executed, executed_price, commission, message, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		event.OrderID, event.Event, event.Status, event.LotsExecuted, event.ExecutedPrice, event.Commission,
+		event.Message, event.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save order event: %w", err)
+	}
+	return nil
+}
+
+// ListOrderEvents - события заявок в порядке записи
+func (s *SQLStore) ListOrderEvents(ctx context.Context, orderIDs []string) ([]OrderEvent, error) {
+	events := make([]OrderEvent, 0)
+	if len(orderIDs) == 0 {
+		return events, nil
+	}
+
+	query := `
+		SELECT id, order_id, event, status, lots_executed, executed_price, commission, message, created_at
+		FROM order_events WHERE order_id IN (?` + strings.Repeat(", ?", len(orderIDs)-1) + `) ORDER BY id`
+	args := make([]interface{}, len(orderIDs))
+	for i, id := range orderIDs {
+		args[i] = id
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list order events: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event OrderEvent
+		err := rows.Scan(&event.ID, &event.OrderID, &event.Event, &event.Status, &event.LotsExecuted,
+			&event.ExecutedPrice, &event.Commission, &event.Message, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"trading-bot-web/auth"
+	"trading-bot-web/bots"
+	"trading-bot-web/config"
+	"trading-bot-web/idempotency"
+	"trading-bot-web/risk"
+	"trading-bot-web/synthetic"
+)
+
+// Repository - хранилище ботов, заявок, синтетических заявок, исполнений,
+// статистики, пользователей, API ключей, ключей идемпотентности и дневных
+// результатов риск-движка
+type Repository interface {
+	bots.Store
+	auth.UserStore
+	auth.TokenStore
+	auth.APIKeyStore
+	idempotency.Store
+	synthetic.Store
+	risk.Store
+
+	// SaveOrder - сохранение заявки или обновление уже сохраненной
+	SaveOrder(ctx context.Context, order OrderRecord) error
+	// GetOrder - сохраненная заявка, nil если ее нет
+	GetOrder(ctx context.Context, orderID string) (*OrderRecord, error)
+	ListOrders(ctx context.Context, filter OrderFilter) ([]OrderRecord, error)
+	// AddOrderEvent - запись события жизненного цикла заявки
+	AddOrderEvent(ctx context.Context, event OrderEvent) error
+	// ListOrderEvents - события заявок в порядке записи
+	ListOrderEvents(ctx context.Context, orderIDs []string) ([]OrderEvent, error)
+	ListFills(ctx context.Context, botID string) ([]FillRecord, error)
+	// LoadBotStats - последняя сохраненная статистика бота, nil если ее нет
+	LoadBotStats(ctx context.Context, botID string) (*bots.BotStats, error)
+
+	// Migrate - применение новых миграций схемы, возвращает примененные
+	Migrate(ctx context.Context) ([]Migration, error)
+	// MigrationStatus - все миграции с отметкой о применении
+	MigrationStatus(ctx context.Context) ([]Migration, error)
+
+	Close() error
+}
+
+// OrderRecord - заявка, выставленная через сервер
+type OrderRecord struct {
+	OrderID   string `json:"order_id"`
+	AccountID string `json:"account_id"`
+	BotID     string `json:"bot_id,omitempty"`
+	// Origin и OriginID - источник заявки: api, bot, synthetic или risk
+	Origin       string `json:"origin,omitempty"`
+	OriginID     string `json:"origin_id,omitempty"`
+	InstrumentID string `json:"instrument_id"`
+	Direction    string `json:"direction"`
+	OrderType    string `json:"order_type"`
+	Lots         int64  `json:"lots"`
+	// Price - цена лимитной заявки, nil для рыночной
+	Price         *decimal.Decimal `json:"price,omitempty"`
+	Status        string           `json:"status"`
+	LotsExecuted  int64            `json:"lots_executed"`
+	ExecutedPrice decimal.Decimal  `json:"executed_price"`
+	Commission    decimal.Decimal  `json:"commission"`
+	CreatedAt     time.Time        `json:"created_at"`
+	UpdatedAt     time.Time        `json:"updated_at"`
+}
+
+// OrderFilter - отбор заявок, пустые поля не ограничивают выборку
+type OrderFilter struct {
+	AccountID    string
+	BotID        string
+	InstrumentID string
+	Origin       string
+	OriginID     string
+	// Statuses - допустимые статусы брокера EXECUTION_REPORT_STATUS_*
+	Statuses []string
+	// From и To - границы времени выставления, нулевые не ограничивают
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
+}
+
+// FillRecord - сохраненное исполнение заявки бота
+type FillRecord struct {
+	ID        int64  `json:"id"`
+	BotID     string `json:"bot_id"`
+	AccountID string `json:"account_id"`
+	bots.Fill
+}
+
+// Open - подключение к базе из настроек: postgres или встроенный sqlite
+func Open(cfg config.DatabaseConfig) (Repository, error) {
+	switch cfg.Driver {
+	case "postgres":
+		return OpenPostgres(cfg.Postgres)
+	case "", "sqlite":
+		return OpenSQLite(cfg.SQLite.Path)
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", cfg.Driver)
+	}
+}
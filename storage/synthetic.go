@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"trading-bot-web/synthetic"
+)
+
+// SaveSyntheticOrder - сохранение синтетической заявки вместе с состоянием ног
+func (s *SQLStore) SaveSyntheticOrder(ctx context.Context, order synthetic.Order) error {
+	data, err := json.Marshal(order)
+	if err != nil {
+		return fmt.Errorf("failed to encode synthetic order: %w", err)
+	}
+
+	err = s.exec(ctx, `
+		INSERT INTO synthetic_orders (id, account_id, kind, status, state, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			status = excluded.status,
+			state = excluded.state,
+			updated_at = excluded.updated_at`,
+		order.ID, order.AccountID, string(order.Kind), string(order.Status), string(data), order.CreatedAt, order.UpdatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save synthetic order: %w", err)
+	}
+	return nil
+}
+
+// ListSyntheticOrders - синтетические заявки, старые первыми; с active только незавершенные
+func (s *SQLStore) ListSyntheticOrders(ctx context.Context, active bool) ([]synthetic.Order, error) {
+	query := "SELECT state FROM synthetic_orders"
+	args := make([]interface{}, 0, 2)
+	if active {
+		query += " WHERE status IN (?, ?)"
+		args = append(args, string(synthetic.StatusPending), string(synthetic.StatusActive))
+	}
+	query += " ORDER BY created_at"
+
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list synthetic orders: %w", err)
+	}
+	defer rows.Close()
+
+	orders := make([]synthetic.Order, 0)
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var order synthetic.Order
+		if err := json.Unmarshal(data, &order); err != nil {
+			return nil, fmt.Errorf("failed to decode synthetic order: %w", err)
+		}
+		orders = append(orders, order)
+	}
+	return orders, rows.Err()
+}
@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"trading-bot-web/auth"
+)
+
+// CreateUser - сохранение нового пользователя
+func (s *SQLStore) CreateUser(ctx context.Context, user auth.User) error {
+	roles, err := json.Marshal(user.Roles)
+	if err != nil {
+		return fmt.Errorf("failed to encode user roles: %w", err)
+	}
+
+	err = s.exec(ctx, `
+		INSERT INTO users (id, username, password_hash, roles, disabled, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		user.ID, user.Username, user.PasswordHash, string(roles), user.Disabled, user.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+	return nil
+}
+
+// UserByUsername - пользователь по логину
+func (s *SQLStore) UserByUsername(ctx context.Context, username string) (*auth.User, error) {
+	return s.user(ctx, "username", username)
+}
+
+// UserByID - пользователь по идентификатору
+func (s *SQLStore) UserByID(ctx context.Context, id string) (*auth.User, error) {
+	return s.user(ctx, "id", id)
+}
+
+// user - пользователь по значению колонки, nil если его нет
+func (s *SQLStore) user(ctx context.Context, column, value string) (*auth.User, error) {
+	query := "SELECT id, username, password_hash, roles, disabled, created_at FROM users WHERE " + column + " = ?"
+
+	user, err := scanUser(s.db.QueryRowContext(ctx, s.dialect.rebind(query), value))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return user, err
+}
+
+// scanUser - разбор строки таблицы users
+func scanUser(row interface{ Scan(...interface{}) error }) (*auth.User, error) {
+	var user auth.User
+	var roles []byte
+	err := row.Scan(&user.ID, &user.Username, &user.PasswordHash, &roles, &user.Disabled, &user.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+
+	if err := json.Unmarshal(roles, &user.Roles); err != nil {
+		return nil, fmt.Errorf("failed to decode user roles: %w", err)
+	}
+	return &user, nil
+}
+
+// CountUsers - количество пользователей
+func (s *SQLStore) CountUsers(ctx context.Context) (int, error) {
+	var count int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count users: %w", err)
+	}
+	return count, nil
+}
+
+// ListUsers - все пользователи по дате создания
+func (s *SQLStore) ListUsers(ctx context.Context) ([]auth.User, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, username, password_hash, roles, disabled, created_at
+		FROM users ORDER BY created_at`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	users := make([]auth.User, 0)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	return users, rows.Err()
+}
+
+// UpdateUser - сохранение ролей и блокировки пользователя
+func (s *SQLStore) UpdateUser(ctx context.Context, user auth.User) error {
+	roles, err := json.Marshal(user.Roles)
+	if err != nil {
+		return fmt.Errorf("failed to encode user roles: %w", err)
+	}
+
+	err = s.exec(ctx, "UPDATE users SET roles = ?, disabled = ? WHERE id = ?", string(roles), user.Disabled, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	return nil
+}
+
+// UserAccounts - счета, выданные пользователю
+func (s *SQLStore) UserAccounts(ctx context.Context, userID string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx,
+		s.dialect.rebind("SELECT account_id FROM user_accounts WHERE user_id = ? ORDER BY account_id"), userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user accounts: %w", err)
+	}
+	defer rows.Close()
+
+	accounts := make([]string, 0)
+	for rows.Next() {
+		var accountID string
+		if err := rows.Scan(&accountID); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, accountID)
+	}
+	return accounts, rows.Err()
+}
+
+// GrantAccount - выдача доступа к счету, повторная выдача ничего не меняет
+func (s *SQLStore) GrantAccount(ctx context.Context, userID, accountID string) error {
+	err := s.exec(ctx, `
+		INSERT INTO user_accounts (user_id, account_id, created_at) VALUES (?, ?, ?)
+		ON CONFLICT (user_id, account_id) DO NOTHING`,
+		userID, accountID, time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to grant account: %w", err)
+	}
+	return nil
+}
+
+// RevokeAccount - отзыв доступа к счету
+func (s *SQLStore) RevokeAccount(ctx context.Context, userID, accountID string) error {
+	err := s.exec(ctx, "DELETE FROM user_accounts WHERE user_id = ? AND account_id = ?", userID, accountID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke account: %w", err)
+	}
+	return nil
+}
+
+// RevokeToken - отзыв токена до истечения его срока одной условной вставкой,
+// false - токен уже был отозван
+func (s *SQLStore) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) (bool, error) {
+	result, err := s.db.ExecContext(ctx, s.dialect.rebind(`
+		INSERT INTO revoked_tokens (jti, expires_at) VALUES (?, ?)
+		ON CONFLICT (jti) DO NOTHING`),
+		tokenID, expiresAt,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke token: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke token: %w", err)
+	}
+	return affected > 0, nil
+}
+
+// RevokedTokens - действующие отозванные токены, истекшие удаляются
+func (s *SQLStore) RevokedTokens(ctx context.Context) (map[string]time.Time, error) {
+	now := time.Now()
+	if err := s.exec(ctx, "DELETE FROM revoked_tokens WHERE expires_at < ?", now); err != nil {
+		return nil, fmt.Errorf("failed to prune revoked tokens: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT jti, expires_at FROM revoked_tokens")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list revoked tokens: %w", err)
+	}
+	defer rows.Close()
+
+	revoked := make(map[string]time.Time)
+	for rows.Next() {
+		var jti string
+		var expiresAt time.Time
+		if err := rows.Scan(&jti, &expiresAt); err != nil {
+			return nil, err
+		}
+		revoked[jti] = expiresAt
+	}
+	return revoked, rows.Err()
+}
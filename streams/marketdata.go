@@ -0,0 +1,501 @@
+package streams
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tinkoff/invest-api-go-sdk/investgo"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+	"go.uber.org/zap"
+
+	"trading-bot-web/broker"
+)
+
+// MarketData - стрим маркетдаты, переживающий разрывы соединения.
+// Подписки запоминаются и восстанавливаются после переподключения, а свечи,
+// пропущенные за время разрыва, догружаются через GetCandles. Каналы данных
+// не закрываются и не меняются между переподключениями.
+type MarketData struct {
+	name    string
+	client  *investgo.MarketDataStreamClient
+	history broker.MarketDataProvider
+	monitor *Monitor
+	logger  *zap.SugaredLogger
+
+	candles    chan *pb.Candle
+	orderBooks chan *pb.OrderBook
+	trades     chan *pb.Trade
+	lastPrices chan *pb.LastPrice
+	infos      chan *pb.TradingStatus
+
+	mu sync.Mutex
+	// stream - текущий стрим, nil пока соединения нет
+	stream *investgo.MarketDataStream
+	// session - контекст текущего стрима, отменяется при разрыве
+	session context.Context
+	// pumping - типы данных, которые читаются из текущего стрима
+	pumping map[string]bool
+
+	candleSubs    map[string]pb.SubscriptionInterval
+	orderBookSubs map[string]int32
+	tradeSubs     map[string]bool
+	lastPriceSubs map[string]bool
+	infoSubs      map[string]bool
+	// lastCandles - последняя полученная свеча по инструменту подписки
+	lastCandles map[string]*pb.Candle
+}
+
+// NewMarketData - создание стрима маркетдаты с буфером каналов bufferSize.
+// history используется для догрузки свечей и может быть nil.
+func NewMarketData(name string, client *investgo.Client, history broker.MarketDataProvider, monitor *Monitor, bufferSize int, logger *zap.SugaredLogger) *MarketData {
+	return &MarketData{
+		name:          name,
+		client:        client.NewMarketDataStreamClient(),
+		history:       history,
+		monitor:       monitor,
+		logger:        logger,
+		candles:       make(chan *pb.Candle, bufferSize),
+		orderBooks:    make(chan *pb.OrderBook, bufferSize),
+		trades:        make(chan *pb.Trade, bufferSize),
+		lastPrices:    make(chan *pb.LastPrice, bufferSize),
+		infos:         make(chan *pb.TradingStatus, bufferSize),
+		pumping:       make(map[string]bool),
+		candleSubs:    make(map[string]pb.SubscriptionInterval),
+		orderBookSubs: make(map[string]int32),
+		tradeSubs:     make(map[string]bool),
+		lastPriceSubs: make(map[string]bool),
+		infoSubs:      make(map[string]bool),
+		lastCandles:   make(map[string]*pb.Candle),
+	}
+}
+
+// Run - поддержание стрима до отмены контекста
+func (m *MarketData) Run(ctx context.Context) {
+	m.monitor.Run(ctx, m.name, m.connect)
+}
+
+// Candles - канал свечей
+func (m *MarketData) Candles() <-chan *pb.Candle { return m.candles }
+
+// OrderBooks - канал стаканов
+func (m *MarketData) OrderBooks() <-chan *pb.OrderBook { return m.orderBooks }
+
+// Trades - канал обезличенных сделок
+func (m *MarketData) Trades() <-chan *pb.Trade { return m.trades }
+
+// LastPrices - канал последних цен
+func (m *MarketData) LastPrices() <-chan *pb.LastPrice { return m.lastPrices }
+
+// TradingStatuses - канал торговых статусов
+func (m *MarketData) TradingStatuses() <-chan *pb.TradingStatus { return m.infos }
+
+// SubscribeCandle - подписка на свечи, при отсутствии соединения применится после подключения
+func (m *MarketData) SubscribeCandle(ids []string, interval pb.SubscriptionInterval) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range ids {
+		m.candleSubs[id] = interval
+	}
+	if m.stream == nil {
+		return nil
+	}
+	ch, err := m.stream.SubscribeCandle(ids, interval, false)
+	if err != nil {
+		return err
+	}
+	m.startPump("candles", func(ctx context.Context) { pump(ctx, ch, m.candles, m.observeCandle) })
+	return nil
+}
+
+// UnSubscribeCandle - отписка от свечей
+func (m *MarketData) UnSubscribeCandle(ids []string, interval pb.SubscriptionInterval) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range ids {
+		delete(m.candleSubs, id)
+		delete(m.lastCandles, id)
+	}
+	if m.stream == nil {
+		return nil
+	}
+	return m.stream.UnSubscribeCandle(ids, interval, false)
+}
+
+// SubscribeOrderBook - подписка на стаканы
+func (m *MarketData) SubscribeOrderBook(ids []string, depth int32) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range ids {
+		m.orderBookSubs[id] = depth
+	}
+	if m.stream == nil {
+		return nil
+	}
+	ch, err := m.stream.SubscribeOrderBook(ids, depth)
+	if err != nil {
+		return err
+	}
+	m.startPump("orderbooks", func(ctx context.Context) { pump(ctx, ch, m.orderBooks, nil) })
+	return nil
+}
+
+// UnSubscribeOrderBook - отписка от стаканов
+func (m *MarketData) UnSubscribeOrderBook(ids []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range ids {
+		delete(m.orderBookSubs, id)
+	}
+	if m.stream == nil {
+		return nil
+	}
+	return m.stream.UnSubscribeOrderBook(ids)
+}
+
+// SubscribeTrade - подписка на обезличенные сделки
+func (m *MarketData) SubscribeTrade(ids []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range ids {
+		m.tradeSubs[id] = true
+	}
+	if m.stream == nil {
+		return nil
+	}
+	ch, err := m.stream.SubscribeTrade(ids)
+	if err != nil {
+		return err
+	}
+	m.startPump("trades", func(ctx context.Context) { pump(ctx, ch, m.trades, nil) })
+	return nil
+}
+
+// UnSubscribeTrade - отписка от обезличенных сделок
+func (m *MarketData) UnSubscribeTrade(ids []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range ids {
+		delete(m.tradeSubs, id)
+	}
+	if m.stream == nil {
+		return nil
+	}
+	return m.stream.UnSubscribeTrade(ids)
+}
+
+// SubscribeLastPrice - подписка на последние цены
+func (m *MarketData) SubscribeLastPrice(ids []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range ids {
+		m.lastPriceSubs[id] = true
+	}
+	if m.stream == nil {
+		return nil
+	}
+	ch, err := m.stream.SubscribeLastPrice(ids)
+	if err != nil {
+		return err
+	}
+	m.startPump("last_prices", func(ctx context.Context) { pump(ctx, ch, m.lastPrices, nil) })
+	return nil
+}
+
+// UnSubscribeLastPrice - отписка от последних цен
+func (m *MarketData) UnSubscribeLastPrice(ids []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range ids {
+		delete(m.lastPriceSubs, id)
+	}
+	if m.stream == nil {
+		return nil
+	}
+	return m.stream.UnSubscribeLastPrice(ids)
+}
+
+// SubscribeInfo - подписка на торговые статусы
+func (m *MarketData) SubscribeInfo(ids []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range ids {
+		m.infoSubs[id] = true
+	}
+	if m.stream == nil {
+		return nil
+	}
+	ch, err := m.stream.SubscribeInfo(ids)
+	if err != nil {
+		return err
+	}
+	m.startPump("infos", func(ctx context.Context) { pump(ctx, ch, m.infos, nil) })
+	return nil
+}
+
+// UnSubscribeInfo - отписка от торговых статусов
+func (m *MarketData) UnSubscribeInfo(ids []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range ids {
+		delete(m.infoSubs, id)
+	}
+	if m.stream == nil {
+		return nil
+	}
+	return m.stream.UnSubscribeInfo(ids)
+}
+
+// connect - открытие стрима и восстановление всех подписок
+func (m *MarketData) connect(ctx context.Context, reconnect bool) (Session, error) {
+	stream, err := m.client.MarketDataStream()
+	if err != nil {
+		return Session{}, err
+	}
+	session, cancel := context.WithCancel(ctx)
+
+	m.mu.Lock()
+	m.stream = stream
+	m.session = session
+	m.pumping = make(map[string]bool)
+	// Восстановление подписок: свечи с догрузкой пропущенного, если это переподключение
+	if err := m.resubscribe(reconnect); err != nil {
+		m.stream = nil
+		m.mu.Unlock()
+		cancel()
+		stream.Stop()
+		return Session{}, err
+	}
+	m.mu.Unlock()
+
+	return Session{
+		Listen: stream.Listen,
+		Stop: func() {
+			m.mu.Lock()
+			if m.stream == stream {
+				m.stream = nil
+			}
+			m.mu.Unlock()
+			cancel()
+			stream.Stop()
+		},
+	}, nil
+}
+
+// resubscribe - подписка нового стрима на все запомненные данные, вызывается под блокировкой
+func (m *MarketData) resubscribe(backfill bool) error {
+	var gaps []candleGap
+	if backfill {
+		gaps = m.candleGaps()
+	}
+	for interval, ids := range groupCandles(m.candleSubs) {
+		ch, err := m.stream.SubscribeCandle(ids, interval, false)
+		if err != nil {
+			return err
+		}
+		m.startPump("candles", func(ctx context.Context) {
+			// Пропущенные свечи отдаются раньше новых из стрима
+			for _, candle := range m.missedCandles(ctx, gaps) {
+				if !forward(ctx, m.candles, candle) {
+					return
+				}
+			}
+			pump(ctx, ch, m.candles, m.observeCandle)
+		})
+	}
+	for depth, ids := range groupDepths(m.orderBookSubs) {
+		ch, err := m.stream.SubscribeOrderBook(ids, depth)
+		if err != nil {
+			return err
+		}
+		m.startPump("orderbooks", func(ctx context.Context) { pump(ctx, ch, m.orderBooks, nil) })
+	}
+	if ids := keys(m.tradeSubs); len(ids) > 0 {
+		ch, err := m.stream.SubscribeTrade(ids)
+		if err != nil {
+			return err
+		}
+		m.startPump("trades", func(ctx context.Context) { pump(ctx, ch, m.trades, nil) })
+	}
+	if ids := keys(m.lastPriceSubs); len(ids) > 0 {
+		ch, err := m.stream.SubscribeLastPrice(ids)
+		if err != nil {
+			return err
+		}
+		m.startPump("last_prices", func(ctx context.Context) { pump(ctx, ch, m.lastPrices, nil) })
+	}
+	if ids := keys(m.infoSubs); len(ids) > 0 {
+		ch, err := m.stream.SubscribeInfo(ids)
+		if err != nil {
+			return err
+		}
+		m.startPump("infos", func(ctx context.Context) { pump(ctx, ch, m.infos, nil) })
+	}
+	return nil
+}
+
+// candleGap - инструмент, свечи которого нужно догрузить после разрыва
+type candleGap struct {
+	id       string
+	last     *pb.Candle
+	interval pb.SubscriptionInterval
+}
+
+// candleGaps - инструменты с полученными до разрыва свечами, вызывается под блокировкой
+func (m *MarketData) candleGaps() []candleGap {
+	gaps := make([]candleGap, 0, len(m.lastCandles))
+	for id, last := range m.lastCandles {
+		if interval, exists := m.candleSubs[id]; exists {
+			gaps = append(gaps, candleGap{id: id, last: last, interval: interval})
+		}
+	}
+	return gaps
+}
+
+// missedCandles - свечи с момента последней полученной до текущего времени.
+// Последняя свеча тоже возвращается: до разрыва она могла прийти незакрытой.
+func (m *MarketData) missedCandles(ctx context.Context, gaps []candleGap) []*pb.Candle {
+	if m.history == nil {
+		return nil
+	}
+
+	var missed []*pb.Candle
+	for _, gap := range gaps {
+		candleInterval, ok := historyInterval(gap.interval)
+		if !ok {
+			continue
+		}
+		from := gap.last.GetTime().AsTime()
+
+		reqCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		history, err := m.history.GetCandles(reqCtx, gap.id, candleInterval, from, time.Now())
+		cancel()
+		if err != nil {
+			m.logger.Errorf("Stream %s failed to backfill candles for %s: %v", m.name, gap.id, err)
+			continue
+		}
+		for _, candle := range history {
+			if candle.GetTime().AsTime().Before(from) {
+				continue
+			}
+			missed = append(missed, &pb.Candle{
+				Figi:          gap.last.GetFigi(),
+				InstrumentUid: gap.last.GetInstrumentUid(),
+				Interval:      gap.interval,
+				Open:          candle.GetOpen(),
+				High:          candle.GetHigh(),
+				Low:           candle.GetLow(),
+				Close:         candle.GetClose(),
+				Volume:        candle.GetVolume(),
+				Time:          candle.GetTime(),
+			})
+		}
+		m.logger.Infof("Stream %s backfilled %d candles for %s", m.name, len(history), gap.id)
+	}
+	sort.SliceStable(missed, func(i, j int) bool {
+		return missed[i].GetTime().AsTime().Before(missed[j].GetTime().AsTime())
+	})
+	return missed
+}
+
+// observeCandle - запоминание последней свечи для догрузки после разрыва
+func (m *MarketData) observeCandle(candle *pb.Candle) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range []string{candle.GetFigi(), candle.GetInstrumentUid()} {
+		if _, subscribed := m.candleSubs[id]; subscribed {
+			m.lastCandles[id] = candle
+		}
+	}
+}
+
+// startPump - запуск чтения типа данных из текущего стрима, если оно еще не запущено.
+// Вызывается под блокировкой.
+func (m *MarketData) startPump(kind string, run func(ctx context.Context)) {
+	if m.pumping[kind] {
+		return
+	}
+	m.pumping[kind] = true
+	go run(m.session)
+}
+
+// pump - пересылка данных стрима в постоянный канал до разрыва стрима
+func pump[T any](ctx context.Context, in <-chan T, out chan<- T, observe func(T)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case value, ok := <-in:
+			if !ok {
+				return
+			}
+			if observe != nil {
+				observe(value)
+			}
+			if !forward(ctx, out, value) {
+				return
+			}
+		}
+	}
+}
+
+// forward - отправка значения с учетом отмены контекста
+func forward[T any](ctx context.Context, ch chan<- T, value T) bool {
+	select {
+	case ch <- value:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// historyInterval - интервал исторических свечей для интервала подписки
+func historyInterval(interval pb.SubscriptionInterval) (pb.CandleInterval, bool) {
+	switch interval {
+	case pb.SubscriptionInterval_SUBSCRIPTION_INTERVAL_ONE_MINUTE:
+		return pb.CandleInterval_CANDLE_INTERVAL_1_MIN, true
+	case pb.SubscriptionInterval_SUBSCRIPTION_INTERVAL_FIVE_MINUTES:
+		return pb.CandleInterval_CANDLE_INTERVAL_5_MIN, true
+	}
+	return pb.CandleInterval_CANDLE_INTERVAL_UNSPECIFIED, false
+}
+
+// groupCandles - инструменты подписки на свечи по интервалам
+func groupCandles(subs map[string]pb.SubscriptionInterval) map[pb.SubscriptionInterval][]string {
+	groups := make(map[pb.SubscriptionInterval][]string)
+	for id, interval := range subs {
+		groups[interval] = append(groups[interval], id)
+	}
+	return groups
+}
+
+// groupDepths - инструменты подписки на стаканы по глубине
+func groupDepths(subs map[string]int32) map[int32][]string {
+	groups := make(map[int32][]string)
+	for id, depth := range subs {
+		groups[depth] = append(groups[depth], id)
+	}
+	return groups
+}
+
+// keys - инструменты подписки
+func keys(subs map[string]bool) []string {
+	ids := make([]string, 0, len(subs))
+	for id := range subs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
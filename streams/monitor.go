@@ -0,0 +1,225 @@
+package streams
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// State - состояние стрима
+type State string
+
+const (
+	StateConnecting   State = "connecting"
+	StateConnected    State = "connected"
+	StateDisconnected State = "disconnected"
+	StateStopped      State = "stopped"
+)
+
+// Status - изменение состояния стрима, рассылается подписчикам монитора
+type Status struct {
+	Stream  string    `json:"stream"`
+	State   State     `json:"state"`
+	Attempt int       `json:"attempt,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// Stats - счетчики стрима
+type Stats struct {
+	State       State     `json:"state"`
+	Reconnects  int64     `json:"reconnects"`
+	Disconnects int64     `json:"disconnects"`
+	LastError   string    `json:"last_error,omitempty"`
+	Since       time.Time `json:"since"`
+}
+
+// Session - открытый стрим: Listen блокируется до разрыва, Stop закрывает стрим
+type Session struct {
+	Listen func() error
+	Stop   func()
+}
+
+// Backoff - экспоненциальная задержка между попытками подключения
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+// Delay - задержка перед попыткой attempt, начиная с 1
+func (b Backoff) Delay(attempt int) time.Duration {
+	delay := b.Initial
+	for i := 1; i < attempt && delay < b.Max; i++ {
+		delay *= 2
+	}
+	if delay > b.Max {
+		delay = b.Max
+	}
+	return delay
+}
+
+// DefaultBackoff - задержки от секунды до минуты
+var DefaultBackoff = Backoff{Initial: time.Second, Max: time.Minute}
+
+// Monitor - переподключение стримов и учет их состояния
+type Monitor struct {
+	backoff Backoff
+	logger  *zap.SugaredLogger
+
+	mu         sync.RWMutex
+	stats      map[string]*Stats
+	reconnects int64
+	observers  []func(Status)
+}
+
+// NewMonitor - создание монитора стримов
+func NewMonitor(backoff Backoff, logger *zap.SugaredLogger) *Monitor {
+	return &Monitor{
+		backoff: backoff,
+		logger:  logger,
+		stats:   make(map[string]*Stats),
+	}
+}
+
+// OnStatus - подписка на изменения состояния стримов
+func (m *Monitor) OnStatus(observer func(Status)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.observers = append(m.observers, observer)
+}
+
+// Run - поддержание стрима открытым до отмены контекста.
+// connect открывает стрим и восстанавливает подписки; reconnect равен true
+// для всех подключений, кроме первого.
+func (m *Monitor) Run(ctx context.Context, name string, connect func(ctx context.Context, reconnect bool) (Session, error)) {
+	defer m.remove(name)
+
+	attempt := 0
+	connected := false
+	for {
+		m.update(name, StateConnecting, attempt, nil)
+		session, err := connect(ctx, connected)
+		if err == nil {
+			if connected {
+				m.reconnected(name)
+			}
+			connected = true
+			m.update(name, StateConnected, attempt, nil)
+
+			startedAt := time.Now()
+			err = listen(ctx, session)
+			// Стрим, проживший дольше максимальной задержки, считается стабильным
+			if time.Since(startedAt) > m.backoff.Max {
+				attempt = 0
+			}
+		}
+		if ctx.Err() != nil {
+			m.update(name, StateStopped, 0, nil)
+			return
+		}
+		if err == nil {
+			err = errors.New("stream closed")
+		}
+
+		attempt++
+		delay := m.backoff.Delay(attempt)
+		m.logger.Warnf("Stream %s disconnected: %v, reconnecting in %s", name, err, delay)
+		m.update(name, StateDisconnected, attempt, err)
+
+		select {
+		case <-ctx.Done():
+			m.update(name, StateStopped, 0, nil)
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// Stats - счетчики по открытым стримам
+func (m *Monitor) Stats() map[string]Stats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stats := make(map[string]Stats, len(m.stats))
+	for name, s := range m.stats {
+		stats[name] = *s
+	}
+	return stats
+}
+
+// TotalReconnects - число переподключений всех стримов с запуска
+func (m *Monitor) TotalReconnects() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.reconnects
+}
+
+// listen - ожидание разрыва стрима с закрытием при отмене контекста
+func listen(ctx context.Context, session Session) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			session.Stop()
+		case <-done:
+		}
+	}()
+
+	err := session.Listen()
+	session.Stop()
+	return err
+}
+
+// update - смена состояния стрима и уведомление подписчиков
+func (m *Monitor) update(name string, state State, attempt int, err error) {
+	status := Status{Stream: name, State: state, Attempt: attempt, Time: time.Now()}
+	if err != nil {
+		status.Error = err.Error()
+	}
+
+	m.mu.Lock()
+	s, exists := m.stats[name]
+	if !exists {
+		s = &Stats{}
+		m.stats[name] = s
+	}
+	if s.State != state {
+		s.Since = status.Time
+	}
+	s.State = state
+	if state == StateDisconnected {
+		s.Disconnects++
+		s.LastError = status.Error
+	}
+	observers := m.observers
+	m.mu.Unlock()
+
+	// Промежуточное состояние подключения подписчикам не интересно
+	if state == StateConnecting {
+		return
+	}
+	for _, observer := range observers {
+		observer(status)
+	}
+}
+
+// reconnected - учет успешного переподключения
+func (m *Monitor) reconnected(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, exists := m.stats[name]; exists {
+		s.Reconnects++
+	}
+	m.reconnects++
+}
+
+// remove - удаление остановленного стрима из статистики
+func (m *Monitor) remove(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.stats, name)
+}
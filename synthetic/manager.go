@@ -0,0 +1,579 @@
+package synthetic
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+	"go.uber.org/zap"
+
+	"trading-bot-web/broker"
+	"trading-bot-web/idempotency"
+	"trading-bot-web/money"
+)
+
+// PriceFeed - стрим последних цен, по которому срабатывают стоп-ноги
+type PriceFeed interface {
+	Run(ctx context.Context)
+	SubscribeLastPrice(ids []string) error
+	UnSubscribeLastPrice(ids []string) error
+	LastPrices() <-chan *pb.LastPrice
+}
+
+// maxStopAttempts - сколько раз выставляется заявка сработавшей стоп-ноги,
+// прежде чем нога считается отклоненной
+const maxStopAttempts = 5
+
+// Alert - заявка сработавшей стоп-ноги отклонена брокером или не подтверждена,
+// позиция не закрыта
+type Alert struct {
+	OrderID      string `json:"order_id"`
+	AccountID    string `json:"account_id"`
+	InstrumentID string `json:"instrument_id"`
+	Role         string `json:"role"`
+	Attempt      int    `json:"attempt"`
+	// Retrying - заявка будет выставлена снова при следующем опросе
+	Retrying bool      `json:"retrying"`
+	Error    string    `json:"error"`
+	Time     time.Time `json:"time"`
+}
+
+// Manager - синтетические заявки, которых нет у брокера: OCO, bracket и трейлинг-стоп.
+// Стоп-ноги срабатывают по стриму последних цен, исполнение выставленных
+// заявок проверяется опросом GetOrderState. Заявки сохраняются в базу
+// и продолжают отслеживаться после перезапуска.
+type Manager struct {
+	orders       broker.OrderGateway
+	market       broker.MarketDataProvider
+	feed         PriceFeed
+	store        Store
+	pollInterval time.Duration
+	logger       *zap.SugaredLogger
+
+	// mu защищает только учет заявок, вызовы брокера идут под блокировкой заявки
+	mu     sync.Mutex
+	active map[string]*tracked
+	// watched - число активных заявок по инструменту подписки на цены
+	watched   map[string]int
+	observers []func(Alert)
+}
+
+// tracked - активная заявка; mu сериализует работу с ней, включая вызовы
+// брокера, и не задерживает остальные заявки
+type tracked struct {
+	mu    sync.Mutex
+	order *Order
+}
+
+// NewManager - менеджер синтетических заявок, заявки ног выставляются через orders
+func NewManager(orders broker.OrderGateway, market broker.MarketDataProvider, feed PriceFeed, store Store, pollInterval time.Duration, logger *zap.SugaredLogger) *Manager {
+	return &Manager{
+		orders:       orders,
+		market:       market,
+		feed:         feed,
+		store:        store,
+		pollInterval: pollInterval,
+		logger:       logger,
+		active:       make(map[string]*tracked),
+		watched:      make(map[string]int),
+	}
+}
+
+// OnAlert - подписка на отклонение заявок сработавших стоп-ног; задается до Run
+func (m *Manager) OnAlert(observer func(Alert)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.observers = append(m.observers, observer)
+}
+
+// Run - восстановление незавершенных заявок и отслеживание цен до отмены контекста
+func (m *Manager) Run(ctx context.Context) {
+	m.restore(ctx)
+	go m.feed.Run(ctx)
+
+	ticker := time.NewTicker(m.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case price := <-m.feed.LastPrices():
+			m.onPrice(ctx, price)
+		case <-ticker.C:
+			m.poll(ctx)
+		}
+	}
+}
+
+// Create - создание и активация заявки. Ошибка выставления первой заявки
+// брокеру возвращается, и синтетическая заявка не создается.
+func (m *Manager) Create(ctx context.Context, spec Spec) (Order, error) {
+	now := time.Now()
+	order, err := spec.build(fmt.Sprintf("syn_%d", now.UnixNano()), now)
+	if err != nil {
+		return Order{}, err
+	}
+
+	// До регистрации заявка не видна стриму цен и опросу
+	switch order.Kind {
+	case KindBracket:
+		entry := order.leg(RoleEntry)
+		if err := m.submit(ctx, order, entry); err != nil {
+			return Order{}, err
+		}
+	case KindOCO:
+		if err := m.submit(ctx, order, order.leg(RoleTakeProfit)); err != nil {
+			return Order{}, err
+		}
+		order.leg(RoleStopLoss).State = LegWatching
+	case KindTrailingStop:
+		leg := order.leg(RoleStopLoss)
+		leg.State = LegWatching
+		if prices, err := m.market.GetLastPrices(ctx, []string{order.InstrumentID}); err == nil && len(prices) > 0 {
+			leg.StopPrice = order.Trailing.follow(leg.Direction == "sell", money.FromQuotation(prices[0].GetPrice()))
+		}
+	}
+
+	t := &tracked{order: order}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	m.watch(t)
+	m.advance(ctx, order)
+	m.save(ctx, order)
+	return order.clone(), nil
+}
+
+// Cancel - отмена незавершенной заявки счета вместе с выставленными заявками ног
+func (m *Manager) Cancel(ctx context.Context, accountID, id string) (Order, error) {
+	m.mu.Lock()
+	t, exists := m.active[id]
+	m.mu.Unlock()
+	if !exists || t.order.AccountID != accountID {
+		return Order{}, ErrNotFound
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// Заявка могла завершиться, пока ждали ее блокировку
+	if t.order.Status.Done() {
+		return Order{}, ErrNotFound
+	}
+	m.finish(ctx, t.order, StatusCancelled, "")
+	m.save(ctx, t.order)
+	return t.order.clone(), nil
+}
+
+// Active - незавершенные заявки счета, старые первыми
+func (m *Manager) Active(accountID string) []Order {
+	orders := make([]Order, 0)
+	for _, t := range m.snapshot() {
+		if t.order.AccountID != accountID {
+			continue
+		}
+		t.mu.Lock()
+		if !t.order.Status.Done() {
+			orders = append(orders, t.order.clone())
+		}
+		t.mu.Unlock()
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].CreatedAt.Before(orders[j].CreatedAt) })
+	return orders
+}
+
+// snapshot - снимок активных заявок; ID, счет и инструмент заявки не меняются
+// и читаются без ее блокировки
+func (m *Manager) snapshot() []*tracked {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]*tracked, 0, len(m.active))
+	for _, t := range m.active {
+		list = append(list, t)
+	}
+	return list
+}
+
+// restore - загрузка незавершенных заявок из базы и проверка выставленных заявок ног
+func (m *Manager) restore(ctx context.Context) {
+	orders, err := m.store.ListSyntheticOrders(ctx, true)
+	if err != nil {
+		m.logger.Errorf("Failed to load synthetic orders: %v", err)
+		return
+	}
+
+	for i := range orders {
+		m.watch(&tracked{order: &orders[i]})
+	}
+
+	if len(orders) > 0 {
+		m.logger.Infof("Restored %d synthetic orders", len(orders))
+		m.poll(ctx)
+	}
+}
+
+// onPrice - срабатывание стоп-ног и сдвиг трейлинг-стопов по новой цене
+func (m *Manager) onPrice(ctx context.Context, lastPrice *pb.LastPrice) {
+	price := money.FromQuotation(lastPrice.GetPrice())
+	if !price.IsPositive() {
+		return
+	}
+
+	for _, t := range m.snapshot() {
+		if t.order.InstrumentID != lastPrice.GetFigi() && t.order.InstrumentID != lastPrice.GetInstrumentUid() {
+			continue
+		}
+		t.mu.Lock()
+		m.priceChanged(ctx, t.order, price)
+		t.mu.Unlock()
+	}
+}
+
+// priceChanged - обработка новой цены одной заявкой, вызывается под ее блокировкой
+func (m *Manager) priceChanged(ctx context.Context, order *Order, price decimal.Decimal) {
+	if order.Status != StatusActive {
+		return
+	}
+
+	changed := false
+	for _, leg := range order.Legs {
+		if leg.State != LegWatching {
+			continue
+		}
+		if order.Trailing != nil {
+			stop := order.Trailing.follow(leg.Direction == "sell", price)
+			changed = changed || !stop.Equal(leg.StopPrice)
+			leg.StopPrice = stop
+		}
+		if leg.crossed(price) {
+			m.logger.Infof("Synthetic order %s: %s triggered at %s", order.ID, leg.Role, price)
+			m.trigger(ctx, order, leg)
+			changed = true
+		}
+	}
+	if changed {
+		m.advance(ctx, order)
+		m.save(ctx, order)
+	}
+}
+
+// trigger - выставление рыночной заявки сработавшей стоп-ноги. Выставленные
+// заявки остальных ног сначала отменяются, а частично исполненное ими
+// количество вычитается, чтобы позиция не закрылась дважды.
+func (m *Manager) trigger(ctx context.Context, order *Order, leg *Leg) {
+	lots := leg.Lots
+	for _, sibling := range order.Legs {
+		if sibling == leg || sibling.Role == RoleEntry || !sibling.live() {
+			continue
+		}
+		m.cancelLeg(ctx, order, sibling)
+		lots -= sibling.LotsExecuted
+	}
+
+	if lots <= 0 {
+		leg.State = LegCancelled
+		return
+	}
+	leg.Lots = lots
+	m.closePosition(ctx, order, leg)
+}
+
+// closePosition - выставление рыночной заявки сработавшей стоп-ноги. Позиция
+// без защиты, поэтому неудачное выставление повторяется при опросе.
+// Ошибка вызова не означает отказа: брокер мог принять заявку, а ответ
+// потеряться, поэтому повтор идет с тем же OrderID и вернет уже выставленную
+// заявку. Новый OrderID и новая попытка из maxStopAttempts - только после
+// явного отказа брокера. Подписчики OnAlert получают каждый отказ и первую
+// ошибку из серии одинаковых.
+func (m *Manager) closePosition(ctx context.Context, order *Order, leg *Leg) {
+	err := m.submit(ctx, order, leg)
+	if err == nil && leg.State != LegRejected {
+		order.Error = ""
+		return
+	}
+
+	alert := Alert{
+		OrderID:      order.ID,
+		AccountID:    order.AccountID,
+		InstrumentID: order.InstrumentID,
+		Role:         leg.Role,
+		Retrying:     true,
+		Time:         time.Now(),
+	}
+	if err != nil {
+		leg.State = LegRetrying
+		alert.Attempt = leg.Attempts + 1
+		m.logger.Errorf("Synthetic order %s: %s order not confirmed, retrying with the same order id, position is not closed: %v",
+			order.ID, leg.Role, err)
+	} else {
+		err = fmt.Errorf("order %s rejected by broker", leg.OrderID)
+		leg.Attempts++
+		alert.Attempt = leg.Attempts
+		alert.Retrying = leg.Attempts < maxStopAttempts
+		if alert.Retrying {
+			leg.State = LegRetrying
+			m.logger.Errorf("Synthetic order %s: %s order rejected, attempt %d of %d, position is not closed: %v",
+				order.ID, leg.Role, leg.Attempts, maxStopAttempts, err)
+		} else {
+			m.logger.Errorf("Synthetic order %s: %s order rejected %d times, position is left open: %v",
+				order.ID, leg.Role, leg.Attempts, err)
+		}
+	}
+
+	message := fmt.Sprintf("%s: %v", leg.Role, err)
+	repeated := order.Error == message
+	order.Error = message
+	alert.Error = err.Error()
+	if repeated {
+		return
+	}
+
+	m.mu.Lock()
+	observers := m.observers
+	m.mu.Unlock()
+	for _, observer := range observers {
+		observer(alert)
+	}
+}
+
+// poll - проверка исполнения выставленных заявок ног и повтор отклоненных стоп-ног
+func (m *Manager) poll(ctx context.Context) {
+	for _, t := range m.snapshot() {
+		t.mu.Lock()
+		m.refresh(ctx, t.order)
+		t.mu.Unlock()
+	}
+}
+
+// refresh - опрос ног одной заявки, вызывается под ее блокировкой
+func (m *Manager) refresh(ctx context.Context, order *Order) {
+	if order.Status.Done() {
+		return
+	}
+
+	changed := false
+	for _, leg := range order.Legs {
+		switch leg.State {
+		case LegRetrying:
+			m.closePosition(ctx, order, leg)
+			changed = true
+		case LegWorking:
+			state, err := m.orders.GetOrderState(ctx, order.AccountID, leg.OrderID)
+			if err != nil {
+				m.logger.Warnf("Synthetic order %s: failed to get state of %s order %s: %v", order.ID, leg.Role, leg.OrderID, err)
+				continue
+			}
+			before := *leg
+			applyState(leg, state.GetExecutionReportStatus(), state.GetLotsExecuted(), money.FromMoneyValue(state.GetAveragePositionPrice()))
+			changed = changed || *leg != before
+		}
+	}
+	if changed {
+		m.advance(ctx, order)
+		m.save(ctx, order)
+	}
+}
+
+// advance - переход заявки к следующему этапу по состоянию ног
+func (m *Manager) advance(ctx context.Context, order *Order) {
+	if order.Status.Done() {
+		return
+	}
+
+	if order.Status == StatusPending {
+		entry := order.leg(RoleEntry)
+		switch {
+		case entry.State == LegFilled, !entry.live() && entry.LotsExecuted > 0:
+			m.openExits(ctx, order, entry.LotsExecuted)
+		case !entry.live():
+			m.finish(ctx, order, StatusFailed, fmt.Sprintf("entry order was %s", entry.State))
+		}
+		return
+	}
+
+	// Исполнение любой ноги на выход завершает заявку. Отклоненная нога
+	// не отменяет остальные: позиция остается под защитой оставшихся ног.
+	live := false
+	for _, leg := range order.Legs {
+		if leg.Role == RoleEntry {
+			continue
+		}
+		if leg.State == LegFilled {
+			m.finish(ctx, order, StatusCompleted, "")
+			return
+		}
+		live = live || leg.live()
+	}
+	if !live {
+		status := StatusFailed
+		for _, leg := range order.Legs {
+			if leg.Role != RoleEntry && leg.LotsExecuted > 0 {
+				status = StatusCompleted
+			}
+		}
+		m.finish(ctx, order, status, order.Error)
+	}
+}
+
+// openExits - выставление ног на выход bracket на исполненное количество входа
+func (m *Manager) openExits(ctx context.Context, order *Order, lots int64) {
+	order.Status = StatusActive
+	for _, leg := range order.Legs {
+		if leg.Role == RoleEntry {
+			continue
+		}
+		leg.Lots = lots
+		switch leg.Type {
+		case LegStop:
+			leg.State = LegWatching
+		default:
+			if err := m.submit(ctx, order, leg); err != nil {
+				order.Error = fmt.Sprintf("%s: %v", leg.Role, err)
+			}
+		}
+	}
+}
+
+// finish - завершение заявки с отменой оставшихся ног
+func (m *Manager) finish(ctx context.Context, order *Order, status Status, reason string) {
+	for _, leg := range order.Legs {
+		if leg.live() {
+			m.cancelLeg(ctx, order, leg)
+		}
+	}
+	order.Status = status
+	order.Error = reason
+	if status == StatusFailed {
+		m.logger.Warnf("Synthetic order %s failed: %s", order.ID, reason)
+	}
+	m.unwatch(order)
+}
+
+// submit - выставление заявки ноги. OrderID выводится из ID заявки, роли ноги
+// и числа отказов брокера, поэтому повтор после сбоя не выставит заявку дважды,
+// а новая попытка после отказа не получит прежний отказ.
+func (m *Manager) submit(ctx context.Context, order *Order, leg *Leg) error {
+	direction, err := broker.ParseDirection(leg.Direction)
+	if err != nil {
+		return err
+	}
+	req := broker.OrderRequest{
+		AccountID:    order.AccountID,
+		InstrumentID: order.InstrumentID,
+		Direction:    direction,
+		OrderType:    pb.OrderType_ORDER_TYPE_MARKET,
+		Lots:         leg.Lots,
+		OrderID:      idempotency.OrderID(order.ID, legKey(leg)),
+		Origin:       broker.OriginSynthetic,
+		OriginID:     order.ID,
+	}
+	if leg.Type == LegLimit {
+		req.OrderType = pb.OrderType_ORDER_TYPE_LIMIT
+		req.Price = leg.Price
+	}
+
+	resp, err := m.orders.PostOrder(ctx, req)
+	if err != nil {
+		leg.State = LegRejected
+		m.logger.Errorf("Synthetic order %s: failed to post %s order: %v", order.ID, leg.Role, err)
+		return err
+	}
+	leg.OrderID = resp.GetOrderId()
+	applyState(leg, resp.GetExecutionReportStatus(), resp.GetLotsExecuted(), money.FromMoneyValue(resp.GetExecutedOrderPrice()))
+	return nil
+}
+
+// legKey - ключ идемпотентности заявки ноги, меняется только после отказа брокера
+func legKey(leg *Leg) string {
+	if leg.Attempts == 0 {
+		return leg.Role
+	}
+	return fmt.Sprintf("%s_%d", leg.Role, leg.Attempts)
+}
+
+// cancelLeg - отмена ноги; выставленная заявка снимается у брокера,
+// а ее исполненное количество уточняется
+func (m *Manager) cancelLeg(ctx context.Context, order *Order, leg *Leg) {
+	if leg.State != LegWorking {
+		leg.State = LegCancelled
+		return
+	}
+
+	if _, err := m.orders.CancelOrder(ctx, order.AccountID, leg.OrderID); err != nil {
+		m.logger.Warnf("Synthetic order %s: failed to cancel %s order %s: %v", order.ID, leg.Role, leg.OrderID, err)
+	}
+	state, err := m.orders.GetOrderState(ctx, order.AccountID, leg.OrderID)
+	if err != nil {
+		leg.State = LegCancelled
+		return
+	}
+	applyState(leg, state.GetExecutionReportStatus(), state.GetLotsExecuted(), money.FromMoneyValue(state.GetAveragePositionPrice()))
+	if leg.State == LegWorking {
+		leg.State = LegCancelled
+	}
+}
+
+// applyState - состояние ноги по статусу заявки у брокера
+func applyState(leg *Leg, status pb.OrderExecutionReportStatus, lotsExecuted int64, price decimal.Decimal) {
+	leg.LotsExecuted = lotsExecuted
+	if lotsExecuted > 0 && price.IsPositive() {
+		leg.ExecutedPrice = price
+	}
+	switch status {
+	case pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_FILL:
+		leg.State = LegFilled
+	case pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_CANCELLED:
+		leg.State = LegCancelled
+	case pb.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_REJECTED:
+		leg.State = LegRejected
+	default:
+		leg.State = LegWorking
+	}
+}
+
+// watch - учет активной заявки и подписка на цены ее инструмента
+func (m *Manager) watch(t *tracked) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	order := t.order
+	m.active[order.ID] = t
+	m.watched[order.InstrumentID]++
+	if m.watched[order.InstrumentID] == 1 {
+		if err := m.feed.SubscribeLastPrice([]string{order.InstrumentID}); err != nil {
+			m.logger.Errorf("Failed to subscribe to prices of %s: %v", order.InstrumentID, err)
+		}
+	}
+}
+
+// unwatch - снятие заявки с отслеживания и отписка от цен, если она была последней
+func (m *Manager) unwatch(order *Order) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.active[order.ID]; !exists {
+		return
+	}
+	delete(m.active, order.ID)
+	m.watched[order.InstrumentID]--
+	if m.watched[order.InstrumentID] > 0 {
+		return
+	}
+	delete(m.watched, order.InstrumentID)
+	if err := m.feed.UnSubscribeLastPrice([]string{order.InstrumentID}); err != nil {
+		m.logger.Warnf("Failed to unsubscribe from prices of %s: %v", order.InstrumentID, err)
+	}
+}
+
+// save - сохранение заявки; ошибка базы не останавливает отслеживание
+func (m *Manager) save(ctx context.Context, order *Order) {
+	order.UpdatedAt = time.Now()
+	if err := m.store.SaveSyntheticOrder(context.WithoutCancel(ctx), order.clone()); err != nil {
+		m.logger.Errorf("Failed to save synthetic order %s: %v", order.ID, err)
+	}
+}
@@ -0,0 +1,113 @@
+package synthetic
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+	"go.uber.org/zap"
+
+	"trading-bot-web/broker"
+	"trading-bot-web/money"
+)
+
+const testFigi = "BBG004730N88"
+
+// testFeed - стрим цен без подключения, цены передаются в onPrice напрямую
+type testFeed struct{}
+
+func (testFeed) Run(ctx context.Context)                 {}
+func (testFeed) SubscribeLastPrice(ids []string) error   { return nil }
+func (testFeed) UnSubscribeLastPrice(ids []string) error { return nil }
+func (testFeed) LastPrices() <-chan *pb.LastPrice        { return nil }
+
+// testStore - последние сохраненные версии заявок
+type testStore struct {
+	mu     sync.Mutex
+	orders map[string]Order
+}
+
+func (s *testStore) SaveSyntheticOrder(ctx context.Context, order Order) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.orders[order.ID] = order
+	return nil
+}
+
+func (s *testStore) ListSyntheticOrders(ctx context.Context, active bool) ([]Order, error) {
+	return nil, nil
+}
+
+func (s *testStore) order(id string) *Order {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order := s.orders[id]
+	return &order
+}
+
+func TestStopRetryAfterLostResponse(t *testing.T) {
+	ctx := context.Background()
+	fake := broker.NewFake()
+	fake.SetLastPrice(testFigi, 89)
+	store := &testStore{orders: make(map[string]Order)}
+	manager := NewManager(fake, fake, testFeed{}, store, 0, zap.NewNop().Sugar())
+
+	var alerts []Alert
+	manager.OnAlert(func(alert Alert) { alerts = append(alerts, alert) })
+
+	takeProfit := decimal.NewFromInt(110)
+	stopLoss := decimal.NewFromInt(90)
+	order, err := manager.Create(ctx, Spec{
+		Kind:         KindOCO,
+		AccountID:    "acc",
+		InstrumentID: testFigi,
+		Direction:    pb.OrderDirection_ORDER_DIRECTION_SELL,
+		Lots:         2,
+		TakeProfit:   &takeProfit,
+		StopLoss:     &stopLoss,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Брокер принимает и сразу исполняет заявку стопа, но ответ теряется
+	fake.FailAfterPost(errors.New("context deadline exceeded"))
+	manager.onPrice(ctx, &pb.LastPrice{Figi: testFigi, Price: money.ToQuotation(decimal.NewFromInt(89))})
+	manager.poll(ctx)
+
+	stop := store.order(order.ID).leg(RoleStopLoss)
+	if stop.State != LegRetrying || stop.Attempts != 0 {
+		t.Fatalf("stop leg = %+v, want retrying without counted attempts", *stop)
+	}
+	if len(alerts) != 1 || !alerts[0].Retrying || alerts[0].Attempt != 1 {
+		t.Errorf("alerts = %+v, want one retrying alert for attempt 1", alerts)
+	}
+
+	fake.FailAfterPost(nil)
+	manager.poll(ctx)
+
+	saved := store.order(order.ID)
+	if saved.Status != StatusCompleted {
+		t.Fatalf("status = %s, want %s: %s", saved.Status, StatusCompleted, saved.Error)
+	}
+	if stop := saved.leg(RoleStopLoss); stop.State != LegFilled || stop.LotsExecuted != 2 {
+		t.Errorf("stop leg = %+v, want 2 lots filled", *stop)
+	}
+
+	// Повторы шли с тем же OrderID, и брокер выставил одну заявку на выход
+	var exits []broker.OrderRequest
+	for _, req := range fake.Posted() {
+		if req.OrderType == pb.OrderType_ORDER_TYPE_MARKET {
+			exits = append(exits, req)
+		}
+	}
+	if len(exits) != 1 || exits[0].Lots != 2 {
+		t.Errorf("market orders = %+v, want one for 2 lots", exits)
+	}
+	if len(manager.Active("acc")) != 0 {
+		t.Error("completed order is still active")
+	}
+}
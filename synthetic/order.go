@@ -0,0 +1,305 @@
+package synthetic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+)
+
+// Kind - вид синтетической заявки
+type Kind string
+
+const (
+	// KindOCO - тейк-профит и стоп-лосс на выход: исполнение одного отменяет другой
+	KindOCO Kind = "oco"
+	// KindBracket - заявка на вход, после исполнения которой выставляется OCO на выход
+	KindBracket Kind = "bracket"
+	// KindTrailingStop - стоп, который следует за ценой на заданном расстоянии
+	KindTrailingStop Kind = "trailing_stop"
+)
+
+// Status - состояние синтетической заявки
+type Status string
+
+const (
+	// StatusPending - bracket ждет исполнения заявки на вход
+	StatusPending Status = "pending"
+	// StatusActive - заявка на выход следит за ценой
+	StatusActive    Status = "active"
+	StatusCompleted Status = "completed"
+	StatusCancelled Status = "cancelled"
+	// StatusFailed - брокер отклонил или снял заявки всех ног
+	StatusFailed Status = "failed"
+)
+
+// Done - заявка завершена и больше не отслеживается
+func (s Status) Done() bool {
+	return s == StatusCompleted || s == StatusCancelled || s == StatusFailed
+}
+
+// LegType - как нога исполняется у брокера
+type LegType string
+
+const (
+	// LegMarket - рыночная заявка сразу при активации ноги
+	LegMarket LegType = "market"
+	// LegLimit - лимитная заявка, выставляется при активации и ждет исполнения у брокера
+	LegLimit LegType = "limit"
+	// LegStop - рыночная заявка при достижении стоп-цены, до этого брокер ее не видит
+	LegStop LegType = "stop"
+)
+
+// LegState - состояние ноги
+type LegState string
+
+const (
+	// LegIdle - нога ждет исполнения заявки на вход
+	LegIdle LegState = "idle"
+	// LegWatching - стоп-нога следит за ценой
+	LegWatching LegState = "watching"
+	// LegWorking - заявка выставлена брокеру
+	LegWorking LegState = "working"
+	// LegRetrying - заявка сработавшей стоп-ноги отклонена или не подтверждена,
+	// выставление повторяется при опросе, пока не кончатся попытки
+	LegRetrying  LegState = "retrying"
+	LegFilled    LegState = "filled"
+	LegCancelled LegState = "cancelled"
+	LegRejected  LegState = "rejected"
+)
+
+// Роли ног
+const (
+	RoleEntry      = "entry"
+	RoleTakeProfit = "take_profit"
+	RoleStopLoss   = "stop_loss"
+)
+
+// Leg - одна из заявок, из которых состоит синтетическая заявка.
+// Цены в пунктах котировки.
+type Leg struct {
+	Role      string           `json:"role"`
+	Type      LegType          `json:"type"`
+	Direction string           `json:"direction"`
+	Lots      int64            `json:"lots"`
+	Price     *decimal.Decimal `json:"price,omitempty"`
+	StopPrice decimal.Decimal  `json:"stop_price"`
+	State     LegState         `json:"state"`
+	// OrderID - заявка брокера, выставленная для ноги
+	OrderID       string          `json:"order_id,omitempty"`
+	LotsExecuted  int64           `json:"lots_executed"`
+	ExecutedPrice decimal.Decimal `json:"executed_price"`
+	// Attempts - явные отказы брокера в заявке стоп-ноги; ошибки вызова не считаются
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// live - нога еще может исполниться
+func (l *Leg) live() bool {
+	return l.State == LegIdle || l.State == LegWatching || l.State == LegWorking || l.State == LegRetrying
+}
+
+// crossed - достигнута ли стоп-цена: продажа срабатывает на падении, покупка - на росте
+func (l *Leg) crossed(price decimal.Decimal) bool {
+	if l.Direction == "sell" {
+		return price.LessThanOrEqual(l.StopPrice)
+	}
+	return price.GreaterThanOrEqual(l.StopPrice)
+}
+
+// Trailing - параметры трейлинг-стопа
+type Trailing struct {
+	// Percent или Step - отступ стопа от лучшей цены в процентах или пунктах
+	Percent decimal.Decimal `json:"percent"`
+	Step    decimal.Decimal `json:"step"`
+	// Extreme - лучшая цена с момента создания: максимум для продажи, минимум для покупки
+	Extreme decimal.Decimal `json:"extreme"`
+}
+
+// follow - сдвиг лучшей цены, возвращает стоп-цену; стоп двигается только в сторону позиции
+func (t *Trailing) follow(sell bool, price decimal.Decimal) decimal.Decimal {
+	if t.Extreme.IsZero() || (sell && price.GreaterThan(t.Extreme)) || (!sell && price.LessThan(t.Extreme)) {
+		t.Extreme = price
+	}
+	offset := t.Step
+	if t.Percent.IsPositive() {
+		offset = t.Extreme.Mul(t.Percent).Div(decimal.NewFromInt(100))
+	}
+	if sell {
+		return t.Extreme.Sub(offset)
+	}
+	return t.Extreme.Add(offset)
+}
+
+// Order - синтетическая заявка: OCO, bracket или трейлинг-стоп
+type Order struct {
+	ID           string    `json:"id"`
+	Kind         Kind      `json:"kind"`
+	AccountID    string    `json:"account_id"`
+	InstrumentID string    `json:"instrument_id"`
+	Status       Status    `json:"status"`
+	Legs         []*Leg    `json:"legs"`
+	Trailing     *Trailing `json:"trailing,omitempty"`
+	// Error - причина завершения со статусом failed или сбоя отдельной ноги
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// leg - нога с ролью, nil если ее нет
+func (o *Order) leg(role string) *Leg {
+	for _, leg := range o.Legs {
+		if leg.Role == role {
+			return leg
+		}
+	}
+	return nil
+}
+
+// clone - копия заявки для отдачи за пределы менеджера
+func (o *Order) clone() Order {
+	c := *o
+	c.Legs = make([]*Leg, len(o.Legs))
+	for i, leg := range o.Legs {
+		l := *leg
+		c.Legs[i] = &l
+	}
+	if o.Trailing != nil {
+		t := *o.Trailing
+		c.Trailing = &t
+	}
+	return c
+}
+
+// Store - хранилище синтетических заявок
+type Store interface {
+	// SaveSyntheticOrder - сохранение заявки или обновление уже сохраненной
+	SaveSyntheticOrder(ctx context.Context, order Order) error
+	// ListSyntheticOrders - заявки, с active только незавершенные
+	ListSyntheticOrders(ctx context.Context, active bool) ([]Order, error)
+}
+
+// Spec - параметры новой синтетической заявки, цены в пунктах котировки
+type Spec struct {
+	Kind         Kind
+	AccountID    string
+	InstrumentID string
+	// Direction - направление входа для bracket, выхода для oco и trailing_stop
+	Direction pb.OrderDirection
+	Lots      int64
+	// EntryPrice - цена входа bracket, nil - рыночный вход
+	EntryPrice *decimal.Decimal
+	TakeProfit *decimal.Decimal
+	StopLoss   *decimal.Decimal
+	// TrailPercent или TrailStep - отступ трейлинг-стопа
+	TrailPercent decimal.Decimal
+	TrailStep    decimal.Decimal
+}
+
+// ErrNotFound - синтетической заявки нет или она уже завершена
+var ErrNotFound = errors.New("synthetic order not found")
+
+// SpecError - недопустимые параметры новой заявки
+type SpecError struct {
+	message string
+}
+
+func (e *SpecError) Error() string {
+	return e.message
+}
+
+// invalid - создание ошибки параметров
+func invalid(format string, args ...any) error {
+	return &SpecError{message: fmt.Sprintf(format, args...)}
+}
+
+// build - проверка параметров и создание заявки с ногами
+func (s Spec) build(id string, now time.Time) (*Order, error) {
+	if s.Lots < 1 {
+		return nil, invalid("quantity must be positive")
+	}
+	var sell bool
+	switch s.Direction {
+	case pb.OrderDirection_ORDER_DIRECTION_BUY:
+	case pb.OrderDirection_ORDER_DIRECTION_SELL:
+		sell = true
+	default:
+		return nil, invalid("direction is required")
+	}
+
+	order := &Order{
+		ID:           id,
+		Kind:         s.Kind,
+		AccountID:    s.AccountID,
+		InstrumentID: s.InstrumentID,
+		Status:       StatusActive,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+
+	switch s.Kind {
+	case KindOCO, KindBracket:
+		if !s.TrailPercent.IsZero() || !s.TrailStep.IsZero() {
+			return nil, invalid("trailing is not allowed for %s order", s.Kind)
+		}
+		if s.TakeProfit == nil || s.StopLoss == nil {
+			return nil, invalid("take_profit and stop_loss are required for %s order", s.Kind)
+		}
+
+		exitSell := sell
+		if s.Kind == KindBracket {
+			exitSell = !sell
+			entry := &Leg{Role: RoleEntry, Type: LegMarket, Direction: directionName(sell), Lots: s.Lots, State: LegIdle}
+			if s.EntryPrice != nil {
+				entry.Type = LegLimit
+				entry.Price = s.EntryPrice
+			}
+			order.Legs = append(order.Legs, entry)
+			order.Status = StatusPending
+		} else if s.EntryPrice != nil {
+			return nil, invalid("entry_price is only allowed for bracket order")
+		}
+
+		// Продажа на выход: тейк-профит выше стоп-лосса, покупка - наоборот
+		low, high := *s.StopLoss, *s.TakeProfit
+		if !exitSell {
+			low, high = high, low
+		}
+		if low.GreaterThanOrEqual(high) {
+			return nil, invalid("take_profit and stop_loss are on the wrong side of each other")
+		}
+		if s.EntryPrice != nil && (s.EntryPrice.LessThanOrEqual(low) || s.EntryPrice.GreaterThanOrEqual(high)) {
+			return nil, invalid("entry_price must be between stop_loss and take_profit")
+		}
+
+		order.Legs = append(order.Legs,
+			&Leg{Role: RoleTakeProfit, Type: LegLimit, Direction: directionName(exitSell), Lots: s.Lots, Price: s.TakeProfit, State: LegIdle},
+			&Leg{Role: RoleStopLoss, Type: LegStop, Direction: directionName(exitSell), Lots: s.Lots, StopPrice: *s.StopLoss, State: LegIdle},
+		)
+	case KindTrailingStop:
+		if s.EntryPrice != nil || s.TakeProfit != nil || s.StopLoss != nil {
+			return nil, invalid("only trail_percent or trail_step is allowed for trailing_stop order")
+		}
+		if s.TrailPercent.IsPositive() == s.TrailStep.IsPositive() || s.TrailPercent.IsNegative() || s.TrailStep.IsNegative() {
+			return nil, invalid("exactly one of trail_percent and trail_step must be positive")
+		}
+		if s.TrailPercent.GreaterThanOrEqual(decimal.NewFromInt(100)) {
+			return nil, invalid("trail_percent must be below 100")
+		}
+		order.Trailing = &Trailing{Percent: s.TrailPercent, Step: s.TrailStep}
+		order.Legs = append(order.Legs, &Leg{Role: RoleStopLoss, Type: LegStop, Direction: directionName(sell), Lots: s.Lots, State: LegIdle})
+	default:
+		return nil, invalid("unknown synthetic order kind %q, expected oco, bracket or trailing_stop", s.Kind)
+	}
+	return order, nil
+}
+
+// directionName - направление в представлении API
+func directionName(sell bool) string {
+	if sell {
+		return "sell"
+	}
+	return "buy"
+}
@@ -0,0 +1,268 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+
+	"trading-bot-web/streams"
+)
+
+// Каналы операций, на которые клиенты подписываются по AccountIDs
+const (
+	ChannelPortfolio   = "portfolio"
+	ChannelPositions   = "positions"
+	ChannelOrderTrades = "order_trades"
+)
+
+// PortfolioPositionData - позиция портфеля
+type PortfolioPositionData struct {
+	Figi          string  `json:"figi"`
+	InstrumentUID string  `json:"instrument_uid,omitempty"`
+	Quantity      float64 `json:"quantity"`
+	AveragePrice  float64 `json:"average_price"`
+	CurrentPrice  float64 `json:"current_price"`
+	ExpectedYield float64 `json:"expected_yield"`
+}
+
+// PortfolioData - портфель счета
+type PortfolioData struct {
+	AccountID     string                  `json:"account_id"`
+	TotalAmount   float64                 `json:"total_amount"`
+	ExpectedYield float64                 `json:"expected_yield"`
+	Positions     []PortfolioPositionData `json:"positions"`
+}
+
+// MoneyData - денежная позиция
+type MoneyData struct {
+	Currency  string  `json:"currency"`
+	Available float64 `json:"available"`
+	Blocked   float64 `json:"blocked"`
+}
+
+// SecurityData - позиция по бумаге
+type SecurityData struct {
+	Figi          string `json:"figi"`
+	InstrumentUID string `json:"instrument_uid,omitempty"`
+	Balance       int64  `json:"balance"`
+	Blocked       int64  `json:"blocked"`
+}
+
+// PositionsData - изменение позиций счета
+type PositionsData struct {
+	AccountID  string         `json:"account_id"`
+	Money      []MoneyData    `json:"money"`
+	Securities []SecurityData `json:"securities"`
+	Time       time.Time      `json:"time"`
+}
+
+// OrderTradeData - сделка по заявке
+type OrderTradeData struct {
+	TradeID  string    `json:"trade_id"`
+	Price    float64   `json:"price"`
+	Quantity int64     `json:"quantity"`
+	Time     time.Time `json:"time"`
+}
+
+// OrderTradesData - исполнение заявки
+type OrderTradesData struct {
+	AccountID     string           `json:"account_id"`
+	OrderID       string           `json:"order_id"`
+	Figi          string           `json:"figi"`
+	InstrumentUID string           `json:"instrument_uid,omitempty"`
+	Direction     string           `json:"direction"`
+	Trades        []OrderTradeData `json:"trades"`
+	Time          time.Time        `json:"time"`
+}
+
+// isOperationsChannel - канал данных по счетам
+func isOperationsChannel(channel string) bool {
+	switch channel {
+	case ChannelPortfolio, ChannelPositions, ChannelOrderTrades:
+		return true
+	}
+	return false
+}
+
+// SetAccounts - счета для стримов операций, если в конфиге список пуст
+func (sm *StreamManager) SetAccounts(accounts []string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.accounts = append([]string(nil), accounts...)
+}
+
+// startOperationsStream - открытие стримов портфеля, позиций и сделок по заявкам
+// для счетов из конфига
+func (sm *StreamManager) startOperationsStream() error {
+	cfg := sm.cfg.Operations
+	if !cfg.Enabled {
+		sm.logger.Info("Operations stream is disabled")
+		return nil
+	}
+
+	accounts := cfg.Accounts
+	if len(accounts) == 0 {
+		sm.mu.Lock()
+		accounts = sm.accounts
+		sm.mu.Unlock()
+	}
+	if len(accounts) == 0 {
+		sm.logger.Warn("Operations stream has no accounts")
+		return nil
+	}
+
+	for _, channel := range cfg.Subscriptions {
+		if err := sm.openOperationsStream(channel, accounts); err != nil {
+			return err
+		}
+		streamed := make(map[string]bool, len(accounts))
+		for _, id := range accounts {
+			streamed[id] = true
+		}
+		sm.mu.Lock()
+		sm.streamedAccounts[channel] = streamed
+		sm.mu.Unlock()
+	}
+
+	sm.logger.Infof("Operations stream started for %d accounts: %v", len(accounts), cfg.Subscriptions)
+	return nil
+}
+
+// openOperationsStream - запуск стрима канала операций с переподключением
+// и пересылкой данных подписчикам
+func (sm *StreamManager) openOperationsStream(channel string, accounts []string) error {
+	var connect func(ctx context.Context, reconnect bool) (streams.Session, error)
+	switch channel {
+	case ChannelPortfolio:
+		connect = func(context.Context, bool) (streams.Session, error) {
+			stream, err := sm.operationsStream.PortfolioStream(accounts)
+			if err != nil {
+				return streams.Session{}, fmt.Errorf("portfolio stream error: %w", err)
+			}
+			go relay(sm, channel, stream.Portfolios(), accountIDs, portfolioMessage)
+			return streams.Session{Listen: stream.Listen, Stop: stream.Stop}, nil
+		}
+	case ChannelPositions:
+		connect = func(context.Context, bool) (streams.Session, error) {
+			stream, err := sm.operationsStream.PositionsStream(accounts)
+			if err != nil {
+				return streams.Session{}, fmt.Errorf("positions stream error: %w", err)
+			}
+			go relay(sm, channel, stream.Positions(), accountIDs, positionsMessage)
+			return streams.Session{Listen: stream.Listen, Stop: stream.Stop}, nil
+		}
+	case ChannelOrderTrades:
+		connect = func(context.Context, bool) (streams.Session, error) {
+			stream, err := sm.ordersStream.TradesStream(accounts)
+			if err != nil {
+				return streams.Session{}, fmt.Errorf("trades stream error: %w", err)
+			}
+			go relay(sm, channel, stream.Trades(), accountIDs, orderTradesMessage)
+			return streams.Session{Listen: stream.Listen, Stop: stream.Stop}, nil
+		}
+	default:
+		return fmt.Errorf("unknown operations channel %q", channel)
+	}
+
+	go sm.monitor.Run(sm.ctx, "operations_"+channel, connect)
+	return nil
+}
+
+// checkAccounts - все счета подписки транслируются по каналу
+func (sm *StreamManager) checkAccounts(channel string, accounts []string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	streamed, exists := sm.streamedAccounts[channel]
+	if !exists {
+		return fmt.Errorf("%s stream is not running", channel)
+	}
+	for _, id := range accounts {
+		if !streamed[id] {
+			return fmt.Errorf("account %s is not streamed", id)
+		}
+	}
+	return nil
+}
+
+// accountData - данные операций по счету
+type accountData interface {
+	GetAccountId() string
+}
+
+// accountIDs - счет, подписчикам которого отправляются данные
+func accountIDs[T accountData](value T) []string {
+	return []string{value.GetAccountId()}
+}
+
+// portfolioMessage - портфель для клиента
+func portfolioMessage(portfolio *pb.PortfolioResponse) interface{} {
+	positions := make([]PortfolioPositionData, 0, len(portfolio.GetPositions()))
+	for _, pos := range portfolio.GetPositions() {
+		positions = append(positions, PortfolioPositionData{
+			Figi:          pos.GetFigi(),
+			InstrumentUID: pos.GetInstrumentUid(),
+			Quantity:      pos.GetQuantity().ToFloat(),
+			AveragePrice:  pos.GetAveragePositionPrice().ToFloat(),
+			CurrentPrice:  pos.GetCurrentPrice().ToFloat(),
+			ExpectedYield: pos.GetExpectedYield().ToFloat(),
+		})
+	}
+	return PortfolioData{
+		AccountID:     portfolio.GetAccountId(),
+		TotalAmount:   portfolio.GetTotalAmountPortfolio().ToFloat(),
+		ExpectedYield: portfolio.GetExpectedYield().ToFloat(),
+		Positions:     positions,
+	}
+}
+
+// positionsMessage - изменение позиций для клиента
+func positionsMessage(data *pb.PositionData) interface{} {
+	money := make([]MoneyData, 0, len(data.GetMoney()))
+	for _, m := range data.GetMoney() {
+		money = append(money, MoneyData{
+			Currency:  m.GetAvailableValue().GetCurrency(),
+			Available: m.GetAvailableValue().ToFloat(),
+			Blocked:   m.GetBlockedValue().ToFloat(),
+		})
+	}
+	securities := make([]SecurityData, 0, len(data.GetSecurities()))
+	for _, security := range data.GetSecurities() {
+		securities = append(securities, SecurityData{
+			Figi:          security.GetFigi(),
+			InstrumentUID: security.GetInstrumentUid(),
+			Balance:       security.GetBalance(),
+			Blocked:       security.GetBlocked(),
+		})
+	}
+	return PositionsData{
+		AccountID:  data.GetAccountId(),
+		Money:      money,
+		Securities: securities,
+		Time:       data.GetDate().AsTime(),
+	}
+}
+
+// orderTradesMessage - исполнение заявки для клиента
+func orderTradesMessage(orderTrades *pb.OrderTrades) interface{} {
+	trades := make([]OrderTradeData, 0, len(orderTrades.GetTrades()))
+	for _, trade := range orderTrades.GetTrades() {
+		trades = append(trades, OrderTradeData{
+			TradeID:  trade.GetTradeId(),
+			Price:    trade.GetPrice().ToFloat(),
+			Quantity: trade.GetQuantity(),
+			Time:     trade.GetDateTime().AsTime(),
+		})
+	}
+	return OrderTradesData{
+		AccountID:     orderTrades.GetAccountId(),
+		OrderID:       orderTrades.GetOrderId(),
+		Figi:          orderTrades.GetFigi(),
+		InstrumentUID: orderTrades.GetInstrumentUid(),
+		Direction:     orderTrades.GetDirection().String(),
+		Trades:        trades,
+		Time:          orderTrades.GetCreatedAt().AsTime(),
+	}
+}
@@ -0,0 +1,394 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tinkoff/invest-api-go-sdk/investgo"
+	pb "github.com/tinkoff/invest-api-go-sdk/proto"
+	"go.uber.org/zap"
+
+	"trading-bot-web/broker"
+	"trading-bot-web/config"
+	"trading-bot-web/streams"
+)
+
+// Каналы маркетдаты, на которые подписываются клиенты WebSocket
+const (
+	ChannelCandles   = "candles"
+	ChannelOrderBook = "orderbook"
+	ChannelTrades    = "trades"
+	ChannelLastPrice = "last_price"
+	ChannelInfo      = "info"
+)
+
+// CandleData - минутная свеча
+type CandleData struct {
+	Figi          string    `json:"figi"`
+	InstrumentUID string    `json:"instrument_uid,omitempty"`
+	Open          float64   `json:"open"`
+	High          float64   `json:"high"`
+	Low           float64   `json:"low"`
+	Close         float64   `json:"close"`
+	Volume        int64     `json:"volume"`
+	Time          time.Time `json:"time"`
+}
+
+// PriceLevel - уровень стакана
+type PriceLevel struct {
+	Price    float64 `json:"price"`
+	Quantity int64   `json:"quantity"`
+}
+
+// OrderBookData - стакан
+type OrderBookData struct {
+	Figi          string       `json:"figi"`
+	InstrumentUID string       `json:"instrument_uid,omitempty"`
+	Depth         int32        `json:"depth"`
+	Bids          []PriceLevel `json:"bids"`
+	Asks          []PriceLevel `json:"asks"`
+	Time          time.Time    `json:"time"`
+}
+
+// TradeData - обезличенная сделка
+type TradeData struct {
+	Figi          string    `json:"figi"`
+	InstrumentUID string    `json:"instrument_uid,omitempty"`
+	Direction     string    `json:"direction"`
+	Price         float64   `json:"price"`
+	Quantity      int64     `json:"quantity"`
+	Time          time.Time `json:"time"`
+}
+
+// LastPriceData - последняя цена
+type LastPriceData struct {
+	Figi          string    `json:"figi"`
+	InstrumentUID string    `json:"instrument_uid,omitempty"`
+	Price         float64   `json:"price"`
+	Time          time.Time `json:"time"`
+}
+
+// TradingStatusData - торговый статус инструмента
+type TradingStatusData struct {
+	Figi                 string    `json:"figi"`
+	InstrumentUID        string    `json:"instrument_uid,omitempty"`
+	TradingStatus        string    `json:"trading_status"`
+	LimitOrderAvailable  bool      `json:"limit_order_available"`
+	MarketOrderAvailable bool      `json:"market_order_available"`
+	Time                 time.Time `json:"time"`
+}
+
+// StreamManager - менеджер для управления стримами данных.
+// Один стрим маркетдаты брокера разделяется между всеми клиентами:
+// подписка на инструмент открывается у брокера при первом подписчике
+// и закрывается после ухода последнего.
+type StreamManager struct {
+	hub    *Hub
+	client *investgo.Client
+	cfg    config.StreamsConfig
+	logger *zap.SugaredLogger
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// history - догрузка свечей после разрыва стрима маркетдаты
+	history broker.MarketDataProvider
+	// monitor - переподключение стримов
+	monitor *streams.Monitor
+
+	operationsStream *investgo.OperationsStreamClient
+	ordersStream     *investgo.OrdersStreamClient
+
+	mu     sync.Mutex
+	stream *streams.MarketData
+	// refs - число подписчиков по ключу "канал:инструмент"
+	refs map[string]int
+
+	// accounts - счета стрима операций, если в конфиге список пуст
+	accounts []string
+	// streamedAccounts - счета, транслируемые по каждому каналу операций
+	streamedAccounts map[string]map[string]bool
+}
+
+// NewStreamManager - создание менеджера стримов
+func NewStreamManager(hub *Hub, client *investgo.Client, history broker.MarketDataProvider, monitor *streams.Monitor, cfg config.StreamsConfig, logger *zap.SugaredLogger) *StreamManager {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &StreamManager{
+		hub:              hub,
+		client:           client,
+		cfg:              cfg,
+		logger:           logger,
+		ctx:              ctx,
+		cancel:           cancel,
+		history:          history,
+		monitor:          monitor,
+		operationsStream: client.NewOperationsStreamClient(),
+		ordersStream:     client.NewOrdersStreamClient(),
+		refs:             make(map[string]int),
+		streamedAccounts: make(map[string]map[string]bool),
+	}
+}
+
+// Start - запуск менеджера стримов
+func (sm *StreamManager) Start() error {
+	sm.logger.Info("Starting stream manager...")
+
+	// Запускаем стрим маркетдаты
+	if err := sm.startMarketDataStream(); err != nil {
+		return err
+	}
+
+	// Запускаем стримы операций
+	if err := sm.startOperationsStream(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Stop - остановка менеджера стримов
+func (sm *StreamManager) Stop() {
+	sm.logger.Info("Stopping stream manager...")
+	sm.cancel()
+}
+
+// startMarketDataStream - открытие стрима маркетдаты и подписка на инструменты из конфига
+func (sm *StreamManager) startMarketDataStream() error {
+	cfg := sm.cfg.MarketData
+	if !cfg.Enabled {
+		sm.logger.Info("Market data stream is disabled")
+		return nil
+	}
+
+	stream := streams.NewMarketData("market_data", sm.client, sm.history, sm.monitor, cfg.BufferSize, sm.logger)
+	sm.mu.Lock()
+	sm.stream = stream
+	sm.mu.Unlock()
+
+	// Каналы стрима не меняются при переподключениях, поэтому читаются один раз
+	go relay(sm, ChannelCandles, stream.Candles(), instrumentIDs, candleMessage)
+	go relay(sm, ChannelOrderBook, stream.OrderBooks(), instrumentIDs, orderBookMessage)
+	go relay(sm, ChannelTrades, stream.Trades(), instrumentIDs, tradeMessage)
+	go relay(sm, ChannelLastPrice, stream.LastPrices(), instrumentIDs, lastPriceMessage)
+	go relay(sm, ChannelInfo, stream.TradingStatuses(), instrumentIDs, tradingStatusMessage)
+	go stream.Run(sm.ctx)
+
+	// Подписки из конфига не освобождаются до остановки менеджера
+	if len(cfg.Instruments) > 0 {
+		for _, channel := range cfg.Subscriptions {
+			if err := sm.Acquire(channel, cfg.Instruments); err != nil {
+				sm.logger.Errorf("Failed to subscribe to %s from config: %v", channel, err)
+			}
+		}
+	}
+
+	sm.logger.Infof("Market data stream started, %d instruments from config", len(cfg.Instruments))
+	return nil
+}
+
+// Acquire - учет подписчика на инструменты канала, у брокера подписываются
+// только инструменты без подписчиков. Для каналов операций проверяется,
+// что счета транслируются.
+func (sm *StreamManager) Acquire(channel string, ids []string) error {
+	if isOperationsChannel(channel) {
+		return sm.checkAccounts(channel, ids)
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.stream == nil {
+		return errors.New("market data stream is disabled")
+	}
+
+	var added []string
+	for _, id := range ids {
+		if sm.refs[topicKey(channel, id)] == 0 {
+			added = append(added, id)
+		}
+	}
+	if len(added) > 0 {
+		if err := sm.subscribe(channel, added); err != nil {
+			return err
+		}
+	}
+	for _, id := range ids {
+		sm.refs[topicKey(channel, id)]++
+	}
+	return nil
+}
+
+// Release - уход подписчика, у брокера отписываются инструменты без подписчиков
+func (sm *StreamManager) Release(channel string, ids []string) {
+	if isOperationsChannel(channel) {
+		return
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	var removed []string
+	for _, id := range ids {
+		key := topicKey(channel, id)
+		if sm.refs[key] == 0 {
+			continue
+		}
+		sm.refs[key]--
+		if sm.refs[key] == 0 {
+			delete(sm.refs, key)
+			removed = append(removed, id)
+		}
+	}
+	if len(removed) == 0 || sm.stream == nil {
+		return
+	}
+	if err := sm.unsubscribe(channel, removed); err != nil {
+		sm.logger.Errorf("Failed to unsubscribe from %s %v: %v", channel, removed, err)
+	}
+}
+
+// subscribe - подписка у брокера, вызывается под блокировкой.
+// Пока стрим переподключается, подписка запоминается и применяется после подключения.
+func (sm *StreamManager) subscribe(channel string, instruments []string) error {
+	switch channel {
+	case ChannelCandles:
+		return sm.stream.SubscribeCandle(instruments, pb.SubscriptionInterval_SUBSCRIPTION_INTERVAL_ONE_MINUTE)
+	case ChannelOrderBook:
+		return sm.stream.SubscribeOrderBook(instruments, sm.cfg.MarketData.OrderBookDepth)
+	case ChannelTrades:
+		return sm.stream.SubscribeTrade(instruments)
+	case ChannelLastPrice:
+		return sm.stream.SubscribeLastPrice(instruments)
+	case ChannelInfo:
+		return sm.stream.SubscribeInfo(instruments)
+	}
+	return fmt.Errorf("unknown market data channel %q", channel)
+}
+
+// unsubscribe - отписка у брокера, вызывается под блокировкой
+func (sm *StreamManager) unsubscribe(channel string, instruments []string) error {
+	switch channel {
+	case ChannelCandles:
+		return sm.stream.UnSubscribeCandle(instruments, pb.SubscriptionInterval_SUBSCRIPTION_INTERVAL_ONE_MINUTE)
+	case ChannelOrderBook:
+		return sm.stream.UnSubscribeOrderBook(instruments)
+	case ChannelTrades:
+		return sm.stream.UnSubscribeTrade(instruments)
+	case ChannelLastPrice:
+		return sm.stream.UnSubscribeLastPrice(instruments)
+	case ChannelInfo:
+		return sm.stream.UnSubscribeInfo(instruments)
+	}
+	return nil
+}
+
+// instrumentData - данные маркетдаты по инструменту
+type instrumentData interface {
+	GetFigi() string
+	GetInstrumentUid() string
+}
+
+// instrumentIDs - идентификаторы, по которым клиенты подписываются на данные инструмента
+func instrumentIDs[T instrumentData](value T) []string {
+	return []string{value.GetFigi(), value.GetInstrumentUid()}
+}
+
+// relay - пересылка данных из канала стрима подписчикам инструмента или счета
+func relay[T any](sm *StreamManager, channel string, ch <-chan T, ids func(T) []string, convert func(T) interface{}) {
+	for {
+		select {
+		case <-sm.ctx.Done():
+			return
+		case value, ok := <-ch:
+			if !ok {
+				return
+			}
+			sm.hub.Publish(channel, ids(value), Message{
+				Type:      channel,
+				Action:    "update",
+				Data:      convert(value),
+				Timestamp: time.Now().Unix(),
+			})
+		}
+	}
+}
+
+// topicKey - ключ подписки на инструмент канала
+func topicKey(channel, instrumentID string) string {
+	return channel + ":" + instrumentID
+}
+
+// candleMessage - свеча для клиента
+func candleMessage(candle *pb.Candle) interface{} {
+	return CandleData{
+		Figi:          candle.GetFigi(),
+		InstrumentUID: candle.GetInstrumentUid(),
+		Open:          candle.GetOpen().ToFloat(),
+		High:          candle.GetHigh().ToFloat(),
+		Low:           candle.GetLow().ToFloat(),
+		Close:         candle.GetClose().ToFloat(),
+		Volume:        candle.GetVolume(),
+		Time:          candle.GetTime().AsTime(),
+	}
+}
+
+// orderBookMessage - стакан для клиента
+func orderBookMessage(orderBook *pb.OrderBook) interface{} {
+	return OrderBookData{
+		Figi:          orderBook.GetFigi(),
+		InstrumentUID: orderBook.GetInstrumentUid(),
+		Depth:         orderBook.GetDepth(),
+		Bids:          priceLevels(orderBook.GetBids()),
+		Asks:          priceLevels(orderBook.GetAsks()),
+		Time:          orderBook.GetTime().AsTime(),
+	}
+}
+
+// priceLevels - уровни стакана
+func priceLevels(orders []*pb.Order) []PriceLevel {
+	levels := make([]PriceLevel, 0, len(orders))
+	for _, order := range orders {
+		levels = append(levels, PriceLevel{
+			Price:    order.GetPrice().ToFloat(),
+			Quantity: order.GetQuantity(),
+		})
+	}
+	return levels
+}
+
+// tradeMessage - сделка для клиента
+func tradeMessage(trade *pb.Trade) interface{} {
+	return TradeData{
+		Figi:          trade.GetFigi(),
+		InstrumentUID: trade.GetInstrumentUid(),
+		Direction:     trade.GetDirection().String(),
+		Price:         trade.GetPrice().ToFloat(),
+		Quantity:      trade.GetQuantity(),
+		Time:          trade.GetTime().AsTime(),
+	}
+}
+
+// lastPriceMessage - последняя цена для клиента
+func lastPriceMessage(price *pb.LastPrice) interface{} {
+	return LastPriceData{
+		Figi:          price.GetFigi(),
+		InstrumentUID: price.GetInstrumentUid(),
+		Price:         price.GetPrice().ToFloat(),
+		Time:          price.GetTime().AsTime(),
+	}
+}
+
+// tradingStatusMessage - торговый статус для клиента
+func tradingStatusMessage(status *pb.TradingStatus) interface{} {
+	return TradingStatusData{
+		Figi:                 status.GetFigi(),
+		InstrumentUID:        status.GetInstrumentUid(),
+		TradingStatus:        status.GetTradingStatus().String(),
+		LimitOrderAvailable:  status.GetLimitOrderAvailableFlag(),
+		MarketOrderAvailable: status.GetMarketOrderAvailableFlag(),
+		Time:                 status.GetTime().AsTime(),
+	}
+}
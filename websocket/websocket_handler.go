@@ -1,16 +1,18 @@
 package websocket
 
 import (
-	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
-	"github.com/tinkoff/invest-api-go-sdk/investgo"
 	"go.uber.org/zap"
+
+	"trading-bot-web/auth"
+	"trading-bot-web/middleware"
 )
 
 // Hub - центральный хаб для управления WebSocket соединениями
@@ -21,8 +23,24 @@ type Hub struct {
 	broadcast  chan []byte
 	mu         sync.RWMutex
 	logger     *zap.SugaredLogger
+
+	// listener - источник данных для подписок на инструменты и счета
+	listener SubscriptionListener
+	// authorizer - проверка доступа клиента к счету
+	authorizer AccountAuthorizer
+}
+
+// SubscriptionListener - получает подписки клиентов на инструменты или счета.
+// Acquire и Release вызываются на каждого клиента, поэтому реализация
+// должна вести счетчик ссылок.
+type SubscriptionListener interface {
+	Acquire(channel string, ids []string) error
+	Release(channel string, ids []string)
 }
 
+// AccountAuthorizer - разрешено ли субъекту получать данные счета
+type AccountAuthorizer func(principal *auth.Principal, accountID string) bool
+
 // Client - представляет WebSocket клиента
 type Client struct {
 	hub      *Hub
@@ -30,9 +48,11 @@ type Client struct {
 	send     chan []byte
 	userID   string
 	clientID string
+	// principal - субъект, прошедший аутентификацию при подключении
+	principal *auth.Principal
 
-	// Подписки
-	subscriptions map[string]bool
+	// Подписки: канал -> инструменты или счета, пустой набор - подписка на весь канал
+	subscriptions map[string]map[string]bool
 	mu            sync.RWMutex
 }
 
@@ -105,6 +125,8 @@ func (h *Hub) Run() {
 				delete(h.clients, client)
 				close(client.send)
 				h.logger.Infof("Client %s disconnected", client.clientID)
+				// Отписка от стримов может ждать брокера, не блокируем хаб
+				go client.releaseAll()
 			}
 			h.mu.Unlock()
 
@@ -134,8 +156,63 @@ func (h *Hub) Broadcast(message Message) {
 	h.broadcast <- data
 }
 
-// BroadcastToSubscribers - отправка сообщения подписчикам
+// SetSubscriptionListener - подключение источника данных для подписок на инструменты
+func (h *Hub) SetSubscriptionListener(listener SubscriptionListener) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.listener = listener
+}
+
+// SetAccountAuthorizer - подключение проверки доступа к счетам.
+// Без нее подписки на счета отклоняются.
+func (h *Hub) SetAccountAuthorizer(authorizer AccountAuthorizer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.authorizer = authorizer
+}
+
+// authorized - доступ субъекта к счету
+func (h *Hub) authorized(principal *auth.Principal, accountID string) bool {
+	h.mu.RLock()
+	authorizer := h.authorizer
+	h.mu.RUnlock()
+	return authorizer != nil && authorizer(principal, accountID)
+}
+
+// subscriptionListener - текущий источник данных
+func (h *Hub) subscriptionListener() SubscriptionListener {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.listener
+}
+
+// BroadcastToSubscribers - отправка сообщения подписчикам канала
 func (h *Hub) BroadcastToSubscribers(subscriptionType string, message Message) {
+	h.deliver(message, func(client *Client) bool {
+		_, subscribed := client.subscriptions[subscriptionType]
+		return subscribed
+	})
+}
+
+// Publish - отправка сообщения клиентам, подписанным на канал по любому
+// из идентификаторов: FIGI или UID инструмента, ID счета
+func (h *Hub) Publish(channel string, ids []string, message Message) {
+	h.deliver(message, func(client *Client) bool {
+		for _, id := range ids {
+			if client.subscriptions[channel][id] {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// deliver - отправка сообщения клиентам, для которых match вернул true.
+// Медленный клиент пропускает сообщение, а не блокирует остальных.
+func (h *Hub) deliver(message Message, match func(client *Client) bool) {
+	if message.Timestamp == 0 {
+		message.Timestamp = time.Now().Unix()
+	}
 	data, err := json.Marshal(message)
 	if err != nil {
 		h.logger.Errorf("Failed to marshal message: %v", err)
@@ -147,15 +224,16 @@ func (h *Hub) BroadcastToSubscribers(subscriptionType string, message Message) {
 
 	for client := range h.clients {
 		client.mu.RLock()
-		if client.subscriptions[subscriptionType] {
-			select {
-			case client.send <- data:
-			default:
-				delete(h.clients, client)
-				close(client.send)
-			}
-		}
+		matched := match(client)
 		client.mu.RUnlock()
+		if !matched {
+			continue
+		}
+		select {
+		case client.send <- data:
+		default:
+			h.logger.Warnf("Client %s is too slow, %s message dropped", client.clientID, message.Type)
+		}
 	}
 }
 
@@ -169,9 +247,10 @@ func WebSocketHandler(hub *Hub, tradingServer interface{}) gin.HandlerFunc {
 		}
 
 		clientID := generateClientID()
-		userID := c.Query("user_id")
+		principal := middleware.Principal(c)
+		userID := middleware.UserID(c)
 		if userID == "" {
-			userID = "anonymous"
+			userID = principal.Name()
 		}
 
 		client := &Client{
@@ -180,7 +259,8 @@ func WebSocketHandler(hub *Hub, tradingServer interface{}) gin.HandlerFunc {
 			send:          make(chan []byte, 256),
 			userID:        userID,
 			clientID:      clientID,
-			subscriptions: make(map[string]bool),
+			principal:     principal,
+			subscriptions: make(map[string]map[string]bool),
 		}
 
 		hub.register <- client
@@ -313,7 +393,7 @@ func (c *Client) handleMessage(message Message) {
 func (c *Client) handleSubscription(message Message) {
 	var subscription Subscription
 	data, _ := json.Marshal(message.Data)
-	if err := json.Unmarshal(data, &subscription); err != nil {
+	if err := json.Unmarshal(data, &subscription); err != nil || subscription.Type == "" {
 		c.SendMessage(Message{
 			Type:  "error",
 			Error: "Invalid subscription format",
@@ -321,11 +401,63 @@ func (c *Client) handleSubscription(message Message) {
 		return
 	}
 
+	// Подписка либо на счета, либо на инструменты
+	ids := subscription.Instruments
+	if len(subscription.AccountIDs) > 0 {
+		ids = subscription.AccountIDs
+		for _, accountID := range ids {
+			if !c.hub.authorized(c.principal, accountID) {
+				c.hub.logger.Warnf("Client %s (%s) is not authorized for account %s", c.clientID, c.userID, accountID)
+				c.SendMessage(Message{
+					Type:  "error",
+					Error: fmt.Sprintf("Not authorized for account %s", accountID),
+				})
+				return
+			}
+		}
+	}
+
+	// Upstream-подписка нужна только на то, чего у клиента еще нет
+	c.mu.RLock()
+	added := make([]string, 0, len(ids))
+	for _, id := range uniqueIDs(ids) {
+		if !c.subscriptions[subscription.Type][id] {
+			added = append(added, id)
+		}
+	}
+	c.mu.RUnlock()
+
+	if len(added) > 0 {
+		listener := c.hub.subscriptionListener()
+		if listener == nil {
+			c.SendMessage(Message{
+				Type:  "error",
+				Error: "Market data streaming is not available",
+			})
+			return
+		}
+		if err := listener.Acquire(subscription.Type, added); err != nil {
+			c.hub.logger.Errorf("Client %s failed to subscribe to %s: %v", c.clientID, subscription.Type, err)
+			c.SendMessage(Message{
+				Type:  "error",
+				Error: err.Error(),
+			})
+			return
+		}
+	}
+
 	c.mu.Lock()
-	c.subscriptions[subscription.Type] = true
+	instruments, exists := c.subscriptions[subscription.Type]
+	if !exists {
+		instruments = make(map[string]bool)
+		c.subscriptions[subscription.Type] = instruments
+	}
+	for _, id := range added {
+		instruments[id] = true
+	}
 	c.mu.Unlock()
 
-	c.hub.logger.Infof("Client %s subscribed to %s", c.clientID, subscription.Type)
+	c.hub.logger.Infof("Client %s subscribed to %s %v", c.clientID, subscription.Type, ids)
 
 	c.SendMessage(Message{
 		Type:   "subscription",
@@ -334,7 +466,7 @@ func (c *Client) handleSubscription(message Message) {
 	})
 }
 
-// handleUnsubscription - обработка отписки
+// handleUnsubscription - обработка отписки, без инструментов - отписка от всего канала
 func (c *Client) handleUnsubscription(message Message) {
 	var subscription Subscription
 	data, _ := json.Marshal(message.Data)
@@ -346,10 +478,33 @@ func (c *Client) handleUnsubscription(message Message) {
 		return
 	}
 
+	ids := subscription.Instruments
+	if len(subscription.AccountIDs) > 0 {
+		ids = subscription.AccountIDs
+	}
+
 	c.mu.Lock()
-	delete(c.subscriptions, subscription.Type)
+	subscribed := c.subscriptions[subscription.Type]
+	var removed []string
+	if len(ids) == 0 {
+		for id := range subscribed {
+			removed = append(removed, id)
+		}
+		delete(c.subscriptions, subscription.Type)
+	} else {
+		for _, id := range uniqueIDs(ids) {
+			if subscribed[id] {
+				delete(subscribed, id)
+				removed = append(removed, id)
+			}
+		}
+	}
 	c.mu.Unlock()
 
+	if listener := c.hub.subscriptionListener(); listener != nil && len(removed) > 0 {
+		listener.Release(subscription.Type, removed)
+	}
+
 	c.hub.logger.Infof("Client %s unsubscribed from %s", c.clientID, subscription.Type)
 
 	c.SendMessage(Message{
@@ -359,64 +514,40 @@ func (c *Client) handleUnsubscription(message Message) {
 	})
 }
 
-// StreamManager - менеджер для управления стримами данных
-type StreamManager struct {
-	hub    *Hub
-	client *investgo.Client
-	logger *zap.SugaredLogger
-	ctx    context.Context
-	cancel context.CancelFunc
-
-	marketDataStream *investgo.MarketDataStreamClient
-	operationsStream *investgo.OperationsStreamClient
-}
+// releaseAll - освобождение всех подписок отключившегося клиента
+func (c *Client) releaseAll() {
+	c.mu.Lock()
+	subscriptions := c.subscriptions
+	c.subscriptions = make(map[string]map[string]bool)
+	c.mu.Unlock()
 
-// NewStreamManager - создание менеджера стримов
-func NewStreamManager(hub *Hub, client *investgo.Client, logger *zap.SugaredLogger) *StreamManager {
-	ctx, cancel := context.WithCancel(context.Background())
-
-	return &StreamManager{
-		hub:              hub,
-		client:           client,
-		logger:           logger,
-		ctx:              ctx,
-		cancel:           cancel,
-		marketDataStream: client.NewMarketDataStreamClient(),
-		operationsStream: client.NewOperationsStreamClient(),
+	listener := c.hub.subscriptionListener()
+	if listener == nil {
+		return
+	}
+	for channel, subscribed := range subscriptions {
+		if len(subscribed) == 0 {
+			continue
+		}
+		ids := make([]string, 0, len(subscribed))
+		for id := range subscribed {
+			ids = append(ids, id)
+		}
+		listener.Release(channel, ids)
 	}
 }
 
-// Start - запуск менеджера стримов
-func (sm *StreamManager) Start() error {
-	sm.logger.Info("Starting stream manager...")
-
-	// Запускаем стрим маркетдаты
-	go sm.startMarketDataStream()
-
-	// Запускаем стрим операций
-	go sm.startOperationsStream()
-
-	return nil
-}
-
-// Stop - остановка менеджера стримов
-func (sm *StreamManager) Stop() {
-	sm.logger.Info("Stopping stream manager...")
-	sm.cancel()
-}
-
-// startMarketDataStream - запуск стрима маркетдаты
-func (sm *StreamManager) startMarketDataStream() {
-	// Здесь должна быть логика подключения к стриму маркетдаты
-	// и отправка данных через WebSocket
-	sm.logger.Info("Market data stream started")
-}
-
-// startOperationsStream - запуск стрима операций
-func (sm *StreamManager) startOperationsStream() {
-	// Здесь должна быть логика подключения к стриму операций
-	// и отправка данных через WebSocket
-	sm.logger.Info("Operations stream started")
+// uniqueIDs - идентификаторы без пустых и повторяющихся
+func uniqueIDs(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
 }
 
 // generateClientID - генерация ID клиента